@@ -1,12 +1,10 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"regexp"
 	"strings"
@@ -120,6 +118,12 @@ type chatRequest struct {
 	Stream            bool               `json:"stream"`
 	MaxTokens         int                `json:"max_tokens,omitempty"`
 	ChatTemplateKwargs map[string]any    `json:"chat_template_kwargs,omitempty"`
+	StreamOptions     *streamOptions     `json:"stream_options,omitempty"`
+}
+
+// streamOptions asks for a final chunk with token usage.
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type streamDelta struct {
@@ -149,12 +153,20 @@ type streamChoice struct {
 
 type streamChunk struct {
 	Choices []streamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
+}
+
+// Usage holds token counts reported by the API (zero if not reported).
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
 // StreamResult holds the accumulated response from streaming.
 type StreamResult struct {
 	Content   string
 	ToolCalls []ToolCall
+	Usage     Usage
 }
 
 var requestDebug bool
@@ -174,50 +186,21 @@ const (
 	colorBold  = "\033[1m"
 )
 
-// doStream sends a streaming chat completion request and displays the response.
+// doStream sends a streaming chat request via the model's provider and displays the response.
 // If toolDefs is nil, the request is sent without tools (pure generation).
-func doStream(baseURL, model string, messages []Message, toolDefs []tools.Definition, maxTokens int, showThinking bool, contentOut io.Writer, think thinkMode) (*StreamResult, error) {
-	reqBody := chatRequest{
-		Model:     model,
-		Messages:  messages,
-		Tools:     toolDefs,
-		Stream:    true,
-		MaxTokens: maxTokens,
-	}
-	applyThinkMode(&reqBody, think)
-	payload, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, err
-	}
-
-	apiURL := baseURL + "/chat/completions"
-	if requestDebug {
-		dumpRequestDebug(apiURL, payload)
-	}
-
-	httpReq, err := http.NewRequest("POST", apiURL, bytes.NewReader(payload))
-	if err != nil {
-		return nil, err
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, b)
-	}
-
-	var result StreamResult
-	tcMap := map[int]*ToolCall{}
+// Failed or interrupted streams are re-issued (see withRetry); the result is
+// from the last attempt. Text of a failed attempt stays on screen unless
+// contentOut is a contentDropper, which takes it back before the retry.
+func doStream(ctx context.Context, cfg modelConfig, model string, messages []Message, toolDefs []tools.Definition, maxTokens int, showThinking bool, contentOut io.Writer, think thinkMode) (*StreamResult, error) {
 	showThink := showThinking
+	written := 0 // content bytes of the current attempt
+	writeContent := func(s string) {
+		written += len(s)
+		fmt.Fprint(contentOut, s)
+	}
 	filter := &thinkFilter{
 		writeThink:   func(s string) { if showThink { fmt.Fprint(os.Stderr, s) } },
-		writeContent: func(s string) { fmt.Fprint(contentOut, s) },
+		writeContent: writeContent,
 		onThinkStart: func() { if showThink { fmt.Fprint(os.Stderr, colorDim) } },
 		onThinkEnd:   func() { if showThink { fmt.Fprint(os.Stderr, colorReset+"\n") } },
 	}
@@ -225,96 +208,69 @@ func doStream(baseURL, model string, messages []Message, toolDefs []tools.Defini
 	reasoningDim := false
 	var reasoningBuf strings.Builder
 
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 0, 256*1024), 256*1024)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			break
-		}
-
-		var chunk streamChunk
-		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			continue
-		}
-
-		for _, ch := range chunk.Choices {
-			// Reasoning content: "reasoning_content" (Qwen3 via older vLLM) or "reasoning" (vLLM 0.16+)
-			rc := ch.Delta.ReasoningContent
-			if rc == nil || *rc == "" {
-				rc = ch.Delta.Reasoning
-			}
-			if rc != nil && *rc != "" {
-				hadReasoning = true
-				reasoningBuf.WriteString(*rc)
-				if showThinking {
-					if !reasoningDim {
-						fmt.Fprint(os.Stderr, colorDim)
-						reasoningDim = true
-					}
-					fmt.Fprint(os.Stderr, *rc)
+	ev := streamEvents{
+		Reasoning: func(rc string) {
+			hadReasoning = true
+			reasoningBuf.WriteString(rc)
+			if showThinking {
+				if !reasoningDim {
+					fmt.Fprint(os.Stderr, colorDim)
+					reasoningDim = true
 				}
+				fmt.Fprint(os.Stderr, rc)
 			}
-
-			// Regular content
-			if ch.Delta.Content != nil && *ch.Delta.Content != "" {
-				if reasoningDim {
-					fmt.Fprint(os.Stderr, colorReset+"\n")
-					reasoningDim = false
-				}
-				result.Content += *ch.Delta.Content
-				if hadReasoning {
-					// reasoning_content was used, content is clean
-					fmt.Fprint(contentOut, *ch.Delta.Content)
-				} else {
-					// Fallback: parse <think> tags in content
-					filter.process(*ch.Delta.Content)
-				}
+		},
+		Content: func(c string) {
+			if reasoningDim {
+				fmt.Fprint(os.Stderr, colorReset+"\n")
+				reasoningDim = false
 			}
-
-			// Tool calls (accumulated across chunks)
-			for _, tc := range ch.Delta.ToolCalls {
-				if existing, ok := tcMap[tc.Index]; ok {
-					if tc.ID != "" {
-						existing.ID = tc.ID
-					}
-					if tc.Function.Name != "" {
-						existing.Function.Name = tc.Function.Name
-					}
-					existing.Function.Arguments += tc.Function.Arguments
-				} else {
-					tcMap[tc.Index] = &ToolCall{
-						ID:   tc.ID,
-						Type: tc.Type,
-						Function: FuncCall{
-							Name:      tc.Function.Name,
-							Arguments: tc.Function.Arguments,
-						},
-					}
-				}
+			if hadReasoning {
+				// reasoning_content was used, content is clean
+				writeContent(c)
+			} else {
+				// Fallback: parse <think> tags in content
+				filter.process(c)
 			}
-		}
+		},
 	}
 
-	filter.flush()
-	if reasoningDim {
-		fmt.Fprint(os.Stderr, colorReset+"\n")
+	if prefixDebug {
+		reportPrefixReuse(model, toolDefs, messages)
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("stream read error: %w", err)
+	req := llmRequest{
+		Model:     model,
+		Messages:  messages,
+		Tools:     toolDefs,
+		MaxTokens: maxTokens,
+		Think:     think,
 	}
-
-	for i := 0; i < len(tcMap); i++ {
-		if tc, ok := tcMap[i]; ok {
-			result.ToolCalls = append(result.ToolCalls, *tc)
+	result, err := withRetry(ctx, cfg, func(p chatProvider) (*StreamResult, error) {
+		if d, ok := contentOut.(contentDropper); ok && written > 0 {
+			d.dropContent(written)
+		}
+		written = 0
+		hadReasoning = false
+		reasoningBuf.Reset()
+		res, err := p.Stream(ctx, req, ev)
+		if err != nil {
+			filter.reset()
+		} else {
+			filter.flush()
+		}
+		if reasoningDim {
+			fmt.Fprint(os.Stderr, colorReset+"\n")
+			reasoningDim = false
 		}
+		return res, err
+	})
+	if err != nil {
+		if d, ok := contentOut.(contentDropper); ok && written > 0 {
+			d.dropContent(written) // e.g. a stream cut by the time budget
+		}
+		return nil, err
 	}
+	recordUsage(ctx, model, result.Usage)
 
 	// If no API tool calls, try parsing from text (reasoning + content)
 	if len(result.ToolCalls) == 0 {
@@ -338,7 +294,13 @@ func doStream(baseURL, model string, messages []Message, toolDefs []tools.Defini
 		}
 	}
 
-	return &result, nil
+	return result, nil
+}
+
+// contentDropper is implemented by content writers that can take back the
+// last n bytes written, the text of a failed stream attempt (see doStream).
+type contentDropper interface {
+	dropContent(n int)
 }
 
 // thinkFilter handles <think>...</think> tags in streamed content.
@@ -412,6 +374,16 @@ func (f *thinkFilter) flush() {
 	f.active = false
 }
 
+// reset drops a partial tag and leaves an open <think> block, so that a
+// re-issued stream starts clean.
+func (f *thinkFilter) reset() {
+	if f.active {
+		f.onThinkEnd()
+	}
+	f.pending = ""
+	f.active = false
+}
+
 // partialSuffix returns the length of the longest suffix of s
 // that is a prefix of tag, or 0 if none.
 func partialSuffix(s, tag string) int {
@@ -442,15 +414,29 @@ func estimateTokens(messages []Message) int {
 	return chars/3 + 50 // +50 for message framing overhead
 }
 
+// measuredTokens returns the token count of messages given that the first
+// upTo of them are known to take measured tokens (from API usage);
+// the rest is estimated. With upTo == 0 it is estimateTokens.
+func measuredTokens(messages []Message, measured, upTo int) int {
+	if upTo <= 0 || upTo > len(messages) {
+		return estimateTokens(messages)
+	}
+	return measured + estimateTokens(messages[upTo:])
+}
+
 // capMaxTokens adjusts maxTokens so input+output fits within contextLimit.
 // Returns at least minOutput (256) tokens, or the original maxTokens if
 // contextLimit is 0 (unknown).
 func capMaxTokens(contextLimit, maxTokens int, messages []Message) int {
+	return capMaxTokensUsed(contextLimit, maxTokens, estimateTokens(messages))
+}
+
+// capMaxTokensUsed is capMaxTokens for a known input size in tokens.
+func capMaxTokensUsed(contextLimit, maxTokens, used int) int {
 	if contextLimit <= 0 {
 		return maxTokens
 	}
-	estimated := estimateTokens(messages)
-	available := contextLimit - estimated
+	available := contextLimit - used
 	const minOutput = 256
 	if available < minOutput {
 		return minOutput
@@ -467,27 +453,39 @@ func capMaxTokens(contextLimit, maxTokens int, messages []Message) int {
 // contextLimit is the model's total context window (0 = no capping).
 // maxToolResultChars limits the size of each tool result to prevent context overflow.
 // The logf callback is used for optional progress output (suppressed in -quiet).
+// Tools run in sess, the session of the request the sub-agent works for.
 // toolExecFunc dispatches a tool call by name. Returns result text or error.
-type toolExecFunc func(name string, args json.RawMessage) (string, error)
+type toolExecFunc func(ctx context.Context, sess *tools.Session, name string, args json.RawMessage) (string, error)
 
 // defaultToolExec dispatches to built-in tools only.
-func defaultToolExec(name string, args json.RawMessage) (string, error) {
+func defaultToolExec(ctx context.Context, sess *tools.Session, name string, args json.RawMessage) (string, error) {
+	if !sess.Allows(name) {
+		return "", fmt.Errorf("tool %q is not enabled here", name)
+	}
 	if tool, ok := tools.Get(name); ok {
-		return tool.Execute(args)
+		return tool.Execute(ctx, sess, args)
 	}
 	return "", fmt.Errorf("unknown tool %q", name)
 }
 
-func doSubAgentWithTools(baseURL, model string, messages []Message,
+func doSubAgentWithTools(ctx context.Context, sess *tools.Session, cfg modelConfig, model string, messages []Message,
 	toolDefs []tools.Definition, maxTokens, contextLimit, maxRounds, maxToolResultChars int,
 	logf func(string, ...any), execTool toolExecFunc, think thinkMode) (string, error) {
 
+	// Real size of messages[:measuredUpTo] as reported by the last response;
+	// only messages appended since then are estimated.
+	measured, measuredUpTo := 0, 0
+
 	for round := 0; round < maxRounds; round++ {
-		effectiveMax := capMaxTokens(contextLimit, maxTokens, messages)
-		result, err := doStream(baseURL, model, messages, toolDefs, effectiveMax, false, io.Discard, think)
+		effectiveMax := capMaxTokensUsed(contextLimit, maxTokens, measuredTokens(messages, measured, measuredUpTo))
+		result, err := doStream(ctx, cfg, model, messages, toolDefs, effectiveMax, false, io.Discard, think)
 		if err != nil {
 			return "", fmt.Errorf("round %d: %w", round, err)
 		}
+		if result.Usage.PromptTokens > 0 {
+			measured = result.Usage.PromptTokens + result.Usage.CompletionTokens
+			measuredUpTo = len(messages) + 1 // + the assistant message below
+		}
 
 		if len(result.ToolCalls) == 0 {
 			return stripThinkTags(result.Content), nil
@@ -500,21 +498,19 @@ func doSubAgentWithTools(baseURL, model string, messages []Message,
 			ToolCalls: result.ToolCalls,
 		})
 
-		// Execute each tool call
+		// Execute the tool calls (safe ones in parallel)
 		exec := execTool
 		if exec == nil {
 			exec = defaultToolExec
 		}
-		for _, tc := range result.ToolCalls {
+		results, err := execToolCalls(ctx, sess, result.ToolCalls, exec, cfg.Loop.parallelTools(), func(tc ToolCall) {
 			logf("%s  [sub-agent tool: %s]%s\n", colorDim, tc.Function.Name, colorReset)
-
-			var toolResult string
-			res, execErr := exec(tc.Function.Name, json.RawMessage(tc.Function.Arguments))
-			if execErr != nil {
-				toolResult = "error: " + execErr.Error()
-			} else {
-				toolResult = res
-			}
+		})
+		if err != nil {
+			return "", err
+		}
+		for i, tc := range result.ToolCalls {
+			toolResult := results[i].Content
 
 			// Truncate tool results to prevent context overflow
 			if maxToolResultChars > 0 && len(toolResult) > maxToolResultChars {
@@ -523,13 +519,11 @@ func doSubAgentWithTools(baseURL, model string, messages []Message,
 
 			// Check if the tool produced images (e.g. camera snapshot)
 			var toolImages []ImageURL
-			if imgURIs := tools.TakePendingImages(); len(imgURIs) > 0 {
-				for _, uri := range imgURIs {
-					toolImages = append(toolImages, ImageURL{URL: uri})
-					imgID := tools.AddSessionImage(uri)
-					if tools.ImageSenderAvailable() {
-						toolResult += fmt.Sprintf("\n[Image #%d — use send_image to forward to the user]", imgID)
-					}
+			for _, uri := range results[i].Images {
+				toolImages = append(toolImages, ImageURL{URL: uri})
+				imgID := sess.AddImage(uri)
+				if sess.ImageSenderAvailable() {
+					toolResult += fmt.Sprintf("\n[Image #%d — use send_image to forward to the user]", imgID)
 				}
 			}
 
@@ -544,62 +538,31 @@ func doSubAgentWithTools(baseURL, model string, messages []Message,
 
 	// Max rounds exceeded — force text response by calling without tools
 	logf("%s  [sub-agent: max rounds reached, forcing text]%s\n", colorDim, colorReset)
-	effectiveMax := capMaxTokens(contextLimit, maxTokens, messages)
-	result, err := doStream(baseURL, model, messages, nil, effectiveMax, false, io.Discard, think)
+	effectiveMax := capMaxTokensUsed(contextLimit, maxTokens, measuredTokens(messages, measured, measuredUpTo))
+	result, err := doStream(ctx, cfg, model, messages, nil, effectiveMax, false, io.Discard, think)
 	if err != nil {
 		return "", fmt.Errorf("final round: %w", err)
 	}
 	return stripThinkTags(result.Content), nil
 }
 
-// doChat makes a non-streaming chat completion call (used by sub-agents).
+// doChat makes a non-streaming chat call via the model's provider (used by sub-agents).
 // contextLimit is the model's total context window (0 = no capping).
-func doChat(baseURL, model string, messages []Message, maxTokens, contextLimit int, think thinkMode) (string, error) {
-	effectiveMax := capMaxTokens(contextLimit, maxTokens, messages)
-	reqBody := chatRequest{
+func doChat(ctx context.Context, cfg modelConfig, model string, messages []Message, maxTokens, contextLimit int, think thinkMode) (string, error) {
+	req := llmRequest{
 		Model:     model,
 		Messages:  messages,
-		Stream:    false,
-		MaxTokens: effectiveMax,
+		MaxTokens: capMaxTokens(contextLimit, maxTokens, messages),
+		Think:     think,
 	}
-	applyThinkMode(&reqBody, think)
-	payload, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", err
-	}
-
-	httpReq, err := http.NewRequest("POST", baseURL+"/chat/completions", bytes.NewReader(payload))
+	result, err := withRetry(ctx, cfg, func(p chatProvider) (*StreamResult, error) {
+		return p.Chat(ctx, req)
+	})
 	if err != nil {
 		return "", err
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(httpReq)
-	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error %d: %s", resp.StatusCode, b)
-	}
-
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("decode error: %w", err)
-	}
-	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("empty response from model")
-	}
-
-	return stripThinkTags(result.Choices[0].Message.Content), nil
+	recordUsage(ctx, model, result.Usage)
+	return stripThinkTags(result.Content), nil
 }
 
 var reThinkTags = regexp.MustCompile(`(?s)<think>.*?</think>\s*`)
@@ -719,40 +682,10 @@ func (pw *prefixWriter) WriteString(s string) {
 	}
 }
 
-// doSubAgentStream runs a streaming chat completion for a sub-agent,
+// doSubAgentStream runs a streaming chat request for a sub-agent,
 // displaying all output (thinking + content) on stderr via prefixWriter.
 // Returns the clean content (thinking stripped).
-func doSubAgentStream(baseURL, model string, messages []Message, maxTokens int, pw *prefixWriter, think thinkMode) (string, error) {
-	reqBody := chatRequest{
-		Model:     model,
-		Messages:  messages,
-		Stream:    true,
-		MaxTokens: maxTokens,
-	}
-	applyThinkMode(&reqBody, think)
-	payload, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", err
-	}
-
-	httpReq, err := http.NewRequest("POST", baseURL+"/chat/completions", bytes.NewReader(payload))
-	if err != nil {
-		return "", err
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(httpReq)
-	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error %d: %s", resp.StatusCode, b)
-	}
-
-	var contentBuf strings.Builder
+func doSubAgentStream(ctx context.Context, cfg modelConfig, model string, messages []Message, maxTokens int, pw *prefixWriter, think thinkMode) (string, error) {
 	hadReasoning := false
 	reasoningDim := false
 
@@ -764,61 +697,52 @@ func doSubAgentStream(baseURL, model string, messages []Message, maxTokens int,
 		onThinkEnd:   func() { pw.WriteString(colorReset + "\n") },
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 0, 256*1024), 256*1024)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			break
-		}
-
-		var chunk streamChunk
-		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			continue
-		}
-
-		for _, ch := range chunk.Choices {
-			rc := ch.Delta.ReasoningContent
-			if rc == nil || *rc == "" {
-				rc = ch.Delta.Reasoning
+	ev := streamEvents{
+		Reasoning: func(rc string) {
+			hadReasoning = true
+			if !reasoningDim {
+				pw.WriteString(colorDim)
+				reasoningDim = true
 			}
-			if rc != nil && *rc != "" {
-				hadReasoning = true
-				if !reasoningDim {
-					pw.WriteString(colorDim)
-					reasoningDim = true
-				}
-				pw.WriteString(*rc)
+			pw.WriteString(rc)
+		},
+		Content: func(c string) {
+			if reasoningDim {
+				pw.WriteString(colorReset + "\n")
+				reasoningDim = false
 			}
-
-			if ch.Delta.Content != nil && *ch.Delta.Content != "" {
-				if reasoningDim {
-					pw.WriteString(colorReset + "\n")
-					reasoningDim = false
-				}
-				contentBuf.WriteString(*ch.Delta.Content)
-				if hadReasoning {
-					pw.WriteString(*ch.Delta.Content)
-				} else {
-					filter.process(*ch.Delta.Content)
-				}
+			if hadReasoning {
+				pw.WriteString(c)
+			} else {
+				filter.process(c)
 			}
-		}
+		},
 	}
 
-	filter.flush()
-	if reasoningDim {
-		pw.WriteString(colorReset + "\n")
+	req := llmRequest{
+		Model:     model,
+		Messages:  messages,
+		MaxTokens: maxTokens,
+		Think:     think,
 	}
-
-	if err := scanner.Err(); err != nil {
+	result, err := withRetry(ctx, cfg, func(p chatProvider) (*StreamResult, error) {
+		hadReasoning = false
+		res, err := p.Stream(ctx, req, ev)
+		if err != nil {
+			filter.reset()
+		} else {
+			filter.flush()
+		}
+		if reasoningDim {
+			pw.WriteString(colorReset + "\n")
+			reasoningDim = false
+		}
+		return res, err
+	})
+	if err != nil {
 		return "", fmt.Errorf("stream error: %w", err)
 	}
+	recordUsage(ctx, model, result.Usage)
 
-	return stripThinkTags(contentBuf.String()), nil
+	return stripThinkTags(result.Content), nil
 }
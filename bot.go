@@ -1,9 +1,11 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,6 +13,7 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -58,6 +61,15 @@ type TGDocument struct {
 	FileSize int    `json:"file_size,omitempty"`
 }
 
+// TGAudio is a voice note or an audio file.
+type TGAudio struct {
+	FileID   string `json:"file_id"`
+	Duration int    `json:"duration,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	FileSize int    `json:"file_size,omitempty"`
+}
+
 type TGMessage struct {
 	MessageID int64         `json:"message_id"`
 	From      *TGUser       `json:"from,omitempty"`
@@ -69,8 +81,13 @@ type TGMessage struct {
 	VideoNote *TGVideo      `json:"video_note,omitempty"`
 	Animation *TGVideo      `json:"animation,omitempty"`
 	Document  *TGDocument   `json:"document,omitempty"`
+	Voice     *TGAudio      `json:"voice,omitempty"`
+	Audio     *TGAudio      `json:"audio,omitempty"`
 	Caption        string        `json:"caption,omitempty"`
 	ReplyToMessage *TGMessage    `json:"reply_to_message,omitempty"`
+	MediaGroupID   string        `json:"media_group_id,omitempty"` // shared by the items of an album
+
+	album []*TGMessage // the album's other photos, merged into this message
 }
 
 // hasVideo returns true if the message contains a video in any form
@@ -97,6 +114,28 @@ func (m *TGMessage) videoFileID() (fileID, mime string) {
 	return "", ""
 }
 
+// hasAudio returns true if the message is a voice note, an audio file or a
+// document with audio MIME.
+func (m *TGMessage) hasAudio() bool {
+	if m.Voice != nil || m.Audio != nil {
+		return true
+	}
+	return m.Document != nil && strings.HasPrefix(m.Document.MimeType, "audio/")
+}
+
+// audioFile returns the file_id and a file name for the audio attachment.
+func (m *TGMessage) audioFile() (fileID, fileName string) {
+	switch {
+	case m.Voice != nil:
+		return m.Voice.FileID, "voice.ogg"
+	case m.Audio != nil:
+		return m.Audio.FileID, cmp.Or(m.Audio.FileName, "audio.mp3")
+	case m.Document != nil && strings.HasPrefix(m.Document.MimeType, "audio/"):
+		return m.Document.FileID, cmp.Or(m.Document.FileName, "audio")
+	}
+	return "", ""
+}
+
 type TGInlineKeyboardButton struct {
 	Text         string `json:"text"`
 	CallbackData string `json:"callback_data,omitempty"`
@@ -113,18 +152,37 @@ type TGCallbackQuery struct {
 	Data    string     `json:"data"`
 }
 
+// TGPollAnswer is a vote in a non-anonymous poll sent by the bot.
+type TGPollAnswer struct {
+	PollID    string  `json:"poll_id"`
+	User      *TGUser `json:"user,omitempty"`
+	OptionIDs []int   `json:"option_ids"` // empty when the vote is retracted
+}
+
 type Update struct {
 	UpdateID      int64            `json:"update_id"`
 	Message       *TGMessage       `json:"message,omitempty"`
 	CallbackQuery *TGCallbackQuery `json:"callback_query,omitempty"`
+	PollAnswer    *TGPollAnswer    `json:"poll_answer,omitempty"`
 }
 
+// allowedUpdates are the update types the bot receives.
+const allowedUpdates = `["message","callback_query","poll_answer"]`
+
 // Pending questions: keyboard-based (keyed by message_id) and free-text (keyed by chatID).
 
 type pendingQuestion struct {
 	ChatID   int64
+	UserID   int64 // only this user may answer (0 = anyone in the chat)
 	Options  []tools.UserOption
+	Multi    bool   // options toggle until "Done"
+	FreeText bool   // has the "own answer" button
+	Poll     bool   // asked as a native poll
+	Text     string // message text, kept when the keyboard changes
 	ResultCh chan string
+
+	mu       sync.Mutex
+	selected []bool // toggled options of a Multi question
 }
 
 var (
@@ -136,24 +194,133 @@ func registerKeyboardQuestion(msgID int64, pq *pendingQuestion) {
 	pendingKeyboardQuestions.Store(msgID, pq)
 }
 
-func resolveKeyboardQuestion(msgID int64) *pendingQuestion {
-	v, ok := pendingKeyboardQuestions.LoadAndDelete(msgID)
-	if !ok {
-		return nil
-	}
-	return v.(*pendingQuestion)
+// resolveKeyboardQuestion takes the question asked in message msgID if
+// userID may answer it.
+func resolveKeyboardQuestion(msgID, userID int64) *pendingQuestion {
+	return takeQuestion(&pendingKeyboardQuestions, msgID, userID)
 }
 
 func registerTextQuestion(chatID int64, pq *pendingQuestion) {
 	pendingTextQuestions.Store(chatID, pq)
 }
 
-func resolveTextQuestion(chatID int64) *pendingQuestion {
-	v, ok := pendingTextQuestions.LoadAndDelete(chatID)
+// resolveTextQuestion takes the free-text question pending in a chat if
+// userID may answer it.
+func resolveTextQuestion(chatID, userID int64) *pendingQuestion {
+	return takeQuestion(&pendingTextQuestions, chatID, userID)
+}
+
+// textQuestionPending reports whether userID may answer a free-text
+// question pending in a chat.
+func textQuestionPending(chatID, userID int64) bool {
+	return mayAnswer(&pendingTextQuestions, chatID, userID) != nil
+}
+
+func mayAnswer(m *sync.Map, key, userID int64) *pendingQuestion {
+	v, ok := m.Load(key)
 	if !ok {
 		return nil
 	}
-	return v.(*pendingQuestion)
+	pq := v.(*pendingQuestion)
+	if pq.UserID != 0 && pq.UserID != userID {
+		return nil
+	}
+	return pq
+}
+
+func takeQuestion(m *sync.Map, key, userID int64) *pendingQuestion {
+	pq := mayAnswer(m, key, userID)
+	if pq == nil {
+		return nil
+	}
+	if !m.CompareAndDelete(key, pq) {
+		return nil // answered concurrently
+	}
+	return pq
+}
+
+// Running queries per chat, so /cancel and the Stop button can abort them.
+var (
+	runningMu      sync.Mutex
+	runningQueries = map[int64][]*runningQuery{} // by chat ID
+)
+
+// runningQuery is a query in progress.
+type runningQuery struct {
+	msgID  int64 // the message that started it; 0 for scheduled jobs, alerts and mail
+	userID int64 // the user it runs for
+	cancel context.CancelFunc
+}
+
+// canceller is who asks to cancel: users may cancel their own queries and
+// queued jobs, admins anyone's.
+type canceller struct {
+	userID int64
+	admin  bool
+}
+
+func (c canceller) may(owner int64) bool {
+	return c.admin || (c.userID != 0 && c.userID == owner)
+}
+
+// cancellerOf is the sender of a /cancel or Stop press.
+func cancellerOf(users map[string]*UserConfig, from *TGUser) canceller {
+	if from == nil {
+		return canceller{}
+	}
+	u := resolveUserByTelegramID(users, from.ID)
+	return canceller{userID: from.ID, admin: u != nil && u.Admin}
+}
+
+// stopCallbackPrefix marks the Stop button's callback data ("stop:<message_id>").
+const stopCallbackPrefix = "stop:"
+
+// trackQuery registers the cancel func of the query started by message msgID
+// for user userID. The returned func unregisters it.
+func trackQuery(chatID, msgID, userID int64, cancel context.CancelFunc) (untrack func()) {
+	q := &runningQuery{msgID: msgID, userID: userID, cancel: cancel}
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	runningQueries[chatID] = append(runningQueries[chatID], q)
+	return func() {
+		runningMu.Lock()
+		defer runningMu.Unlock()
+		running := slices.DeleteFunc(runningQueries[chatID], func(r *runningQuery) bool { return r == q })
+		if len(running) == 0 {
+			delete(runningQueries, chatID)
+		} else {
+			runningQueries[chatID] = running
+		}
+	}
+}
+
+// cancelQuery cancels the query started by message msgID if by may cancel
+// it. Reports whether it was running.
+func cancelQuery(chatID, msgID int64, by canceller) bool {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	for _, q := range runningQueries[chatID] {
+		if q.msgID == msgID && msgID != 0 && by.may(q.userID) {
+			q.cancel()
+			return true
+		}
+	}
+	return false
+}
+
+// cancelChatQueries cancels the running queries in a chat that by may
+// cancel and returns how many there were.
+func cancelChatQueries(chatID int64, by canceller) int {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	n := 0
+	for _, q := range runningQueries[chatID] {
+		if by.may(q.userID) {
+			q.cancel()
+			n++
+		}
+	}
+	return n
 }
 
 // TelegramImageSender implements tools.ImageSender for Telegram bot sessions.
@@ -171,44 +338,58 @@ func (s *TelegramImageSender) SendImage(dataURI string, caption string) error {
 type TelegramPrompter struct {
 	Token  string
 	ChatID int64
+	UserID int64 // in group chats: the member whose answer counts
 }
 
-func (p *TelegramPrompter) Ask(q tools.UserQuestion) (string, error) {
-	if len(q.Options) > 0 {
-		// Build inline keyboard: one button per row
-		var rows [][]TGInlineKeyboardButton
-		for i, opt := range q.Options {
-			btn := TGInlineKeyboardButton{
-				Text:         opt.Label,
-				CallbackData: strconv.Itoa(i),
-			}
-			rows = append(rows, []TGInlineKeyboardButton{btn})
-		}
-		keyboard := TGInlineKeyboardMarkup{InlineKeyboard: rows}
-
-		// Build question text with descriptions
-		text := q.Question
-		for _, opt := range q.Options {
-			if opt.Description != "" {
-				text += fmt.Sprintf("\n• %s — %s", opt.Label, opt.Description)
-			}
-		}
-
-		msgID, err := sendMessageWithKeyboard(p.Token, p.ChatID, text, keyboard)
-		if err != nil {
-			return "", fmt.Errorf("send keyboard: %w", err)
-		}
+func (p *TelegramPrompter) Ask(ctx context.Context, q tools.UserQuestion) (string, error) {
+	// Other chats' jobs may use the worker while the user thinks
+	defer botQueue.awaitInput(p.ChatID)()
 
+	if len(q.Options) > 0 {
 		pq := &pendingQuestion{
 			ChatID:   p.ChatID,
+			UserID:   p.UserID,
 			Options:  q.Options,
+			Multi:    q.MultiSelect,
+			FreeText: q.FreeText,
+			Poll:     asPoll(q),
+			Text:     questionText(q),
 			ResultCh: make(chan string, 1),
+			selected: make([]bool, len(q.Options)),
+		}
+
+		var msgID int64
+		if pq.Poll {
+			id, pollID, err := p.sendPoll(pq, q.Question)
+			if err != nil {
+				return "", fmt.Errorf("send poll: %w", err)
+			}
+			msgID = id
+			pollMessages.Store(pollID, msgID)
+			defer pollMessages.Delete(pollID)
+		} else {
+			id, err := sendMessageWithKeyboard(p.Token, p.ChatID, pq.Text, pq.keyboard())
+			if err != nil {
+				return "", fmt.Errorf("send keyboard: %w", err)
+			}
+			msgID = id
 		}
 		registerKeyboardQuestion(msgID, pq)
 
-		// Block until user presses a button (no timeout — user may answer hours later)
-		answer := <-pq.ResultCh
-		return answer, nil
+		// Block until the user answers; the session's ask timeout, if any,
+		// comes as a context deadline
+		select {
+		case answer := <-pq.ResultCh:
+			p.closeQuestion(pq, msgID, "✅ "+pq.display(answer))
+			return answer, nil
+		case <-ctx.Done():
+			pendingKeyboardQuestions.CompareAndDelete(msgID, pq)
+			pendingTextQuestions.CompareAndDelete(p.ChatID, pq) // after "own answer"
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				p.closeQuestion(pq, msgID, "⌛ Время на ответ истекло.")
+			}
+			return "", ctx.Err()
+		}
 	}
 
 	// No options: send as regular message and wait for text reply
@@ -219,15 +400,21 @@ func (p *TelegramPrompter) Ask(q tools.UserQuestion) (string, error) {
 
 	pq := &pendingQuestion{
 		ChatID:   p.ChatID,
+		UserID:   p.UserID,
 		ResultCh: make(chan string, 1),
 	}
 	registerTextQuestion(p.ChatID, pq)
 
-	answer := <-pq.ResultCh
-	return answer, nil
+	select {
+	case answer := <-pq.ResultCh:
+		return answer, nil
+	case <-ctx.Done():
+		pendingTextQuestions.CompareAndDelete(p.ChatID, pq)
+		return "", ctx.Err()
+	}
 }
 
-func handleCallbackQuery(token string, cq *TGCallbackQuery) {
+func handleCallbackQuery(token string, cq *TGCallbackQuery, queue *jobQueue, users map[string]*UserConfig) {
 	// Acknowledge the callback to remove the loading spinner
 	_ = answerCallbackQuery(token, cq.ID)
 
@@ -235,26 +422,87 @@ func handleCallbackQuery(token string, cq *TGCallbackQuery) {
 		return
 	}
 
-	pq := resolveKeyboardQuestion(cq.Message.MessageID)
-	if pq == nil {
-		// Stale button press — ignore silently
+	if data, ok := strings.CutPrefix(cq.Data, reminderCallbackPrefix); ok {
+		handleReminderButton(token, cq, data)
+		return
+	}
+	if data, ok := strings.CutPrefix(cq.Data, haAlertCallbackPrefix); ok {
+		handleHAAlertButton(token, cq, data)
+		return
+	}
+	if data, ok := strings.CutPrefix(cq.Data, mailCallbackPrefix); ok {
+		handleMailButton(token, cq, data, queue)
 		return
 	}
 
-	// Parse callback data as option index
-	idx, err := strconv.Atoi(cq.Data)
-	if err != nil || idx < 0 || idx >= len(pq.Options) {
-		pq.ResultCh <- cq.Data // fallback: raw data
+	// Stop button on a "working" status message
+	if idStr, ok := strings.CutPrefix(cq.Data, stopCallbackPrefix); ok {
+		if msgID, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			by := cancellerOf(users, cq.From)
+			if !cancelQuery(cq.Message.Chat.ID, msgID, by) {
+				queue.cancel(cq.Message.Chat.ID, msgID, by)
+			}
+		}
 		return
 	}
-	pq.ResultCh <- pq.Options[idx].Label
+
+	var fromID int64
+	if cq.From != nil {
+		fromID = cq.From.ID
+	}
+	msgID := cq.Message.MessageID
+	pq := mayAnswer(&pendingKeyboardQuestions, msgID, fromID)
+	if pq == nil {
+		// Stale button press or another member's question — ignore silently
+		return
+	}
+
+	switch {
+	case cq.Data == otherCallbackData:
+		// The asking user's next message is the answer
+		if takeQuestion(&pendingKeyboardQuestions, msgID, fromID) != pq {
+			return
+		}
+		registerTextQuestion(pq.ChatID, pq)
+		const prompt = "✏️ Напишите свой ответ сообщением."
+		if pq.Poll {
+			_ = sendToChat(token, pq.ChatID, prompt)
+		} else {
+			_ = editMessageText(token, pq.ChatID, msgID, pq.Text+"\n\n"+prompt, "", nil)
+		}
+	case pq.Multi && cq.Data == doneCallbackData:
+		if takeQuestion(&pendingKeyboardQuestions, msgID, fromID) == pq {
+			pq.ResultCh <- tools.FormatSelection(pq.selection())
+		}
+	case pq.Multi:
+		if idx, err := strconv.Atoi(cq.Data); err == nil && pq.toggle(idx) {
+			_ = editMessageText(token, pq.ChatID, msgID, pq.Text, "", pq.keyboard())
+		}
+	default:
+		if takeQuestion(&pendingKeyboardQuestions, msgID, fromID) != pq {
+			return
+		}
+		// Parse callback data as option index
+		idx, err := strconv.Atoi(cq.Data)
+		if err != nil || idx < 0 || idx >= len(pq.Options) {
+			pq.ResultCh <- cq.Data // fallback: raw data
+			return
+		}
+		pq.ResultCh <- pq.Options[idx].Label
+	}
 }
 
 // Webhook management
 
-func setWebhook(token, webhookURL string) error {
+// setWebhook points Telegram at webhookURL; every update it posts carries
+// secret in the X-Telegram-Bot-Api-Secret-Token header.
+func setWebhook(token, webhookURL, secret string) error {
 	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/setWebhook", token)
-	vals := url.Values{"url": {webhookURL}}
+	vals := url.Values{
+		"url":             {webhookURL},
+		"secret_token":    {secret},
+		"allowed_updates": {allowedUpdates},
+	}
 	resp, err := http.PostForm(apiURL, vals)
 	if err != nil {
 		return fmt.Errorf("setWebhook request failed: %w", err)
@@ -316,6 +564,20 @@ func startTyping(token string, chatID int64) (cancel func()) {
 	return func() { close(done) }
 }
 
+// showStopButton posts a status message with a Stop button that cancels the
+// query started by message msgID. The returned func deletes the message.
+func showStopButton(token string, chatID, msgID int64) (remove func()) {
+	keyboard := TGInlineKeyboardMarkup{InlineKeyboard: [][]TGInlineKeyboardButton{{
+		{Text: "⏹ Стоп", CallbackData: stopCallbackPrefix + strconv.FormatInt(msgID, 10)},
+	}}}
+	statusID, err := sendMessageWithKeyboard(token, chatID, "⏳ Работаю…", keyboard)
+	if err != nil {
+		log.Printf("Error sending stop button to chat %d: %v", chatID, err)
+		return func() {}
+	}
+	return func() { _ = deleteMessage(token, chatID, statusID) }
+}
+
 // downloadTelegramFile downloads a file by file_id via the Bot API.
 func downloadTelegramFile(token, fileID string) ([]byte, error) {
 	// Step 1: getFile to obtain file_path
@@ -366,63 +628,180 @@ func runBot(tgCfg *telegramConfig, cfg modelConfig, modelID string,
 	// Load user configs
 	users := getUsers()
 
-	// Set webhook
-	if err := setWebhook(tgCfg.Token, botCfg.WebhookURL); err != nil {
-		return fmt.Errorf("set webhook: %w", err)
-	}
-	log.Printf("Webhook set to %s", botCfg.WebhookURL)
-
-	// Extract path from webhook URL for handler registration
-	u, err := url.Parse(botCfg.WebhookURL)
+	store, err := openConversationStore(botCfg.History)
 	if err != nil {
-		return fmt.Errorf("parse webhook URL: %w", err)
+		return err
 	}
-	hookPath := u.Path
-	if hookPath == "" {
-		hookPath = "/"
+	convStore = store
+
+	botSpeech = botCfg.Speech
+	botAskTimeout = botCfg.askTimeout()
+	if botSpeech.tts() == nil {
+		for name, u := range users {
+			if u.VoiceReplies {
+				log.Printf("User %s has voice_replies, but speech.tts is not configured", name)
+			}
+		}
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc(hookPath, func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
+	// Group chats need the bot's identity to tell whether they address it
+	var me *TGUser
+	if len(botCfg.Groups) > 0 {
+		if me, err = getMe(tgCfg.Token); err != nil {
+			return err
 		}
+		log.Printf("Group chats enabled: %d (bot @%s)", len(botCfg.Groups), me.Username)
+	}
+
+	installCommandMenu(tgCfg.Token, botCfg, users, mcpMgr)
+
+	queue := newJobQueue(telegramJobStatus(tgCfg.Token))
+	queue.start(botCfg.workers())
+	botQueue = queue
+	albums := newAlbumBuffer(albumWindow)
 
-		bodyBytes, readErr := io.ReadAll(r.Body)
-		if readErr != nil {
-			http.Error(w, "read error", http.StatusBadRequest)
+	// Scheduled jobs run through the queue, in the chat of their category
+	sched, err := newScheduler(users, schedulesPath, func(j *scheduledJob, done func()) {
+		chatID := userChatID(j.user, j.category(), 0)
+		if chatID == 0 {
+			log.Printf("Schedule %s: no chat for category %q in users.json", j.key(), j.category())
+			done()
 			return
 		}
+		queue.submit(&botJob{
+			chatID: chatID,
+			userID: j.user.TelegramID,
+			label:  j.userName,
+			text:   "⏰ " + j.Name,
+			run: func() {
+				defer done()
+				runScheduledJob(tgCfg.Token, cfg, modelID, showThinking, logf, promptsTemplate, defaultLang, verboseTools, newsConfigPath, mcpMgr, globalThink, j, chatID)
+			},
+			dropped: done,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("schedules: %w", err)
+	}
 
-		if requestDebug {
-			log.Printf("Telegram update: %s", string(bodyBytes))
+	// Reminders are sent as they are; prompt reminders run through the queue
+	if botReminders, err = tools.OpenReminderStore(remindersPath); err != nil {
+		return fmt.Errorf("reminders: %w", err)
+	}
+	deliverReminder := func(r tools.Reminder) {
+		if !r.Prompt {
+			if _, err := sendMessageWithKeyboard(tgCfg.Token, r.ChatID, "⏰ "+r.Text, reminderKeyboard(r.ID)); err != nil {
+				log.Printf("Error sending reminder %s to chat %d: %v", r.ID, r.ChatID, err)
+			}
+			return
+		}
+		u := users[r.User]
+		if u == nil {
+			log.Printf("Reminder %s: user %q is not in users.json", r.ID, r.User)
+			return
 		}
+		j := reminderJob(r, u)
+		queue.submit(&botJob{
+			chatID: r.ChatID,
+			userID: u.TelegramID,
+			label:  r.User,
+			text:   "⏰ " + j.Name,
+			run: func() {
+				runScheduledJob(tgCfg.Token, cfg, modelID, showThinking, logf, promptsTemplate, defaultLang, verboseTools, newsConfigPath, mcpMgr, globalThink, j, r.ChatID)
+			},
+		})
+	}
 
-		var update Update
-		if err := json.Unmarshal(bodyBytes, &update); err != nil {
-			http.Error(w, "bad request", http.StatusBadRequest)
+	// Home Assistant alerts are triaged through the queue, in the chat of
+	// their category or else the user's private chat
+	botHAAlerts = haAlertWatches(users)
+	fireHAAlert := func(ev tools.HATriggerEvent) {
+		if ev.Trigger < 0 || ev.Trigger >= len(botHAAlerts) {
 			return
 		}
+		w, now := botHAAlerts[ev.Trigger], time.Now()
+		if !w.due(now) {
+			return
+		}
+		chatID := userChatID(w.user, w.Chat, 0)
+		if chatID == 0 {
+			chatID = w.user.TelegramID
+		}
+		queue.submit(&botJob{
+			chatID: chatID,
+			userID: w.user.TelegramID,
+			label:  w.userName,
+			text:   "🏠 " + w.Name,
+			run: func() {
+				runHAAlert(tgCfg.Token, cfg, modelID, logf, promptsTemplate, defaultLang, globalThink, ev.Trigger, ev, now, chatID)
+			},
+		})
+	}
 
-		// Always respond 200 quickly to avoid Telegram retries
-		w.WriteHeader(http.StatusOK)
+	// New mail of watched mailboxes is classified through the queue
+	botMailWatches = mailWatches(users)
+	mailFound := func(index int) func(tools.ImapNewMessage) {
+		w := botMailWatches[index]
+		return func(msg tools.ImapNewMessage) {
+			queue.submit(&botJob{
+				chatID: w.chatID(),
+				userID: w.user.TelegramID,
+				label:  w.userName,
+				text:   "📬 " + msg.Subject,
+				run:    func() { runMailAlert(tgCfg.Token, index, msg) },
+			})
+		}
+	}
 
+	// dispatch routes one update; shared by the webhook and polling modes.
+	// It must not block: queries go to the queue.
+	dispatch := func(update *Update) {
 		// Handle callback queries (inline keyboard button presses)
 		if update.CallbackQuery != nil {
-			handleCallbackQuery(tgCfg.Token, update.CallbackQuery)
+			handleCallbackQuery(tgCfg.Token, update.CallbackQuery, queue, users)
+			return
+		}
+		if update.PollAnswer != nil {
+			handlePollAnswer(update.PollAnswer)
 			return
 		}
 
 		msg := update.Message
-		if msg == nil || (msg.Text == "" && len(msg.Photo) == 0 && !msg.hasVideo()) {
+		if msg == nil || (msg.Text == "" && len(msg.Photo) == 0 && !msg.hasVideo() && !msg.hasDocument() && !msg.hasAudio()) {
 			if requestDebug && msg != nil {
-				log.Printf("Message filtered out: text=%q photo=%d video=%v anim=%v doc=%v",
-					msg.Text, len(msg.Photo), msg.Video != nil, msg.Animation != nil, msg.Document != nil)
+				log.Printf("Message filtered out: text=%q photo=%d video=%v anim=%v doc=%v voice=%v",
+					msg.Text, len(msg.Photo), msg.Video != nil, msg.Animation != nil, msg.Document != nil, msg.Voice != nil)
 			}
 			return
 		}
 
+		// Groups: only enabled ones, and only messages addressed to the bot
+		// or answering the member's pending ask_user question (routed below)
+		var group *groupConfig
+		if isGroupChat(msg.Chat) {
+			if group = botCfg.group(msg.Chat.ID); group == nil || me == nil {
+				if requestDebug {
+					log.Printf("Message in group %d ignored: group not enabled", msg.Chat.ID)
+				}
+				return
+			}
+			if msg.From == nil {
+				return
+			}
+			text := &msg.Text
+			if *text == "" {
+				text = &msg.Caption
+			}
+			if addressed, ok := addressedText(*text, msg, me); ok {
+				*text = addressed
+				if msg.Text == "" && len(msg.Photo) == 0 && !msg.hasVideo() && !msg.hasDocument() && !msg.hasAudio() {
+					return // only the mention
+				}
+			} else if !albums.pending(msg) && (msg.Text == "" || !textQuestionPending(msg.Chat.ID, msg.From.ID)) {
+				return // the rest of an addressed album is taken
+			}
+		}
+
 		// Resolve user by Telegram ID
 		var user *UserConfig
 		var userName string
@@ -437,11 +816,16 @@ func runBot(tgCfg *telegramConfig, cfg modelConfig, modelID string,
 		}
 
 		// Access control
-		if user == nil && !botCfg.AllowUnregistered {
+		if user == nil && !botCfg.AllowUnregistered && (group == nil || !group.AllowUnregistered) {
 			log.Printf("Rejected message from unregistered user %d (%s)",
 				msg.From.ID, msg.From.Username)
 			return
 		}
+		// Unregistered users get their own usage ledger and quota entry
+		if user == nil && msg.From != nil {
+			userName = fmt.Sprintf("tg:%d", msg.From.ID)
+		}
+		quota := effectiveQuota(user, botCfg.DefaultQuota)
 
 		userLabel := "unknown"
 		if msg.From != nil {
@@ -457,54 +841,122 @@ func runBot(tgCfg *telegramConfig, cfg modelConfig, modelID string,
 		if logText == "" && msg.hasVideo() {
 			logText = "[video] " + msg.Caption
 		}
-		log.Printf("Message from %s (chat %d): %s", userLabel, msg.Chat.ID, truncate(logText, 100))
+		if logText == "" && msg.hasAudio() {
+			logText = "[voice] " + msg.Caption
+		}
+		if logText == "" && msg.hasDocument() {
+			logText = fmt.Sprintf("[document %s] %s", msg.Document.FileName, msg.Caption)
+		}
+		chatLabel := fmt.Sprintf("chat %d", msg.Chat.ID)
+		if group != nil && group.Name != "" {
+			chatLabel = fmt.Sprintf("group %q", group.Name)
+		}
+		log.Printf("Message from %s (%s): %s", userLabel, chatLabel, truncate(logText, 100))
+
+		// /cancel aborts the sender's running and queued queries in this chat
+		// (including ones waiting for an ask_user answer), so it is handled
+		// before answer routing; admins abort everyone's
+		text := strings.TrimSpace(msg.Text)
+		if text == "/cancel" || strings.HasPrefix(text, "/cancel@") {
+			by := cancellerOf(users, msg.From)
+			if n := cancelChatQueries(msg.Chat.ID, by) + queue.cancelChat(msg.Chat.ID, by); n > 0 {
+				_ = sendToChat(tgCfg.Token, msg.Chat.ID, fmt.Sprintf("Отменено запросов: %d", n))
+			} else {
+				_ = sendToChat(tgCfg.Token, msg.Chat.ID, "Нет выполняющихся запросов.")
+			}
+			return
+		}
 
-		// Check if there's a pending text question for this chat — route answer there
-		if pq := resolveTextQuestion(msg.Chat.ID); pq != nil && msg.Text != "" {
-			pq.ResultCh <- strings.TrimSpace(msg.Text)
+		// /jobs shows the queue to admins
+		if (text == "/jobs" || strings.HasPrefix(text, "/jobs@")) && user != nil && user.Admin {
+			_ = sendToChat(tgCfg.Token, msg.Chat.ID, queue.report(time.Now()))
 			return
 		}
 
-		// Process asynchronously
-		go handleBotMessage(tgCfg.Token, cfg, modelID, showThinking, logf, promptsTemplate, defaultLang, verboseTools, newsConfigPath, mcpMgr, globalThink, msg, user, userName)
-	})
+		// /schedules lists, pauses and triggers the user's scheduled jobs
+		if args := strings.Fields(text); len(args) > 0 && user != nil &&
+			(args[0] == "/schedules" || strings.HasPrefix(args[0], "/schedules@")) {
+			_ = sendToChat(tgCfg.Token, msg.Chat.ID, sched.command(userName, args[1:], time.Now()))
+			return
+		}
 
-	server := &http.Server{
-		Addr:    botCfg.Listen,
-		Handler: mux,
+		// Check if there's a pending text question for this chat — route answer there
+		var fromID int64
+		if msg.From != nil {
+			fromID = msg.From.ID
+		}
+		if msg.Text != "" {
+			if pq := resolveTextQuestion(msg.Chat.ID, fromID); pq != nil {
+				pq.ResultCh <- strings.TrimSpace(msg.Text)
+				return
+			}
+		}
+
+		submit := func(m *TGMessage) {
+			queue.submit(&botJob{
+				chatID: m.Chat.ID,
+				msgID:  m.MessageID,
+				userID: fromID,
+				label:  userLabel,
+				text:   logText,
+				run: func() {
+					handleBotMessage(tgCfg.Token, cfg, modelID, showThinking, logf, promptsTemplate, defaultLang, verboseTools, newsConfigPath, mcpMgr, globalThink, m, user, userName, quota, group)
+				},
+			})
+		}
+		// Photos of an album are collected and asked about together
+		if msg.MediaGroupID != "" && len(msg.Photo) > 0 {
+			albums.add(msg, submit)
+			return
+		}
+		submit(msg)
 	}
 
 	// Graceful shutdown
-	shutdownCh := make(chan os.Signal, 1)
-	signal.Notify(shutdownCh, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		<-shutdownCh
-		log.Println("Shutting down...")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		if err := deleteWebhook(tgCfg.Token); err != nil {
-			log.Printf("deleteWebhook error: %v", err)
-		} else {
-			log.Println("Webhook deleted")
+	if len(sched.jobs) > 0 {
+		log.Printf("Scheduled jobs: %d", len(sched.jobs))
+		sched.start(ctx)
+	}
+	watchReminders(ctx, botReminders, deliverReminder)
+	if len(botHAAlerts) > 0 {
+		triggers := make([]any, len(botHAAlerts))
+		for i, w := range botHAAlerts {
+			triggers[i] = w.trigger()
 		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("server shutdown error: %v", err)
+		log.Printf("Home Assistant alerts: %d", len(triggers))
+		go func() {
+			if err := tools.HAWatch(ctx, triggers, fireHAAlert, log.Printf); err != nil {
+				log.Printf("Home Assistant alerts: %v", err)
+			}
+		}()
+	}
+	for i, w := range botMailWatches {
+		sess := userSession(w.user, w.userName)
+		for _, mailbox := range w.mailboxes {
+			log.Printf("Mail watch %s: %s", w.userName, mailbox)
+			go func() {
+				if err := tools.WatchImap(ctx, sess, mailbox, mailFound(i), log.Printf); err != nil {
+					log.Printf("Mail watch %s: %v", w.userName, err)
+				}
+			}()
 		}
-	}()
+	}
 
-	log.Printf("Bot listening on %s", botCfg.Listen)
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		return fmt.Errorf("server: %w", err)
+	switch botCfg.Mode {
+	case "", botModeWebhook:
+		return serveWebhook(ctx, tgCfg.Token, botCfg, dispatch)
+	case botModePolling:
+		return pollUpdates(ctx, tgCfg.Token, botCfg.pollTimeout(), dispatch)
 	}
-	return nil
+	return fmt.Errorf("telegram config: unknown bot mode %q (known: %s, %s)", botCfg.Mode, botModeWebhook, botModePolling)
 }
 
 func handleBotMessage(token string, cfg modelConfig, modelID string,
 	showThinking bool, logf func(string, ...any), promptsTemplate *Prompts, defaultLang string,
-	verboseTools bool, newsConfigPath string, mcpMgr *MCPManager, globalThink thinkMode, msg *TGMessage, user *UserConfig, userName string) {
+	verboseTools bool, newsConfigPath string, mcpMgr *MCPManager, globalThink thinkMode, msg *TGMessage, user *UserConfig, userName string, quota *UserQuota, group *groupConfig) {
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -513,53 +965,36 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 		}
 	}()
 
-	// Set per-user overrides from user config
-	if user != nil {
-		if imapCfg := userImapConfig(user); imapCfg != nil {
-			tools.SetImapOverride(imapCfg)
-			defer tools.ClearImapOverride()
-		}
-		haEnabled := user.HA != nil && user.HA.Enabled
-		tools.SetHAEnabled(haEnabled)
-		defer tools.ClearHAEnabled()
-		if calCfg := userCalendarConfig(user); calCfg != nil {
-			tools.SetCalendarOverride(calCfg)
-			defer tools.ClearCalendarOverride()
-		}
-		if contactsCfg := userContactsConfig(user); contactsCfg != nil {
-			tools.SetContactsOverride(contactsCfg)
-			defer tools.ClearContactsOverride()
-		}
-		if user.Memory != "" {
-			tools.SetMemoryOverride(user.Memory)
-			defer tools.ClearMemoryOverride()
-			defer tools.ClearTempMemory()
-		}
-		if user.Userinfo != "" && userName != "" {
-			tools.SetUserInfoOverride(user.Userinfo, userName)
-			defer tools.ClearUserInfoOverride()
-		}
+	// Per-user tool session; ask_user and send_image go to this chat.
+	// In a group, only the asking member can answer ask_user.
+	sess := userSession(user, userName)
+	prompter := &TelegramPrompter{Token: token, ChatID: msg.Chat.ID}
+	if group != nil {
+		group.restrict(sess, user, userName, mcpMgr.ReadOnlyTools())
+		prompter.UserID = msg.From.ID
+	}
+	sess.Prompter = prompter
+	sess.AskTimeout = botAskTimeout
+	sess.ImageSender = &TelegramImageSender{Token: token, ChatID: msg.Chat.ID}
+	if botReminders != nil && user != nil {
+		sess.Reminders = &tools.Reminders{Store: botReminders, ChatID: msg.Chat.ID}
 	}
 
-	// Enable ask_user and send_image tools for Telegram sessions
-	tools.SetPrompter(&TelegramPrompter{Token: token, ChatID: msg.Chat.ID})
-	defer tools.ClearPrompter()
-	tools.SetImageSender(&TelegramImageSender{Token: token, ChatID: msg.Chat.ID})
-	defer tools.ClearImageSender()
-	defer tools.ClearSessionImages()
-
-	// Apply per-user language to prompts
+	// Apply per-user (or per-group) language to prompts
 	lang := defaultLang
 	if user != nil && user.Language != "" {
 		lang = user.Language
 	}
+	if group != nil && group.Language != "" {
+		lang = group.Language
+	}
 	prompts := *promptsTemplate // copy template
 	applyLanguage(&prompts, lang)
 	prompts.SystemPrompt += AskUserPromptHint
 	if user != nil && user.Memory != "" {
 		prompts.SystemPrompt += MemoryPromptHint
 	}
-	if tools.UserInfoAvailable() {
+	if sess.UserInfoAvailable() {
 		prompts.SystemPrompt += UserInfoPromptHint
 	}
 
@@ -573,29 +1008,134 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 	cancel := startTyping(token, chatID)
 	defer cancel()
 
-	// Use Caption as text when message has photo or video
+	// Cancellable via /cancel or the Stop button
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	var fromID int64
+	if msg.From != nil {
+		fromID = msg.From.ID
+	}
+	defer trackQuery(chatID, msg.MessageID, fromID, stop)()
+
+	// Token usage of this message goes to the user's usage ledger
+	ctx, meter := withUsageMeter(ctx)
+	usageMode := usageModeQuery
+	defer func() {
+		if err := saveQueryUsage(userName, usageMode, meter); err != nil {
+			log.Printf("Usage ledger error: %v", err)
+		}
+	}()
+
+	// Use Caption as text when message has photo, video, document or audio
 	text := strings.TrimSpace(msg.Text)
-	if text == "" && (len(msg.Photo) > 0 || msg.hasVideo()) {
+	if text == "" && (len(msg.Photo) > 0 || msg.hasVideo() || msg.hasDocument() || msg.hasAudio()) {
 		text = strings.TrimSpace(msg.Caption)
 	}
 
-	// Download photo if present
-	var images []ImageURL
-	if len(msg.Photo) > 0 {
-		// Telegram sends multiple sizes; last element is the largest
-		best := msg.Photo[len(msg.Photo)-1]
-		data, dlErr := downloadTelegramFile(token, best.FileID)
+	// Parse /model prefix before media handling (video frame extraction depends
+	// on the model config). Photos/videos use roles.vision unless a model is given.
+	modelName, text := parseModelPrefix(text)
+	if text == "/usage" || strings.HasPrefix(text, "/usage@") {
+		report, err := usageReport(userName, time.Now())
+		if err != nil {
+			report = fmt.Sprintf("Ошибка: %v", err)
+		}
+		// A member's ledger is not for the whole group: it goes to their private chat
+		if isGroupChat(msg.Chat) && msg.From != nil {
+			note := "Расход токенов отправлен в личный чат."
+			if err := sendToChat(token, msg.From.ID, report); err != nil {
+				log.Printf("Error sending usage to user %d: %v", msg.From.ID, err)
+				note = "Не удалось отправить расход в личный чат: сначала напишите боту в личку."
+			}
+			_ = sendToChat(token, chatID, note)
+			return
+		}
+		_ = sendToChat(token, chatID, report)
+		return
+	}
+	if modelName == "" && (text == "/model" || strings.HasPrefix(text, "/model ")) {
+		// "/model" or "/model name" without a query: list available models
+		_ = sendToChat(token, chatID, "Models (usage: /model name query):\n"+models.describe())
+		return
+	}
+	if modelName != "" {
+		var err error
+		cfg, modelID, err = models.get(modelName)
+		if err != nil {
+			_ = sendToChat(token, chatID, fmt.Sprintf("Model error: %v", err))
+			return
+		}
+	} else if len(msg.Photo) > 0 || msg.hasVideo() {
+		cfg, modelID = models.forRole(roleVision, cfg, modelID)
+	}
+
+	// Enforce the user's request and token quota (admins have none)
+	if text != "/start" && text != "/help" {
+		var err error
+		if ctx, err = admitQuery(ctx, userName, quota, meter, time.Now()); err != nil {
+			log.Printf("Quota: message %d from %s rejected: %v", msg.MessageID, usageUser(userName), err)
+			_ = sendToChat(token, chatID, "⛔ "+err.Error())
+			return
+		}
+		// Shown before downloads, transcription and document processing,
+		// which can take longer than the answer
+		defer showStopButton(token, chatID, msg.MessageID)()
+	}
+
+	// Voice and audio are transcribed and handled as if typed, after the
+	// caption if any; the transcript is shown to the user
+	if msg.hasAudio() {
+		stt := botSpeech.stt()
+		if stt == nil {
+			_ = sendToChat(token, chatID, "Распознавание речи не настроено.")
+			return
+		}
+		fileID, fileName := msg.audioFile()
+		data, dlErr := downloadTelegramFile(token, fileID)
 		if dlErr != nil {
-			log.Printf("Error downloading photo for message %d: %v", msg.MessageID, dlErr)
-			_ = sendToChat(token, chatID, fmt.Sprintf("Ошибка загрузки фото: %v", dlErr))
+			log.Printf("Error downloading audio for message %d: %v", msg.MessageID, dlErr)
+			_ = sendToChat(token, chatID, fmt.Sprintf("Ошибка загрузки аудио: %v", dlErr))
 			return
 		}
-		mime := http.DetectContentType(data)
-		b64 := base64.StdEncoding.EncodeToString(data)
-		images = append(images, ImageURL{URL: fmt.Sprintf("data:%s;base64,%s", mime, b64)})
+		transcript, sttErr := stt.transcribe(ctx, fileName, data)
+		if ctx.Err() != nil {
+			_ = sendToChat(token, chatID, "Запрос отменён.")
+			return
+		}
+		if sttErr != nil {
+			log.Printf("Error transcribing message %d: %v", msg.MessageID, sttErr)
+			_ = sendToChat(token, chatID, fmt.Sprintf("Ошибка распознавания речи: %v", sttErr))
+			return
+		}
+		if transcript == "" {
+			_ = sendToChat(token, chatID, "Речь не распознана.")
+			return
+		}
+		if _, err := sendBotReply(token, chatID, "🎤 "+transcript, msg.MessageID); err != nil {
+			log.Printf("Error sending transcript to chat %d: %v", chatID, err)
+		}
+		text = strings.TrimSpace(text + "\n" + transcript)
+	}
+
+	// Download photos if present (all photos of an album)
+	var images []ImageURL
+	if photos := msg.photoFileIDs(); len(photos) > 0 {
+		for _, fileID := range photos {
+			data, dlErr := downloadTelegramFile(token, fileID)
+			if dlErr != nil {
+				log.Printf("Error downloading photo for message %d: %v", msg.MessageID, dlErr)
+				_ = sendToChat(token, chatID, fmt.Sprintf("Ошибка загрузки фото: %v", dlErr))
+				return
+			}
+			mime := http.DetectContentType(data)
+			b64 := base64.StdEncoding.EncodeToString(data)
+			images = append(images, ImageURL{URL: fmt.Sprintf("data:%s;base64,%s", mime, b64)})
+		}
 
 		// Default prompt if no caption
-		if text == "" {
+		if text == "" && len(photos) > 1 {
+			text = "Опиши эти изображения."
+		} else if text == "" {
 			text = "Опиши это изображение."
 		}
 	}
@@ -641,14 +1181,45 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 			text += fmt.Sprintf("\n\n=== Video Overview (%d frames from %s to %s, interval ~%.1fs) ===\n"+
 				"Use video_get_frames to zoom into specific time ranges at higher density/resolution.",
 				len(frames), tools.FormatTimestamp(0), tools.FormatTimestamp(duration), interval)
-			tools.SetVideoState(tmpPath, duration, cfg.VideoAsFrames.FrameWidth, cfg.VideoAsFrames.MaxFrames)
-			defer tools.ClearVideoState()
+			sess.SetVideoState(tmpPath, duration, cfg.VideoAsFrames.FrameWidth, cfg.VideoAsFrames.MaxFrames)
 		} else {
 			b64 := base64.StdEncoding.EncodeToString(data)
 			videos = append(videos, VideoURL{URL: fmt.Sprintf("data:%s;base64,%s", mimeType, b64)})
 		}
 	}
 
+	// Extract text of a document (PDF, DOCX, XLSX, text); it is attached to
+	// the query once the question is parsed
+	var docName, docText string
+	if msg.hasDocument() {
+		doc := msg.Document
+		docName = doc.FileName
+		if docName == "" {
+			docName = "document"
+		}
+		if doc.FileSize > maxDocumentSize {
+			_ = sendToChat(token, chatID, fmt.Sprintf("Файл слишком большой (%d МБ), максимум %d МБ.", doc.FileSize>>20, maxDocumentSize>>20))
+			return
+		}
+		data, dlErr := downloadTelegramFile(token, doc.FileID)
+		if dlErr != nil {
+			log.Printf("Error downloading document for message %d: %v", msg.MessageID, dlErr)
+			_ = sendToChat(token, chatID, fmt.Sprintf("Ошибка загрузки файла: %v", dlErr))
+			return
+		}
+		var exErr error
+		if docText, exErr = extractDocument(docName, doc.MimeType, data); exErr != nil {
+			log.Printf("Error reading document %q for message %d: %v", docName, msg.MessageID, exErr)
+			_ = sendToChat(token, chatID, fmt.Sprintf("Не удалось прочитать файл %s: %v", docName, exErr))
+			return
+		}
+
+		// Default prompt if no caption
+		if text == "" {
+			text = "Кратко перескажи содержание этого документа."
+		}
+	}
+
 	// Parse /think and /nothink prefixes (before /mcp)
 	thinkPrefix, text := parseThinkPrefix(text)
 	noThinkPrefix, text := parseNothinkPrefix(text)
@@ -679,6 +1250,9 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 		skillNames = chainSkills
 		mcpNames = chainMCP
 	}
+	if group != nil && len(skillNames) == 0 {
+		skillNames = group.Skills
+	}
 
 	var skillMCPNames []string
 	if len(skillNames) > 0 {
@@ -702,7 +1276,25 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 		}
 	}
 
+	// Attach the document; the stored user message keeps it for replies
+	if docText != "" {
+		notify := func(s string) { _ = sendToChat(token, chatID, s) }
+		attachment, docErr := documentAttachment(ctx, docName, docText, text, prompts.DocumentSummarize, notify)
+		if ctx.Err() != nil {
+			_ = sendToChat(token, chatID, "Запрос отменён.")
+			return
+		}
+		if docErr != nil {
+			log.Printf("Error processing document %q for message %d: %v", docName, msg.MessageID, docErr)
+			_ = sendToChat(token, chatID, fmt.Sprintf("Ошибка обработки файла %s: %v", docName, docErr))
+			return
+		}
+		text += attachment
+	}
+
 	var result string
+	var turn []Message // tool rounds of a free-form query, stored with the reply
+	var live *liveReply // streamed answer of a free-form query
 	var err error
 
 	// Content output: stderr for debugging (unless quiet)
@@ -714,6 +1306,10 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 	// Check for registered commands (e.g. /eat)
 	if cmdName, cmdText := parseCommandName(text); cmdName != "" {
 		if cmd := tools.GetCommand(cmdName); cmd != nil {
+			if !sess.AllowsCommand(cmd) {
+				_ = sendToChat(token, chatID, fmt.Sprintf("Команда /%s здесь недоступна.", cmdName))
+				return
+			}
 			// Init command's MCP servers
 			if mcpMgr != nil && len(cmd.MCPServers) > 0 {
 				if initErr := mcpMgr.InitServers(cmd.MCPServers); initErr != nil {
@@ -731,8 +1327,12 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 				cmdImages = append(cmdImages, img.URL)
 			}
 
-			ctx := &tools.CommandContext{Text: cmdText, Images: cmdImages}
-			result, err = cmd.Handler(ctx)
+			cmdCtx := &tools.CommandContext{Text: cmdText, Images: cmdImages, Context: ctx, Session: sess}
+			result, err = cmd.Handler(cmdCtx)
+			if ctx.Err() != nil {
+				_ = sendToChat(token, chatID, "Запрос отменён.")
+				return
+			}
 			if err != nil {
 				log.Printf("Command /%s error: %v", cmdName, err)
 				_ = sendToChat(token, chatID, fmt.Sprintf("Ошибка /%s: %v", cmdName, err))
@@ -740,12 +1340,12 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 			}
 
 			// Store and send reply
-			storeMessage(chatID, msg.MessageID, "user", text, 0, nil, cmd.MCPServers)
+			storeMessage(chatID, msg.MessageID, &storedMessage{Role: "user", Content: text, MCPNames: cmd.MCPServers})
 			sentMsgID, sendErr := sendBotReply(token, chatID, result, msg.MessageID)
 			if sendErr != nil {
 				log.Printf("Error sending command response to chat %d: %v", chatID, sendErr)
 			} else if sentMsgID != 0 {
-				storeMessage(chatID, sentMsgID, "assistant", result, msg.MessageID, nil, cmd.MCPServers)
+				storeMessage(chatID, sentMsgID, &storedMessage{Role: "assistant", Content: result, ReplyToMsgID: msg.MessageID, MCPNames: cmd.MCPServers})
 			}
 			return
 		}
@@ -753,29 +1353,31 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 
 	switch {
 	case text == "/news" || strings.HasPrefix(text, "/news "):
+		usageMode = usageModeNews
 		newsArg := strings.TrimSpace(strings.TrimPrefix(text, "/news"))
 		if newsArg == "" {
-			result, err = runNewsSummary(cfg, modelID, showThinking, debugOut, logf, newsConfigPath, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
+			result, err = runNewsSummary(ctx, cfg, modelID, showThinking, debugOut, logf, newsConfigPath, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
 		} else {
 			// Try to match a category for browse mode
 			categories, catErr := readNewsConfig(newsConfigPath)
 			if catErr != nil {
 				err = catErr
 			} else if cat := matchCategory(newsArg, categories); cat != nil {
-				prompter := tools.GetPrompter()
+				prompter := sess.Prompter
 				if prompter == nil {
 					// Fallback: no prompter, do full category summary without interaction
-					result, err = runNewsSummary(cfg, modelID, showThinking, debugOut, logf, newsConfigPath, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
+					result, err = runNewsSummary(ctx, cfg, modelID, showThinking, debugOut, logf, newsConfigPath, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
 				} else {
-					result, err = runNewsBrowse(cfg, modelID, cat, showThinking, debugOut, logf, &prompts, mcpMgr, mcpNames, think, mcpOverrides, prompter)
+					result, err = runNewsBrowse(ctx, cfg, modelID, cat, showThinking, debugOut, logf, &prompts, mcpMgr, mcpNames, think, mcpOverrides, prompter)
 				}
 			} else {
 				// Free text search
-				result, err = runNewsSearch(cfg, modelID, newsArg, showThinking, debugOut, logf, newsConfigPath, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
+				result, err = runNewsSearch(ctx, cfg, modelID, newsArg, showThinking, debugOut, logf, newsConfigPath, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
 			}
 		}
 
 	case text == "/mail" || strings.HasPrefix(text, "/mail "):
+		usageMode = usageModeMail
 		sinceHours := 24.0
 		parts := strings.Fields(text)
 		if len(parts) >= 2 {
@@ -783,13 +1385,16 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 				sinceHours = h
 			}
 		}
-		result, err = runMailSummary(cfg, modelID, showThinking, debugOut, logf, &prompts, sinceHours, mcpMgr, mcpNames, think, mcpOverrides)
+		result, err = runMailSummary(ctx, sess, cfg, modelID, showThinking, debugOut, logf, &prompts, sinceHours, mcpMgr, mcpNames, think, mcpOverrides)
 
 	default:
 		query := text
 		if query == "/start" || query == "/help" {
-			query = "Привет! Чем могу помочь? Доступные команды: /news — дайджест новостей, /mail [часы] — дайджест почты, /mcp сервер запрос — с MCP-инструментами, /think — включить reasoning, /nothink — отключить reasoning, или отправь любой вопрос."
-			_ = sendToChat(token, chatID, query)
+			help := helpText(commandMenu(user, mcpMgr, listSkills(skillDirs)), sess, mcpMgr, mcpOverrides)
+			if query == "/start" {
+				help = "Привет! Чем могу помочь?\n\n" + help
+			}
+			_ = sendToChat(token, chatID, help)
 			return
 		}
 
@@ -798,7 +1403,13 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 		if msg.ReplyToMessage != nil {
 			userReplyToMsgID = msg.ReplyToMessage.MessageID
 		}
-		storeMessage(chatID, msg.MessageID, "user", query, userReplyToMsgID, skillNames, mcpNames)
+		userMsg := &storedMessage{Role: "user", Content: query, ReplyToMsgID: userReplyToMsgID, SkillNames: skillNames, MCPNames: mcpNames}
+		if len(msg.Photo) > 0 { // video frames are only useful within this query
+			for _, img := range images {
+				userMsg.Images = append(userMsg.Images, img.URL)
+			}
+		}
+		storeMessage(chatID, msg.MessageID, userMsg)
 
 		// Build conversation chain if this is a reply
 		var history []Message
@@ -820,20 +1431,33 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 			}
 		}
 
-		var contentBuf strings.Builder
-		contentOut := io.MultiWriter(&contentBuf, debugOut)
+		live = newLiveReply(telegramLiveAPI(token), chatID, msg.MessageID, debugOut, liveEditInterval)
+		defer live.close()
 		activeModules := append(append([]string{}, skillNames...), mcpNames...)
-		result, err = runQuery(cfg, modelID, query, showThinking, verboseTools, contentOut, logf, &prompts, mcpMgr, mcpNames, think, images, videos, history, mcpOverrides, activeModules)
-		// runQuery returns only the last round's content; contentBuf has
+		result, turn, err = runQuery(ctx, sess, cfg, modelID, query, showThinking, verboseTools, live, logf, &prompts, mcpMgr, mcpNames, think, images, videos, history, mcpOverrides, activeModules)
+		// runQuery returns only the last round's content; the live reply has
 		// accumulated content from ALL rounds (including intermediate tool-calling
 		// rounds). Use it as fallback when the final response is empty.
 		if err == nil && strings.TrimSpace(stripThinkTags(result)) == "" {
-			if s := strings.TrimSpace(contentBuf.String()); s != "" {
+			if s := strings.TrimSpace(live.text()); s != "" {
 				result = s
 			}
 		}
 	}
 
+	if live != nil && (err != nil || ctx.Err() != nil) {
+		live.discard()
+	}
+	if ctx.Err() != nil {
+		log.Printf("Message %d cancelled", msg.MessageID)
+		_ = sendToChat(token, chatID, "Запрос отменён.")
+		return
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		log.Printf("Quota: message %d from %s stopped: %v", msg.MessageID, usageUser(userName), err)
+		_ = sendToChat(token, chatID, "⛔ "+err.Error())
+		return
+	}
 	if err != nil {
 		log.Printf("Error processing message %d: %v", msg.MessageID, err)
 		_ = sendToChat(token, chatID, fmt.Sprintf("Ошибка: %v", err))
@@ -844,11 +1468,21 @@ func handleBotMessage(token string, cfg modelConfig, modelID string,
 	if strings.TrimSpace(reply) == "" {
 		reply = "(Модель не вернула текстовый ответ — возможно, tool-вызов остался в reasoning. Попробуйте /nothink.)"
 	}
-	sentMsgID, sendErr := sendBotReply(token, chatID, reply, msg.MessageID)
+	var sentMsgID int64
+	var sendErr error
+	if live != nil {
+		sentMsgID, sendErr = live.finish(reply)
+	} else {
+		sentMsgID, sendErr = sendBotReply(token, chatID, reply, msg.MessageID)
+	}
 	if sendErr != nil {
 		log.Printf("Error sending response to chat %d: %v", chatID, sendErr)
 	} else if sentMsgID != 0 {
-		storeMessage(chatID, sentMsgID, "assistant", reply, msg.MessageID, skillNames, mcpNames)
+		storeMessage(chatID, sentMsgID, &storedMessage{Role: "assistant", Content: reply, ReplyToMsgID: msg.MessageID,
+			SkillNames: skillNames, MCPNames: mcpNames, Turn: storedTurn(turn)})
+	}
+	if user != nil && user.VoiceReplies {
+		sendVoiceReply(ctx, token, chatID, reply, msg.MessageID)
 	}
 }
 
@@ -859,64 +1493,29 @@ func truncate(s string, n int) string {
 	return s[:n] + "..."
 }
 
-// Conversation threading: in-memory message store.
+// Conversation threading (see convstore.go for the store).
 
-type storedMessage struct {
-	Role         string // "user" or "assistant"
-	Content      string
-	ReplyToMsgID int64
-	SkillNames   []string // skill names active when this message was sent
-	MCPNames     []string // MCP server names active when this message was sent
-}
+// convStore holds the bot's chats; runBot replaces it with the configured store.
+var convStore conversationStore = newMemoryConvStore((*historyConfig)(nil).retention)
 
-var botMessages = struct {
-	sync.RWMutex
-	chats map[int64]map[int64]*storedMessage // chatID → messageID → msg
-}{chats: make(map[int64]map[int64]*storedMessage)}
-
-const maxStoredPerChat = 1000
 const maxChainDepth = 20
 
-func storeMessage(chatID, messageID int64, role, content string, replyToMsgID int64, skillNames, mcpNames []string) {
-	botMessages.Lock()
-	defer botMessages.Unlock()
-	if botMessages.chats[chatID] == nil {
-		botMessages.chats[chatID] = make(map[int64]*storedMessage)
-	}
-	chat := botMessages.chats[chatID]
-	chat[messageID] = &storedMessage{
-		Role:         role,
-		Content:      content,
-		ReplyToMsgID: replyToMsgID,
-		SkillNames:   skillNames,
-		MCPNames:     mcpNames,
-	}
-	// Evict oldest if too many
-	if len(chat) > maxStoredPerChat {
-		var minID int64
-		for id := range chat {
-			if minID == 0 || id < minID {
-				minID = id
-			}
-		}
-		delete(chat, minID)
+// storeMessage saves a message for reply-chain threading.
+func storeMessage(chatID, messageID int64, m *storedMessage) {
+	m.Time = time.Now()
+	if err := convStore.Put(chatID, messageID, m); err != nil {
+		log.Printf("History store error (chat %d): %v", chatID, err)
 	}
 }
 
 // findChainContext walks the reply chain to find the first message
 // that has skill/MCP context (i.e., the message that started the skill session).
 func findChainContext(chatID, replyToMsgID int64) (skillNames, mcpNames []string) {
-	botMessages.RLock()
-	defer botMessages.RUnlock()
-	chat := botMessages.chats[chatID]
-	if chat == nil {
-		return nil, nil
-	}
 	seen := make(map[int64]bool)
 	msgID := replyToMsgID
 	for msgID != 0 && !seen[msgID] && len(seen) < maxChainDepth {
 		seen[msgID] = true
-		stored, ok := chat[msgID]
+		stored, ok := convStore.Get(chatID, msgID)
 		if !ok {
 			break
 		}
@@ -928,28 +1527,25 @@ func findChainContext(chatID, replyToMsgID int64) (skillNames, mcpNames []string
 	return nil, nil
 }
 
+// buildConversationChain returns the reply chain ending at replyToMsgID as
+// history, oldest first, including stored tool rounds and images.
 func buildConversationChain(chatID, replyToMsgID int64) []Message {
-	botMessages.RLock()
-	defer botMessages.RUnlock()
-	chat := botMessages.chats[chatID]
-	if chat == nil {
-		return nil
-	}
-	var chain []Message
+	var chain []*storedMessage
 	seen := make(map[int64]bool)
 	msgID := replyToMsgID
 	for msgID != 0 && !seen[msgID] && len(chain) < maxChainDepth {
 		seen[msgID] = true
-		stored, ok := chat[msgID]
+		stored, ok := convStore.Get(chatID, msgID)
 		if !ok {
 			break
 		}
-		chain = append(chain, Message{Role: stored.Role, Content: stored.Content})
+		chain = append(chain, stored)
 		msgID = stored.ReplyToMsgID
 	}
-	// Reverse for chronological order
-	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
-		chain[i], chain[j] = chain[j], chain[i]
+	// Expand in chronological order
+	var history []Message
+	for i := len(chain) - 1; i >= 0; i-- {
+		history = append(history, chain[i].messages()...)
 	}
-	return chain
+	return history
 }
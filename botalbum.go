@@ -0,0 +1,110 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Albums
+//
+// Telegram delivers an album (media group) as one message per item, all
+// with the same media_group_id. The photos of an album are collected until
+// none arrived for albumWindow and then handled as one query: the album's
+// first message, carrying the caption and all the photos.
+
+const albumWindow = time.Second
+
+type albumKey struct {
+	chatID  int64
+	groupID string
+}
+
+type pendingAlbum struct {
+	msgs   []*TGMessage
+	timer  *time.Timer
+	submit func(*TGMessage) // of the first message that arrived
+}
+
+// albumBuffer collects the photos of albums.
+type albumBuffer struct {
+	mu     sync.Mutex
+	window time.Duration
+	albums map[albumKey]*pendingAlbum
+}
+
+func newAlbumBuffer(window time.Duration) *albumBuffer {
+	return &albumBuffer{window: window, albums: map[albumKey]*pendingAlbum{}}
+}
+
+// add buffers a photo of an album. Once the album is complete, the submit
+// func given with its first photo is called with the merged message.
+func (b *albumBuffer) add(msg *TGMessage, submit func(*TGMessage)) {
+	key := albumKey{msg.Chat.ID, msg.MediaGroupID}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if a, ok := b.albums[key]; ok {
+		a.msgs = append(a.msgs, msg)
+		a.timer.Reset(b.window)
+		return
+	}
+	b.albums[key] = &pendingAlbum{
+		msgs:   []*TGMessage{msg},
+		timer:  time.AfterFunc(b.window, func() { b.flush(key) }),
+		submit: submit,
+	}
+}
+
+// pending reports whether msg belongs to an album being collected.
+func (b *albumBuffer) pending(msg *TGMessage) bool {
+	if msg.MediaGroupID == "" {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.albums[albumKey{msg.Chat.ID, msg.MediaGroupID}]
+	return ok
+}
+
+func (b *albumBuffer) flush(key albumKey) {
+	b.mu.Lock()
+	a := b.albums[key]
+	delete(b.albums, key)
+	b.mu.Unlock()
+	if a != nil {
+		a.submit(mergeAlbum(a.msgs))
+	}
+}
+
+// mergeAlbum returns a copy of the album's first message with the others
+// attached; the caption and the replied-to message come from whichever item
+// has them.
+func mergeAlbum(msgs []*TGMessage) *TGMessage {
+	msgs = slices.Clone(msgs)
+	slices.SortFunc(msgs, func(a, b *TGMessage) int { return cmp.Compare(a.MessageID, b.MessageID) })
+	lead := *msgs[0]
+	lead.album = msgs[1:]
+	for _, m := range lead.album {
+		if lead.Caption == "" {
+			lead.Caption = m.Caption
+		}
+		if lead.ReplyToMessage == nil {
+			lead.ReplyToMessage = m.ReplyToMessage
+		}
+	}
+	return &lead
+}
+
+// photoFileIDs returns the file ID of the largest size of each photo of the
+// message and its album.
+func (m *TGMessage) photoFileIDs() []string {
+	var ids []string
+	for _, msg := range append([]*TGMessage{m}, m.album...) {
+		if n := len(msg.Photo); n > 0 {
+			// Telegram sends multiple sizes; last element is the largest
+			ids = append(ids, msg.Photo[n-1].FileID)
+		}
+	}
+	return ids
+}
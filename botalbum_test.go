@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func albumPhoto(id int64, group, fileID, caption string) *TGMessage {
+	return &TGMessage{
+		MessageID:    id,
+		Chat:         TGChat{ID: 1},
+		Photo:        []TGPhotoSize{{FileID: fileID + "-small"}, {FileID: fileID}},
+		Caption:      caption,
+		MediaGroupID: group,
+	}
+}
+
+func TestAlbumBuffer(t *testing.T) {
+	b := newAlbumBuffer(50 * time.Millisecond)
+	got := make(chan *TGMessage, 2)
+	submit := func(m *TGMessage) { got <- m }
+	other := func(m *TGMessage) { t.Errorf("submit of a later photo called for %d", m.MessageID) }
+
+	b.add(albumPhoto(11, "g1", "meal", ""), submit)
+	b.add(albumPhoto(10, "g1", "dish", ""), other)
+	b.add(albumPhoto(12, "g1", "label", "/eat обед"), other)
+	b.add(albumPhoto(20, "g2", "cat", "кто это?"), submit)
+	if !b.pending(&TGMessage{Chat: TGChat{ID: 1}, MediaGroupID: "g1"}) || b.pending(&TGMessage{Chat: TGChat{ID: 2}, MediaGroupID: "g1"}) {
+		t.Error("pending")
+	}
+
+	byID := map[int64]*TGMessage{}
+	for range 2 {
+		select {
+		case m := <-got:
+			byID[m.MessageID] = m
+		case <-time.After(2 * time.Second):
+			t.Fatal("album not submitted")
+		}
+	}
+	album := byID[10]
+	if album == nil || album.Caption != "/eat обед" {
+		t.Fatalf("album = %+v", album)
+	}
+	if ids := album.photoFileIDs(); !slices.Equal(ids, []string{"dish", "meal", "label"}) {
+		t.Errorf("photos = %v", ids)
+	}
+	if single := byID[20]; single == nil || !slices.Equal(single.photoFileIDs(), []string{"cat"}) {
+		t.Errorf("single-photo album = %+v", single)
+	}
+	if b.pending(albumPhoto(13, "g1", "x", "")) {
+		t.Error("album still pending after submit")
+	}
+}
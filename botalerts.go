@@ -0,0 +1,354 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+// Home Assistant alerts
+//
+// Users with Home Assistant can have alerts in users.json
+// ("homeassistant": {"alerts": [...]}): a trigger — a state change of some
+// entities or any Home Assistant trigger — plus when and how to report it.
+// The bot subscribes to all triggers over one WebSocket connection
+// (tools.HAWatch). A firing inside the alert's hours and outside its
+// cooldown runs a triage sub-agent through the queue; it may read states
+// and camera snapshots and decides whether the user is notified. Alerts
+// carry buttons for the alert's Home Assistant actions.
+
+// haAlertCallbackPrefix marks alert action buttons ("ha:<id>", see actionID).
+const haAlertCallbackPrefix = "ha:"
+
+const defaultHAAlertCooldown = 5 * time.Minute
+
+// HAAlert is a Home Assistant event the bot reports to a user.
+type HAAlert struct {
+	Name        string          `json:"name"`
+	Entities    []string        `json:"entities,omitempty"` // state trigger on these entities...
+	From        string          `json:"from,omitempty"`     // ...from this state
+	To          string          `json:"to,omitempty"`       // ...to this state
+	Trigger     json.RawMessage `json:"trigger,omitempty"`  // any Home Assistant trigger instead
+	Hours       string          `json:"hours,omitempty"`    // active hours as a cron hour field, e.g. "22-23,0-6" (default: always)
+	Timezone    string          `json:"timezone,omitempty"` // IANA zone of hours (default: local)
+	Cameras     []string        `json:"cameras,omitempty"`  // cameras the triage may look at
+	Prompt      string          `json:"prompt,omitempty"`   // triage instructions: when to notify
+	Actions     []HAAlertAction `json:"actions,omitempty"`  // buttons under the alert
+	Chat        string          `json:"chat,omitempty"`     // chats category (default: other)
+	CooldownMin int             `json:"cooldown_min,omitempty"`
+}
+
+// HAAlertAction is a button under an alert that calls a service.
+type HAAlertAction struct {
+	Label    string         `json:"label"`
+	Service  string         `json:"service"` // "domain.service", e.g. "light.turn_on"
+	EntityID string         `json:"entity_id"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// haAlertWatch is an alert of a user being watched.
+type haAlertWatch struct {
+	HAAlert
+	userName string
+	user     *UserConfig
+	hours    uint64 // bit set of active hours; 0 = always
+	loc      *time.Location
+	cooldown time.Duration
+
+	mu   sync.Mutex
+	last time.Time // last triage
+}
+
+// botHAAlerts are the alerts watched by the running bot.
+var botHAAlerts []*haAlertWatch
+
+func (w *haAlertWatch) key() string { return w.userName + "/" + w.Name }
+
+func newHAAlertWatch(userName string, u *UserConfig, a HAAlert) (*haAlertWatch, error) {
+	if a.Name == "" {
+		return nil, fmt.Errorf("alert without a name")
+	}
+	if len(a.Trigger) == 0 && len(a.Entities) == 0 {
+		return nil, fmt.Errorf("alert %s: entities or trigger is required", a.Name)
+	}
+	for _, act := range a.Actions {
+		if domain, service, ok := strings.Cut(act.Service, "."); !ok || domain == "" || service == "" || act.Label == "" || act.EntityID == "" {
+			return nil, fmt.Errorf("alert %s: action %q needs label, service \"domain.service\" and entity_id", a.Name, act.Label)
+		}
+	}
+	w := &haAlertWatch{HAAlert: a, userName: userName, user: u, loc: time.Local, cooldown: defaultHAAlertCooldown}
+	if a.Hours != "" {
+		hours, err := parseCronField(a.Hours, 0, 23)
+		if err != nil {
+			return nil, fmt.Errorf("alert %s: hours: %w", a.Name, err)
+		}
+		w.hours = hours
+	}
+	if a.Timezone != "" {
+		loc, err := time.LoadLocation(a.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("alert %s: %w", a.Name, err)
+		}
+		w.loc = loc
+	}
+	if a.CooldownMin > 0 {
+		w.cooldown = time.Duration(a.CooldownMin) * time.Minute
+	}
+	return w, nil
+}
+
+// haAlertWatches returns the valid alerts of users with Home Assistant
+// enabled, sorted by user and name. The order matters: a watch's index is the
+// HATriggerEvent.Trigger of its subscription.
+func haAlertWatches(users map[string]*UserConfig) []*haAlertWatch {
+	var watches []*haAlertWatch
+	for name, u := range users {
+		if u.HA == nil || !u.HA.Enabled {
+			continue
+		}
+		for _, a := range u.HA.Alerts {
+			w, err := newHAAlertWatch(name, u, a)
+			if err != nil {
+				log.Printf("User %s: %v", name, err)
+				continue
+			}
+			watches = append(watches, w)
+		}
+	}
+	slices.SortFunc(watches, func(a, b *haAlertWatch) int {
+		return cmp.Or(cmp.Compare(a.userName, b.userName), cmp.Compare(a.Name, b.Name))
+	})
+	return watches
+}
+
+// trigger is the alert's Home Assistant trigger config.
+func (w *haAlertWatch) trigger() any {
+	if len(w.Trigger) > 0 {
+		return w.Trigger
+	}
+	t := map[string]any{"platform": "state", "entity_id": w.Entities}
+	if w.From != "" {
+		t["from"] = w.From
+	}
+	if w.To != "" {
+		t["to"] = w.To
+	}
+	return t
+}
+
+// due reports whether an event at now is triaged: it is inside the active
+// hours and the cooldown since the last one has passed.
+func (w *haAlertWatch) due(now time.Time) bool {
+	if w.hours != 0 && w.hours&(1<<now.In(w.loc).Hour()) == 0 {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.last.IsZero() && now.Sub(w.last) < w.cooldown {
+		return false
+	}
+	w.last = now
+	return true
+}
+
+// describe is the event as given to the triage and, if that fails, to the user.
+func (w *haAlertWatch) describe(ev tools.HATriggerEvent, now time.Time) string {
+	what := ev.Description
+	if ev.EntityID != "" {
+		what = ev.EntityID
+		if ev.Name != "" {
+			what += " (" + ev.Name + ")"
+		}
+		if ev.FromState != "" || ev.ToState != "" {
+			what += fmt.Sprintf(": %s → %s", ev.FromState, ev.ToState)
+		}
+	}
+	return fmt.Sprintf("%s: %s, %s", w.Name, what, now.In(w.loc).Format("02.01 15:04"))
+}
+
+func (w *haAlertWatch) keyboard() any {
+	var rows [][]TGInlineKeyboardButton
+	for _, act := range w.Actions {
+		rows = append(rows, []TGInlineKeyboardButton{{
+			Text:         act.Label,
+			CallbackData: haAlertCallbackPrefix + w.actionID(act),
+		}})
+	}
+	return TGInlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// actionID names an action button by what it does rather than by position,
+// so that a button sent before users.json was edited cannot run another
+// action: once the action changes, its old buttons match nothing.
+func (w *haAlertWatch) actionID(act HAAlertAction) string {
+	data, _ := json.Marshal(act.Data)
+	return callbackHash(w.userName, w.Name, act.Service, act.EntityID, string(data))
+}
+
+// haAlertAction finds the alert and action of a button ID.
+func haAlertAction(id string) (*haAlertWatch, HAAlertAction, bool) {
+	for _, w := range botHAAlerts {
+		for _, act := range w.Actions {
+			if w.actionID(act) == id {
+				return w, act, true
+			}
+		}
+	}
+	return nil, HAAlertAction{}, false
+}
+
+// callbackHash is a short stable hash of parts for button callback data,
+// which Telegram limits to 64 bytes.
+func callbackHash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// haTriage is the triage sub-agent's decision.
+type haTriage struct {
+	Notify         bool   `json:"notify"`
+	Message        string `json:"message"`
+	AttachSnapshot bool   `json:"attach_snapshot"`
+}
+
+// runHAAlert triages an event of alert index and notifies chatID if the
+// triage says so. When the triage fails the user is notified anyway: a
+// missed leak is worse than a needless message.
+func runHAAlert(token string, cfg modelConfig, modelID string, logf func(string, ...any), promptsTemplate *Prompts,
+	defaultLang string, globalThink thinkMode, index int, ev tools.HATriggerEvent, at time.Time, chatID int64) {
+
+	w := botHAAlerts[index]
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	defer trackQuery(chatID, 0, w.user.TelegramID, stop)()
+	ctx, meter := withUsageMeter(ctx)
+	defer func() {
+		if err := saveQueryUsage(w.userName, usageModeQuery, meter); err != nil {
+			log.Printf("Usage ledger error: %v", err)
+		}
+	}()
+
+	lang := defaultLang
+	if w.user.Language != "" {
+		lang = w.user.Language
+	}
+	prompts := *promptsTemplate // copy template
+	applyLanguage(&prompts, lang)
+
+	// The triage only looks: states and the alert's cameras
+	sess := userSession(w.user, w.userName)
+	sess.ReadOnly = true
+	var defs []tools.Definition
+	toolNames := []string{"ha_state"}
+	role := roleSubAgent
+	if len(w.Cameras) > 0 {
+		toolNames = append(toolNames, "ha_camera_snapshot")
+		role = roleVision
+	}
+	for _, name := range toolNames {
+		if t, ok := tools.Get(name); ok {
+			defs = append(defs, t.Def)
+		}
+	}
+	subCfg, subModelID := models.forRole(role, cfg, modelID)
+
+	event := w.describe(ev, at)
+	input := "Alert: " + event
+	if len(w.Cameras) > 0 {
+		input += "\nCameras: " + strings.Join(w.Cameras, ", ")
+	}
+	if w.Prompt != "" {
+		input += "\nInstructions: " + w.Prompt
+	}
+	messages := []Message{
+		{Role: "system", Content: prompts.HAAlertTriage},
+		{Role: "user", Content: input},
+	}
+	raw, err := doSubAgentWithTools(ctx, sess, subCfg, subModelID, messages, defs, subCfg.Limit.Output, subCfg.Limit.Context, 4, 15000, logf, nil, globalThink)
+	if ctx.Err() != nil {
+		return
+	}
+	decision, parseErr := extractJSON[haTriage](raw)
+	switch {
+	case err != nil:
+		log.Printf("Alert %s: triage: %v", w.key(), err)
+		decision = haTriage{Notify: true}
+	case parseErr != nil:
+		log.Printf("Alert %s: triage answer: %v", w.key(), parseErr)
+		decision = haTriage{Notify: true}
+	case !decision.Notify:
+		log.Printf("Alert %s: not notified: %s", w.key(), decision.Message)
+		return
+	}
+	text := "🏠 " + event
+	if decision.Message != "" {
+		text = "🏠 " + w.Name + ": " + decision.Message
+	}
+
+	if decision.AttachSnapshot {
+		var snapshot string
+		for id := 1; ; id++ {
+			img, ok := sess.Image(id)
+			if !ok {
+				break
+			}
+			snapshot = img
+		}
+		if snapshot != "" {
+			if _, err := sendPhotoDataURI(token, chatID, snapshot, "", 0); err != nil {
+				log.Printf("Alert %s: snapshot: %v", w.key(), err)
+			}
+		}
+	}
+	if len(w.Actions) == 0 {
+		err = sendToChat(token, chatID, text)
+	} else {
+		_, err = sendMessageWithKeyboard(token, chatID, text, w.keyboard())
+	}
+	if err != nil {
+		log.Printf("Error sending alert %s to chat %d: %v", w.key(), chatID, err)
+	}
+}
+
+// handleHAAlertButton runs an alert action. Only the alert's user may press
+// its buttons; the service call runs in the background.
+func handleHAAlertButton(token string, cq *TGCallbackQuery, data string) {
+	w, act, ok := haAlertAction(data)
+	if !ok {
+		log.Printf("Alert button %q: no such action (users.json changed?)", data)
+		return
+	}
+	if cq.From == nil || cq.From.ID != w.user.TelegramID {
+		return
+	}
+	chatID, msgID, text := cq.Message.Chat.ID, cq.Message.MessageID, cq.Message.Text
+	go func() {
+		domain, service, _ := strings.Cut(act.Service, ".")
+		args := map[string]any{"domain": domain, "service": service, "entity_id": act.EntityID}
+		if len(act.Data) > 0 {
+			data, _ := json.Marshal(act.Data)
+			args["data"] = string(data)
+		}
+		raw, _ := json.Marshal(args)
+		note := "✅ " + act.Label
+		haCall, _ := tools.Get("ha_call")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if _, err := haCall.Execute(ctx, userSession(w.user, w.userName), raw); err != nil {
+			log.Printf("Alert %s: %s: %v", w.key(), act.Service, err)
+			note = fmt.Sprintf("❌ %s: %v", act.Label, err)
+		}
+		// The other buttons stay for further actions
+		_ = editMessageText(token, chatID, msgID, text+"\n\n"+note, "", w.keyboard())
+	}()
+}
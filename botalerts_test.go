@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+func TestHAAlertWatches(t *testing.T) {
+	users := map[string]*UserConfig{
+		"bob": {HA: &UserHAConfig{Enabled: true, Alerts: []HAAlert{
+			{Name: "leak", Entities: []string{"binary_sensor.leak"}, To: "on"},
+			{Name: "broken"},
+			{Name: "bad-action", Entities: []string{"lock.door"}, Actions: []HAAlertAction{{Label: "Lock", Service: "lock", EntityID: "lock.door"}}},
+			{Name: "bad-hours", Entities: []string{"lock.door"}, Hours: "22-7"},
+		}}},
+		"alice": {HA: &UserHAConfig{Enabled: true, Alerts: []HAAlert{
+			{Name: "person", Trigger: json.RawMessage(`{"platform":"state","entity_id":"binary_sensor.person","to":"on"}`), Cameras: []string{"camera.yard"}},
+			{Name: "door", Entities: []string{"binary_sensor.door"}, From: "off", To: "on"},
+		}}},
+		"carol": {HA: &UserHAConfig{Alerts: []HAAlert{{Name: "off", Entities: []string{"sensor.x"}}}}},
+	}
+	watches := haAlertWatches(users)
+	var keys []string
+	for _, w := range watches {
+		keys = append(keys, w.key())
+	}
+	if got := strings.Join(keys, " "); got != "alice/door alice/person bob/leak" {
+		t.Fatalf("watches = %s", got)
+	}
+
+	trigger, _ := json.Marshal(watches[0].trigger())
+	if string(trigger) != `{"entity_id":["binary_sensor.door"],"from":"off","platform":"state","to":"on"}` {
+		t.Errorf("state trigger = %s", trigger)
+	}
+	if raw, _ := json.Marshal(watches[1].trigger()); !strings.Contains(string(raw), "binary_sensor.person") {
+		t.Errorf("raw trigger = %s", raw)
+	}
+}
+
+func TestHAAlertDue(t *testing.T) {
+	w, err := newHAAlertWatch("alice", &UserConfig{}, HAAlert{Name: "door", Entities: []string{"binary_sensor.door"}, Hours: "22-23,0-6", Timezone: "UTC", CooldownMin: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	night := time.Date(2026, 10, 16, 23, 5, 0, 0, time.UTC)
+	if w.due(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)) {
+		t.Error("due outside the hours")
+	}
+	if !w.due(night) || w.due(night.Add(5*time.Minute)) || !w.due(night.Add(11*time.Minute)) {
+		t.Error("cooldown")
+	}
+
+	ev := tools.HATriggerEvent{EntityID: "binary_sensor.door", Name: "Door", FromState: "off", ToState: "on"}
+	if got := w.describe(ev, night); got != "door: binary_sensor.door (Door): off → on, 16.10 23:05" {
+		t.Errorf("describe = %q", got)
+	}
+	if got := w.describe(tools.HATriggerEvent{Description: "time pattern"}, night); got != "door: time pattern, 16.10 23:05" {
+		t.Errorf("describe without entity = %q", got)
+	}
+}
+
+func TestHAAlertActionID(t *testing.T) {
+	prev := botHAAlerts
+	defer func() { botHAAlerts = prev }()
+
+	lock := HAAlertAction{Label: "Lock", Service: "lock.lock", EntityID: "lock.door"}
+	light := HAAlertAction{Label: "Light", Service: "light.turn_on", EntityID: "light.yard"}
+	botHAAlerts = []*haAlertWatch{{userName: "alice", HAAlert: HAAlert{Name: "door", Actions: []HAAlertAction{lock, light}}}}
+	keyboard := botHAAlerts[0].keyboard().(TGInlineKeyboardMarkup)
+	data, _ := strings.CutPrefix(keyboard.InlineKeyboard[0][0].CallbackData, haAlertCallbackPrefix)
+
+	if w, act, ok := haAlertAction(data); !ok || w != botHAAlerts[0] || act.Service != "lock.lock" {
+		t.Fatalf("button %q: %v, %+v", data, ok, act)
+	}
+	// users.json edited: the old first button must not run the new first action
+	botHAAlerts = []*haAlertWatch{{userName: "alice", HAAlert: HAAlert{Name: "door", Actions: []HAAlertAction{light}}}}
+	if _, act, ok := haAlertAction(data); ok {
+		t.Errorf("stale button runs %+v", act)
+	}
+}
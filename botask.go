@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+// ask_user in Telegram
+//
+// A question with options is sent with an inline keyboard, or as a native
+// poll when it has more options than fit a keyboard comfortably. The
+// buttons of a multi-select keyboard toggle until "Готово". Questions that
+// take a free-text answer get a "✏️ Свой ответ" button, after which the
+// asking user's next message is the answer. After ask_timeout_sec the
+// question is closed and the model told that nobody answered. While a
+// question is open its job gives up its worker slot (jobQueue.awaitInput).
+
+const (
+	otherCallbackData = "ask:other" // "own answer" button
+	doneCallbackData  = "ask:done"  // ends a multi-select question
+
+	maxKeyboardOptions = 5 // more options are asked as a poll
+	maxPollOptions     = 10
+	maxPollQuestion    = 300 // Bot API limits, in characters
+	maxPollOption      = 100
+)
+
+// pollMessages maps the poll ID of a pending poll question to its message.
+var pollMessages sync.Map // poll ID (string) -> message_id (int64)
+
+const defaultAskTimeout = 10 * time.Minute
+
+// botAskTimeout is how long ask_user waits for an answer; runBot sets it.
+var botAskTimeout = defaultAskTimeout
+
+func (c *botConfig) askTimeout() time.Duration {
+	if c.AskTimeoutSec > 0 {
+		return time.Duration(c.AskTimeoutSec) * time.Second
+	}
+	return defaultAskTimeout
+}
+
+// asPoll reports whether q is asked as a poll: it has too many options for
+// a keyboard and fits the poll limits.
+func asPoll(q tools.UserQuestion) bool {
+	if len(q.Options) <= maxKeyboardOptions || len(q.Options) > maxPollOptions ||
+		len([]rune(q.Question)) > maxPollQuestion {
+		return false
+	}
+	for _, opt := range q.Options {
+		if n := len([]rune(opt.Label)); n == 0 || n > maxPollOption {
+			return false
+		}
+	}
+	return true
+}
+
+// questionText is the question followed by the descriptions of its options.
+func questionText(q tools.UserQuestion) string {
+	text := q.Question
+	for _, opt := range q.Options {
+		if opt.Description != "" {
+			text += fmt.Sprintf("\n• %s — %s", opt.Label, opt.Description)
+		}
+	}
+	return text
+}
+
+// keyboard is the inline keyboard of a question: one option per row, marked
+// when selected in a multi-select question, then "Готово" and "Свой ответ".
+func (pq *pendingQuestion) keyboard() TGInlineKeyboardMarkup {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	var rows [][]TGInlineKeyboardButton
+	for i, opt := range pq.Options {
+		label := opt.Label
+		if pq.Multi {
+			mark := "⬜ "
+			if pq.selected[i] {
+				mark = "✅ "
+			}
+			label = mark + label
+		}
+		rows = append(rows, []TGInlineKeyboardButton{{Text: label, CallbackData: strconv.Itoa(i)}})
+	}
+	var last []TGInlineKeyboardButton
+	if pq.Multi {
+		last = append(last, TGInlineKeyboardButton{Text: "Готово", CallbackData: doneCallbackData})
+	}
+	if pq.FreeText {
+		last = append(last, otherButton)
+	}
+	if len(last) > 0 {
+		rows = append(rows, last)
+	}
+	return TGInlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+var otherButton = TGInlineKeyboardButton{Text: "✏️ Свой ответ", CallbackData: otherCallbackData}
+
+// toggle flips option i of a multi-select question; false if there is no
+// such option.
+func (pq *pendingQuestion) toggle(i int) bool {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	if i < 0 || i >= len(pq.selected) {
+		return false
+	}
+	pq.selected[i] = !pq.selected[i]
+	return true
+}
+
+// selection returns the labels of the selected options, in option order.
+func (pq *pendingQuestion) selection() []string {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	labels := []string{}
+	for i, on := range pq.selected {
+		if on {
+			labels = append(labels, pq.Options[i].Label)
+		}
+	}
+	return labels
+}
+
+// pollAnswer is the answer given by voting for options ids.
+func (pq *pendingQuestion) pollAnswer(ids []int) string {
+	var labels []string
+	for _, id := range ids {
+		if id >= 0 && id < len(pq.Options) {
+			labels = append(labels, pq.Options[id].Label)
+		}
+	}
+	if pq.Multi {
+		return tools.FormatSelection(labels)
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return labels[0]
+}
+
+// display formats an answer for the question message: a multi-select
+// answer as a plain list.
+func (pq *pendingQuestion) display(answer string) string {
+	var labels []string
+	if pq.Multi && json.Unmarshal([]byte(answer), &labels) == nil {
+		if len(labels) == 0 {
+			return "ничего не выбрано"
+		}
+		return strings.Join(labels, ", ")
+	}
+	return answer
+}
+
+// sendPoll asks pq as a poll. Option descriptions do not fit a poll, so
+// they go in a message before it.
+func (p *TelegramPrompter) sendPoll(pq *pendingQuestion, question string) (int64, string, error) {
+	if pq.Text != question {
+		if _, err := sendTelegramChunk(p.Token, p.ChatID, pq.Text, "", 0); err != nil {
+			return 0, "", err
+		}
+	}
+	labels := make([]string, len(pq.Options))
+	for i, opt := range pq.Options {
+		labels[i] = opt.Label
+	}
+	var keyboard any
+	if pq.FreeText {
+		keyboard = TGInlineKeyboardMarkup{InlineKeyboard: [][]TGInlineKeyboardButton{{otherButton}}}
+	}
+	return sendPoll(p.Token, p.ChatID, question, labels, pq.Multi, keyboard)
+}
+
+// closeQuestion ends an answered or expired question: a poll is stopped, a
+// keyboard message loses its buttons and gets note appended (best-effort).
+func (p *TelegramPrompter) closeQuestion(pq *pendingQuestion, msgID int64, note string) {
+	if pq.Poll {
+		_ = stopPoll(p.Token, p.ChatID, msgID)
+		return
+	}
+	_ = editMessageText(p.Token, p.ChatID, msgID, pq.Text+"\n\n"+note, "", nil)
+}
+
+// handlePollAnswer routes a vote to the poll question it answers; votes of
+// other members and retracted votes are ignored.
+func handlePollAnswer(pa *TGPollAnswer) {
+	v, ok := pollMessages.Load(pa.PollID)
+	if !ok || pa.User == nil || len(pa.OptionIDs) == 0 {
+		return
+	}
+	if pq := resolveKeyboardQuestion(v.(int64), pa.User.ID); pq != nil {
+		pq.ResultCh <- pq.pollAnswer(pa.OptionIDs)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"ai-webfetch/tools"
+)
+
+func askOptions(labels ...string) []tools.UserOption {
+	var opts []tools.UserOption
+	for _, l := range labels {
+		opts = append(opts, tools.UserOption{Label: l})
+	}
+	return opts
+}
+
+func TestAsPoll(t *testing.T) {
+	few := tools.UserQuestion{Question: "?", Options: askOptions("a", "b", "c")}
+	many := tools.UserQuestion{Question: "?", Options: askOptions("a", "b", "c", "d", "e", "f")}
+	tooMany := tools.UserQuestion{Question: "?", Options: askOptions(strings.Split("abcdefghijk", "")...)}
+	longLabel := tools.UserQuestion{Question: "?", Options: askOptions("a", "b", "c", "d", "e", strings.Repeat("f", maxPollOption+1))}
+	for _, tc := range []struct {
+		name string
+		q    tools.UserQuestion
+		want bool
+	}{{"few", few, false}, {"many", many, true}, {"too many", tooMany, false}, {"long label", longLabel, false}} {
+		if got := asPoll(tc.q); got != tc.want {
+			t.Errorf("%s: asPoll = %v", tc.name, got)
+		}
+	}
+}
+
+func TestPendingQuestion_MultiSelect(t *testing.T) {
+	pq := &pendingQuestion{Options: askOptions("red", "green", "blue"), Multi: true, FreeText: true, selected: make([]bool, 3)}
+	if !pq.toggle(2) || !pq.toggle(0) || !pq.toggle(1) || !pq.toggle(1) || pq.toggle(3) {
+		t.Fatal("toggle")
+	}
+	if got := pq.selection(); !slices.Equal(got, []string{"red", "blue"}) {
+		t.Errorf("selection = %v", got)
+	}
+
+	rows := pq.keyboard().InlineKeyboard
+	if len(rows) != 4 || rows[0][0].Text != "✅ red" || rows[1][0].Text != "⬜ green" || rows[1][0].CallbackData != "1" {
+		t.Fatalf("keyboard = %+v", rows)
+	}
+	if last := rows[3]; len(last) != 2 || last[0].CallbackData != doneCallbackData || last[1].CallbackData != otherCallbackData {
+		t.Errorf("last row = %+v", last)
+	}
+
+	if got := pq.display(tools.FormatSelection(pq.selection())); got != "red, blue" {
+		t.Errorf("display = %q", got)
+	}
+	if got := pq.display("something else"); got != "something else" {
+		t.Errorf("display of a typed answer = %q", got)
+	}
+}
+
+func TestPendingQuestion_SingleKeyboard(t *testing.T) {
+	pq := &pendingQuestion{Options: askOptions("yes", "no"), selected: make([]bool, 2)}
+	rows := pq.keyboard().InlineKeyboard
+	if len(rows) != 2 || rows[0][0].Text != "yes" || rows[1][0].CallbackData != "1" {
+		t.Errorf("keyboard = %+v", rows)
+	}
+}
+
+func TestHandlePollAnswer(t *testing.T) {
+	pq := &pendingQuestion{ChatID: 5, UserID: 42, Options: askOptions("a", "b", "c"), Multi: true, ResultCh: make(chan string, 1)}
+	registerKeyboardQuestion(900, pq)
+	pollMessages.Store("poll-1", int64(900))
+	defer pollMessages.Delete("poll-1")
+
+	handlePollAnswer(&TGPollAnswer{PollID: "poll-1", User: &TGUser{ID: 7}, OptionIDs: []int{0}})
+	handlePollAnswer(&TGPollAnswer{PollID: "poll-1", User: &TGUser{ID: 42}})
+	select {
+	case got := <-pq.ResultCh:
+		t.Fatalf("answered by another member or a retraction: %q", got)
+	default:
+	}
+
+	handlePollAnswer(&TGPollAnswer{PollID: "poll-1", User: &TGUser{ID: 42}, OptionIDs: []int{2, 0}})
+	if got := <-pq.ResultCh; got != `["c","a"]` {
+		t.Errorf("answer = %s", got)
+	}
+	if _, ok := pendingKeyboardQuestions.Load(int64(900)); ok {
+		t.Error("question still pending")
+	}
+}
+
+func TestParseOptionNumbers(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{"1", []int{1}, true},
+		{"3, 1", []int{3, 1}, true},
+		{"1,3-5 2", []int{1, 3, 4, 5, 2}, true},
+		{"2-3, 3", []int{2, 3}, true},
+		{"0", nil, false},
+		{"6", nil, false},
+		{"4-2", nil, false},
+		{"blue", nil, false},
+		{" , ", nil, false},
+	} {
+		got, ok := parseOptionNumbers(tc.in, 5)
+		if ok != tc.ok || !slices.Equal(got, tc.want) {
+			t.Errorf("parseOptionNumbers(%q) = %v, %v", tc.in, got, ok)
+		}
+	}
+}
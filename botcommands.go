@@ -0,0 +1,171 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"ai-webfetch/tools"
+)
+
+// Command menu and /help
+//
+// The menu is built from the built-in commands, the registered
+// tools.Commands and the skills that have a description, and set with
+// setMyCommands at startup: one menu for all chats and, with
+// commands_per_user, one for each registered user's private chat that
+// leaves out what the user cannot run. /help lists the same commands and
+// the integrations the user has.
+
+// botCommand is an entry of the Telegram command menu.
+type botCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+const (
+	maxMenuCommands    = 100 // Bot API limits
+	maxMenuDescription = 256
+)
+
+var validCommandName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// commandMenu lists the commands user can run; a nil user gets the menu for
+// everyone. Skills without a description and names Telegram does not accept
+// are left out.
+func commandMenu(u *UserConfig, mcpMgr *MCPManager, skills []skillInfo) []botCommand {
+	var menu []botCommand
+	add := func(name, description string) {
+		if !validCommandName.MatchString(name) || len(menu) >= maxMenuCommands ||
+			slices.ContainsFunc(menu, func(c botCommand) bool { return c.Command == name }) {
+			return
+		}
+		if r := []rune(description); len(r) > maxMenuDescription {
+			description = string(r[:maxMenuDescription-1]) + "…"
+		}
+		menu = append(menu, botCommand{Command: name, Description: description})
+	}
+
+	add("news", "дайджест новостей; /news тема — поиск по теме")
+	if u == nil || u.Imap != nil {
+		add("mail", "дайджест почты за N часов (по умолчанию 24)")
+	}
+	for _, c := range tools.Commands() {
+		if len(c.MCPServers) > 0 && (mcpMgr == nil || mcpMgr.ValidateNames(c.MCPServers) != nil) {
+			continue
+		}
+		add(c.Name, cmp.Or(c.Description, "команда /"+c.Name))
+	}
+	for _, s := range skills {
+		if s.Description != "" && !reservedCommands[s.Name] && !tools.IsCommand(s.Name) {
+			add(s.Name, s.Description)
+		}
+	}
+	add("model", "выбрать модель: /model имя запрос")
+	add("think", "включить reasoning для запроса")
+	add("nothink", "отключить reasoning для запроса")
+	if len(skills) > 0 {
+		add("skills", "запрос с навыками: /skills имя1,имя2 запрос")
+	}
+	if mcpMgr != nil {
+		add("mcp", "запрос с MCP-серверами: /mcp сервер запрос")
+	}
+	add("cancel", "остановить выполняющиеся запросы")
+	add("usage", "расход токенов")
+	if u == nil || len(u.Schedules) > 0 {
+		add("schedules", "расписания: список; pause, resume или run имя")
+	}
+	if u != nil && u.Admin {
+		add("jobs", "очередь запросов")
+	}
+	add("help", "команды и подключённые сервисы")
+	return menu
+}
+
+// installCommandMenu sets the command menu for all chats and, with
+// commands_per_user, for each registered user's private chat.
+func installCommandMenu(token string, botCfg *botConfig, users map[string]*UserConfig, mcpMgr *MCPManager) {
+	skills := listSkills(skillSearchDirs())
+	if err := setMyCommands(token, commandMenu(nil, mcpMgr, skills), nil); err != nil {
+		log.Printf("Error setting command menu: %v", err)
+		return
+	}
+	if !botCfg.CommandsPerUser {
+		return
+	}
+	for name, u := range users {
+		if u.TelegramID == 0 {
+			continue
+		}
+		// A user's private chat has the user's ID
+		scope := map[string]any{"type": "chat", "chat_id": u.TelegramID}
+		if err := setMyCommands(token, commandMenu(u, mcpMgr, skills), scope); err != nil {
+			log.Printf("Error setting command menu for %s: %v", name, err)
+		}
+	}
+}
+
+// helpIntegrations are the integrations /help reports, by tool name prefix.
+var helpIntegrations = []struct {
+	label, prefix string
+	writes        bool // has tools that change state
+}{
+	{"Почта (IMAP)", "imap_", false},
+	{"Home Assistant", "ha_", true},
+	{"Календарь", "cal_", true},
+	{"Контакты", "contacts_", true},
+	{"Память", "memory_", true},
+	{"Личные настройки", "userinfo_", true},
+}
+
+// helpText describes the commands in menu and what the session gives
+// access to: an integration counts if any of its tools is available, which
+// includes a group's tool policy.
+func helpText(menu []botCommand, sess *tools.Session, mcpMgr *MCPManager, mcpOverrides map[string]bool) string {
+	var sb strings.Builder
+	sb.WriteString("Команды:\n")
+	for _, c := range menu {
+		fmt.Fprintf(&sb, "/%s — %s\n", c.Command, c.Description)
+	}
+
+	sb.WriteString("\nСервисы:\n")
+	defs := tools.All(sess)
+	for _, in := range helpIntegrations {
+		available, writable := false, false
+		for _, d := range defs {
+			if name := d.Function.Name; strings.HasPrefix(name, in.prefix) {
+				available = true
+				if t, ok := tools.Get(name); ok && t.Writes {
+					writable = true
+				}
+			}
+		}
+		switch {
+		case !available:
+			fmt.Fprintf(&sb, "❌ %s\n", in.label)
+		case in.writes && !writable:
+			fmt.Fprintf(&sb, "✅ %s (только чтение)\n", in.label)
+		default:
+			fmt.Fprintf(&sb, "✅ %s\n", in.label)
+		}
+	}
+	if mcpMgr != nil {
+		active, onDemand := mcpMgr.ServerStates(mcpOverrides)
+		if len(active) > 0 {
+			fmt.Fprintf(&sb, "✅ MCP: %s\n", strings.Join(active, ", "))
+		}
+		if len(onDemand) > 0 {
+			fmt.Fprintf(&sb, "➕ MCP по запросу (/mcp имя запрос): %s\n", strings.Join(onDemand, ", "))
+		}
+	}
+
+	if botSpeech.stt() != nil {
+		sb.WriteString("\nИли просто напиши вопрос — можно с фото, видео, документом или голосовым сообщением.")
+	} else {
+		sb.WriteString("\nИли просто напиши вопрос — можно с фото, видео или документом.")
+	}
+	return sb.String()
+}
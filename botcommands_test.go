@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"ai-webfetch/tools"
+)
+
+func TestListSkills(t *testing.T) {
+	global, local := t.TempDir(), t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(global, "review.md"), "---\ndescription: code review\nmcp: github\n---\nbody")
+	write(filepath.Join(global, "trip", "SKILL.md"), "---\ndescription: \"plan a trip\"\n---\nbody")
+	write(filepath.Join(global, "plain.md"), "no frontmatter")
+	write(filepath.Join(global, "notes.txt"), "not a skill")
+	write(filepath.Join(local, "review.md"), "---\ndescription: shadowed\n---\n")
+
+	got := listSkills([]string{global, local, filepath.Join(global, "missing")})
+	want := []skillInfo{{"plain", ""}, {"review", "code review"}, {"trip", "plan a trip"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("listSkills = %v, want %v", got, want)
+	}
+}
+
+func TestCommandMenu(t *testing.T) {
+	skills := []skillInfo{{"review", "code review"}, {"plain", ""}, {"Bad-Name", "x"}, {"news", "shadows a command"}}
+	names := func(menu []botCommand) []string {
+		var out []string
+		for _, c := range menu {
+			out = append(out, c.Command)
+		}
+		return out
+	}
+
+	all := names(commandMenu(nil, nil, skills))
+	if !slices.Contains(all, "mail") || !slices.Contains(all, "review") || !slices.Contains(all, "skills") ||
+		slices.Contains(all, "jobs") || slices.Contains(all, "plain") || slices.Contains(all, "Bad-Name") ||
+		slices.Contains(all, "eat") || slices.Contains(all, "mcp") {
+		t.Errorf("menu for everyone = %v", all)
+	}
+
+	mgr := &MCPManager{servers: map[string]*MCPServer{"nutricalc": {name: "nutricalc"}}}
+	admin := names(commandMenu(&UserConfig{Admin: true}, mgr, nil))
+	if slices.Contains(admin, "mail") || !slices.Contains(admin, "jobs") || !slices.Contains(admin, "eat") ||
+		!slices.Contains(admin, "mcp") || slices.Contains(admin, "skills") {
+		t.Errorf("menu for an admin without IMAP = %v", admin)
+	}
+}
+
+func TestHelpText(t *testing.T) {
+	sess := tools.NewSession()
+	sess.HA = true
+	sess.ReadOnly = true
+	mgr := &MCPManager{servers: map[string]*MCPServer{
+		"context7": {name: "context7", cfg: MCPServerConfig{Enabled: true}},
+		"github":   {name: "github"},
+		"fs":       {name: "fs", cfg: MCPServerConfig{Enabled: true}},
+	}}
+	help := helpText([]botCommand{{"news", "дайджест"}}, sess, mgr, map[string]bool{"github": true, "fs": false})
+	for _, want := range []string{
+		"/news — дайджест",
+		"❌ Почта (IMAP)",
+		"✅ Home Assistant (только чтение)",
+		"✅ MCP: context7, github",
+		"➕ MCP по запросу (/mcp имя запрос): fs",
+	} {
+		if !strings.Contains(help, want) {
+			t.Errorf("help lacks %q:\n%s", want, help)
+		}
+	}
+}
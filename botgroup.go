@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"ai-webfetch/tools"
+)
+
+// Group chats
+//
+// The bot works only in groups listed in the bot config. There it answers
+// messages addressed to it, acting for the member who wrote them: their
+// integrations, quota and usage ledger, limited by the group's tool policy.
+
+// groupConfig is one entry of the "groups" part of the bot section in telegram.json.
+type groupConfig struct {
+	Name     string   `json:"name,omitempty"`     // shown in logs
+	Tools    []string `json:"tools,omitempty"`    // enabled tools: names or "prefix*" patterns (default: all)
+	Language string   `json:"language,omitempty"` // reply language, overrides the member's
+	Skills   []string `json:"skills,omitempty"`   // active when a message selects none
+	// WriteMembers lists the users.json names that may use tools which change
+	// state (ha_call, calendar and contacts writes, ...); "*" allows all
+	// registered members. Everyone else gets read-only tools.
+	WriteMembers      []string `json:"write_members,omitempty"`
+	AllowUnregistered bool     `json:"allow_unregistered_members,omitempty"`
+}
+
+func isGroupChat(chat TGChat) bool {
+	return chat.Type == "group" || chat.Type == "supergroup"
+}
+
+// group returns the configuration of a group chat, nil if it is not enabled.
+func (c *botConfig) group(chatID int64) *groupConfig {
+	return c.Groups[strconv.FormatInt(chatID, 10)]
+}
+
+// canWrite reports whether a member may use tools that change state.
+func (g *groupConfig) canWrite(user *UserConfig, userName string) bool {
+	if user == nil {
+		return false
+	}
+	for _, m := range g.WriteMembers {
+		if m == "*" || m == userName {
+			return true
+		}
+	}
+	return false
+}
+
+// restrict applies the group's tool policy to a member's session.
+// readTools are the MCP tools that do not change state (MCPManager.ReadOnlyTools).
+func (g *groupConfig) restrict(sess *tools.Session, user *UserConfig, userName string, readTools []string) {
+	sess.EnabledTools = g.Tools
+	sess.ReadOnly = !g.canWrite(user, userName)
+	sess.ReadTools = readTools
+}
+
+// addressedText reports whether a group message is meant for the bot: a
+// command (unless it names another bot), a mention of the bot, or a reply
+// to one of its messages. The returned text has the bot's @name removed.
+func addressedText(text string, msg *TGMessage, bot *TGUser) (string, bool) {
+	if strings.HasPrefix(text, "/") {
+		end := strings.IndexAny(text, " \n")
+		if end < 0 {
+			end = len(text)
+		}
+		cmd, target, ok := strings.Cut(text[:end], "@")
+		if !ok {
+			return text, true
+		}
+		if !strings.EqualFold(target, bot.Username) {
+			return text, false
+		}
+		return cmd + text[end:], true
+	}
+	if i := mentionIndex(text, bot.Username); i >= 0 {
+		return strings.TrimSpace(text[:i] + text[i+1+len(bot.Username):]), true
+	}
+	if r := msg.ReplyToMessage; r != nil && r.From != nil && r.From.ID == bot.ID {
+		return text, true
+	}
+	return text, false
+}
+
+// mentionIndex returns the position of "@username" in text (case-insensitive,
+// not as the prefix of a longer username), or -1.
+func mentionIndex(text, username string) int {
+	if username == "" {
+		return -1
+	}
+	mention := "@" + username
+	for i := 0; i+len(mention) <= len(text); i++ {
+		if !strings.EqualFold(text[i:i+len(mention)], mention) {
+			continue
+		}
+		if j := i + len(mention); j < len(text) && isUsernameChar(text[j]) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func isUsernameChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"ai-webfetch/tools"
+)
+
+func TestAddressedText(t *testing.T) {
+	bot := &TGUser{ID: 42, IsBot: true, Username: "HelperBot"}
+	replyToBot := &TGMessage{ReplyToMessage: &TGMessage{From: bot}}
+	replyToMember := &TGMessage{ReplyToMessage: &TGMessage{From: &TGUser{ID: 7}}}
+
+	tests := []struct {
+		text string
+		msg  *TGMessage
+		want string
+		ok   bool
+	}{
+		{"what's for dinner", &TGMessage{}, "what's for dinner", false},
+		{"@helperbot what's for dinner", &TGMessage{}, "what's for dinner", true},
+		{"ask @HelperBot\nabout it", &TGMessage{}, "ask \nabout it", true},
+		{"@HelperBotFan said hi", &TGMessage{}, "@HelperBotFan said hi", false},
+		{"/news", &TGMessage{}, "/news", true},
+		{"/mail@HelperBot 12", &TGMessage{}, "/mail 12", true},
+		{"/mail@OtherBot 12", &TGMessage{}, "/mail@OtherBot 12", false},
+		{"and tomorrow?", replyToBot, "and tomorrow?", true},
+		{"and tomorrow?", replyToMember, "and tomorrow?", false},
+	}
+	for _, tt := range tests {
+		got, ok := addressedText(tt.text, tt.msg, bot)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("addressedText(%q) = %q, %v; want %q, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestGroupRestrict(t *testing.T) {
+	g := &groupConfig{Tools: []string{"ha_*", "web_fetch"}, WriteMembers: []string{"alice"}}
+	alice, bob := &UserConfig{TelegramID: 1}, &UserConfig{TelegramID: 2}
+
+	sess := tools.NewSession()
+	g.restrict(sess, alice, "alice", nil)
+	if !sess.Allows("ha_call") || !sess.Allows("web_fetch") || sess.Allows("imap_read_message") {
+		t.Error("alice: wrong tool policy")
+	}
+
+	sess = tools.NewSession()
+	g.restrict(sess, bob, "bob", []string{"wiki__*"})
+	if sess.Allows("ha_call") || !sess.Allows("ha_state") {
+		t.Error("bob: write tools not hidden")
+	}
+	g.Tools = nil
+	g.restrict(sess, bob, "bob", []string{"wiki__*"})
+	if sess.Allows("github__create_issue") || !sess.Allows("wiki__search") {
+		t.Error("bob: MCP tools of a server not marked read_only not hidden")
+	}
+	if (&groupConfig{WriteMembers: []string{"*"}}).canWrite(nil, "tg:5") {
+		t.Error("unregistered member may write")
+	}
+}
+
+func TestPendingQuestionOwner(t *testing.T) {
+	pq := &pendingQuestion{ChatID: -100, UserID: 1, ResultCh: make(chan string, 1)}
+	registerTextQuestion(-100, pq)
+	defer pendingTextQuestions.Delete(int64(-100))
+
+	if textQuestionPending(-100, 2) || resolveTextQuestion(-100, 2) != nil {
+		t.Error("another member took the question")
+	}
+	if !textQuestionPending(-100, 1) || resolveTextQuestion(-100, 1) != pq {
+		t.Error("asker could not answer")
+	}
+	if resolveTextQuestion(-100, 1) != nil {
+		t.Error("question answered twice")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+// Mail watch
+//
+// Users with "imap": {"watch": {...}} in users.json have their mailboxes
+// watched in the background (tools.WatchImap). Every new message is
+// classified through the queue with imap_digest_message; messages at least
+// as important as the user's threshold are pushed to the mail chat with
+// buttons to summarize the message, mark it read or be reminded of it later.
+
+// mailCallbackPrefix marks the buttons under a pushed message
+// ("mail:<action>:<mailbox id>:<uid>", see mailboxID).
+const mailCallbackPrefix = "mail:"
+
+const (
+	mailActionSummarize = "sum"
+	mailActionSeen      = "seen"
+	mailActionLater     = "later"
+)
+
+// mailRemindAfter is when "remind me later" brings a message back.
+const mailRemindAfter = 3 * time.Hour
+
+const defaultMailThreshold = "needs-reply"
+
+// mailCategories are the digest categories from the least to the most important.
+var mailCategories = []string{"newsletter/promo", "regular", "invoice/accounting", "needs-reply", "important"}
+
+// mailCategory returns the rank of the category named on the digest's
+// CATEGORY line, or -1 if there is none.
+func mailCategory(digest string) int {
+	for _, line := range strings.Split(digest, "\n") {
+		_, rest, ok := strings.Cut(strings.ToLower(line), "category")
+		if !ok {
+			continue
+		}
+		rank := -1
+		for i, c := range mailCategories {
+			if strings.Contains(rest, c) {
+				rank = i
+			}
+		}
+		if rank >= 0 {
+			return rank
+		}
+	}
+	return -1
+}
+
+// mailWatch is a watched mail account of a user.
+type mailWatch struct {
+	userName    string
+	user        *UserConfig
+	mailboxes   []string
+	threshold   int // rank in mailCategories
+	sentMailbox string
+}
+
+// botMailWatches are the mail watches of the running bot.
+var botMailWatches []*mailWatch
+
+func newMailWatch(userName string, u *UserConfig) (*mailWatch, error) {
+	cfg := u.Imap.Watch
+	w := &mailWatch{userName: userName, user: u, mailboxes: cfg.Mailboxes, sentMailbox: cfg.SentMailbox}
+	if len(w.mailboxes) == 0 {
+		w.mailboxes = []string{"INBOX"}
+	}
+	threshold := cmp.Or(cfg.Threshold, defaultMailThreshold)
+	w.threshold = slices.Index(mailCategories, threshold)
+	if w.threshold < 0 {
+		return nil, fmt.Errorf("mail watch: unknown threshold %q (known: %s)", threshold, strings.Join(mailCategories, ", "))
+	}
+	return w, nil
+}
+
+// mailWatches returns the valid mail watches of users, sorted by user name.
+func mailWatches(users map[string]*UserConfig) []*mailWatch {
+	var watches []*mailWatch
+	for name, u := range users {
+		if u.Imap == nil || u.Imap.Watch == nil {
+			continue
+		}
+		w, err := newMailWatch(name, u)
+		if err != nil {
+			log.Printf("User %s: %v", name, err)
+			continue
+		}
+		watches = append(watches, w)
+	}
+	slices.SortFunc(watches, func(a, b *mailWatch) int { return cmp.Compare(a.userName, b.userName) })
+	return watches
+}
+
+// chatID is where the watch pushes: the mail chat or else the user's private chat.
+func (w *mailWatch) chatID() int64 {
+	if id := userChatID(w.user, "mail", 0); id != 0 {
+		return id
+	}
+	return w.user.TelegramID
+}
+
+// mailboxID names a watched mailbox in button data. Unlike a position in
+// botMailWatches it survives edits of users.json: a button of a mailbox
+// that is no longer watched matches nothing.
+func (w *mailWatch) mailboxID(mailbox string) string {
+	return callbackHash(w.userName, mailbox)
+}
+
+// watchedMailbox finds the watch and mailbox of a button's mailbox ID.
+func watchedMailbox(id string) (*mailWatch, string, bool) {
+	for _, w := range botMailWatches {
+		for _, mailbox := range w.mailboxes {
+			if w.mailboxID(mailbox) == id {
+				return w, mailbox, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+func (w *mailWatch) keyboard(mailbox string, uid uint32) TGInlineKeyboardMarkup {
+	data := func(action string) string {
+		return fmt.Sprintf("%s%s:%s:%d", mailCallbackPrefix, action, w.mailboxID(mailbox), uid)
+	}
+	return TGInlineKeyboardMarkup{InlineKeyboard: [][]TGInlineKeyboardButton{{
+		{Text: "📝 Кратко", CallbackData: data(mailActionSummarize)},
+		{Text: "✅ Прочитано", CallbackData: data(mailActionSeen)},
+		{Text: "⏰ Позже", CallbackData: data(mailActionLater)},
+	}}}
+}
+
+// runMailTool runs an imap_* tool for the watch's user, metered as mail usage.
+func (w *mailWatch) runMailTool(chatID int64, name string, args map[string]any) (string, error) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	defer trackQuery(chatID, 0, w.user.TelegramID, stop)()
+	ctx, meter := withUsageMeter(ctx)
+	defer func() {
+		if err := saveQueryUsage(w.userName, usageModeMail, meter); err != nil {
+			log.Printf("Usage ledger error: %v", err)
+		}
+	}()
+
+	t, ok := tools.Get(name)
+	if !ok {
+		return "", fmt.Errorf("no tool %s", name)
+	}
+	raw, _ := json.Marshal(args)
+	return t.Execute(ctx, userSession(w.user, w.userName), raw)
+}
+
+// runMailAlert digests a new message of watch index and pushes the digest
+// with the message buttons if its category reaches the watch's threshold.
+// A digest without a category counts as reaching it, and if the digest
+// cannot be made at all, the envelope's sender and subject are pushed.
+func runMailAlert(token string, index int, msg tools.ImapNewMessage) {
+	w := botMailWatches[index]
+	chatID := w.chatID()
+
+	args := map[string]any{"mailbox": msg.Mailbox, "uid": msg.UID}
+	if w.sentMailbox != "" {
+		args["sent_mailbox"] = w.sentMailbox
+	}
+	digest, err := w.runMailTool(chatID, "imap_digest_message", args)
+	if err != nil {
+		log.Printf("Mail watch %s: %s %d: %v", w.userName, msg.Mailbox, msg.UID, err)
+		digest = fmt.Sprintf("From: %s\nSubject: %s", msg.From, msg.Subject)
+	} else if rank := mailCategory(digest); rank >= 0 && rank < w.threshold {
+		log.Printf("Mail watch %s: not pushed (%s): %s", w.userName, mailCategories[rank], msg.Subject)
+		return
+	}
+	if _, err := sendMessageWithKeyboard(token, chatID, "📬 "+digest, w.keyboard(msg.Mailbox, msg.UID)); err != nil {
+		log.Printf("Error sending mail alert to chat %d: %v", chatID, err)
+	}
+}
+
+// handleMailButton handles the buttons under a pushed message. Only the
+// watch's user may press them.
+func handleMailButton(token string, cq *TGCallbackQuery, data string, queue *jobQueue) {
+	parts := strings.Split(data, ":")
+	if len(parts) != 3 {
+		return
+	}
+	uid, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return
+	}
+	w, box, ok := watchedMailbox(parts[1])
+	if !ok {
+		log.Printf("Mail button %q: mailbox no longer watched", data)
+		return
+	}
+	if cq.From == nil || cq.From.ID != w.user.TelegramID {
+		return
+	}
+	chatID, msgID, text := cq.Message.Chat.ID, cq.Message.MessageID, cq.Message.Text
+	keyboard := w.keyboard(box, uint32(uid))
+
+	switch parts[0] {
+	case mailActionSummarize:
+		queue.submit(&botJob{
+			chatID: chatID,
+			userID: w.user.TelegramID,
+			label:  w.userName,
+			text:   "📝 Кратко",
+			run: func() {
+				summary, err := w.runMailTool(chatID, "imap_summarize_message", map[string]any{"mailbox": box, "uid": uid})
+				if err != nil {
+					summary = fmt.Sprintf("❌ %v", err)
+				}
+				if _, err := sendBotReply(token, chatID, summary, msgID); err != nil {
+					log.Printf("Error sending mail summary to chat %d: %v", chatID, err)
+				}
+			},
+		})
+	case mailActionSeen:
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			note := "✅ Отмечено прочитанным"
+			if err := tools.ImapMarkSeen(ctx, userSession(w.user, w.userName), box, uint32(uid)); err != nil {
+				log.Printf("Mail watch %s: mark %s %d read: %v", w.userName, box, uid, err)
+				note = fmt.Sprintf("❌ %v", err)
+			}
+			_ = editMessageText(token, chatID, msgID, text+"\n\n"+note, "", keyboard)
+		}()
+	case mailActionLater:
+		if botReminders == nil {
+			return
+		}
+		// The reminder repeats the sender and subject lines of the digest
+		head, _, _ := strings.Cut(strings.TrimPrefix(text, "📬 "), "\n\n")
+		loc := tools.ReminderLocation(userSession(w.user, w.userName))
+		r, err := botReminders.Add(tools.Reminder{
+			User:     w.userName,
+			ChatID:   chatID,
+			Text:     "📬 " + head,
+			At:       time.Now().Add(mailRemindAfter),
+			Timezone: loc.String(),
+		})
+		note := "⏰ Напомню в " + r.At.In(loc).Format("15:04")
+		if err != nil {
+			log.Printf("Mail watch %s: reminder: %v", w.userName, err)
+			note = fmt.Sprintf("❌ %v", err)
+		}
+		_ = editMessageText(token, chatID, msgID, text+"\n\n"+note, "", keyboard)
+	}
+}
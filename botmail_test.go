@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMailCategory(t *testing.T) {
+	for _, tc := range []struct {
+		digest string
+		want   string
+	}{
+		{"From: a\n\n1. SUMMARY: Invoice attached.\n2. CATEGORY: invoice/accounting\n3. CONVERSATION: none", "invoice/accounting"},
+		{"**Category:** Needs-Reply", "needs-reply"},
+		{"SUMMARY: regular important news\nCATEGORY: newsletter/promo", "newsletter/promo"},
+		{"SUMMARY: no category line", ""},
+	} {
+		got := ""
+		if rank := mailCategory(tc.digest); rank >= 0 {
+			got = mailCategories[rank]
+		}
+		if got != tc.want {
+			t.Errorf("mailCategory(%q) = %q, want %q", tc.digest, got, tc.want)
+		}
+	}
+}
+
+func TestMailWatches(t *testing.T) {
+	users := map[string]*UserConfig{
+		"bob":   {TelegramID: 2, Imap: &UserImapConfig{Watch: &UserImapWatch{Threshold: "urgent"}}},
+		"alice": {TelegramID: 1, Chats: UserChats{Mail: -100}, Imap: &UserImapConfig{Watch: &UserImapWatch{}}},
+		"carol": {TelegramID: 3, Imap: &UserImapConfig{Watch: &UserImapWatch{Mailboxes: []string{"INBOX", "Work"}, Threshold: "important"}}},
+		"dave":  {Imap: &UserImapConfig{}},
+	}
+	watches := mailWatches(users)
+	if len(watches) != 2 || watches[0].userName != "alice" || watches[1].userName != "carol" {
+		t.Fatalf("watches = %+v", watches)
+	}
+	alice, carol := watches[0], watches[1]
+	if mailCategories[alice.threshold] != "needs-reply" || len(alice.mailboxes) != 1 || alice.mailboxes[0] != "INBOX" {
+		t.Errorf("defaults = %+v", alice)
+	}
+	if alice.chatID() != -100 || carol.chatID() != 3 {
+		t.Errorf("chats = %d, %d", alice.chatID(), carol.chatID())
+	}
+
+	// Button data fits Telegram's 64 bytes
+	for _, row := range carol.keyboard("Work", 4294967295).InlineKeyboard {
+		for _, b := range row {
+			if !strings.HasPrefix(b.CallbackData, mailCallbackPrefix) || len(b.CallbackData) > 64 {
+				t.Errorf("callback data %q", b.CallbackData)
+			}
+		}
+	}
+
+	// Buttons find their mailbox by ID, not by position
+	prev := botMailWatches
+	defer func() { botMailWatches = prev }()
+	botMailWatches = watches
+	id := carol.mailboxID("Work")
+	if w, box, ok := watchedMailbox(id); !ok || w != carol || box != "Work" {
+		t.Errorf("watchedMailbox = %v, %q, %v", w, box, ok)
+	}
+	carol.mailboxes = []string{"INBOX"}
+	if _, box, ok := watchedMailbox(id); ok {
+		t.Errorf("button of an unwatched mailbox finds %q", box)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Bot update modes (botConfig.Mode).
+const (
+	botModeWebhook = "webhook"
+	botModePolling = "polling"
+)
+
+const defaultPollTimeout = 50 * time.Second
+
+func (c *botConfig) pollTimeout() time.Duration {
+	if c.PollTimeoutSec > 0 {
+		return time.Duration(c.PollTimeoutSec) * time.Second
+	}
+	return defaultPollTimeout
+}
+
+// Long polling
+
+// getUpdates fetches updates with update_id >= offset, waiting up to
+// timeout for one to arrive.
+func getUpdates(ctx context.Context, token string, offset int64, timeout time.Duration) ([]Update, error) {
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates", token)
+	vals := url.Values{
+		"offset":          {strconv.FormatInt(offset, 10)},
+		"timeout":         {strconv.Itoa(int(timeout / time.Second))},
+		"allowed_updates": {allowedUpdates},
+	}
+	// The server holds the request for up to timeout; allow for the network on top
+	ctx, cancel := context.WithTimeout(ctx, timeout+15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = vals.Encode()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("getUpdates request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		OK          bool     `json:"ok"`
+		Description string   `json:"description"`
+		Result      []Update `json:"result"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("getUpdates decode (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if !result.OK {
+		return nil, fmt.Errorf("getUpdates: %s", result.Description)
+	}
+	return result.Result, nil
+}
+
+// pollUpdates receives updates with getUpdates until ctx is done and hands
+// each to dispatch. A configured webhook is removed first, since Telegram
+// refuses getUpdates while one is set. Errors are retried with backoff.
+func pollUpdates(ctx context.Context, token string, timeout time.Duration, dispatch func(*Update)) error {
+	if err := deleteWebhook(token); err != nil {
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+	log.Printf("Bot polling for updates (timeout %s)", timeout)
+
+	var offset int64
+	backoff := time.Second
+	for {
+		updates, err := getUpdates(ctx, token, offset, timeout)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("%v (retrying in %s)", err, backoff)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		backoff = time.Second
+
+		for i := range updates {
+			if requestDebug {
+				b, _ := json.Marshal(updates[i])
+				log.Printf("Telegram update: %s", b)
+			}
+			// Confirmed by the next getUpdates call
+			offset = updates[i].UpdateID + 1
+			dispatch(&updates[i])
+		}
+	}
+
+	log.Println("Shutting down...")
+	// Confirm what was dispatched so it is not delivered again after a restart
+	if offset != 0 {
+		confirmCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := getUpdates(confirmCtx, token, offset, 0); err != nil {
+			log.Printf("confirm updates: %v", err)
+		}
+	}
+	return nil
+}
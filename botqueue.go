@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request queue
+//
+// Messages are queued in arrival order and run by a fixed pool of workers,
+// sized to what the inference backend can serve at once. A chat runs one
+// message at a time, so its messages are answered in order and do not race
+// on the same accounts. Waiting messages get a status message with their
+// position, edited in place as the queue moves. A job waiting for the
+// user's answer to ask_user gives its worker slot to the next job and
+// takes a slot again once answered.
+
+const defaultBotWorkers = 2
+
+func (c *botConfig) workers() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return defaultBotWorkers
+}
+
+// botJob is one queued message.
+type botJob struct {
+	chatID  int64
+	msgID   int64
+	userID  int64  // the user it runs for, who may cancel it
+	label   string // user, for the admin view
+	text    string // message preview, for the admin view
+	run     func()
+	dropped func() // optional, called if the job is cancelled before it runs
+	queued  time.Time
+	started time.Time
+	asking  bool // waiting for the user's answer, without a worker slot
+}
+
+// jobStatus shows queue positions in Telegram; fields are swapped in tests.
+// msgID is the queued message, which the Stop button cancels.
+type jobStatus struct {
+	send   func(chatID, msgID int64, text string) (statusID int64, err error)
+	edit   func(chatID, msgID, statusID int64, text string) error
+	remove func(chatID, statusID int64)
+}
+
+func telegramJobStatus(token string) jobStatus {
+	keyboard := func(msgID int64) TGInlineKeyboardMarkup {
+		return TGInlineKeyboardMarkup{InlineKeyboard: [][]TGInlineKeyboardButton{{
+			{Text: "⏹ Стоп", CallbackData: stopCallbackPrefix + strconv.FormatInt(msgID, 10)},
+		}}}
+	}
+	return jobStatus{
+		send: func(chatID, msgID int64, text string) (int64, error) {
+			return sendMessageWithKeyboard(token, chatID, text, keyboard(msgID))
+		},
+		edit: func(chatID, msgID, statusID int64, text string) error {
+			return editMessageText(token, chatID, statusID, text, "", keyboard(msgID))
+		},
+		remove: func(chatID, statusID int64) { _ = deleteMessage(token, chatID, statusID) },
+	}
+}
+
+// botQueue is the queue of the running bot; ask_user frees its slot through it.
+var botQueue *jobQueue
+
+type jobQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	waiting []*botJob // arrival order
+	running []*botJob
+	busy    map[int64]bool // chats with a running job
+	workers int            // worker slots
+	active  int            // running jobs holding a slot
+	idle    int            // worker goroutines waiting for a job
+
+	status  jobStatus
+	changed chan struct{} // wakes the status loop (coalescing)
+	shown   map[*botJob]*shownStatus
+}
+
+// shownStatus is the status message of a waiting job (status loop only).
+type shownStatus struct {
+	id  int64
+	pos int
+}
+
+func newJobQueue(status jobStatus) *jobQueue {
+	q := &jobQueue{
+		busy:    map[int64]bool{},
+		status:  status,
+		changed: make(chan struct{}, 1),
+		shown:   map[*botJob]*shownStatus{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// start launches the workers and the status loop.
+func (q *jobQueue) start(workers int) {
+	q.mu.Lock()
+	q.workers = workers
+	q.mu.Unlock()
+	for range workers {
+		go q.worker()
+	}
+	go func() {
+		for range q.changed {
+			q.refreshStatus()
+		}
+	}()
+}
+
+// submit queues a job.
+func (q *jobQueue) submit(j *botJob) {
+	j.queued = time.Now()
+	q.mu.Lock()
+	q.waiting = append(q.waiting, j)
+	q.mu.Unlock()
+	q.cond.Signal()
+	q.notify()
+}
+
+func (q *jobQueue) notify() {
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (q *jobQueue) worker() {
+	for {
+		j := q.next()
+		q.notify()
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("panic in queued job (chat %d): %v", j.chatID, r)
+				}
+			}()
+			j.run()
+		}()
+		q.finish(j)
+	}
+}
+
+// next waits for a free slot and the oldest job whose chat has nothing
+// running, and marks it running.
+func (q *jobQueue) next() *botJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.idle++
+	defer func() { q.idle-- }()
+	for {
+		for i, j := range q.waiting {
+			if q.active >= q.workers {
+				break
+			}
+			if q.busy[j.chatID] {
+				continue
+			}
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.busy[j.chatID] = true
+			j.started = time.Now()
+			q.running = append(q.running, j)
+			q.active++
+			return j
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *jobQueue) finish(j *botJob) {
+	q.mu.Lock()
+	q.active--
+	delete(q.busy, j.chatID)
+	for i, r := range q.running {
+		if r == j {
+			q.running = append(q.running[:i], q.running[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+	// The chat's next job may now run on any idle worker
+	q.cond.Broadcast()
+}
+
+// awaitInput frees the slot of the chat's running job while it waits for
+// the user; the returned func takes a slot again, waiting for one if the
+// queue is full. If every worker goroutine is busy, one more is started so
+// that the freed slot is used. Safe on a nil queue.
+func (q *jobQueue) awaitInput(chatID int64) (resume func()) {
+	if q == nil {
+		return func() {}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.IndexFunc(q.running, func(j *botJob) bool { return j.chatID == chatID && !j.asking })
+	if i < 0 {
+		return func() {}
+	}
+	j := q.running[i]
+	j.asking = true
+	q.active--
+	if q.idle == 0 {
+		go q.worker()
+	}
+	q.cond.Broadcast()
+	q.notify()
+	return func() {
+		q.mu.Lock()
+		for q.active >= q.workers {
+			q.cond.Wait()
+		}
+		q.active++
+		j.asking = false
+		q.mu.Unlock()
+	}
+}
+
+// cancel removes the waiting job of message msgID if by may cancel it.
+// Reports whether there was one.
+func (q *jobQueue) cancel(chatID, msgID int64, by canceller) bool {
+	return q.drop(func(j *botJob) bool { return j.chatID == chatID && j.msgID == msgID && by.may(j.userID) }) > 0
+}
+
+// cancelChat removes the waiting jobs of a chat that by may cancel and
+// returns how many there were.
+func (q *jobQueue) cancelChat(chatID int64, by canceller) int {
+	return q.drop(func(j *botJob) bool { return j.chatID == chatID && by.may(j.userID) })
+}
+
+func (q *jobQueue) drop(match func(*botJob) bool) int {
+	q.mu.Lock()
+	var dropped []*botJob
+	kept := q.waiting[:0]
+	for _, j := range q.waiting {
+		if match(j) {
+			dropped = append(dropped, j)
+		} else {
+			kept = append(kept, j)
+		}
+	}
+	clear(q.waiting[len(kept):])
+	q.waiting = kept
+	q.mu.Unlock()
+	for _, j := range dropped {
+		if j.dropped != nil {
+			j.dropped()
+		}
+	}
+	if len(dropped) > 0 {
+		q.notify()
+	}
+	return len(dropped)
+}
+
+// refreshStatus brings the status messages in line with the queue: jobs
+// that have to wait get one, moved jobs get it edited, and it is deleted
+// when the job starts or is cancelled.
+func (q *jobQueue) refreshStatus() {
+	q.mu.Lock()
+	positions := make(map[*botJob]int, len(q.waiting))
+	for i, j := range q.waiting {
+		// A job a free worker is about to take needs no status
+		if q.busy[j.chatID] || q.active >= q.workers {
+			positions[j] = i + 1
+		}
+	}
+	q.mu.Unlock()
+
+	for j, st := range q.shown {
+		if _, ok := positions[j]; !ok {
+			q.status.remove(j.chatID, st.id)
+			delete(q.shown, j)
+		}
+	}
+	for j, pos := range positions {
+		text := fmt.Sprintf("🕒 В очереди, позиция %d", pos)
+		st := q.shown[j]
+		switch {
+		case st == nil:
+			id, err := q.status.send(j.chatID, j.msgID, text)
+			if err != nil {
+				log.Printf("Error sending queue status to chat %d: %v", j.chatID, err)
+				continue
+			}
+			q.shown[j] = &shownStatus{id: id, pos: pos}
+		case st.pos != pos:
+			if err := q.status.edit(j.chatID, j.msgID, st.id, text); err != nil {
+				log.Printf("Error updating queue status in chat %d: %v", j.chatID, err)
+			}
+			st.pos = pos
+		}
+	}
+}
+
+// report describes running and waiting jobs (admin /jobs).
+func (q *jobQueue) report(now time.Time) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Выполняется: %d из %d, в очереди: %d\n", q.active, q.workers, len(q.waiting))
+	for _, j := range q.running {
+		mark := "▶"
+		if j.asking {
+			mark = "❓" // waiting for the user, without a slot
+		}
+		fmt.Fprintf(&sb, "\n%s %s, чат %d, %s: %s", mark, j.label, j.chatID,
+			now.Sub(j.started).Round(time.Second), truncate(j.text, 60))
+	}
+	for i, j := range q.waiting {
+		fmt.Fprintf(&sb, "\n%d. %s, чат %d, ждёт %s: %s", i+1, j.label, j.chatID,
+			now.Sub(j.queued).Round(time.Second), truncate(j.text, 60))
+	}
+	return sb.String()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJobStatus records the status messages of a queue.
+type fakeJobStatus struct {
+	mu   sync.Mutex
+	next int64
+	text map[int64]string // statusID -> text
+}
+
+func (f *fakeJobStatus) status() jobStatus {
+	f.text = map[int64]string{}
+	return jobStatus{
+		send: func(chatID, msgID int64, text string) (int64, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.next++
+			f.text[f.next] = text
+			return f.next, nil
+		},
+		edit: func(chatID, msgID, statusID int64, text string) error {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.text[statusID] = text
+			return nil
+		},
+		remove: func(chatID, statusID int64) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			delete(f.text, statusID)
+		},
+	}
+}
+
+func TestJobQueue_OrderAndLimits(t *testing.T) {
+	q := newJobQueue((&fakeJobStatus{}).status())
+	q.start(2)
+
+	var mu sync.Mutex
+	var order []string
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	job := func(chatID, msgID int64, name string) *botJob {
+		wg.Add(1)
+		return &botJob{chatID: chatID, msgID: msgID, run: func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, name)
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}}
+	}
+	q.submit(job(1, 1, "a1"))
+	q.submit(job(1, 2, "a2"))
+	q.submit(job(2, 1, "b1"))
+	q.submit(job(3, 1, "c1"))
+	wg.Wait()
+
+	if maxRunning != 2 {
+		t.Errorf("max running = %d, want 2", maxRunning)
+	}
+	pos := map[string]int{}
+	for i, name := range order {
+		pos[name] = i
+	}
+	if pos["a1"] > pos["a2"] {
+		t.Errorf("chat 1 out of order: %v", order)
+	}
+}
+
+func TestJobQueue_StatusAndCancel(t *testing.T) {
+	f := &fakeJobStatus{}
+	q := newJobQueue(f.status())
+	q.workers = 1 // no workers started: every job waits
+
+	block := &botJob{chatID: 1, msgID: 1, label: "alice", text: "first"}
+	q.running = []*botJob{block}
+	q.busy[1] = true
+	q.active = 1
+	block.started = time.Now()
+
+	a, b := &botJob{chatID: 1, msgID: 2, userID: 10, text: "second"}, &botJob{chatID: 2, msgID: 1, userID: 20, text: "other chat"}
+	q.submit(a)
+	q.submit(b)
+	q.refreshStatus()
+	if len(f.text) != 2 || f.text[q.shown[b].id] != "🕒 В очереди, позиция 2" {
+		t.Fatalf("status = %v", f.text)
+	}
+
+	if q.cancel(1, 2, canceller{userID: 20}) {
+		t.Error("cancelled another user's job")
+	}
+	if !q.cancel(1, 2, canceller{userID: 10}) || q.cancel(1, 2, canceller{userID: 10}) {
+		t.Error("cancel of a waiting job")
+	}
+	q.refreshStatus()
+	if len(f.text) != 1 || f.text[q.shown[b].id] != "🕒 В очереди, позиция 1" {
+		t.Errorf("status after cancel = %v", f.text)
+	}
+
+	report := q.report(time.Now())
+	if !strings.Contains(report, "Выполняется: 1 из 1, в очереди: 1") || !strings.Contains(report, "alice") {
+		t.Errorf("report = %q", report)
+	}
+	if q.cancelChat(2, canceller{userID: 10}) != 0 || q.cancelChat(2, canceller{userID: 30, admin: true}) != 1 {
+		t.Error("cancelChat")
+	}
+}
+
+func TestJobQueue_AwaitInputFreesSlot(t *testing.T) {
+	q := newJobQueue((&fakeJobStatus{}).status())
+	q.start(1)
+
+	asked, answer, done := make(chan struct{}), make(chan struct{}), make(chan string, 2)
+	q.submit(&botJob{chatID: 1, run: func() {
+		resume := q.awaitInput(1)
+		close(asked)
+		<-answer
+		resume()
+		done <- "asker"
+	}})
+	<-asked
+	if report := q.report(time.Now()); !strings.Contains(report, "Выполняется: 0 из 1") || !strings.Contains(report, "❓") {
+		t.Errorf("report = %q", report)
+	}
+	q.submit(&botJob{chatID: 2, run: func() { done <- "other" }})
+
+	select {
+	case name := <-done:
+		if name != "other" {
+			t.Fatalf("%s finished first", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the job of another chat waits for the asking job")
+	}
+	close(answer)
+	if name := <-done; name != "asker" {
+		t.Errorf("finished: %s", name)
+	}
+}
+
+func TestCancelRunningQueries(t *testing.T) {
+	var cancelled []string
+	track := func(msgID, userID int64, name string) func() {
+		return trackQuery(-100, msgID, userID, func() { cancelled = append(cancelled, name) })
+	}
+	defer track(1, 10, "alice")()
+	defer track(2, 20, "bob")()
+	defer track(0, 10, "alice's alert")()
+
+	if cancelQuery(-100, 1, canceller{userID: 20}) || len(cancelled) != 0 {
+		t.Errorf("bob stopped alice's query: %v", cancelled)
+	}
+	if !cancelQuery(-100, 2, canceller{userID: 20}) {
+		t.Error("bob could not stop his query")
+	}
+	if n := cancelChatQueries(-100, canceller{userID: 10}); n != 2 {
+		t.Errorf("alice's /cancel = %d", n)
+	}
+	if n := cancelChatQueries(-100, canceller{}); n != 0 {
+		t.Errorf("/cancel without a sender = %d", n)
+	}
+	if n := cancelChatQueries(-100, canceller{userID: 30, admin: true}); n != 3 {
+		t.Errorf("admin /cancel = %d", n)
+	}
+	if strings.Join(cancelled, ",") != "bob,alice,alice's alert,alice,bob,alice's alert" {
+		t.Errorf("cancelled = %v", cancelled)
+	}
+}
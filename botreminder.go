@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+// Reminders
+//
+// The reminder_* tools (tools/reminder.go) keep reminders in
+// reminders.json next to users.json; the bot watches the store and
+// delivers them to the chat they were created in. A plain reminder is sent
+// with snooze buttons. A prompt reminder runs through the queue like a
+// scheduled prompt job and may decide that nothing needs to be sent.
+
+// reminderCallbackPrefix marks the buttons of a delivered reminder
+// ("remind:<id>:<minutes>"); 0 minutes is "done".
+const reminderCallbackPrefix = "remind:"
+
+// reminderMaxWait bounds the sleep between checks, so that a changed system
+// clock or a suspended host delays reminders by at most this much.
+const reminderMaxWait = time.Minute
+
+// reminderPromptFormat turns a prompt reminder into the query run at its time.
+const reminderPromptFormat = "It is time for a reminder the user set earlier: %s\n\n" +
+	"Do what it asks and write the message for the user. If it turns out that nothing needs to be said, answer exactly %s."
+
+var remindersPath = "reminders.json"
+
+// botReminders is the reminder store of the running bot (nil without a bot).
+var botReminders *tools.ReminderStore
+
+var snoozeButtons = []struct {
+	label   string
+	minutes int
+}{{"+10 мин", 10}, {"+1 час", 60}, {"Завтра", 24 * 60}}
+
+func reminderKeyboard(id string) TGInlineKeyboardMarkup {
+	var row []TGInlineKeyboardButton
+	for _, b := range snoozeButtons {
+		row = append(row, TGInlineKeyboardButton{Text: b.label, CallbackData: reminderCallbackPrefix + id + ":" + strconv.Itoa(b.minutes)})
+	}
+	return TGInlineKeyboardMarkup{InlineKeyboard: [][]TGInlineKeyboardButton{
+		row,
+		{{Text: "✅ Готово", CallbackData: reminderCallbackPrefix + id + ":0"}},
+	}}
+}
+
+// watchReminders delivers due reminders until ctx is done.
+func watchReminders(ctx context.Context, store *tools.ReminderStore, deliver func(tools.Reminder)) {
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-store.Changed():
+			case <-timer.C:
+			}
+			now := time.Now()
+			for _, r := range store.Due(now) {
+				deliver(r)
+			}
+			wait := reminderMaxWait
+			if next := store.Next(); !next.IsZero() {
+				wait = min(wait, next.Sub(now))
+			}
+			timer.Reset(wait)
+		}
+	}()
+}
+
+// reminderJob is the scheduled prompt job that runs a prompt reminder,
+// under the tool policy of the chat the reminder was set in.
+func reminderJob(r tools.Reminder, u *UserConfig) *scheduledJob {
+	return &scheduledJob{
+		UserSchedule: UserSchedule{
+			Name:   "Напоминание " + r.ID,
+			Type:   schedulePrompt,
+			Prompt: fmt.Sprintf(reminderPromptFormat, r.Text, silentReply),
+		},
+		userName:     r.User,
+		user:         u,
+		enabledTools: r.Tools,
+		readOnly:     r.ReadOnly,
+	}
+}
+
+// handleReminderButton snoozes or completes a delivered reminder; anyone in
+// the chat it was delivered to may press the buttons.
+func handleReminderButton(token string, cq *TGCallbackQuery, data string) {
+	chatID, msgID := cq.Message.Chat.ID, cq.Message.MessageID
+	id, minStr, _ := strings.Cut(data, ":")
+	minutes, err := strconv.Atoi(minStr)
+	if err != nil || minutes < 0 || botReminders == nil {
+		return
+	}
+	note := "✅ Готово"
+	if minutes > 0 {
+		r, err := botReminders.Snooze(chatID, id, time.Duration(minutes)*time.Minute, time.Now())
+		if err != nil {
+			log.Printf("Reminder %s: snooze: %v", id, err)
+			note = "Напоминание уже недоступно."
+		} else {
+			note = "💤 Отложено до " + r.At.In(r.Location()).Format("02.01 15:04")
+		}
+	}
+	_ = editMessageText(token, chatID, msgID, cq.Message.Text+"\n\n"+note, "", nil)
+}
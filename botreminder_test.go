@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+func TestWatchReminders(t *testing.T) {
+	store, err := tools.OpenReminderStore(filepath.Join(t.TempDir(), "reminders.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan tools.Reminder, 1)
+	watchReminders(ctx, store, func(r tools.Reminder) { got <- r })
+
+	// Added while the watcher sleeps; due right away
+	r, err := store.Add(tools.Reminder{User: "alice", ChatID: 1, Text: "tea", At: time.Now(), Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-got:
+		if d.ID != r.ID || d.Text != "tea" {
+			t.Errorf("delivered %+v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reminder not delivered")
+	}
+}
+
+func TestReminderKeyboard(t *testing.T) {
+	rows := reminderKeyboard("r123456").InlineKeyboard
+	if len(rows) != 2 || len(rows[0]) != len(snoozeButtons) || rows[1][0].CallbackData != "remind:r123456:0" {
+		t.Fatalf("keyboard = %+v", rows)
+	}
+	for _, b := range rows[0] {
+		if len(b.CallbackData) > 64 {
+			t.Errorf("callback data too long: %q", b.CallbackData)
+		}
+	}
+
+	j := reminderJob(tools.Reminder{ID: "r5", User: "alice", Text: "umbrella?", Prompt: true}, &UserConfig{})
+	if j.Type != schedulePrompt || j.userName != "alice" || !strings.Contains(j.Prompt, "umbrella?") || !strings.Contains(j.Prompt, silentReply) {
+		t.Errorf("job = %+v", j)
+	}
+}
+
+func TestReminderJob_GroupPolicy(t *testing.T) {
+	r := tools.Reminder{ID: "r1", User: "bob", Text: "weather", Prompt: true, Tools: []string{"web_fetch"}, ReadOnly: true}
+	j := reminderJob(r, &UserConfig{})
+	if !j.readOnly || len(j.enabledTools) != 1 || !strings.Contains(j.Prompt, "weather") {
+		t.Errorf("job = %+v", j)
+	}
+}
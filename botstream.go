@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Live replies
+//
+// The answer to a free-form query is streamed into a Telegram message: it is
+// sent with the first content and edited at a throttled rate as more arrives,
+// with a status line while tools run. Telegram allows about one edit per
+// second in a chat. When the query finishes, the message is replaced by the
+// answer rendered as HTML, continued in new messages if it is too long.
+
+const (
+	liveEditInterval = 2 * time.Second
+	liveMaxPreview   = 3500 // streamed characters shown; older text is cut
+)
+
+// toolObserver is implemented by content writers that show which tools the
+// model is calling (see runQuery).
+type toolObserver interface {
+	toolCall(name string)
+}
+
+// liveAPI sends and edits the live message; fields are swapped in tests.
+type liveAPI struct {
+	send   func(chatID, replyTo int64, text, parseMode string) (msgID int64, err error)
+	edit   func(chatID, msgID int64, text, parseMode string) error
+	remove func(chatID, msgID int64)
+}
+
+func telegramLiveAPI(token string) liveAPI {
+	return liveAPI{
+		send: func(chatID, replyTo int64, text, parseMode string) (int64, error) {
+			return sendTelegramChunk(token, chatID, text, parseMode, replyTo)
+		},
+		edit: func(chatID, msgID int64, text, parseMode string) error {
+			return editMessageText(token, chatID, msgID, text, parseMode, nil)
+		},
+		remove: func(chatID, msgID int64) { _ = deleteMessage(token, chatID, msgID) },
+	}
+}
+
+// liveReply is the content writer of a bot query.
+type liveReply struct {
+	api     liveAPI
+	chatID  int64
+	replyTo int64
+	tee     io.Writer // also gets the content (debug output)
+
+	mu      sync.Mutex
+	content strings.Builder
+	tools   []string // tools of the current round, until the model writes again
+	dirty   bool
+
+	// Owned by the update loop, then by finish/discard
+	msgID int64
+	shown string
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newLiveReply starts updating a live message every interval. The message
+// is sent with the first content, as a reply to message replyTo.
+func newLiveReply(api liveAPI, chatID, replyTo int64, tee io.Writer, interval time.Duration) *liveReply {
+	l := &liveReply{
+		api:     api,
+		chatID:  chatID,
+		replyTo: replyTo,
+		tee:     tee,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.loop(interval)
+	return l
+}
+
+func (l *liveReply) Write(p []byte) (int, error) {
+	_, _ = l.tee.Write(p)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.content.Write(p)
+	if len(bytes.TrimSpace(p)) > 0 {
+		l.tools = nil
+	}
+	l.dirty = true
+	return len(p), nil
+}
+
+// dropContent takes back the text of a failed stream attempt; the debug
+// output in tee keeps it.
+func (l *liveReply) dropContent(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	text := l.content.String()
+	l.content.Reset()
+	l.content.WriteString(text[:max(len(text)-n, 0)])
+	l.dirty = true
+}
+
+// text is the content of all rounds so far.
+func (l *liveReply) text() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.content.String()
+}
+
+func (l *liveReply) toolCall(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !slices.Contains(l.tools, name) {
+		l.tools = append(l.tools, name)
+	}
+	l.dirty = true
+}
+
+func (l *liveReply) loop(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.flush()
+		}
+	}
+}
+
+// flush shows the current content and status if they changed.
+func (l *liveReply) flush() {
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return
+	}
+	l.dirty = false
+	text := livePreview(l.content.String(), l.tools)
+	l.mu.Unlock()
+	if text == "" || text == l.shown {
+		return
+	}
+
+	var err error
+	if l.msgID == 0 {
+		l.msgID, err = l.api.send(l.chatID, l.replyTo, text, "")
+	} else {
+		err = l.api.edit(l.chatID, l.msgID, text, "")
+	}
+	if err != nil {
+		// Typically rate limiting: retry on the next tick
+		log.Printf("Error updating live reply in chat %d: %v", l.chatID, err)
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+		return
+	}
+	l.shown = text
+}
+
+// livePreview is the streamed text as plain text: without reasoning, cut to
+// its end, followed by the running tools.
+func livePreview(content string, tools []string) string {
+	text := reThinkTags.ReplaceAllString(content, "")
+	if i := strings.Index(text, "<think>"); i >= 0 {
+		text = text[:i] // still reasoning
+	}
+	text = strings.TrimSpace(text)
+	if r := []rune(text); len(r) > liveMaxPreview {
+		text = "…" + string(r[len(r)-liveMaxPreview:])
+	}
+	if len(tools) > 0 {
+		if text != "" {
+			text += "\n\n"
+		}
+		text += "🔧 Вызываю " + strings.Join(tools, ", ") + "…"
+	}
+	return text
+}
+
+// close stops the updates; it may be called more than once.
+func (l *liveReply) close() {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+}
+
+// finish stops the updates and replaces the live message with the reply
+// rendered from markdown; the rest of a long reply goes to new messages.
+// Without a live message the reply is sent as usual. Returns the ID of the
+// first message.
+func (l *liveReply) finish(reply string) (int64, error) {
+	l.close()
+	id, err := l.render(splitTelegramMessage(markdownToTelegramHTML(reply)), "HTML")
+	if err != nil {
+		// Fallback: plain text
+		return l.render(splitTelegramMessage(reply), "")
+	}
+	return id, nil
+}
+
+func (l *liveReply) render(chunks []string, parseMode string) (int64, error) {
+	first := l.msgID
+	for i, chunk := range chunks {
+		if i == 0 && l.msgID != 0 {
+			if err := l.api.edit(l.chatID, l.msgID, chunk, parseMode); err != nil && !isNotModified(err) {
+				return 0, err
+			}
+			continue
+		}
+		replyTo := int64(0)
+		if i == 0 {
+			replyTo = l.replyTo
+		}
+		id, err := l.api.send(l.chatID, replyTo, chunk, parseMode)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			first, l.msgID = id, id
+		}
+	}
+	return first, nil
+}
+
+// discard stops the updates and deletes the live message (errors, cancellation).
+func (l *liveReply) discard() {
+	l.close()
+	if l.msgID != 0 {
+		l.api.remove(l.chatID, l.msgID)
+	}
+}
+
+// isNotModified reports Telegram's refusal of an edit that changes nothing.
+func isNotModified(err error) bool {
+	return strings.Contains(err.Error(), "message is not modified")
+}
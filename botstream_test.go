@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLiveAPI records the calls made by a live reply.
+type fakeLiveAPI struct {
+	calls []string
+	next  int64
+}
+
+func (f *fakeLiveAPI) api() liveAPI {
+	return liveAPI{
+		send: func(chatID, replyTo int64, text, parseMode string) (int64, error) {
+			f.next++
+			f.calls = append(f.calls, fmt.Sprintf("send %d reply=%d %s: %s", f.next, replyTo, parseMode, text))
+			return f.next, nil
+		},
+		edit: func(chatID, msgID int64, text, parseMode string) error {
+			f.calls = append(f.calls, fmt.Sprintf("edit %d %s: %s", msgID, parseMode, text))
+			return nil
+		},
+		remove: func(chatID, msgID int64) {
+			f.calls = append(f.calls, fmt.Sprintf("delete %d", msgID))
+		},
+	}
+}
+
+func (f *fakeLiveAPI) take() []string {
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func TestLiveReply_Stream(t *testing.T) {
+	f := &fakeLiveAPI{}
+	var tee strings.Builder
+	l := newLiveReply(f.api(), 1, 7, &tee, time.Hour) // flushed by hand
+
+	l.flush()
+	if calls := f.take(); len(calls) != 0 {
+		t.Errorf("flush without content: %v", calls)
+	}
+
+	io.WriteString(l, "<think>hmm</think>Checking **mail**")
+	l.toolCall("imap_list_messages")
+	l.toolCall("imap_list_messages")
+	l.flush()
+	l.flush()
+	want := []string{"send 1 reply=7 : Checking **mail**\n\n🔧 Вызываю imap_list_messages…"}
+	if calls := f.take(); strings.Join(calls, "|") != strings.Join(want, "|") {
+		t.Errorf("first update: %q", calls)
+	}
+
+	io.WriteString(l, "\nDone")
+	l.flush()
+	if calls := f.take(); len(calls) != 1 || calls[0] != "edit 1 : Checking **mail**\nDone" {
+		t.Errorf("second update: %q", calls)
+	}
+
+	id, err := l.finish("Checking **mail**\nDone")
+	if err != nil || id != 1 {
+		t.Fatalf("finish = %d, %v", id, err)
+	}
+	if calls := f.take(); len(calls) != 1 || calls[0] != "edit 1 HTML: Checking <b>mail</b>\nDone" {
+		t.Errorf("final render: %q", calls)
+	}
+	if !strings.HasPrefix(tee.String(), "<think>hmm</think>Checking") {
+		t.Errorf("tee = %q", tee.String())
+	}
+}
+
+func TestLiveReply_DropContent(t *testing.T) {
+	f := &fakeLiveAPI{}
+	l := newLiveReply(f.api(), 1, 7, io.Discard, time.Hour)
+	io.WriteString(l, "Round one. ")
+	io.WriteString(l, "Partial")
+	l.dropContent(len("Partial"))
+	io.WriteString(l, "Retried")
+	if got := l.text(); got != "Round one. Retried" {
+		t.Errorf("text = %q", got)
+	}
+	l.flush()
+	if calls := f.take(); len(calls) != 1 || calls[0] != "send 1 reply=7 : Round one. Retried" {
+		t.Errorf("update: %q", calls)
+	}
+}
+
+func TestLiveReply_FinishWithoutLiveMessage(t *testing.T) {
+	f := &fakeLiveAPI{}
+	l := newLiveReply(f.api(), 1, 7, io.Discard, time.Hour)
+
+	long := strings.Repeat("word ", telegramMaxLen/5+10)
+	id, err := l.finish(long)
+	calls := f.take()
+	if err != nil || id != 1 || len(calls) != 2 {
+		t.Fatalf("finish = %d, %v; calls %d", id, err, len(calls))
+	}
+	if !strings.HasPrefix(calls[0], "send 1 reply=7 HTML") || !strings.HasPrefix(calls[1], "send 2 reply=0 HTML") {
+		t.Errorf("calls = %.40q", calls)
+	}
+}
+
+func TestLiveReply_Discard(t *testing.T) {
+	f := &fakeLiveAPI{}
+	l := newLiveReply(f.api(), 1, 7, io.Discard, time.Hour)
+	io.WriteString(l, "partial")
+	l.flush()
+	l.discard()
+	l.close()
+	if calls := f.take(); len(calls) != 2 || calls[1] != "delete 1" {
+		t.Errorf("calls = %q", calls)
+	}
+}
+
+func TestLivePreview(t *testing.T) {
+	if got := livePreview("<think>still going", nil); got != "" {
+		t.Errorf("unclosed reasoning shown: %q", got)
+	}
+	got := livePreview(strings.Repeat("я", liveMaxPreview+10), nil)
+	if r := []rune(got); len(r) != liveMaxPreview+1 || r[0] != '…' {
+		t.Errorf("long preview: %d runes", len(r))
+	}
+	if got := livePreview("", []string{"ha_list", "ha_state"}); got != "🔧 Вызываю ha_list, ha_state…" {
+		t.Errorf("status only: %q", got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Webhook mode
+
+// maxWebhookBody caps an update body. Updates carry file IDs, not files,
+// so real ones are a few KB.
+const maxWebhookBody = 1 << 20
+
+// secretTokenHeader carries the secret_token given to setWebhook.
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Telegram accepts 1-256 characters A-Z, a-z, 0-9, _ and - as secret_token.
+var validSecretToken = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookSecret returns the configured secret token, or a random one for
+// this run when none is set (the webhook is registered anew at each start).
+func (c *botConfig) webhookSecret() (string, error) {
+	if c.SecretToken != "" {
+		if !validSecretToken.MatchString(c.SecretToken) {
+			return "", fmt.Errorf("telegram config: secret_token must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+		}
+		return c.SecretToken, nil
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// seenUpdates remembers recent update IDs, so an update Telegram delivers
+// again (e.g. after a timed-out response) is not dispatched twice.
+type seenUpdates struct {
+	mu    sync.Mutex
+	ids   map[int64]bool
+	order []int64 // oldest first, for eviction
+	max   int
+}
+
+func newSeenUpdates(max int) *seenUpdates {
+	return &seenUpdates{ids: map[int64]bool{}, max: max}
+}
+
+// add records id and reports whether it is new.
+func (s *seenUpdates) add(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids[id] {
+		return false
+	}
+	s.ids[id] = true
+	s.order = append(s.order, id)
+	if len(s.order) > s.max {
+		delete(s.ids, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true
+}
+
+// webhookHandler accepts update POSTs that carry secret and hands each new
+// update to dispatch.
+func webhookHandler(secret string, dispatch func(*Update)) http.HandlerFunc {
+	seen := newSeenUpdates(1000)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(secret)) != 1 {
+			log.Printf("Rejected webhook request from %s: bad secret token", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		bodyBytes, readErr := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+		if readErr != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(readErr, &tooLarge) {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "read error", http.StatusBadRequest)
+			return
+		}
+
+		if requestDebug {
+			log.Printf("Telegram update: %s", string(bodyBytes))
+		}
+
+		var update Update
+		if err := json.Unmarshal(bodyBytes, &update); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// Always respond 200 quickly to avoid Telegram retries
+		w.WriteHeader(http.StatusOK)
+		if !seen.add(update.UpdateID) {
+			log.Printf("Skipping duplicate update %d", update.UpdateID)
+			return
+		}
+		dispatch(&update)
+	}
+}
+
+// serveWebhook registers the webhook with Telegram and serves updates on
+// botCfg.Listen until ctx is done; the webhook is deleted on shutdown.
+func serveWebhook(ctx context.Context, token string, botCfg *botConfig, dispatch func(*Update)) error {
+	secret, err := botCfg.webhookSecret()
+	if err != nil {
+		return err
+	}
+	if err := setWebhook(token, botCfg.WebhookURL, secret); err != nil {
+		return fmt.Errorf("set webhook: %w", err)
+	}
+	log.Printf("Webhook set to %s", botCfg.WebhookURL)
+
+	// Extract path from webhook URL for handler registration
+	u, err := url.Parse(botCfg.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("parse webhook URL: %w", err)
+	}
+	hookPath := u.Path
+	if hookPath == "" {
+		hookPath = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(hookPath, webhookHandler(secret, dispatch))
+
+	server := &http.Server{
+		Addr:              botCfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down...")
+
+		if err := deleteWebhook(token); err != nil {
+			log.Printf("deleteWebhook error: %v", err)
+		} else {
+			log.Println("Webhook deleted")
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("server shutdown error: %v", err)
+		}
+	}()
+
+	log.Printf("Bot listening on %s", botCfg.Listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return fmt.Errorf("server: %w", err)
+	}
+	return nil
+}
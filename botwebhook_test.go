@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	var got []int64
+	h := webhookHandler("s3cret", func(u *Update) { got = append(got, u.UpdateID) })
+
+	post := func(secret, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set(secretTokenHeader, secret)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	if code := post("", `{"update_id":1}`); code != http.StatusForbidden {
+		t.Errorf("no secret: HTTP %d", code)
+	}
+	if code := post("wrong", `{"update_id":1}`); code != http.StatusForbidden {
+		t.Errorf("wrong secret: HTTP %d", code)
+	}
+	if code := post("s3cret", `{"update_id":1}`); code != http.StatusOK {
+		t.Errorf("valid update: HTTP %d", code)
+	}
+	// A retried delivery is acknowledged but not dispatched again
+	if code := post("s3cret", `{"update_id":1}`); code != http.StatusOK {
+		t.Errorf("duplicate update: HTTP %d", code)
+	}
+	if code := post("s3cret", `{"update_id":2,"message":{"text":"`+strings.Repeat("a", maxWebhookBody)+`"}}`); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: HTTP %d", code)
+	}
+	if len(got) != 1 || got[0] != 1 {
+		t.Errorf("dispatched %v, want [1]", got)
+	}
+}
+
+func TestSeenUpdates_Evicts(t *testing.T) {
+	s := newSeenUpdates(2)
+	for _, id := range []int64{1, 2, 3} {
+		if !s.add(id) {
+			t.Errorf("add(%d) = false", id)
+		}
+	}
+	if s.add(3) {
+		t.Error("recent update not detected")
+	}
+	if !s.add(1) {
+		t.Error("evicted update still remembered")
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	if s, err := (&botConfig{SecretToken: "abc_DEF-1"}).webhookSecret(); err != nil || s != "abc_DEF-1" {
+		t.Errorf("configured secret = %q, %v", s, err)
+	}
+	if _, err := (&botConfig{SecretToken: "has space"}).webhookSecret(); err == nil {
+		t.Error("invalid secret accepted")
+	}
+	a, _ := (&botConfig{}).webhookSecret()
+	b, _ := (&botConfig{}).webhookSecret()
+	if !validSecretToken.MatchString(a) || a == b {
+		t.Errorf("generated secrets %q, %q", a, b)
+	}
+}
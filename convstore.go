@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Conversation threading: message store.
+//
+// Every message the bot receives or sends is stored per chat so that a
+// reply can be answered with its whole reply chain as history. The file
+// store keeps chats on disk, so threads survive restarts.
+
+type storedMessage struct {
+	Role         string        `json:"role"` // "user" or "assistant"
+	Content      string        `json:"content"`
+	ReplyToMsgID int64         `json:"reply_to,omitempty"`
+	SkillNames   []string      `json:"skills,omitempty"` // skill names active when this message was sent
+	MCPNames     []string      `json:"mcp,omitempty"`    // MCP server names active when this message was sent
+	Images       []string      `json:"images,omitempty"` // photos of a user message (data URIs)
+	Turn         []turnMessage `json:"turn,omitempty"`   // tool rounds that led to an assistant reply
+	Time         time.Time     `json:"time"`
+}
+
+// turnMessage is a tool-round Message in the store's JSON form (Message
+// itself marshals to the API format, which does not round-trip images).
+type turnMessage struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Images     []string   `json:"images,omitempty"`
+}
+
+// maxStoredToolResult caps each stored tool result; a resumed chain needs
+// the gist of what tools returned, not whole web pages.
+const maxStoredToolResult = 8000
+
+// storedTurn converts the tool rounds returned by runQuery for storage.
+// Video frames are dropped: they are only useful within one query.
+func storedTurn(turn []Message) []turnMessage {
+	out := make([]turnMessage, 0, len(turn))
+	for _, m := range turn {
+		tm := turnMessage{Role: m.Role, Content: m.Content, ToolCalls: m.ToolCalls, ToolCallID: m.ToolCallID}
+		if m.Role == "tool" && len(tm.Content) > maxStoredToolResult {
+			tm.Content = tm.Content[:maxStoredToolResult] + "\n[...truncated]"
+		}
+		if !m.VideoFrames {
+			for _, img := range m.Images {
+				tm.Images = append(tm.Images, img.URL)
+			}
+		}
+		out = append(out, tm)
+	}
+	return out
+}
+
+// messages expands a stored message into conversation history.
+func (s *storedMessage) messages() []Message {
+	var out []Message
+	for _, tm := range s.Turn {
+		m := Message{Role: tm.Role, Content: tm.Content, ToolCalls: tm.ToolCalls, ToolCallID: tm.ToolCallID}
+		for _, uri := range tm.Images {
+			m.Images = append(m.Images, ImageURL{URL: uri})
+		}
+		out = append(out, m)
+	}
+	m := Message{Role: s.Role, Content: s.Content}
+	for _, uri := range s.Images {
+		m.Images = append(m.Images, ImageURL{URL: uri})
+	}
+	return append(out, m)
+}
+
+// conversationStore holds the stored messages of all chats.
+type conversationStore interface {
+	// Put stores a message of a chat, replacing one with the same ID.
+	Put(chatID, messageID int64, m *storedMessage) error
+	// Get returns a stored message, if it is still retained.
+	Get(chatID, messageID int64) (*storedMessage, bool)
+}
+
+// Conversation store kinds (historyConfig.Store).
+const (
+	historyStoreFile   = "file"
+	historyStoreMemory = "memory"
+)
+
+const defaultHistoryMaxMessages = 1000
+
+// historyRetention limits what is kept of one chat.
+type historyRetention struct {
+	MaxMessages int `json:"max_messages,omitempty"` // newest messages kept (default 1000)
+	MaxAgeDays  int `json:"max_age_days,omitempty"` // older messages are dropped (0 = no limit)
+}
+
+// historyConfig is the "history" part of the bot section in telegram.json.
+type historyConfig struct {
+	Store       string `json:"store,omitempty"` // "file" (default) or "memory"
+	Dir         string `json:"dir,omitempty"`   // file store directory (default: conversations/ in the config dir)
+	MaxMessages int    `json:"max_messages,omitempty"`
+	MaxAgeDays  int    `json:"max_age_days,omitempty"`
+	// Chats overrides the retention for single chats, keyed by chat ID.
+	Chats map[string]historyRetention `json:"chats,omitempty"`
+}
+
+// retention returns the limits for a chat: its own entry if present,
+// with unset fields taken from the defaults.
+func (c *historyConfig) retention(chatID int64) historyRetention {
+	r := historyRetention{MaxMessages: defaultHistoryMaxMessages}
+	if c == nil {
+		return r
+	}
+	if c.MaxMessages > 0 {
+		r.MaxMessages = c.MaxMessages
+	}
+	r.MaxAgeDays = c.MaxAgeDays
+	if own, ok := c.Chats[strconv.FormatInt(chatID, 10)]; ok {
+		if own.MaxMessages > 0 {
+			r.MaxMessages = own.MaxMessages
+		}
+		if own.MaxAgeDays > 0 {
+			r.MaxAgeDays = own.MaxAgeDays
+		}
+	}
+	return r
+}
+
+// conversationsPath is the default file store directory (set from the config dir).
+var conversationsPath = "conversations"
+
+// openConversationStore returns the store configured in cfg (nil = defaults).
+func openConversationStore(cfg *historyConfig) (conversationStore, error) {
+	store := historyStoreFile
+	if cfg != nil && cfg.Store != "" {
+		store = cfg.Store
+	}
+	switch store {
+	case historyStoreMemory:
+		return newMemoryConvStore(cfg.retention), nil
+	case historyStoreFile:
+		dir := conversationsPath
+		if cfg != nil && cfg.Dir != "" {
+			dir = cfg.Dir
+		}
+		return newFileConvStore(dir, cfg.retention)
+	}
+	return nil, fmt.Errorf("unknown history store %q (known: %s, %s)", store, historyStoreFile, historyStoreMemory)
+}
+
+// prune applies the retention to one chat's messages.
+func prune(msgs map[int64]*storedMessage, r historyRetention, now time.Time) {
+	if r.MaxAgeDays > 0 {
+		cutoff := now.AddDate(0, 0, -r.MaxAgeDays)
+		for id, m := range msgs {
+			if m.Time.Before(cutoff) {
+				delete(msgs, id)
+			}
+		}
+	}
+	if r.MaxMessages > 0 && len(msgs) > r.MaxMessages {
+		// Telegram message IDs grow within a chat: drop the lowest
+		ids := make([]int64, 0, len(msgs))
+		for id := range msgs {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids[:len(ids)-r.MaxMessages] {
+			delete(msgs, id)
+		}
+	}
+}
+
+// --- Memory store ---
+
+// memoryConvStore keeps chats in memory only (lost on restart).
+type memoryConvStore struct {
+	mu        sync.RWMutex
+	chats     map[int64]map[int64]*storedMessage // chatID → messageID → msg
+	retention func(chatID int64) historyRetention
+}
+
+func newMemoryConvStore(retention func(chatID int64) historyRetention) *memoryConvStore {
+	return &memoryConvStore{chats: map[int64]map[int64]*storedMessage{}, retention: retention}
+}
+
+func (s *memoryConvStore) Put(chatID, messageID int64, m *storedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chat := s.chats[chatID]
+	if chat == nil {
+		chat = map[int64]*storedMessage{}
+		s.chats[chatID] = chat
+	}
+	chat[messageID] = m
+	prune(chat, s.retention(chatID), time.Now())
+	return nil
+}
+
+func (s *memoryConvStore) Get(chatID, messageID int64) (*storedMessage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.chats[chatID][messageID]
+	return m, ok
+}
+
+// --- File store ---
+
+// fileConvStore keeps each chat in <dir>/<chatID>.jsonl, one record per
+// line; a later record for the same message ID replaces an earlier one.
+// Chats are read into memory on first use. New messages are appended, and
+// the file is rewritten when retention or superseded records leave it
+// with more lines than messages it holds.
+type fileConvStore struct {
+	dir       string
+	retention func(chatID int64) historyRetention
+
+	mu    sync.Mutex
+	chats map[int64]*fileChat
+}
+
+type fileChat struct {
+	msgs  map[int64]*storedMessage
+	lines int // records in the file
+}
+
+// convRecord is one line of a chat file.
+type convRecord struct {
+	ID int64 `json:"id"`
+	storedMessage
+}
+
+func newFileConvStore(dir string, retention func(chatID int64) historyRetention) (*fileConvStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("history store: %w", err)
+	}
+	return &fileConvStore{dir: dir, retention: retention, chats: map[int64]*fileChat{}}, nil
+}
+
+func (s *fileConvStore) path(chatID int64) string {
+	return filepath.Join(s.dir, strconv.FormatInt(chatID, 10)+".jsonl")
+}
+
+func (s *fileConvStore) Put(chatID, messageID int64, m *storedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chat, err := s.chat(chatID)
+	if err != nil {
+		return err
+	}
+	chat.msgs[messageID] = m
+
+	line, err := json.Marshal(convRecord{ID: messageID, storedMessage: *m})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(chatID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	chat.lines++
+
+	prune(chat.msgs, s.retention(chatID), time.Now())
+	if chat.lines > 2*len(chat.msgs)+10 {
+		return s.rewrite(chatID, chat)
+	}
+	return nil
+}
+
+func (s *fileConvStore) Get(chatID, messageID int64) (*storedMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chat, err := s.chat(chatID)
+	if err != nil {
+		return nil, false
+	}
+	m, ok := chat.msgs[messageID]
+	return m, ok
+}
+
+// chat returns a chat, reading its file on first use.
+func (s *fileConvStore) chat(chatID int64) (*fileChat, error) {
+	if chat, ok := s.chats[chatID]; ok {
+		return chat, nil
+	}
+	chat := &fileChat{msgs: map[int64]*storedMessage{}}
+	f, err := os.Open(s.path(chatID))
+	if errors.Is(err, os.ErrNotExist) {
+		s.chats[chatID] = chat
+		return chat, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history store: %w", err)
+	}
+	defer f.Close()
+
+	// Lines with images can be large: read whole lines, not tokens
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec convRecord
+			if json.Unmarshal(line, &rec) == nil && rec.ID != 0 {
+				chat.msgs[rec.ID] = &rec.storedMessage
+			}
+			chat.lines++ // a damaged line (e.g. cut off by a crash) is dropped on rewrite
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("history store: %w", err)
+		}
+	}
+	s.chats[chatID] = chat
+
+	prune(chat.msgs, s.retention(chatID), time.Now())
+	if chat.lines > len(chat.msgs) {
+		if err := s.rewrite(chatID, chat); err != nil {
+			return nil, err
+		}
+	}
+	return chat, nil
+}
+
+// rewrite replaces a chat file with one record per retained message.
+func (s *fileConvStore) rewrite(chatID int64, chat *fileChat) error {
+	ids := make([]int64, 0, len(chat.msgs))
+	for id := range chat.msgs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	path := s.path(chatID)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, id := range ids {
+		if err := enc.Encode(convRecord{ID: id, storedMessage: *chat.msgs[id]}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	chat.lines = len(ids)
+	return nil
+}
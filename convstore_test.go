@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvStore_FileSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &historyConfig{Dir: dir}
+	store, err := openConversationStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	turn := []Message{
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "c1", Type: "function", Function: FuncCall{Name: "web_fetch", Arguments: `{"url":"x"}`}}}},
+		{Role: "tool", ToolCallID: "c1", Content: strings.Repeat("a", maxStoredToolResult+10), Images: []ImageURL{{URL: "data:image/png;base64,AA"}}},
+	}
+	store.Put(5, 10, &storedMessage{Role: "user", Content: "q", Images: []string{"data:image/jpeg;base64,BB"}, MCPNames: []string{"m"}})
+	store.Put(5, 11, &storedMessage{Role: "assistant", Content: "draft", ReplyToMsgID: 10})
+	store.Put(5, 11, &storedMessage{Role: "assistant", Content: "a", ReplyToMsgID: 10, Turn: storedTurn(turn)})
+
+	reopened, err := openConversationStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := reopened.Get(5, 11)
+	if !ok || m.Content != "a" || m.ReplyToMsgID != 10 {
+		t.Fatalf("Get(11) = %+v, %v", m, ok)
+	}
+	msgs := m.messages()
+	if len(msgs) != 3 || msgs[0].ToolCalls[0].Function.Name != "web_fetch" || msgs[1].ToolCallID != "c1" {
+		t.Fatalf("messages = %+v", msgs)
+	}
+	if !strings.HasSuffix(msgs[1].Content, "[...truncated]") || len(msgs[1].Images) != 1 {
+		t.Errorf("tool result = %q, images %v", msgs[1].Content[len(msgs[1].Content)-20:], msgs[1].Images)
+	}
+	if u, ok := reopened.Get(5, 10); !ok || len(u.Images) != 1 || u.MCPNames[0] != "m" {
+		t.Errorf("Get(10) = %+v, %v", u, ok)
+	}
+
+	// The superseded record is dropped when the chat is loaded
+	data, _ := os.ReadFile(filepath.Join(dir, "5.jsonl"))
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("file has %d lines, want 2", n)
+	}
+}
+
+func TestConvStore_Retention(t *testing.T) {
+	cfg := &historyConfig{
+		MaxMessages: 3,
+		MaxAgeDays:  7,
+		Chats:       map[string]historyRetention{"-100": {MaxMessages: 1}},
+	}
+	if r := cfg.retention(1); r != (historyRetention{MaxMessages: 3, MaxAgeDays: 7}) {
+		t.Errorf("retention(1) = %+v", r)
+	}
+	if r := cfg.retention(-100); r != (historyRetention{MaxMessages: 1, MaxAgeDays: 7}) {
+		t.Errorf("retention(-100) = %+v", r)
+	}
+	if r := (*historyConfig)(nil).retention(1); r.MaxMessages != defaultHistoryMaxMessages {
+		t.Errorf("default retention = %+v", r)
+	}
+
+	now := time.Now()
+	msgs := map[int64]*storedMessage{
+		1: {Time: now.AddDate(0, 0, -8)},
+		2: {Time: now}, 3: {Time: now}, 4: {Time: now}, 5: {Time: now},
+	}
+	prune(msgs, cfg.retention(1), now)
+	if len(msgs) != 3 || msgs[2] != nil {
+		t.Errorf("kept %v, want 3..5", msgs)
+	}
+}
+
+func TestBuildConversationChain_IncludesTurns(t *testing.T) {
+	prev := convStore
+	convStore = newMemoryConvStore((*historyConfig)(nil).retention)
+	defer func() { convStore = prev }()
+
+	storeMessage(1, 10, &storedMessage{Role: "user", Content: "q1", SkillNames: []string{"eat"}})
+	storeMessage(1, 11, &storedMessage{Role: "assistant", Content: "a1", ReplyToMsgID: 10, SkillNames: []string{"eat"},
+		Turn: []turnMessage{{Role: "assistant", ToolCalls: []ToolCall{{ID: "c1"}}}, {Role: "tool", ToolCallID: "c1", Content: "r"}}})
+	storeMessage(1, 12, &storedMessage{Role: "user", Content: "q2", ReplyToMsgID: 11})
+
+	chain := buildConversationChain(1, 12)
+	var roles []string
+	for _, m := range chain {
+		roles = append(roles, m.Role)
+	}
+	if got := strings.Join(roles, ","); got != "user,assistant,tool,assistant,user" {
+		t.Errorf("chain roles = %s", got)
+	}
+	if skills, _ := findChainContext(1, 12); len(skills) != 1 || skills[0] != "eat" {
+		t.Errorf("chain skills = %v", skills)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_Errors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) accepted", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc := time.UTC
+	start := time.Date(2026, 10, 16, 8, 30, 15, 0, loc) // a Friday
+	for _, tc := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 16, 8, 31, 0, 0, loc)},
+		{"0 8 * * *", time.Date(2026, 10, 17, 8, 0, 0, 0, loc)},
+		{"@daily", time.Date(2026, 10, 17, 0, 0, 0, 0, loc)},
+		{"*/20 9-18/3 * * *", time.Date(2026, 10, 16, 9, 0, 0, 0, loc)},
+		{"45 8,20 * * 1-5", time.Date(2026, 10, 16, 8, 45, 0, 0, loc)},
+		{"0 10 * * 7", time.Date(2026, 10, 18, 10, 0, 0, 0, loc)},
+		{"0 9 1 * *", time.Date(2026, 11, 1, 9, 0, 0, 0, loc)},
+		{"0 9 1 * 1", time.Date(2026, 10, 19, 9, 0, 0, 0, loc)}, // day or weekday
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, loc)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		spec, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tc.expr, err)
+			continue
+		}
+		if got := spec.next(start); !got.Equal(tc.want) {
+			t.Errorf("%q: next = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"ai-webfetch/tools"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Documents
+//
+// Files sent to the bot (PDF, DOCX, XLSX and text formats) are converted to
+// text in process and attached to the query the same way the REPL embeds
+// @file references. A document too large for the context is split into
+// chunks that a sub-agent condenses with the user's question in mind.
+
+const (
+	maxDocumentSize   = 20 << 20 // Bot API download limit
+	maxDocumentXML    = 64 << 20 // uncompressed size of one office XML part
+	maxDocumentInline = 60000    // characters embedded as is
+	documentChunkSize = 30000    // characters per sub-agent call
+	maxDocumentChunks = 16
+)
+
+const (
+	docxMIME = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	xlsxMIME = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// hasDocument reports whether the message carries a file other than a video or audio.
+func (m *TGMessage) hasDocument() bool {
+	return m.Document != nil && !m.hasVideo() && !m.hasAudio()
+}
+
+// extractDocument returns the text of a document, choosing the format by
+// file name and MIME type.
+func extractDocument(name, mimeType string, data []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case ext == ".pdf" || mimeType == "application/pdf":
+		return pdfText(data)
+	case ext == ".docx" || mimeType == docxMIME:
+		return docxText(data)
+	case ext == ".xlsx" || mimeType == xlsxMIME:
+		return xlsxText(data)
+	case strings.HasPrefix(mimeType, "text/") || isTextFile(name, data):
+		if !utf8.Valid(data) {
+			// Spreadsheet exports on Russian Windows are usually in cp1251
+			return charmap.Windows1251.NewDecoder().String(string(data))
+		}
+		return string(data), nil
+	}
+	if mimeType == "" {
+		mimeType = ext
+	}
+	return "", fmt.Errorf("unsupported file type %s", mimeType)
+}
+
+// pdfText extracts the text layer page by page.
+func pdfText(data []byte) (text string, err error) {
+	// The parser panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("pdf: %v", r)
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("pdf: %w", err)
+	}
+	var sb strings.Builder
+	empty := true
+	fonts := make(map[string]*pdf.Font)
+	for i := 1; i <= r.NumPage(); i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+		for _, name := range p.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				f := p.Font(name)
+				fonts[name] = &f
+			}
+		}
+		pageText, err := p.GetPlainText(fonts)
+		if err != nil {
+			return "", fmt.Errorf("pdf page %d: %w", i, err)
+		}
+		pageText = strings.TrimSpace(pageText)
+		if pageText != "" {
+			empty = false
+		}
+		fmt.Fprintf(&sb, "[Page %d]\n%s\n\n", i, pageText)
+	}
+	if empty {
+		return "", fmt.Errorf("pdf has no text layer (scanned document?)")
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// docxText extracts paragraphs of a Word document, table cells separated by tabs.
+func docxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("docx: %w", err)
+	}
+	dec, closeFn, err := openZipXML(zr, "word/document.xml")
+	if err != nil {
+		return "", fmt.Errorf("docx: %w", err)
+	}
+	defer closeFn()
+
+	var buf bytes.Buffer
+	// endWith replaces a trailing separator of a finished paragraph or cell
+	endWith := func(old, sep byte) {
+		if n := buf.Len(); n > 0 && buf.Bytes()[n-1] == old {
+			buf.Truncate(n - 1)
+		}
+		buf.WriteByte(sep)
+	}
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("docx: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				buf.WriteByte('\t')
+			case "br", "cr":
+				buf.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				buf.WriteByte('\n')
+			case "tc":
+				endWith('\n', '\t')
+			case "tr":
+				endWith('\t', '\n')
+			}
+		case xml.CharData:
+			if inText {
+				buf.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// xlsxText renders each worksheet as tab-separated rows under a "[Sheet: name]" header.
+func xlsxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("xlsx: %w", err)
+	}
+	shared, err := xlsxSharedStrings(zr)
+	if err != nil {
+		return "", fmt.Errorf("xlsx: %w", err)
+	}
+	sheets, err := xlsxSheets(zr)
+	if err != nil {
+		return "", fmt.Errorf("xlsx: %w", err)
+	}
+	var sb strings.Builder
+	for _, sh := range sheets {
+		fmt.Fprintf(&sb, "[Sheet: %s]\n", sh.name)
+		if err := xlsxSheetText(zr, sh.part, shared, &sb); err != nil {
+			return "", fmt.Errorf("xlsx sheet %q: %w", sh.name, err)
+		}
+		sb.WriteByte('\n')
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+type xlsxSheet struct {
+	name string
+	part string // zip path of the worksheet
+}
+
+// xlsxSheets lists the worksheets in workbook order.
+func xlsxSheets(zr *zip.Reader) ([]xlsxSheet, error) {
+	var wb struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := unmarshalZipXML(zr, "xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := unmarshalZipXML(zr, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Rels))
+	for _, r := range rels.Rels {
+		// Targets are relative to xl/ unless absolute
+		if strings.HasPrefix(r.Target, "/") {
+			targets[r.ID] = strings.TrimPrefix(r.Target, "/")
+		} else {
+			targets[r.ID] = path.Join("xl", r.Target)
+		}
+	}
+	var sheets []xlsxSheet
+	for _, s := range wb.Sheets {
+		if part, ok := targets[s.RID]; ok {
+			sheets = append(sheets, xlsxSheet{name: s.Name, part: part})
+		}
+	}
+	return sheets, nil
+}
+
+// xlsxSharedStrings reads the shared string table; a workbook may have none.
+func xlsxSharedStrings(zr *zip.Reader) ([]string, error) {
+	dec, closeFn, err := openZipXML(zr, "xl/sharedStrings.xml")
+	if err != nil {
+		return nil, nil
+	}
+	defer closeFn()
+
+	var strs []string
+	var cur strings.Builder
+	inText, inPhonetic := false, false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return strs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				cur.Reset()
+			case "t":
+				inText = true
+			case "rPh": // phonetic hints, not part of the value
+				inPhonetic = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				strs = append(strs, cur.String())
+			case "t":
+				inText = false
+			case "rPh":
+				inPhonetic = false
+			}
+		case xml.CharData:
+			if inText && !inPhonetic {
+				cur.Write(t)
+			}
+		}
+	}
+}
+
+// xlsxSheetText writes the rows of one worksheet, keeping cells in their columns.
+func xlsxSheetText(zr *zip.Reader, part string, shared []string, w *strings.Builder) error {
+	dec, closeFn, err := openZipXML(zr, part)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	var row []string
+	var cellRef, cellType string
+	var value strings.Builder
+	inValue := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = row[:0]
+			case "c":
+				cellRef, cellType = "", ""
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "r":
+						cellRef = a.Value
+					case "t":
+						cellType = a.Value
+					}
+				}
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				v := value.String()
+				switch cellType {
+				case "s":
+					if i, err := strconv.Atoi(v); err == nil && i >= 0 && i < len(shared) {
+						v = shared[i]
+					}
+				case "b":
+					if v == "1" {
+						v = "TRUE"
+					} else {
+						v = "FALSE"
+					}
+				}
+				col := len(row)
+				if c, ok := xlsxColumn(cellRef); ok {
+					col = c
+				}
+				for len(row) <= col {
+					row = append(row, "")
+				}
+				row[col] = strings.ReplaceAll(v, "\n", " ")
+			case "row":
+				for len(row) > 0 && row[len(row)-1] == "" {
+					row = row[:len(row)-1]
+				}
+				if len(row) > 0 {
+					w.WriteString(strings.Join(row, "\t"))
+					w.WriteByte('\n')
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+}
+
+// xlsxColumn returns the zero-based column of a cell reference like "C12".
+func xlsxColumn(ref string) (int, bool) {
+	col, n := 0, 0
+	for ; n < len(ref) && 'A' <= ref[n] && ref[n] <= 'Z'; n++ {
+		col = col*26 + int(ref[n]-'A'+1)
+	}
+	if n == 0 || col > 16384 { // the format's column limit
+		return 0, false
+	}
+	return col - 1, true
+}
+
+// openZipXML opens an XML part of an office document, limiting its size.
+func openZipXML(zr *zip.Reader, name string) (*xml.Decoder, func() error, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return xml.NewDecoder(io.LimitReader(f, maxDocumentXML)), f.Close, nil
+}
+
+func unmarshalZipXML(zr *zip.Reader, name string, v any) error {
+	dec, closeFn, err := openZipXML(zr, name)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// documentAttachment formats the document for the query. Text over
+// maxDocumentInline characters is condensed chunk by chunk by a sub-agent
+// that is given the user's question; progress is reported via notify.
+func documentAttachment(ctx context.Context, name, text, question, prompt string, notify func(string)) (string, error) {
+	if utf8.RuneCountInString(text) <= maxDocumentInline {
+		return fmt.Sprintf("\n--- File: %s ---\n%s\n--- End of file ---\n", name, text), nil
+	}
+	if tools.SubAgentFn == nil {
+		return "", fmt.Errorf("document is too large and no sub-agent is configured")
+	}
+
+	chunks := chunkText(text, documentChunkSize)
+	truncated := len(chunks) > maxDocumentChunks
+	if truncated {
+		chunks = chunks[:maxDocumentChunks]
+	}
+	notify(fmt.Sprintf("📄 Документ большой, обрабатываю по частям (%d)...", len(chunks)))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\n--- File: %s (%d characters; condensed by parts with respect to the question) ---\n",
+		name, utf8.RuneCountInString(text))
+	for i, chunk := range chunks {
+		input := fmt.Sprintf("Question: %s\n\nDocument %s, part %d of %d:\n\n%s", question, name, i+1, len(chunks), chunk)
+		summary, err := tools.SubAgentFn(ctx, prompt, input)
+		if err != nil {
+			return "", fmt.Errorf("part %d of %d: %w", i+1, len(chunks), err)
+		}
+		fmt.Fprintf(&sb, "\n[Part %d of %d]\n%s\n", i+1, len(chunks), strings.TrimSpace(stripThinkTags(summary)))
+	}
+	if truncated {
+		fmt.Fprintf(&sb, "\n[The rest of the document (after %d characters) was not processed]\n",
+			maxDocumentChunks*documentChunkSize)
+	}
+	sb.WriteString("--- End of file ---\n")
+	return sb.String(), nil
+}
+
+// chunkText splits text into pieces of at most size runes, preferring to
+// cut at a paragraph or line break in the second half of a piece.
+func chunkText(text string, size int) []string {
+	var chunks []string
+	for text != "" {
+		if utf8.RuneCountInString(text) <= size {
+			chunks = append(chunks, text)
+			break
+		}
+		// Byte offset of the size-th rune
+		end := 0
+		for n := 0; n < size; n++ {
+			_, w := utf8.DecodeRuneInString(text[end:])
+			end += w
+		}
+		cut := end
+		if i := strings.LastIndex(text[:end], "\n\n"); i > end/2 {
+			cut = i + 2
+		} else if i := strings.LastIndex(text[:end], "\n"); i > end/2 {
+			cut = i + 1
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	return chunks
+}
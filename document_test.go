@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"ai-webfetch/tools"
+)
+
+// zipFiles builds an office document from its parts.
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// minimalPDF builds a one-page PDF showing text, with a valid xref table.
+func minimalPDF(text string) []byte {
+	content := fmt.Sprintf("BT /F1 12 Tf 10 100 Td (%s) Tj ET", text)
+	objs := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 200] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+	return buf.Bytes()
+}
+
+func TestExtractDocument(t *testing.T) {
+	docx := zipFiles(t, map[string]string{
+		"word/document.xml": `<?xml version="1.0"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>Hello</w:t></w:r><w:r><w:t xml:space="preserve"> world</w:t></w:r></w:p>
+<w:tbl><w:tr><w:tc><w:p><w:r><w:t>a</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>b</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+</w:body></w:document>`,
+	})
+	xlsx := zipFiles(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Budget" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><si><t>Item</t></si><si><r><t>Co</t></r><r><t>st</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="inlineStr"><is><t>Rent</t></is></c><c r="C2"><v>1200</v></c><c r="D2" t="b"><v>1</v></c></row>
+</sheetData></worksheet>`,
+	})
+
+	tests := []struct {
+		name, mime string
+		data       []byte
+		want       string
+	}{
+		{"notes.md", "", []byte("# Title\ntext"), "# Title\ntext"},
+		{"data.csv", "text/csv", []byte{0xd6, 0xe5, 0xed, 0xe0}, "Цена"}, // cp1251
+		{"letter.docx", docxMIME, docx, "Hello world\na\tb"},
+		{"budget.xlsx", "", xlsx, "[Sheet: Budget]\nItem\t\tCost\nRent\t\t1200\tTRUE"},
+		{"report.pdf", "application/pdf", minimalPDF("Hello PDF"), "[Page 1]\nHello PDF"},
+	}
+	for _, tt := range tests {
+		got, err := extractDocument(tt.name, tt.mime, tt.data)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := extractDocument("archive.bin", "application/octet-stream", []byte{0, 1, 2}); err == nil {
+		t.Error("binary file accepted")
+	}
+	if _, err := extractDocument("broken.pdf", "", []byte("%PDF-1.4 garbage")); err == nil {
+		t.Error("broken pdf accepted")
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	text := strings.Repeat("ы", 6) + "\n\n" + strings.Repeat("ж", 10)
+	chunks := chunkText(text, 10)
+	if strings.Join(chunks, "") != text {
+		t.Fatalf("chunks lose text: %q", chunks)
+	}
+	if len(chunks) != 2 || chunks[0] != strings.Repeat("ы", 6)+"\n\n" {
+		t.Errorf("chunks = %q", chunks)
+	}
+	for _, c := range chunkText(strings.Repeat("ю", 25), 10) {
+		if n := len([]rune(c)); n > 10 {
+			t.Errorf("chunk of %d runes", n)
+		}
+	}
+}
+
+func TestDocumentAttachment(t *testing.T) {
+	orig := tools.SubAgentFn
+	defer func() { tools.SubAgentFn = orig }()
+	var calls []string
+	tools.SubAgentFn = func(ctx context.Context, systemPrompt, userMessage string) (string, error) {
+		calls = append(calls, userMessage)
+		return fmt.Sprintf("summary %d", len(calls)), nil
+	}
+	notify := func(string) {}
+
+	small, err := documentAttachment(context.Background(), "a.txt", "short", "q", "prompt", notify)
+	if err != nil || small != "\n--- File: a.txt ---\nshort\n--- End of file ---\n" || len(calls) != 0 {
+		t.Errorf("small document: %q, %v, %d sub-agent calls", small, err, len(calls))
+	}
+
+	text := strings.Repeat("line\n", maxDocumentInline/5+documentChunkSize/5)
+	large, err := documentAttachment(context.Background(), "big.txt", text, "what is it?", "prompt", notify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 || !strings.HasPrefix(calls[0], "Question: what is it?") {
+		t.Errorf("sub-agent calls: %d, first %q", len(calls), truncate(calls[0], 40))
+	}
+	if !strings.Contains(large, "[Part 3 of 3]\nsummary 3") || strings.Contains(large, "line\nline") {
+		t.Errorf("large document attachment = %q", large)
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"ai-webfetch/tools"
 
@@ -40,6 +43,10 @@ type interactiveConfig struct {
 	Mode string
 	// SkillNames lists active skills (for userinfo prompt block).
 	SkillNames []string
+	// UserName is the users.json name usage is recorded under.
+	UserName string
+	// Session is the tool session shared by all queries of the REPL.
+	Session *tools.Session
 }
 
 // expandedInput holds the result of expanding @file references.
@@ -52,8 +59,7 @@ type expandedInput struct {
 // If initialQuery is non-empty, it's processed first; then the loop waits for more input.
 func runInteractive(ic interactiveConfig, initialQuery string) error {
 	// Set up CLI prompter
-	tools.SetPrompter(&CLIPrompter{})
-	defer tools.ClearPrompter()
+	ic.Session.Prompter = &CLIPrompter{}
 
 	printBanner(ic)
 
@@ -78,12 +84,16 @@ func runInteractive(ic interactiveConfig, initialQuery string) error {
 
 	var history []Message
 	query := initialQuery
+	measured := 0 // real context size reported by the last query (0 = estimate)
 
 	for {
 		if query == "" {
 			line, err := rl.Readline()
+			if err == readline.ErrInterrupt {
+				// Ctrl+C at the prompt clears the line; Ctrl+D or /exit quits
+				continue
+			}
 			if err != nil {
-				// EOF or interrupt
 				break
 			}
 			query = strings.TrimSpace(line)
@@ -100,12 +110,28 @@ func runInteractive(ic interactiveConfig, initialQuery string) error {
 
 		// Compact command
 		if query == "/compact" {
-			compacted, err := compactHistory(ic.Cfg, ic.ModelID, history, ic.Logf, ic.Think)
+			ctx, stop := turnContext()
+			ctx, meter := withUsageMeter(ctx)
+			compacted, err := compactHistory(ctx, ic.Cfg, ic.ModelID, history, ic.Logf, ic.Think)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%sCompact error: %v%s\n", colorCyan, err, colorReset)
 			} else {
-				history = compacted
-				printContextUsage(ic.Cfg, ic.Prompts, history)
+				history, measured = compacted, 0
+				printContextUsage(ic.Cfg, ic.Prompts, history, measured)
+			}
+			stop()
+			ic.saveUsage(usageModeQuery, meter)
+			query = ""
+			continue
+		}
+
+		// Usage command
+		if query == "/usage" {
+			report, err := usageReport(ic.UserName, time.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sUsage error: %v%s\n", colorCyan, err, colorReset)
+			} else {
+				fmt.Fprintln(os.Stderr, report)
 			}
 			query = ""
 			continue
@@ -118,26 +144,63 @@ func runInteractive(ic interactiveConfig, initialQuery string) error {
 			continue
 		}
 
+		// Model command: "/model" lists, "/model <name>" switches for the session,
+		// "/model <name> <query>" uses the model for one query only
+		qCfg, qModelID := ic.Cfg, ic.ModelID
+		if query == "/model" {
+			fmt.Fprintf(os.Stderr, "%sModels (current: %s):%s\n%s", colorBold, ic.ModelID, colorReset, models.describe())
+			query = ""
+			continue
+		}
+		if name, ok := strings.CutPrefix(query, "/model "); ok && !strings.Contains(strings.TrimSpace(name), " ") {
+			c, id, err := models.get(strings.TrimSpace(name))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sModel error: %v%s\n", colorCyan, err, colorReset)
+			} else {
+				ic.Cfg, ic.ModelID = c, id
+				measured = 0 // different tokenizer
+				fmt.Fprintf(os.Stderr, "%sSwitched to model %s%s\n", colorDim, id, colorReset)
+			}
+			query = ""
+			continue
+		}
+		if name, rest := parseModelPrefix(query); name != "" {
+			c, id, err := models.get(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sModel error: %v%s\n", colorCyan, err, colorReset)
+				query = ""
+				continue
+			}
+			qCfg, qModelID, query = c, id, rest
+		}
+
 		// Expand @file references (text + images)
 		expanded := expandFileRefs(query)
 
+		// Ctrl+C from here on cancels this turn only
+		ctx, stop := turnContext()
+		ctx, meter := withUsageMeter(ctx)
+		usageMode := usageModeQuery
+
 		// Dispatch: /news command or general query
 		switch {
 		case expanded.Query == "/news" || strings.HasPrefix(expanded.Query, "/news "):
-			result, err := processNewsCommand(expanded.Query, ic.Cfg, ic.ModelID, ic.ShowThinking, ic.Logf,
+			usageMode = usageModeNews
+			result, err := processNewsCommand(ctx, ic.Session, expanded.Query, qCfg, qModelID, ic.ShowThinking, ic.Logf,
 				ic.Prompts, ic.NewsConfigPath, ic.McpMgr, ic.McpNames, ic.Think, ic.McpOverrides)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "\n%sError: %v%s\n", colorCyan, err, colorReset)
 			} else {
 				history = append(history, Message{Role: "user", Content: expanded.Query})
 				history = append(history, Message{Role: "assistant", Content: result})
+				measured = 0 // the news pipeline's calls don't reflect this history
 			}
 
 		default:
 			// General query — use LLM with full conversation history
 			history = append(history, Message{Role: "user", Content: expanded.Query, Images: expanded.Images})
 			activeModules := append(append([]string{}, ic.SkillNames...), ic.McpNames...)
-			result, err := runQuery(ic.Cfg, ic.ModelID, expanded.Query, ic.ShowThinking, ic.VerboseTools,
+			result, _, err := runQuery(ctx, ic.Session, qCfg, qModelID, expanded.Query, ic.ShowThinking, ic.VerboseTools,
 				os.Stdout, ic.Logf, ic.Prompts, ic.McpMgr, ic.McpNames, ic.Think,
 				expanded.Images, nil, history[:len(history)-1], ic.McpOverrides, activeModules)
 			if err != nil {
@@ -145,26 +208,36 @@ func runInteractive(ic interactiveConfig, initialQuery string) error {
 				history = history[:len(history)-1] // remove failed user message
 			} else {
 				history = append(history, Message{Role: "assistant", Content: result})
+				if qModelID == ic.ModelID {
+					measured = meter.lastContextTokens()
+				} else {
+					measured = 0
+				}
 			}
 		}
+		stop()
+		ic.saveUsage(usageMode, meter)
 
 		// Show context usage
-		printContextUsage(ic.Cfg, ic.Prompts, history)
+		printContextUsage(ic.Cfg, ic.Prompts, history, measured)
 
 		// Auto-compact if >80% context used
 		if ic.Cfg.Limit.Context > 0 {
-			allMsgs := append([]Message{{Role: "system", Content: ic.Prompts.SystemPrompt}}, history...)
-			tokens := estimateTokens(allMsgs)
+			tokens := contextTokens(ic.Prompts, history, measured)
 			pct := tokens * 100 / ic.Cfg.Limit.Context
 			if pct > 80 {
 				fmt.Fprintf(os.Stderr, "\n%s⚠ Context > 80%%, auto-compacting...%s\n",
 					colorCyan, colorReset)
-				compacted, err := compactHistory(ic.Cfg, ic.ModelID, history, ic.Logf, ic.Think)
+				ctx, stop := turnContext()
+				ctx, meter := withUsageMeter(ctx)
+				compacted, err := compactHistory(ctx, ic.Cfg, ic.ModelID, history, ic.Logf, ic.Think)
+				stop()
+				ic.saveUsage(usageModeQuery, meter)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%sAuto-compact error: %v%s\n", colorCyan, err, colorReset)
 				} else {
-					history = compacted
-					printContextUsage(ic.Cfg, ic.Prompts, history)
+					history, measured = compacted, 0
+					printContextUsage(ic.Cfg, ic.Prompts, history, measured)
 				}
 			}
 		}
@@ -175,6 +248,34 @@ func runInteractive(ic interactiveConfig, initialQuery string) error {
 	return nil
 }
 
+// saveUsage adds a turn's token usage to the usage ledger.
+func (ic interactiveConfig) saveUsage(mode string, m *usageMeter) {
+	if err := saveQueryUsage(ic.UserName, mode, m); err != nil {
+		ic.Logf("%susage ledger error: %v%s\n", colorDim, err, colorReset)
+	}
+}
+
+// turnContext returns a context cancelled by Ctrl+C, so an interrupt
+// aborts the running turn (LLM stream, tool calls, sub-agents) but not
+// the REPL. stop must be called when the turn is over.
+func turnContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		select {
+		case <-sig:
+			fmt.Fprint(os.Stderr, colorReset+"\n")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
+
 // --- Readline auto-completion ---
 
 // interactiveCompleter provides tab completion for the REPL.
@@ -263,7 +364,7 @@ func completeFilePath(partial string, prefixLen int) ([][]rune, int) {
 }
 
 func completeCommand(prefix string) ([][]rune, int) {
-	commands := []string{"/news", "/compact", "/help", "/exit", "/quit"}
+	commands := []string{"/news", "/model", "/compact", "/usage", "/help", "/exit", "/quit"}
 	var candidates [][]rune
 	for _, cmd := range commands {
 		if strings.HasPrefix(cmd, prefix) {
@@ -480,7 +581,7 @@ func printBanner(ic interactiveConfig) {
 	if ic.McpMgr != nil && (len(ic.McpNames) > 0 || len(ic.McpOverrides) > 0) {
 		active = append(active, "MCP")
 	}
-	if tools.AskAvailable() {
+	if ic.Session.AskAvailable() {
 		active = append(active, "ask_user")
 	}
 	if len(active) > 0 {
@@ -500,9 +601,14 @@ func printHelp(ic interactiveConfig) {
 	fmt.Fprintf(os.Stderr, "  %s/news <topic>%s     Search by topic\n", colorCyan, colorReset)
 	fmt.Fprintf(os.Stderr, "  %s@file%s             Attach file to query (Tab for auto-completion)\n", colorCyan, colorReset)
 	fmt.Fprintf(os.Stderr, "  %s@\"path with spaces\"%s  Attach file with spaces in path\n", colorCyan, colorReset)
+	fmt.Fprintf(os.Stderr, "  %s/model%s            List configured models\n", colorCyan, colorReset)
+	fmt.Fprintf(os.Stderr, "  %s/model <name>%s     Switch model for this session\n", colorCyan, colorReset)
+	fmt.Fprintf(os.Stderr, "  %s/model <name> <q>%s Use model for a single query\n", colorCyan, colorReset)
 	fmt.Fprintf(os.Stderr, "  %s/compact%s          Compact context (summarize history to save tokens)\n", colorCyan, colorReset)
+	fmt.Fprintf(os.Stderr, "  %s/usage%s            Token usage today and this month (per model and mode)\n", colorCyan, colorReset)
 	fmt.Fprintf(os.Stderr, "  %s/help%s             This help\n", colorCyan, colorReset)
 	fmt.Fprintf(os.Stderr, "  %s/exit%s             Quit (or /quit, /q, Ctrl+D)\n", colorCyan, colorReset)
+	fmt.Fprintf(os.Stderr, "  %sCtrl+C%s            Cancel the running query (clears the line at the prompt)\n", colorCyan, colorReset)
 	fmt.Fprintf(os.Stderr, "\n%sFile formats:%s\n", colorBold, colorReset)
 	fmt.Fprintf(os.Stderr, "  %sText%s (.go, .py, .md, .json, ...)     — embedded in query\n", colorDim, colorReset)
 	fmt.Fprintf(os.Stderr, "  %sImages%s (.png, .jpg, .webp, ...)      — attached as vision\n", colorDim, colorReset)
@@ -516,7 +622,7 @@ func printHelp(ic interactiveConfig) {
 
 // --- News command dispatcher ---
 
-func processNewsCommand(text string, cfg modelConfig, modelID string,
+func processNewsCommand(ctx context.Context, sess *tools.Session, text string, cfg modelConfig, modelID string,
 	showThinking bool, logf func(string, ...any), prompts *Prompts,
 	newsConfigPath string, mcpMgr *MCPManager, mcpNames []string,
 	think thinkMode, mcpOverrides map[string]bool) (string, error) {
@@ -525,7 +631,7 @@ func processNewsCommand(text string, cfg modelConfig, modelID string,
 	contentOut := os.Stdout
 
 	if arg == "" {
-		return runNewsSummary(cfg, modelID, showThinking, contentOut, logf,
+		return runNewsSummary(ctx, cfg, modelID, showThinking, contentOut, logf,
 			newsConfigPath, prompts, mcpMgr, mcpNames, think, mcpOverrides)
 	}
 
@@ -535,35 +641,48 @@ func processNewsCommand(text string, cfg modelConfig, modelID string,
 	}
 
 	if cat := matchCategory(arg, categories); cat != nil {
-		prompter := tools.GetPrompter()
+		prompter := sess.Prompter
 		if prompter == nil {
-			return runNewsSummary(cfg, modelID, showThinking, contentOut, logf,
+			return runNewsSummary(ctx, cfg, modelID, showThinking, contentOut, logf,
 				newsConfigPath, prompts, mcpMgr, mcpNames, think, mcpOverrides)
 		}
-		return runNewsBrowse(cfg, modelID, cat, showThinking, contentOut, logf,
+		return runNewsBrowse(ctx, cfg, modelID, cat, showThinking, contentOut, logf,
 			prompts, mcpMgr, mcpNames, think, mcpOverrides, prompter)
 	}
 
-	return runNewsSearch(cfg, modelID, arg, showThinking, contentOut, logf,
+	return runNewsSearch(ctx, cfg, modelID, arg, showThinking, contentOut, logf,
 		newsConfigPath, prompts, mcpMgr, mcpNames, think, mcpOverrides)
 }
 
 // --- Context tracking ---
 
-func printContextUsage(cfg modelConfig, prompts *Prompts, history []Message) {
+// contextTokens returns the conversation size: measured (prompt+completion
+// tokens reported for the last response) if known, otherwise an estimate.
+func contextTokens(prompts *Prompts, history []Message, measured int) int {
+	if measured > 0 {
+		return measured
+	}
 	allMsgs := make([]Message, 0, len(history)+1)
 	allMsgs = append(allMsgs, Message{Role: "system", Content: prompts.SystemPrompt})
 	allMsgs = append(allMsgs, history...)
-	tokens := estimateTokens(allMsgs)
+	return estimateTokens(allMsgs)
+}
+
+func printContextUsage(cfg modelConfig, prompts *Prompts, history []Message, measured int) {
+	tokens := contextTokens(prompts, history, measured)
+	approx := "~"
+	if measured > 0 {
+		approx = ""
+	}
 
 	if cfg.Limit.Context > 0 {
 		pct := tokens * 100 / cfg.Limit.Context
 		bar := contextBar(pct)
-		fmt.Fprintf(os.Stderr, "%s[context: ~%dk/%dk tokens %s %d%%]%s\n",
-			colorDim, tokens/1000, cfg.Limit.Context/1000, bar, pct, colorReset)
+		fmt.Fprintf(os.Stderr, "%s[context: %s%dk/%dk tokens %s %d%%]%s\n",
+			colorDim, approx, tokens/1000, cfg.Limit.Context/1000, bar, pct, colorReset)
 	} else {
-		fmt.Fprintf(os.Stderr, "%s[context: ~%dk tokens]%s\n",
-			colorDim, tokens/1000, colorReset)
+		fmt.Fprintf(os.Stderr, "%s[context: %s%dk tokens]%s\n",
+			colorDim, approx, tokens/1000, colorReset)
 	}
 }
 
@@ -578,12 +697,13 @@ func contextBar(pct int) string {
 
 // --- Context compaction ---
 
-func compactHistory(cfg modelConfig, modelID string, history []Message,
+func compactHistory(ctx context.Context, cfg modelConfig, modelID string, history []Message,
 	logf func(string, ...any), think thinkMode) ([]Message, error) {
 
 	if len(history) == 0 {
 		return history, nil
 	}
+	cfg, modelID = models.forRole(roleCompact, cfg, modelID)
 
 	var sb strings.Builder
 	for _, m := range history {
@@ -610,7 +730,7 @@ func compactHistory(cfg modelConfig, modelID string, history []Message,
 
 	logf("%sCompacting context (%d messages)...%s\n", colorDim, len(history), colorReset)
 
-	summary, err := doChat(cfg.BaseURL, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, think)
+	summary, err := doChat(ctx, cfg, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, think)
 	if err != nil {
 		return history, fmt.Errorf("compact LLM call: %w", err)
 	}
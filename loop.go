@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+// loopConfig bounds runQuery's tool loop. Zero values mean defaults.
+type loopConfig struct {
+	MaxRounds  int `json:"maxRounds,omitempty"`  // tool rounds per query (default 30)
+	MaxRepeats int `json:"maxRepeats,omitempty"` // identical tool calls (name + arguments) per query (default 3)
+	TimeoutSec int `json:"timeoutSec,omitempty"` // wall-clock budget per query (default 600)
+	// ParallelTools is how many concurrency-safe tool calls of one round
+	// run at once (default 4, 1 = sequential). Also used by sub-agents.
+	ParallelTools int `json:"parallelTools,omitempty"`
+}
+
+const (
+	defaultLoopMaxRounds     = 30
+	defaultLoopMaxRepeats    = 3
+	defaultLoopTimeout       = 10 * time.Minute
+	defaultLoopParallelTools = 4
+
+	// loopFinalTimeout is the budget of the answer without tools, which
+	// may start when the query's own budget is used up.
+	loopFinalTimeout = 2 * time.Minute
+)
+
+// loopBudgetReason is the stop reason once the query's time budget is used up.
+const loopBudgetReason = "time budget for this request exhausted"
+
+func (c loopConfig) parallelTools() int {
+	if c.ParallelTools > 0 {
+		return c.ParallelTools
+	}
+	return defaultLoopParallelTools
+}
+
+// loopFinalPrompt asks for an answer without tools once the loop is stopped.
+const loopFinalPrompt = "[Tool use stopped: %s. Do not call any more tools. Answer now with the information gathered so far and say briefly what is missing.]"
+
+// loopGuard tracks one tool loop (runQuery, mailSynthesis) and tells it when to stop.
+type loopGuard struct {
+	maxRounds  int
+	maxRepeats int
+	deadline   time.Time
+	calls      map[string]int // canonical "name args" -> times requested
+}
+
+func newLoopGuard(cfg loopConfig, start time.Time) *loopGuard {
+	g := &loopGuard{
+		maxRounds:  defaultLoopMaxRounds,
+		maxRepeats: defaultLoopMaxRepeats,
+		deadline:   start.Add(defaultLoopTimeout),
+		calls:      map[string]int{},
+	}
+	if cfg.MaxRounds > 0 {
+		g.maxRounds = cfg.MaxRounds
+	}
+	if cfg.MaxRepeats > 0 {
+		g.maxRepeats = cfg.MaxRepeats
+	}
+	if cfg.TimeoutSec > 0 {
+		g.deadline = start.Add(time.Duration(cfg.TimeoutSec) * time.Second)
+	}
+	return g
+}
+
+// stop is called with the tool calls of round n (1-based) before they are
+// executed. It returns a non-empty reason when the loop must end instead.
+func (g *loopGuard) stop(round int, calls []ToolCall, now time.Time) string {
+	if round > g.maxRounds {
+		return fmt.Sprintf("limit of %d tool rounds reached", g.maxRounds)
+	}
+	if now.After(g.deadline) {
+		return loopBudgetReason
+	}
+	for _, tc := range calls {
+		key := tc.Function.Name + " " + canonicalArgs(tc.Function.Arguments)
+		g.calls[key]++
+		if g.calls[key] >= g.maxRepeats {
+			return fmt.Sprintf("%s was called %d times with the same arguments", tc.Function.Name, g.calls[key])
+		}
+	}
+	return ""
+}
+
+// canonicalArgs re-encodes JSON arguments so that whitespace and key order
+// do not hide a repeated call.
+func canonicalArgs(args string) string {
+	var v any
+	if err := json.Unmarshal([]byte(args), &v); err != nil {
+		return args
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return args
+	}
+	return string(b)
+}
+
+// toolCallResult is the outcome of one tool call of a round.
+type toolCallResult struct {
+	Content    string   // result text, or "error: ..." if the tool failed
+	Images     []string // data URIs produced by the tool (Session.TakePendingImages)
+	StripVideo bool     // tool asked to strip old video frames
+}
+
+// execToolCalls runs a round's tool calls in sess and returns their results
+// in the original order. Runs of consecutive concurrency-safe calls execute
+// in parallel, up to parallel at a time, each with its own sess.ForCall view;
+// every other call runs alone on the calling goroutine. before is called for
+// each call just before it starts.
+func execToolCalls(ctx context.Context, sess *tools.Session, calls []ToolCall, exec toolExecFunc, parallel int, before func(ToolCall)) ([]toolCallResult, error) {
+	results := make([]toolCallResult, len(calls))
+	run := func(i int, cs *tools.Session) {
+		tc := calls[i]
+		res, err := exec(ctx, cs, tc.Function.Name, json.RawMessage(tc.Function.Arguments))
+		if err != nil {
+			res = "error: " + err.Error()
+		}
+		results[i] = toolCallResult{
+			Content:    res,
+			Images:     cs.TakePendingImages(),
+			StripVideo: cs.TakeVideoFrameStrip(),
+		}
+	}
+
+	for i := 0; i < len(calls); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Batch of consecutive safe calls
+		j := i
+		for j < len(calls) && parallel > 1 && tools.ConcurrencySafe(calls[j].Function.Name) {
+			j++
+		}
+		if j-i < 2 {
+			before(calls[i])
+			run(i, sess)
+			i++
+			continue
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, parallel)
+		for k := i; k < j; k++ {
+			before(calls[k])
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(cs *tools.Session) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				defer func() {
+					if r := recover(); r != nil {
+						results[k] = toolCallResult{Content: fmt.Sprintf("error: panic: %v", r)}
+					}
+				}()
+				run(k, cs)
+			}(sess.ForCall())
+		}
+		wg.Wait()
+		i = j
+	}
+	return results, nil
+}
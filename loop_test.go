@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+func testToolCall(name, args string) ToolCall {
+	return ToolCall{Function: FuncCall{Name: name, Arguments: args}}
+}
+
+func TestLoopGuard_RepeatedCalls(t *testing.T) {
+	now := time.Now()
+	g := newLoopGuard(loopConfig{}, now)
+	if r := g.stop(1, []ToolCall{testToolCall("fs_grep", `{"pattern":"x","path":"."}`)}, now); r != "" {
+		t.Fatalf("round 1 stopped: %s", r)
+	}
+	// Different arguments are a different call
+	if r := g.stop(2, []ToolCall{testToolCall("fs_grep", `{"pattern":"y","path":"."}`)}, now); r != "" {
+		t.Fatalf("round 2 stopped: %s", r)
+	}
+	// Key order and whitespace do not matter
+	if r := g.stop(3, []ToolCall{testToolCall("fs_grep", `{ "path": ".", "pattern": "x" }`)}, now); r != "" {
+		t.Fatalf("round 3 stopped: %s", r)
+	}
+	r := g.stop(4, []ToolCall{testToolCall("fs_grep", `{"pattern":"x","path":"."}`)}, now)
+	if !strings.Contains(r, "fs_grep was called 3 times") {
+		t.Errorf("reason = %q", r)
+	}
+}
+
+func TestLoopGuard_RoundsAndTime(t *testing.T) {
+	now := time.Now()
+	g := newLoopGuard(loopConfig{MaxRounds: 2, TimeoutSec: 60}, now)
+	if r := g.stop(2, []ToolCall{testToolCall("a", "{}")}, now); r != "" {
+		t.Fatalf("round 2 stopped: %s", r)
+	}
+	if r := g.stop(3, []ToolCall{testToolCall("b", "{}")}, now); !strings.Contains(r, "2 tool rounds") {
+		t.Errorf("round cap reason = %q", r)
+	}
+
+	g = newLoopGuard(loopConfig{TimeoutSec: 60}, now)
+	if r := g.stop(1, []ToolCall{testToolCall("a", "{}")}, now.Add(61*time.Second)); !strings.Contains(r, "time budget") {
+		t.Errorf("timeout reason = %q", r)
+	}
+}
+
+func TestExecToolCalls_ParallelInOrder(t *testing.T) {
+	var running, peak atomic.Int32
+	register := func(name string, safe bool) {
+		tools.Register(&tools.Tool{
+			Def:             tools.Definition{Type: "function", Function: tools.Function{Name: name}},
+			ConcurrencySafe: safe,
+			Execute: func(_ context.Context, sess *tools.Session, args json.RawMessage) (string, error) {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+				}
+				time.Sleep(20 * time.Millisecond)
+				if !sess.ImapAvailable() {
+					return "", fmt.Errorf("session config lost")
+				}
+				sess.SetPendingImages([]string{"img" + string(args)})
+				return name + " " + string(args), nil
+			},
+		})
+	}
+	register("test_safe", true)
+	register("test_unsafe", false)
+
+	sess := tools.NewSession()
+	sess.Imap = &tools.ImapUserConfig{}
+
+	calls := []ToolCall{
+		testToolCall("test_safe", "1"),
+		testToolCall("test_safe", "2"),
+		testToolCall("test_safe", "3"),
+		testToolCall("test_unsafe", "4"),
+		testToolCall("test_safe", "5"),
+	}
+	var started []string
+	results, err := execToolCalls(context.Background(), sess, calls, defaultToolExec, 2, func(tc ToolCall) {
+		started = append(started, tc.Function.Arguments)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"test_safe 1", "test_safe 2", "test_safe 3", "test_unsafe 4", "test_safe 5"} {
+		if results[i].Content != want {
+			t.Errorf("result %d = %q, want %q", i, results[i].Content, want)
+		}
+		if img := fmt.Sprintf("img%d", i+1); len(results[i].Images) != 1 || results[i].Images[0] != img {
+			t.Errorf("result %d images = %v, want [%s]", i, results[i].Images, img)
+		}
+	}
+	if got := strings.Join(started, ","); got != "1,2,3,4,5" {
+		t.Errorf("started = %s", got)
+	}
+	if peak.Load() != 2 {
+		t.Errorf("peak concurrency = %d, want 2", peak.Load())
+	}
+}
+
+// fakeModel is an OpenAI-compatible server for runQuery: a request with
+// tools gets a call of test_loop_echo, one without tools the answer "done".
+type fakeModel struct {
+	mu       sync.Mutex
+	requests []llmTestRequest
+	delay    time.Duration // before each tool call
+}
+
+type llmTestRequest struct {
+	Messages []struct {
+		Role    string `json:"role"`
+		Content any    `json:"content"`
+	} `json:"messages"`
+	Tools []any `json:"tools"`
+}
+
+func (f *fakeModel) serve(t *testing.T) modelConfig {
+	t.Helper()
+	tools.Register(&tools.Tool{
+		Def: tools.Definition{Type: "function", Function: tools.Function{Name: "test_loop_echo"}},
+		Execute: func(_ context.Context, _ *tools.Session, args json.RawMessage) (string, error) {
+			return "echo " + string(args), nil
+		},
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req llmTestRequest
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+		f.mu.Lock()
+		f.requests = append(f.requests, req)
+		n := len(f.requests)
+		f.mu.Unlock()
+		if len(req.Tools) == 0 {
+			fmt.Fprint(w, strings.ReplaceAll(okSSE, `"ok"`, `"done"`))
+			return
+		}
+		select {
+		case <-time.After(f.delay):
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"c%d\",\"type\":\"function\",\"function\":{\"name\":\"test_loop_echo\",\"arguments\":\"{\\\"n\\\":%d}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\ndata: [DONE]\n\n", n, n)
+	}))
+	t.Cleanup(srv.Close)
+	return modelConfig{BaseURL: srv.URL, Retry: retryConfig{Attempts: 1}}
+}
+
+func runTestQuery(ctx context.Context, cfg modelConfig) (string, []Message, error) {
+	prompts := &Prompts{SystemPrompt: "sys"}
+	logf := func(string, ...any) {}
+	return runQuery(ctx, tools.NewSession(), cfg, "m", "question", false, false, io.Discard, logf, prompts, nil, nil, thinkDefault, nil, nil, nil, nil, nil)
+}
+
+func TestRunQuery_RoundQuotaAnswers(t *testing.T) {
+	prev := usagePath
+	usagePath = filepath.Join(t.TempDir(), "usage.json")
+	defer func() { usagePath = prev }()
+
+	f := &fakeModel{}
+	cfg := f.serve(t)
+	ctx, meter := withUsageMeter(context.Background())
+	ctx, err := admitQuery(ctx, "quota-rounds", &UserQuota{MaxToolRounds: 1}, meter, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, turn, err := runTestQuery(ctx, cfg)
+	if err != nil {
+		t.Fatalf("runQuery: %v", err)
+	}
+	if !strings.HasPrefix(answer, "done\n\n⛔ tool round limit reached (1 per query)") {
+		t.Errorf("answer = %q", answer)
+	}
+	// round 1 ran its tool; round 2's call was dropped for the final answer
+	if len(f.requests) != 3 || len(turn) != 2 || turn[1].Role != "tool" {
+		t.Errorf("requests = %d, turn = %+v", len(f.requests), turn)
+	}
+}
+
+func TestRunQuery_TimeBudgetCutsStream(t *testing.T) {
+	f := &fakeModel{delay: 10 * time.Second}
+	cfg := f.serve(t)
+	cfg.Loop.TimeoutSec = 1
+
+	start := time.Now()
+	answer, _, err := runTestQuery(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("runQuery: %v", err)
+	}
+	if answer != "done" {
+		t.Errorf("answer = %q", answer)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("runQuery took %s, the stream was not cut at the deadline", d)
+	}
+	// the cut stream, then the answer without tools
+	if len(f.requests) != 2 || len(f.requests[1].Tools) != 0 {
+		t.Errorf("requests = %+v", f.requests)
+	}
+}
+
+func TestRunQuery_RequestContextEndsUserMessage(t *testing.T) {
+	f := &fakeModel{}
+	cfg := f.serve(t)
+	cfg.Loop.MaxRounds = 1
+
+	if _, _, err := runTestQuery(context.Background(), cfg); err != nil {
+		t.Fatalf("runQuery: %v", err)
+	}
+	msgs := f.requests[0].Messages
+	if len(msgs) != 2 || msgs[0].Role != "system" || msgs[1].Role != "user" {
+		t.Fatalf("messages = %+v", msgs)
+	}
+	content, _ := msgs[1].Content.(string)
+	question, rest, ok := strings.Cut(content, "\n\n[Request context, not part of the question]\n")
+	if !ok || question != "question" || !strings.HasPrefix(rest, "Current time: ") {
+		t.Errorf("user message = %q", content)
+	}
+}
+
+func TestMailSynthesis_StopsAtRoundLimit(t *testing.T) {
+	f := &fakeModel{}
+	cfg := f.serve(t)
+	cfg.Loop.MaxRounds = 2
+
+	sess := tools.NewSession()
+	t1, _ := tools.Get("test_loop_echo")
+	messages := []Message{{Role: "system", Content: "sys"}, {Role: "user", Content: "digests"}}
+	answer, err := mailSynthesis(context.Background(), sess, cfg, "m", messages, []tools.Definition{t1.Def},
+		makeToolExec(nil, nil), false, io.Discard, func(string, ...any) {}, thinkDefault)
+	if err != nil || answer != "done" {
+		t.Fatalf("mailSynthesis = %q, %v", answer, err)
+	}
+	// two rounds with tools, the third round's call dropped for the final answer
+	if len(f.requests) != 4 || len(f.requests[3].Tools) != 0 {
+		t.Errorf("requests = %d", len(f.requests))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"flag"
@@ -27,45 +28,55 @@ type limitConfig struct {
 
 type modelConfig struct {
 	Name          string            `json:"name"`
+	Provider      string            `json:"provider,omitempty"` // "openai" (default), "ollama", "anthropic"
 	BaseURL       string            `json:"baseURL"`
+	BaseURLs      []string          `json:"baseURLs,omitempty"` // failover endpoints, tried after baseURL
+	APIKey        string            `json:"apiKey,omitempty"`
 	Limit         limitConfig       `json:"limit"`
+	Retry         retryConfig       `json:"retry,omitempty"`
+	Loop          loopConfig        `json:"loop,omitempty"`
 	VideoAsFrames *VideoFrameConfig `json:"videoAsFrames,omitempty"`
 }
 
 type appConfig struct {
 	Model    map[string]modelConfig `json:"model"`
+	Default  string                 `json:"default,omitempty"`
+	Roles    map[string]string      `json:"roles,omitempty"`
 	Language string                 `json:"language"`
 }
 
 func strPtr(s string) *string { return &s }
 
-func loadConfig(path string) (modelID string, cfg modelConfig, language string, err error) {
+func loadConfig(path string) (registry *modelRegistry, language string, err error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return "", modelConfig{}, "", err
+		return nil, "", err
 	}
 
-	// Try new format: {"model": {...}, "language": "..."}
+	// Try new format: {"model": {...}, "default": "...", "roles": {...}, "language": "..."}
 	var ac appConfig
 	if err := json.Unmarshal(data, &ac); err != nil {
-		return "", modelConfig{}, "", err
+		return nil, "", err
 	}
 
 	if len(ac.Model) > 0 {
-		for id, c := range ac.Model {
-			return id, c, ac.Language, nil
+		registry, err = newModelRegistry(ac.Model, ac.Default, ac.Roles)
+		if err != nil {
+			return nil, "", err
 		}
+		return registry, ac.Language, nil
 	}
 
 	// Fallback: old flat format {"modelId": {...}}
 	var flat map[string]modelConfig
 	if err := json.Unmarshal(data, &flat); err != nil {
-		return "", modelConfig{}, "", err
+		return nil, "", err
 	}
-	for id, c := range flat {
-		return id, c, "", nil
+	registry, err = newModelRegistry(flat, "", nil)
+	if err != nil {
+		return nil, "", err
 	}
-	return "", modelConfig{}, "", fmt.Errorf("no models defined in config")
+	return registry, "", nil
 }
 
 func main() {
@@ -78,6 +89,7 @@ func main() {
 	showSubAgents := flag.Bool("show-subagents", false, "show sub-agent input, thinking, and output")
 	verboseTools := flag.Bool("verbose-tools", false, "show tool call arguments and results")
 	requestDebugFlag := flag.Bool("request-debug", false, "dump API request JSON to stderr (base64 data truncated)")
+	prefixDebugFlag := flag.Bool("prefix-debug", false, "report how much of each prompt is shared with the previous request (prefix caching)")
 	mailSummary := flag.Bool("mail-summary", false, "standalone mail digest: fetch unread, group by sender, categorize")
 	newsSummary := flag.Bool("news-summary", false, "cross-referenced news digest from configured URLs")
 	newsInteractive := flag.Bool("news-interactive", false, "interactive news analysis session (REPL with context)")
@@ -87,7 +99,7 @@ func main() {
 	telegram := flag.Bool("telegram", false, "send output to Telegram instead of stdout")
 	telegramCfgPath := flag.String("telegram-config", "", "path to telegram config file")
 	telegramChatID := flag.Int64("telegram-chatid", 0, "override Telegram chat ID for this invocation")
-	telegramBot := flag.Bool("telegram-bot", false, "run as Telegram webhook bot service")
+	telegramBot := flag.Bool("telegram-bot", false, "run as Telegram bot service (webhook or long polling, see telegram.json)")
 	quiet := flag.Bool("quiet", false, "suppress all non-error output (for cron)")
 	configPath := flag.String("config", "", "path to config file (also sets base dir for other configs)")
 	languageFlag := flag.String("language", "", "response language (overrides config)")
@@ -107,6 +119,7 @@ func main() {
 	noAsk := flag.Bool("no-ask", false, "disable interactive ask_user tool (for cron/scripting)")
 	memoryFlag := flag.String("memory", "", "enable memory tools at this path (\"off\" to disable even if set in users.json)")
 	userinfoFlag := flag.String("userinfo", "", "enable userinfo tools at this path (\"off\" to disable even if set in users.json)")
+	modelFlag := flag.String("model", "", "model name from config.json for the main tool loop (overrides default/roles)")
 	flag.Parse()
 
 	// Resolve config base directory and default config paths.
@@ -135,6 +148,10 @@ func main() {
 		mcpConfigPath = strPtr(filepath.Join(configDir, "mcp.json"))
 	}
 	usersPath = filepath.Join(configDir, "users.json")
+	usagePath = filepath.Join(configDir, "usage.json")
+	schedulesPath = filepath.Join(configDir, "schedules.json")
+	remindersPath = filepath.Join(configDir, "reminders.json")
+	conversationsPath = filepath.Join(configDir, "conversations")
 	tools.SetHAConfigPath(filepath.Join(configDir, "homeassistant.json"))
 
 	// Merge -cli alias into interactive
@@ -143,6 +160,7 @@ func main() {
 	}
 
 	requestDebug = *requestDebugFlag
+	prefixDebug = *prefixDebugFlag
 	quietMode = *quiet
 
 	// Register filesystem and git tools
@@ -164,17 +182,6 @@ func main() {
 		}
 	}
 
-	// Reset terminal colors on Ctrl+C (interactive mode only)
-	if !*telegramBot {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, os.Interrupt)
-		go func() {
-			<-sig
-			fmt.Fprint(os.Stderr, colorReset+"\n")
-			os.Exit(130)
-		}()
-	}
-
 	// Export default prompts and exit (before usage check)
 	if *exportDefaultPrompts != "" {
 		if err := exportPrompts(*exportDefaultPrompts); err != nil {
@@ -214,14 +221,16 @@ func main() {
 			fmt.Fprintf(os.Stderr, format, args...)
 		}
 	}
+	llmLogf = logf
 
 	query := strings.Join(flag.Args(), " ")
 
-	modelID, cfg, configLanguage, err := loadConfig(*configPath)
+	registry, configLanguage, err := loadConfig(*configPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
 		os.Exit(1)
 	}
+	models = registry
 
 	// Resolve base language: CLI flag > config > default
 	// (user language is applied later, after user resolution)
@@ -258,6 +267,26 @@ func main() {
 		think = thinkDisable
 	}
 
+	// Resolve the main model: /model prefix > -model flag > roles.main > default.
+	// Image/video queries use roles.vision unless a model was picked explicitly.
+	modelPrefix, query := parseModelPrefix(query)
+	cfg, modelID := models.defaultModel()
+	cfg, modelID = models.forRole(roleMain, cfg, modelID)
+	if *imageFile != "" || *videoFile != "" {
+		cfg, modelID = models.forRole(roleVision, cfg, modelID)
+	}
+	modelName := *modelFlag
+	if modelPrefix != "" {
+		modelName = modelPrefix
+	}
+	if modelName != "" {
+		cfg, modelID, err = models.get(modelName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "model error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Parse /skills prefix and merge with flag names
 	skillsPrefixNames, query := parseSkillsPrefix(query)
 	var flagSkillNames []string
@@ -312,8 +341,8 @@ func main() {
 
 	// Set MCPCallFn for tools that need direct MCP access (e.g. /eat command)
 	if mcpMgr != nil {
-		tools.MCPCallFn = func(name string, args json.RawMessage) (string, error) {
-			return mcpMgr.ExecuteTool(name, args)
+		tools.MCPCallFn = func(ctx context.Context, name string, args json.RawMessage) (string, error) {
+			return mcpMgr.ExecuteTool(ctx, name, args)
 		}
 	}
 
@@ -338,7 +367,8 @@ func main() {
 
 	// Set up sub-agent function for tools that need AI processing
 	showSA := *showSubAgents && !*quiet
-	tools.SubAgentFn = func(systemPrompt, userMessage string) (string, error) {
+	subCfg, subModelID := models.forRole(roleSubAgent, cfg, modelID)
+	tools.SubAgentFn = func(ctx context.Context, systemPrompt, userMessage string) (string, error) {
 		tools.SubAgentDepth.Add(1)
 		defer tools.SubAgentDepth.Add(-1)
 
@@ -360,7 +390,7 @@ func main() {
 			pw.WriteString(colorDim + "Input: " + input + colorReset + "\n")
 			pw.WriteString("\n")
 
-			result, err := doSubAgentStream(cfg.BaseURL, modelID, msgs, cfg.Limit.Output, pw, think)
+			result, err := doSubAgentStream(ctx, subCfg, subModelID, msgs, subCfg.Limit.Output, pw, think)
 			if err != nil {
 				return "", err
 			}
@@ -369,11 +399,12 @@ func main() {
 			return result, nil
 		}
 
-		return doChat(cfg.BaseURL, modelID, msgs, cfg.Limit.Output, cfg.Limit.Context, think)
+		return doChat(ctx, subCfg, subModelID, msgs, subCfg.Limit.Output, subCfg.Limit.Context, think)
 	}
 
 	// SubAgentImageFn: like SubAgentFn but with image support
-	tools.SubAgentImageFn = func(systemPrompt, userMessage string, images []string, thinkOverride *bool) (string, error) {
+	visionCfg, visionModelID := models.forRole(roleVision, subCfg, subModelID)
+	tools.SubAgentImageFn = func(ctx context.Context, systemPrompt, userMessage string, images []string, thinkOverride *bool) (string, error) {
 		tools.SubAgentDepth.Add(1)
 		defer tools.SubAgentDepth.Add(-1)
 
@@ -407,7 +438,7 @@ func main() {
 			pw.WriteString(colorDim + fmt.Sprintf("Input: %s [%d image(s)]", input, len(images)) + colorReset + "\n")
 			pw.WriteString("\n")
 
-			result, err := doSubAgentStream(cfg.BaseURL, modelID, msgs, cfg.Limit.Output, pw, th)
+			result, err := doSubAgentStream(ctx, visionCfg, visionModelID, msgs, visionCfg.Limit.Output, pw, th)
 			if err != nil {
 				return "", err
 			}
@@ -416,7 +447,7 @@ func main() {
 			return result, nil
 		}
 
-		return doChat(cfg.BaseURL, modelID, msgs, cfg.Limit.Output, cfg.Limit.Context, th)
+		return doChat(ctx, visionCfg, visionModelID, msgs, visionCfg.Limit.Output, visionCfg.Limit.Context, th)
 	}
 
 	// VideoFramesFn: extract frames from a video time range (used by video_get_frames tool)
@@ -451,57 +482,25 @@ func main() {
 		}
 	}
 
-	// Apply per-user overrides for CLI
-	if user != nil {
-		if imapCfg := userImapConfig(user); imapCfg != nil {
-			tools.SetImapOverride(imapCfg)
-		}
-		haEnabled := user.HA != nil && user.HA.Enabled
-		tools.SetHAEnabled(haEnabled)
-		if calCfg := userCalendarConfig(user); calCfg != nil {
-			tools.SetCalendarOverride(calCfg)
-		}
-		if contactsCfg := userContactsConfig(user); contactsCfg != nil {
-			tools.SetContactsOverride(contactsCfg)
-		}
-		// User language (overridden by CLI flag)
-		if user.Language != "" && *languageFlag == "" {
-			language = user.Language
-		}
+	// Per-user tool session for CLI modes
+	sess := userSession(user, userName)
+	// User language (overridden by CLI flag)
+	if user != nil && user.Language != "" && *languageFlag == "" {
+		language = user.Language
 	}
 
-	// Determine memory path: user config, overridden by -memory flag
-	memoryPath := ""
-	if user != nil && user.Memory != "" {
-		memoryPath = user.Memory
-	}
-	if *memoryFlag != "" {
-		if *memoryFlag == "off" {
-			memoryPath = ""
-		} else {
-			memoryPath = *memoryFlag
-		}
-	}
-	if memoryPath != "" {
-		tools.SetMemoryOverride(memoryPath)
-		defer tools.ClearMemoryOverride()
+	// Memory path: user config, overridden by -memory flag
+	if *memoryFlag == "off" {
+		sess.MemoryDir = ""
+	} else if *memoryFlag != "" {
+		sess.MemoryDir = *memoryFlag
 	}
 
-	// Determine userinfo path: user config, overridden by -userinfo flag
-	userinfoPath := ""
-	if user != nil && user.Userinfo != "" {
-		userinfoPath = user.Userinfo
-	}
-	if *userinfoFlag != "" {
-		if *userinfoFlag == "off" {
-			userinfoPath = ""
-		} else {
-			userinfoPath = *userinfoFlag
-		}
-	}
-	if userinfoPath != "" && userName != "" {
-		tools.SetUserInfoOverride(userinfoPath, userName)
-		defer tools.ClearUserInfoOverride()
+	// Userinfo path: user config, overridden by -userinfo flag
+	if *userinfoFlag == "off" {
+		sess.UserInfo = ""
+	} else if *userinfoFlag != "" {
+		sess.UserInfo = *userinfoFlag
 	}
 
 	// Save prompt template before language application (for bot per-user language)
@@ -539,6 +538,28 @@ func main() {
 		}
 	}
 
+	dotMode := query == "."
+
+	// One-shot modes: token usage of the run goes to the usage ledger
+	ctx, meter := withUsageMeter(context.Background())
+	saveUsage := func(mode string) {
+		if err := saveQueryUsage(userName, mode, meter); err != nil {
+			logf("usage ledger error: %v\n", err)
+		}
+	}
+
+	// Reset terminal colors on Ctrl+C (one-shot modes; the REPL cancels
+	// only the current turn instead, see runInteractive)
+	if !*telegramBot && !*interactive && !*newsInteractive && !dotMode {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			<-sig
+			fmt.Fprint(os.Stderr, colorReset+"\n")
+			os.Exit(130)
+		}()
+	}
+
 	// Check for registered commands (e.g. /eat)
 	if cmdName, cmdText := parseCommandName(query); cmdName != "" {
 		if cmd := tools.GetCommand(cmdName); cmd != nil {
@@ -555,8 +576,9 @@ func main() {
 			}
 			_ = allMCP
 
-			ctx := &tools.CommandContext{Text: cmdText}
-			result, err := cmd.Handler(ctx)
+			cmdCtx := &tools.CommandContext{Text: cmdText, Context: ctx, Session: sess}
+			result, err := cmd.Handler(cmdCtx)
+			saveUsage(usageModeQuery)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "command /%s error: %v\n", cmdName, err)
 				os.Exit(1)
@@ -574,7 +596,8 @@ func main() {
 	}
 
 	if *mailSummary {
-		content, err := runMailSummary(cfg, modelID, showThinking, contentOut, logf, &prompts, 24, mcpMgr, mcpNames, think, mcpOverrides)
+		content, err := runMailSummary(ctx, sess, cfg, modelID, showThinking, contentOut, logf, &prompts, 24, mcpMgr, mcpNames, think, mcpOverrides)
+		saveUsage(usageModeMail)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "mail summary error: %v\n", err)
 			os.Exit(1)
@@ -597,7 +620,7 @@ func main() {
 
 		if newsQuery == "" {
 			// No query — full summary (existing behavior)
-			content, err = runNewsSummary(cfg, modelID, showThinking, contentOut, logf, *newsConfig, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
+			content, err = runNewsSummary(ctx, cfg, modelID, showThinking, contentOut, logf, *newsConfig, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
 		} else {
 			// Try to match category for browse mode
 			categories, catErr := readNewsConfig(*newsConfig)
@@ -608,22 +631,22 @@ func main() {
 			if cat := matchCategory(newsQuery, categories); cat != nil {
 				// Interactive browse: set up CLI prompter
 				if !*noAsk && !*telegram && !*quiet {
-					tools.SetPrompter(&CLIPrompter{})
-					defer tools.ClearPrompter()
+					sess.Prompter = &CLIPrompter{}
 				}
-				prompter := tools.GetPrompter()
+				prompter := sess.Prompter
 				if prompter == nil {
 					// No interaction available — fall back to full summary
-					content, err = runNewsSummary(cfg, modelID, showThinking, contentOut, logf, *newsConfig, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
+					content, err = runNewsSummary(ctx, cfg, modelID, showThinking, contentOut, logf, *newsConfig, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
 				} else {
-					content, err = runNewsBrowse(cfg, modelID, cat, showThinking, contentOut, logf, &prompts, mcpMgr, mcpNames, think, mcpOverrides, prompter)
+					content, err = runNewsBrowse(ctx, cfg, modelID, cat, showThinking, contentOut, logf, &prompts, mcpMgr, mcpNames, think, mcpOverrides, prompter)
 				}
 			} else {
 				// Free text search
-				content, err = runNewsSearch(cfg, modelID, newsQuery, showThinking, contentOut, logf, *newsConfig, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
+				content, err = runNewsSearch(ctx, cfg, modelID, newsQuery, showThinking, contentOut, logf, *newsConfig, &prompts, mcpMgr, mcpNames, think, mcpOverrides)
 			}
 		}
 
+		saveUsage(usageModeNews)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "news summary error: %v\n", err)
 			os.Exit(1)
@@ -641,7 +664,6 @@ func main() {
 	}
 
 	// "." shortcut: interactive mode with filesystem (cwd, rw) + git
-	dotMode := query == "."
 	if dotMode {
 		query = "" // clear so the REPL prompts for input
 		cwd, _ := os.Getwd()
@@ -656,10 +678,10 @@ func main() {
 	}
 
 	if *newsInteractive || *interactive || dotMode {
-		if memoryPath != "" {
+		if sess.MemoryAvailable() {
 			prompts.SystemPrompt += MemoryPromptHint
 		}
-		if tools.UserInfoAvailable() {
+		if sess.UserInfoAvailable() {
 			prompts.SystemPrompt += UserInfoPromptHint
 		}
 		prompts.SystemPrompt += AskUserPromptHint
@@ -686,6 +708,8 @@ func main() {
 			McpOverrides:   mcpOverrides,
 			Mode:           mode,
 			SkillNames:     skillNames,
+			UserName:       userName,
+			Session:        sess,
 		}
 
 		if err := runInteractive(ic, query); err != nil {
@@ -731,8 +755,7 @@ func main() {
 			query += fmt.Sprintf("\n\n=== Video Overview (%d frames from %s to %s, interval ~%.1fs) ===\n"+
 				"Use video_get_frames to zoom into specific time ranges at higher density/resolution.",
 				len(frames), tools.FormatTimestamp(0), tools.FormatTimestamp(duration), interval)
-			tools.SetVideoState(*videoFile, duration, cfg.VideoAsFrames.FrameWidth, cfg.VideoAsFrames.MaxFrames)
-			defer tools.ClearVideoState()
+			sess.SetVideoState(*videoFile, duration, cfg.VideoAsFrames.FrameWidth, cfg.VideoAsFrames.MaxFrames)
 			logf("%sИзвлечено %d кадров (%.1f сек)%s\n", colorDim, len(frames), duration, colorReset)
 		} else {
 			dataURL, vidErr := loadFileDataURL(*videoFile)
@@ -746,20 +769,20 @@ func main() {
 
 	// Enable ask_user in interactive CLI mode (not telegram, quiet, mail, news, or -no-ask)
 	if !*noAsk && !*telegram && !*quiet {
-		tools.SetPrompter(&CLIPrompter{})
-		defer tools.ClearPrompter()
+		sess.Prompter = &CLIPrompter{}
 		prompts.SystemPrompt += AskUserPromptHint
 	}
 
-	if memoryPath != "" {
+	if sess.MemoryAvailable() {
 		prompts.SystemPrompt += MemoryPromptHint
 	}
-	if tools.UserInfoAvailable() {
+	if sess.UserInfoAvailable() {
 		prompts.SystemPrompt += UserInfoPromptHint
 	}
 
 	activeModules := append(append([]string{}, skillNames...), mcpNames...)
-	finalContent, err := runQuery(cfg, modelID, query, showThinking, *verboseTools, contentOut, logf, &prompts, mcpMgr, mcpNames, think, images, videos, nil, mcpOverrides, activeModules)
+	finalContent, _, err := runQuery(ctx, sess, cfg, modelID, query, showThinking, *verboseTools, contentOut, logf, &prompts, mcpMgr, mcpNames, think, images, videos, nil, mcpOverrides, activeModules)
+	saveUsage(usageModeQuery)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "\nerror: %v\n", err)
 		os.Exit(1)
@@ -776,17 +799,21 @@ func main() {
 	}
 }
 
-func runQuery(cfg modelConfig, modelID string, query string,
+// runQuery answers one query with the tool loop. Besides the final answer
+// it returns the turn's tool rounds (assistant tool calls and tool results)
+// for callers that keep them as conversation history. A contentOut that
+// implements toolObserver is told about each tool call.
+func runQuery(ctx context.Context, sess *tools.Session, cfg modelConfig, modelID string, query string,
 	showThinking, verboseTools bool, contentOut io.Writer,
 	logf func(string, ...any), prompts *Prompts,
 	mcpMgr *MCPManager, mcpNames []string, think thinkMode,
 	images []ImageURL, videos []VideoURL, history []Message,
-	mcpOverrides map[string]bool, activeModules []string) (string, error) {
+	mcpOverrides map[string]bool, activeModules []string) (string, []Message, error) {
 
 	// Merge built-in + MCP tool definitions
-	toolDefs := tools.All()
+	toolDefs := tools.All(sess)
 	if mcpMgr != nil {
-		toolDefs = append(toolDefs, mcpMgr.ActiveToolDefs(mcpNames, mcpOverrides)...)
+		toolDefs = append(toolDefs, sess.FilterAllowed(mcpMgr.ActiveToolDefs(mcpNames, mcpOverrides))...)
 	}
 	execTool := makeToolExec(mcpMgr, mcpNames)
 
@@ -794,32 +821,67 @@ func runQuery(cfg modelConfig, modelID string, query string,
 	if strings.Contains(query, "\n=== Video Overview") {
 		userMsg.VideoFrames = true
 	}
-	// Inject current time dynamically so it's always fresh
-	now := time.Now()
-	zone, _ := now.Zone()
-	systemPrompt := prompts.SystemPrompt + fmt.Sprintf("\n\nCurrent time: %s (%s).",
-		now.Format("2006-01-02 15:04"), zone)
-
-	// Inject user info settings into the system prompt
-	if block := tools.UserInfoPromptBlock(activeModules); block != "" {
-		systemPrompt += block
-	}
-
+	// Current time and userinfo change between requests: they trail the
+	// user's message so the system prompt and history stay a stable prefix
 	messages := []Message{
-		{Role: "system", Content: systemPrompt},
+		{Role: "system", Content: prompts.SystemPrompt},
 	}
 	messages = append(messages, history...)
-	messages = append(messages, userMsg)
+	messages = append(messages, withRequestContext(userMsg, time.Now(), tools.UserInfoPromptBlock(sess, activeModules)))
+	turnStart := len(messages)
+	turn := func() []Message { return append([]Message(nil), messages[turnStart:]...) }
+
+	// finish drops the pending tool calls and asks for an answer without
+	// tools; note, if any, is added to the answer for the user
+	finish := func(reason, note string) (string, []Message, error) {
+		logf("%s[tool loop stopped: %s]%s\n", colorCyan, reason, colorReset)
+		done := turn()
+		messages = append(messages, Message{Role: "user", Content: fmt.Sprintf(loopFinalPrompt, reason)})
+		fctx, cancel := context.WithTimeout(ctx, loopFinalTimeout)
+		defer cancel()
+		result, err := doStream(fctx, cfg, modelID, messages, nil, cfg.Limit.Output, showThinking, contentOut, think)
+		if err != nil {
+			return "", nil, fmt.Errorf("final round: %w", err)
+		}
+		content := result.Content
+		if note != "" {
+			fmt.Fprint(contentOut, "\n\n"+note)
+			content = strings.TrimSpace(content) + "\n\n" + note
+		}
+		fmt.Fprintln(contentOut)
+		return content, done, nil
+	}
+
+	// The time budget also cuts a stream or tool call that runs past it;
+	// the answer without tools then gets a budget of its own
+	guard := newLoopGuard(cfg.Loop, time.Now())
+	qctx, cancelBudget := context.WithDeadline(ctx, guard.deadline)
+	defer cancelBudget()
+	overBudget := func() bool { return qctx.Err() != nil && ctx.Err() == nil }
 
-	for {
-		result, err := doStream(cfg.BaseURL, modelID, messages, toolDefs, cfg.Limit.Output, showThinking, contentOut, think)
+	for round := 1; ; round++ {
+		result, err := doStream(qctx, cfg, modelID, messages, toolDefs, cfg.Limit.Output, showThinking, contentOut, think)
+		if overBudget() {
+			return finish(loopBudgetReason, "")
+		}
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
 		if len(result.ToolCalls) == 0 {
 			fmt.Fprintln(contentOut)
-			return result.Content, nil
+			return result.Content, turn(), nil
+		}
+		if err := checkQuotaRound(ctx, round); err != nil {
+			return "", nil, err
+		}
+		if limit := quotaRoundLimit(ctx, round); limit > 0 {
+			return finish(fmt.Sprintf("the user's limit of %d tool rounds reached", limit), fmt.Sprintf(quotaRoundNote, limit))
+		}
+
+		// Stuck or over budget: drop this round's calls and force a text answer
+		if reason := guard.stop(round, result.ToolCalls, time.Now()); reason != "" {
+			return finish(reason, "")
 		}
 
 		messages = append(messages, Message{
@@ -828,7 +890,11 @@ func runQuery(cfg modelConfig, modelID string, query string,
 			ToolCalls: result.ToolCalls,
 		})
 
-		for _, tc := range result.ToolCalls {
+		observer, _ := contentOut.(toolObserver)
+		results, err := execToolCalls(qctx, sess, result.ToolCalls, execTool, cfg.Loop.parallelTools(), func(tc ToolCall) {
+			if observer != nil {
+				observer.toolCall(tc.Function.Name)
+			}
 			if verboseTools {
 				logf("%s[tool: %s]%s\n", colorCyan, tc.Function.Name, colorReset)
 				logf("%s  args: %s%s\n", colorDim, tc.Function.Arguments, colorReset)
@@ -836,24 +902,28 @@ func runQuery(cfg modelConfig, modelID string, query string,
 				logf("%s[tool: %s(%s)]%s\n",
 					colorCyan, tc.Function.Name, tc.Function.Arguments, colorReset)
 			}
-
-			res, execErr := execTool(tc.Function.Name, json.RawMessage(tc.Function.Arguments))
-			var toolResult string
-			if execErr != nil {
-				toolResult = "error: " + execErr.Error()
-			} else {
-				toolResult = res
+		})
+		if overBudget() && err != nil {
+			// Every call gets a result, so the turn stays a valid history
+			for _, tc := range result.ToolCalls {
+				messages = append(messages, Message{Role: "tool", Content: "error: " + loopBudgetReason, ToolCallID: tc.ID})
 			}
+			return finish(loopBudgetReason, "")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		for i, tc := range result.ToolCalls {
+			toolResult := results[i].Content
 
 			// Check if the tool produced images (e.g. camera snapshot)
 			var toolImages []ImageURL
-			if imgURIs := tools.TakePendingImages(); len(imgURIs) > 0 {
-				for _, uri := range imgURIs {
-					toolImages = append(toolImages, ImageURL{URL: uri})
-					imgID := tools.AddSessionImage(uri)
-					if tools.ImageSenderAvailable() {
-						toolResult += fmt.Sprintf("\n[Image #%d — use send_image to forward to the user]", imgID)
-					}
+			for _, uri := range results[i].Images {
+				toolImages = append(toolImages, ImageURL{URL: uri})
+				imgID := sess.AddImage(uri)
+				if sess.ImageSenderAvailable() {
+					toolResult += fmt.Sprintf("\n[Image #%d — use send_image to forward to the user]", imgID)
 				}
 			}
 
@@ -862,19 +932,19 @@ func runQuery(cfg modelConfig, modelID string, query string,
 				if len(preview) > 500 {
 					preview = preview[:500] + "..."
 				}
-				logf("%s  result: %s%s\n", colorDim, preview, colorReset)
+				logf("%s  result %s: %s%s\n", colorDim, tc.Function.Name, preview, colorReset)
 				if len(toolImages) > 0 {
 					logf("%s  images: %d%s\n", colorDim, len(toolImages), colorReset)
 				}
 			}
 
 			// Check if video_get_frames wants to strip old video frames
-			isVideoFrameResult := tools.TakeVideoFrameStrip()
+			isVideoFrameResult := results[i].StripVideo
 			if isVideoFrameResult {
-				for i := range messages {
-					if messages[i].VideoFrames {
-						messages[i].Images = nil
-						messages[i].VideoFrames = false
+				for j := range messages {
+					if messages[j].VideoFrames {
+						messages[j].Images = nil
+						messages[j].VideoFrames = false
 					}
 				}
 			}
@@ -890,16 +960,14 @@ func runQuery(cfg modelConfig, modelID string, query string,
 	}
 }
 
-func runMailSummary(cfg modelConfig, modelID string, showThinking bool, contentOut io.Writer, logf func(string, ...any), prompts *Prompts, sinceHours float64, mcpMgr *MCPManager, mcpNames []string, think thinkMode, mcpOverrides map[string]bool) (string, error) {
-	defer tools.ClearTempMemory()
-
+func runMailSummary(ctx context.Context, sess *tools.Session, cfg modelConfig, modelID string, showThinking bool, contentOut io.Writer, logf func(string, ...any), prompts *Prompts, sinceHours float64, mcpMgr *MCPManager, mcpNames []string, think thinkMode, mcpOverrides map[string]bool) (string, error) {
 	progress := func(msg string) {
 		logf("%s%s%s\n", colorDim, msg, colorReset)
 	}
 
 	progress("Получение непрочитанных писем...")
 
-	groups, err := tools.FetchUnreadGrouped(tools.MailDigestConfig{
+	groups, err := tools.FetchUnreadGrouped(ctx, sess, tools.MailDigestConfig{
 		SinceHours: sinceHours,
 		ProgressFn: progress,
 	})
@@ -924,10 +992,10 @@ func runMailSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 
 		input := buildGroupDigestInput(g)
 		// Inject persistent memory context about the sender
-		if memCtx := tools.MemoryLookup(g.SenderAddr); memCtx != "" {
+		if memCtx := tools.MemoryLookup(sess, g.SenderAddr); memCtx != "" {
 			input += "\n\n=== MEMORY ===\n" + memCtx
 		}
-		digest, err := tools.SubAgentFn(prompts.MailDigestSubAgent, input)
+		digest, err := tools.SubAgentFn(ctx, prompts.MailDigestSubAgent, input)
 		if err != nil {
 			progress(fmt.Sprintf("    ошибка: %v", err))
 			g.Digest = fmt.Sprintf("(ошибка анализа: %v)", err)
@@ -962,14 +1030,44 @@ func runMailSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 	}
 
 	// Merge built-in + MCP tools for final synthesis
-	toolDefs := tools.All()
+	toolDefs := tools.All(sess)
 	execTool := makeToolExec(mcpMgr, mcpNames)
 	if mcpMgr != nil && (len(mcpNames) > 0 || len(mcpOverrides) > 0) {
-		toolDefs = append(toolDefs, mcpMgr.ActiveToolDefs(mcpNames, mcpOverrides)...)
+		toolDefs = append(toolDefs, sess.FilterAllowed(mcpMgr.ActiveToolDefs(mcpNames, mcpOverrides))...)
+	}
+
+	return mailSynthesis(ctx, sess, cfg, modelID, messages, toolDefs, execTool, showThinking, contentOut, logf, think)
+}
+
+// mailSynthesis runs the tool loop of the final mail digest. Scheduled
+// digests run on a bot worker, so the loop has the same round, repeat and
+// time limits as a query and ends with an answer without tools.
+func mailSynthesis(ctx context.Context, sess *tools.Session, cfg modelConfig, modelID string, messages []Message,
+	toolDefs []tools.Definition, execTool toolExecFunc, showThinking bool, contentOut io.Writer,
+	logf func(string, ...any), think thinkMode) (string, error) {
+
+	finish := func(reason string) (string, error) {
+		logf("%s[tool loop stopped: %s]%s\n", colorCyan, reason, colorReset)
+		messages = append(messages, Message{Role: "user", Content: fmt.Sprintf(loopFinalPrompt, reason)})
+		fctx, cancel := context.WithTimeout(ctx, loopFinalTimeout)
+		defer cancel()
+		result, err := doStream(fctx, cfg, modelID, messages, nil, cfg.Limit.Output, showThinking, contentOut, think)
+		if err != nil {
+			return "", fmt.Errorf("final synthesis: %w", err)
+		}
+		fmt.Fprintln(contentOut)
+		return result.Content, nil
 	}
+	// As in runQuery, the time budget also cuts a stream or tool call
+	guard := newLoopGuard(cfg.Loop, time.Now())
+	qctx, cancelBudget := context.WithDeadline(ctx, guard.deadline)
+	defer cancelBudget()
 
-	for {
-		result, err := doStream(cfg.BaseURL, modelID, messages, toolDefs, cfg.Limit.Output, showThinking, contentOut, think)
+	for round := 1; ; round++ {
+		result, err := doStream(qctx, cfg, modelID, messages, toolDefs, cfg.Limit.Output, showThinking, contentOut, think)
+		if qctx.Err() != nil && ctx.Err() == nil {
+			return finish(loopBudgetReason)
+		}
 		if err != nil {
 			return "", fmt.Errorf("final synthesis: %w", err)
 		}
@@ -978,6 +1076,9 @@ func runMailSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 			fmt.Fprintln(contentOut)
 			return result.Content, nil
 		}
+		if reason := guard.stop(round, result.ToolCalls, time.Now()); reason != "" {
+			return finish(reason)
+		}
 
 		messages = append(messages, Message{
 			Role:      "assistant",
@@ -987,7 +1088,7 @@ func runMailSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 
 		for _, tc := range result.ToolCalls {
 			logf("%s[tool: %s]%s\n", colorCyan, tc.Function.Name, colorReset)
-			res, execErr := execTool(tc.Function.Name, json.RawMessage(tc.Function.Arguments))
+			res, execErr := execTool(qctx, sess, tc.Function.Name, json.RawMessage(tc.Function.Arguments))
 			var toolResult string
 			if execErr != nil {
 				toolResult = "error: " + execErr.Error()
@@ -1049,7 +1150,10 @@ func loadFileDataURL(path string) (string, error) {
 // It prints the question and numbered options to stderr, reads from stdin.
 type CLIPrompter struct{}
 
-func (p *CLIPrompter) Ask(q tools.UserQuestion) (string, error) {
+func (p *CLIPrompter) Ask(ctx context.Context, q tools.UserQuestion) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	fmt.Fprintf(os.Stderr, "\n%s%s%s\n", colorBold, q.Question, colorReset)
 
 	if len(q.Options) > 0 {
@@ -1061,7 +1165,7 @@ func (p *CLIPrompter) Ask(q tools.UserQuestion) (string, error) {
 			}
 		}
 		if q.MultiSelect {
-			fmt.Fprintf(os.Stderr, "%sВведите номера через запятую или свой ответ: %s", colorDim, colorReset)
+			fmt.Fprintf(os.Stderr, "%sВведите номера через запятую (можно диапазоны: 1,3-5) или свой ответ: %s", colorDim, colorReset)
 		} else {
 			fmt.Fprintf(os.Stderr, "%sВведите номер или свой ответ: %s", colorDim, colorReset)
 		}
@@ -1086,24 +1190,14 @@ func (p *CLIPrompter) Ask(q tools.UserQuestion) (string, error) {
 	}
 
 	if q.MultiSelect {
-		// Parse comma-separated numbers
-		parts := strings.Split(input, ",")
-		var labels []string
-		allNumeric := true
-		for _, part := range parts {
-			part = strings.TrimSpace(part)
-			n, err := strconv.Atoi(part)
-			if err != nil || n < 1 || n > len(q.Options) {
-				allNumeric = false
-				break
+		if nums, ok := parseOptionNumbers(input, len(q.Options)); ok {
+			labels := make([]string, len(nums))
+			for i, n := range nums {
+				labels[i] = q.Options[n-1].Label
 			}
-			labels = append(labels, q.Options[n-1].Label)
-		}
-		if allNumeric && len(labels) > 0 {
-			data, _ := json.Marshal(labels)
-			return string(data), nil
+			return tools.FormatSelection(labels), nil
 		}
-		// Not numeric — return raw input
+		// Not numbers — return raw input
 		return input, nil
 	}
 
@@ -1115,6 +1209,32 @@ func (p *CLIPrompter) Ask(q tools.UserQuestion) (string, error) {
 	return input, nil
 }
 
+// parseOptionNumbers parses a multi-select answer such as "1, 3-5" into
+// option numbers from 1 to n, in order and without repeats. ok is false if
+// input is not such a list.
+func parseOptionNumbers(input string, n int) (nums []int, ok bool) {
+	seen := map[int]bool{}
+	fields := strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' || r == ';' })
+	for _, f := range fields {
+		lo, hi, isRange := strings.Cut(f, "-")
+		if !isRange {
+			hi = lo
+		}
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || from < 1 || to > n || from > to {
+			return nil, false
+		}
+		for i := from; i <= to; i++ {
+			if !seen[i] {
+				seen[i] = true
+				nums = append(nums, i)
+			}
+		}
+	}
+	return nums, len(nums) > 0
+}
+
 // dedup merges two name lists, removing duplicates.
 func dedup(a, b []string) []string {
 	seen := map[string]bool{}
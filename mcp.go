@@ -3,11 +3,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 
@@ -16,9 +18,10 @@ import (
 
 // MCPServerConfig holds the configuration for a single MCP server.
 type MCPServerConfig struct {
-	URL     string            `json:"url"`
-	Enabled bool              `json:"enabled"`
-	Headers map[string]string `json:"headers"`
+	URL      string            `json:"url"`
+	Enabled  bool              `json:"enabled"`
+	Headers  map[string]string `json:"headers"`
+	ReadOnly bool              `json:"read_only,omitempty"` // no tool changes state; kept in read-only sessions
 }
 
 type mcpTool struct {
@@ -106,6 +109,42 @@ func (m *MCPManager) serverNames() []string {
 	return names
 }
 
+// ReadOnlyTools returns the tool patterns ("server__*") of the servers marked
+// read_only, for tools.Session.ReadTools. A nil manager has none.
+func (m *MCPManager) ReadOnlyTools() []string {
+	if m == nil {
+		return nil
+	}
+	var patterns []string
+	for name, srv := range m.servers {
+		if srv.cfg.ReadOnly {
+			patterns = append(patterns, name+"__*")
+		}
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// ServerStates splits the configured servers into active ones (enabled in
+// config or by a per-user override) and ones available on demand, each
+// sorted by name.
+func (m *MCPManager) ServerStates(overrides map[string]bool) (active, onDemand []string) {
+	for name, srv := range m.servers {
+		enabled, ok := overrides[name]
+		if !ok {
+			enabled = srv.cfg.Enabled
+		}
+		if enabled {
+			active = append(active, name)
+		} else {
+			onDemand = append(onDemand, name)
+		}
+	}
+	sort.Strings(active)
+	sort.Strings(onDemand)
+	return active, onDemand
+}
+
 // ActiveToolDefs returns tool definitions for enabled + extra servers.
 // overrides applies per-user MCP settings: true adds a server, false removes it.
 func (m *MCPManager) ActiveToolDefs(extraNames []string, overrides map[string]bool) []tools.Definition {
@@ -134,8 +173,16 @@ func (m *MCPManager) ActiveToolDefs(extraNames []string, overrides map[string]bo
 		}
 	}
 
-	var defs []tools.Definition
+	// Servers in name order, each server's tools in the order it lists them,
+	// so the definitions are identical between requests (prefix caching)
+	names := make([]string, 0, len(active))
 	for name := range active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var defs []tools.Definition
+	for _, name := range names {
 		srv := m.servers[name]
 		if srv == nil || !srv.inited {
 			continue
@@ -155,7 +202,7 @@ func (m *MCPManager) ActiveToolDefs(extraNames []string, overrides map[string]bo
 }
 
 // ExecuteTool routes a qualified tool name (server__tool) to the correct server.
-func (m *MCPManager) ExecuteTool(qualifiedName string, args json.RawMessage) (string, error) {
+func (m *MCPManager) ExecuteTool(ctx context.Context, qualifiedName string, args json.RawMessage) (string, error) {
 	parts := strings.SplitN(qualifiedName, "__", 2)
 	if len(parts) != 2 {
 		return "", fmt.Errorf("invalid MCP tool name %q", qualifiedName)
@@ -165,17 +212,20 @@ func (m *MCPManager) ExecuteTool(qualifiedName string, args json.RawMessage) (st
 	if !ok {
 		return "", fmt.Errorf("unknown MCP server %q", serverName)
 	}
-	return srv.callTool(toolName, args)
+	return srv.callTool(ctx, toolName, args)
 }
 
 // makeToolExec creates a tool executor that handles both built-in and MCP tools.
-func makeToolExec(mcpMgr *MCPManager, mcpNames []string) func(string, json.RawMessage) (string, error) {
-	return func(name string, args json.RawMessage) (string, error) {
+func makeToolExec(mcpMgr *MCPManager, mcpNames []string) toolExecFunc {
+	return func(ctx context.Context, sess *tools.Session, name string, args json.RawMessage) (string, error) {
+		if !sess.Allows(name) {
+			return "", fmt.Errorf("tool %q is not enabled here", name)
+		}
 		if tool, ok := tools.Get(name); ok {
-			return tool.Execute(args)
+			return tool.Execute(ctx, sess, args)
 		}
 		if mcpMgr != nil && strings.Contains(name, "__") {
-			return mcpMgr.ExecuteTool(name, args)
+			return mcpMgr.ExecuteTool(ctx, name, args)
 		}
 		return "", fmt.Errorf("unknown tool %q", name)
 	}
@@ -211,7 +261,7 @@ func (s *MCPServer) initialize() error {
 
 	// Step 1: initialize
 	id1 := 1
-	initResp, err := s.rpcCall(&jsonRPCRequest{
+	initResp, err := s.rpcCall(context.Background(), &jsonRPCRequest{
 		JSONRPC: "2.0",
 		ID:      &id1,
 		Method:  "initialize",
@@ -239,7 +289,7 @@ func (s *MCPServer) initialize() error {
 
 	// Step 3: tools/list
 	id3 := 2
-	listResp, err := s.rpcCall(&jsonRPCRequest{
+	listResp, err := s.rpcCall(context.Background(), &jsonRPCRequest{
 		JSONRPC: "2.0",
 		ID:      &id3,
 		Method:  "tools/list",
@@ -263,9 +313,9 @@ func (s *MCPServer) initialize() error {
 	return nil
 }
 
-func (s *MCPServer) callTool(toolName string, args json.RawMessage) (string, error) {
+func (s *MCPServer) callTool(ctx context.Context, toolName string, args json.RawMessage) (string, error) {
 	id := 1
-	resp, err := s.rpcCall(&jsonRPCRequest{
+	resp, err := s.rpcCall(ctx, &jsonRPCRequest{
 		JSONRPC: "2.0",
 		ID:      &id,
 		Method:  "tools/call",
@@ -305,13 +355,13 @@ func (s *MCPServer) callTool(toolName string, args json.RawMessage) (string, err
 	return result, nil
 }
 
-func (s *MCPServer) rpcCall(req *jsonRPCRequest) (*jsonRPCResponse, error) {
+func (s *MCPServer) rpcCall(ctx context.Context, req *jsonRPCRequest) (*jsonRPCResponse, error) {
 	payload, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequest("POST", s.cfg.URL, bytes.NewReader(payload))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.cfg.URL, bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
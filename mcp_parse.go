@@ -62,3 +62,20 @@ func parseMCPPrefix(s string) ([]string, string) {
 	}
 	return result, query
 }
+
+// parseModelPrefix extracts a model name from a "/model name ..." prefix.
+// Returns (modelName, remainingQuery). If no prefix, returns ("", original).
+func parseModelPrefix(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "/model ") && !strings.HasPrefix(s, "/model\n") {
+		return "", s
+	}
+	rest := strings.TrimSpace(s[len("/model"):])
+
+	sepIdx := strings.IndexAny(rest, " \n")
+	if sepIdx < 0 {
+		// "/model name" with no query — not a valid prefix
+		return "", s
+	}
+	return rest[:sepIdx], strings.TrimSpace(rest[sepIdx+1:])
+}
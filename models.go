@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Model roles that can be assigned to a named model in config.json ("roles").
+// A role without an explicit assignment falls back to the model the caller
+// is already using (the default model, or the one picked via /model).
+const (
+	roleMain     = "main"     // main tool loop (final answer)
+	roleSubAgent = "subagent" // SubAgentFn and doSubAgentWithTools sub-agents
+	roleNews     = "news"     // news headline extraction, clustering and keywords
+	roleCompact  = "compact"  // interactive context compaction
+	roleVision   = "vision"   // image/video queries and image sub-agents
+)
+
+var knownRoles = []string{roleMain, roleSubAgent, roleNews, roleCompact, roleVision}
+
+// namedModel is a single configured model addressable by its name.
+type namedModel struct {
+	Name string // short name used in "default", "roles" and /model
+	ID   string // model ID sent to the API (key in config.json)
+	Cfg  modelConfig
+}
+
+// modelRegistry holds all models from config.json, addressable by name,
+// plus the default model and per-role assignments.
+type modelRegistry struct {
+	byName      map[string]*namedModel
+	names       []string // sorted, for listings and error messages
+	defaultName string
+	roles       map[string]string // role → model name
+}
+
+// models is the registry loaded from config.json (set in main).
+var models *modelRegistry
+
+// newModelRegistry builds a registry from config.json model entries.
+// Each model is addressable by its "name" (or by its ID if name is empty).
+// defaultName may be empty only when exactly one model is configured.
+func newModelRegistry(entries map[string]modelConfig, defaultName string, roles map[string]string) (*modelRegistry, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no models defined in config")
+	}
+
+	r := &modelRegistry{
+		byName: make(map[string]*namedModel, len(entries)),
+		roles:  map[string]string{},
+	}
+
+	ids := make([]string, 0, len(entries))
+	for id := range entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		c := entries[id]
+		name := c.Name
+		if name == "" {
+			name = id
+		}
+		if prev, ok := r.byName[name]; ok {
+			return nil, fmt.Errorf("duplicate model name %q (%s and %s)", name, prev.ID, id)
+		}
+		if _, err := newProvider(c); err != nil {
+			return nil, fmt.Errorf("model %q: %w", name, err)
+		}
+		r.byName[name] = &namedModel{Name: name, ID: id, Cfg: c}
+		r.names = append(r.names, name)
+	}
+	sort.Strings(r.names)
+
+	if defaultName == "" {
+		if len(r.names) > 1 {
+			return nil, fmt.Errorf("%d models defined but no \"default\" set (available: %s)",
+				len(r.names), strings.Join(r.names, ", "))
+		}
+		defaultName = r.names[0]
+	}
+	def := r.lookup(defaultName)
+	if def == nil {
+		return nil, fmt.Errorf("default model %q not found (available: %s)",
+			defaultName, strings.Join(r.names, ", "))
+	}
+	r.defaultName = def.Name
+
+	for role, name := range roles {
+		if !isKnownRole(role) {
+			return nil, fmt.Errorf("unknown model role %q (known: %s)", role, strings.Join(knownRoles, ", "))
+		}
+		m := r.lookup(name)
+		if m == nil {
+			return nil, fmt.Errorf("role %q: model %q not found (available: %s)",
+				role, name, strings.Join(r.names, ", "))
+		}
+		r.roles[role] = m.Name
+	}
+
+	return r, nil
+}
+
+func isKnownRole(role string) bool {
+	for _, r := range knownRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup finds a model by name, falling back to a match on model ID.
+func (r *modelRegistry) lookup(name string) *namedModel {
+	if m, ok := r.byName[name]; ok {
+		return m
+	}
+	for _, m := range r.byName {
+		if m.ID == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// get returns the model with the given name (or model ID).
+func (r *modelRegistry) get(name string) (modelConfig, string, error) {
+	if r == nil {
+		return modelConfig{}, "", fmt.Errorf("no models configured")
+	}
+	m := r.lookup(name)
+	if m == nil {
+		return modelConfig{}, "", fmt.Errorf("unknown model %q (available: %s)", name, strings.Join(r.names, ", "))
+	}
+	return m.Cfg, m.ID, nil
+}
+
+// defaultModel returns the declared default model.
+func (r *modelRegistry) defaultModel() (modelConfig, string) {
+	m := r.byName[r.defaultName]
+	return m.Cfg, m.ID
+}
+
+// assigned returns the model explicitly assigned to role, if any.
+func (r *modelRegistry) assigned(role string) (modelConfig, string, bool) {
+	if r == nil {
+		return modelConfig{}, "", false
+	}
+	name, ok := r.roles[role]
+	if !ok {
+		return modelConfig{}, "", false
+	}
+	m := r.byName[name]
+	return m.Cfg, m.ID, true
+}
+
+// forRole returns the model assigned to role, or the given fallback
+// model (cfg, modelID) when the role has no explicit assignment.
+func (r *modelRegistry) forRole(role string, cfg modelConfig, modelID string) (modelConfig, string) {
+	if c, id, ok := r.assigned(role); ok {
+		return c, id
+	}
+	return cfg, modelID
+}
+
+// describe returns a human-readable list of models with default/role markers.
+func (r *modelRegistry) describe() string {
+	if r == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, name := range r.names {
+		m := r.byName[name]
+		var tags []string
+		if name == r.defaultName {
+			tags = append(tags, "default")
+		}
+		for _, role := range knownRoles {
+			if r.roles[role] == name {
+				tags = append(tags, role)
+			}
+		}
+		sb.WriteString(fmt.Sprintf("- %s (%s)", name, m.ID))
+		if len(tags) > 0 {
+			sb.WriteString(" [" + strings.Join(tags, ", ") + "]")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewModelRegistry(t *testing.T) {
+	two := map[string]modelConfig{
+		"org/big-model-v2": {Name: "big"},
+		"small-model":      {},
+	}
+	tests := []struct {
+		name     string
+		entries  map[string]modelConfig
+		def      string
+		roles    map[string]string
+		wantErr  string
+		wantDesc string
+	}{
+		{name: "no models", wantErr: "no models defined"},
+		{name: "single model is the default", entries: map[string]modelConfig{"m": {}}, wantDesc: "- m (m) [default]\n"},
+		{name: "several models need a default", entries: two, wantErr: `no "default" set`},
+		{name: "unknown default", entries: two, def: "huge", wantErr: `default model "huge" not found`},
+		{name: "default by ID", entries: two, def: "org/big-model-v2",
+			wantDesc: "- big (org/big-model-v2) [default]\n- small-model (small-model)\n"},
+		{name: "roles", entries: two, def: "big", roles: map[string]string{roleNews: "small-model", roleVision: "big"},
+			wantDesc: "- big (org/big-model-v2) [default, vision]\n- small-model (small-model) [news]\n"},
+		{name: "unknown role", entries: two, def: "big", roles: map[string]string{"poet": "big"}, wantErr: `unknown model role "poet"`},
+		{name: "unknown role target", entries: two, def: "big", roles: map[string]string{roleNews: "huge"}, wantErr: `role "news": model "huge" not found`},
+		{name: "duplicate name", entries: map[string]modelConfig{"a": {Name: "x"}, "b": {Name: "x"}}, def: "x", wantErr: `duplicate model name "x"`},
+		{name: "unknown provider", entries: map[string]modelConfig{"m": {Provider: "carrier-pigeon"}}, wantErr: `unknown provider "carrier-pigeon"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newModelRegistry(tt.entries, tt.def, tt.roles)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.describe(); got != tt.wantDesc {
+				t.Errorf("describe = %q, want %q", got, tt.wantDesc)
+			}
+		})
+	}
+}
+
+func TestModelRegistry_ForRole(t *testing.T) {
+	r, err := newModelRegistry(map[string]modelConfig{
+		"big-id":   {Name: "big", BaseURL: "http://big"},
+		"small-id": {Name: "small", BaseURL: "http://small"},
+	}, "big", map[string]string{roleSubAgent: "small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := modelConfig{BaseURL: "http://picked"}
+	for _, tt := range []struct {
+		role, wantID string
+	}{
+		{roleSubAgent, "small-id"},
+		{roleNews, "picked-id"}, // not assigned: the caller's model
+	} {
+		if _, id := r.forRole(tt.role, current, "picked-id"); id != tt.wantID {
+			t.Errorf("forRole(%q) = %s, want %s", tt.role, id, tt.wantID)
+		}
+	}
+	if cfg, id := r.defaultModel(); id != "big-id" || cfg.BaseURL != "http://big" {
+		t.Errorf("defaultModel = %s, %+v", id, cfg)
+	}
+	if _, _, err := r.get("huge"); err == nil || !strings.Contains(err.Error(), "available: big, small") {
+		t.Errorf("get(unknown) = %v", err)
+	}
+
+	var none *modelRegistry
+	if _, id := none.forRole(roleMain, current, "picked-id"); id != "picked-id" {
+		t.Errorf("nil registry forRole = %s", id)
+	}
+}
+
+func TestParseModelPrefix(t *testing.T) {
+	tests := []struct {
+		in, model, query string
+	}{
+		{"/model big what time is it", "big", "what time is it"},
+		{"  /model big\nmulti\nline", "big", "multi\nline"},
+		{"/model big", "", "/model big"}, // no query
+		{"/models big q", "", "/models big q"},
+		{"plain question", "", "plain question"},
+	}
+	for _, tt := range tests {
+		model, query := parseModelPrefix(tt.in)
+		if model != tt.model || query != tt.query {
+			t.Errorf("parseModelPrefix(%q) = %q, %q; want %q, %q", tt.in, model, query, tt.model, tt.query)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -102,7 +103,7 @@ type newsSource struct {
 	Err     error
 }
 
-func fetchAllNews(urls []string, progress func(string)) []newsSource {
+func fetchAllNews(ctx context.Context, urls []string, progress func(string)) []newsSource {
 	sources := make([]newsSource, len(urls))
 	var wg sync.WaitGroup
 
@@ -113,7 +114,7 @@ func fetchAllNews(urls []string, progress func(string)) []newsSource {
 		go func(idx int, rawURL string) {
 			defer wg.Done()
 			progress(fmt.Sprintf("  [%d/%d] %s...", idx+1, len(urls), sourceName(rawURL)))
-			content, err := tools.FetchURL(rawURL)
+			content, err := tools.FetchURL(ctx, rawURL)
 			if err != nil {
 				sources[idx].Err = err
 				return
@@ -241,11 +242,15 @@ func extractJSON[T any](raw string) (T, error) {
 
 // --- Main pipeline ---
 
-func runNewsSummary(cfg modelConfig, modelID string, showThinking bool, contentOut io.Writer, logf func(string, ...any), configPath string, prompts *Prompts, mcpMgr *MCPManager, mcpNames []string, think thinkMode, mcpOverrides map[string]bool) (string, error) {
+func runNewsSummary(ctx context.Context, cfg modelConfig, modelID string, showThinking bool, contentOut io.Writer, logf func(string, ...any), configPath string, prompts *Prompts, mcpMgr *MCPManager, mcpNames []string, think thinkMode, mcpOverrides map[string]bool) (string, error) {
 	progress := func(msg string) {
 		logf("%s%s%s\n", colorDim, msg, colorReset)
 	}
 
+	// Headline extraction/clustering and deep dives may use dedicated models
+	newsCfg, newsModelID := models.forRole(roleNews, cfg, modelID)
+	subCfg, subModelID := models.forRole(roleSubAgent, cfg, modelID)
+
 	// --- Phase 0: Read config, fetch all source pages ---
 	categories, err := readNewsConfig(configPath)
 	if err != nil {
@@ -266,7 +271,7 @@ func runNewsSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 
 	progress(fmt.Sprintf("Загрузка %d новостных источников (%d категорий)...", len(allURLs), len(categories)))
 
-	sources := fetchAllNews(allURLs, progress)
+	sources := fetchAllNews(ctx, allURLs, progress)
 
 	var ok int
 	for _, s := range sources {
@@ -297,6 +302,7 @@ func runNewsSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 		}
 	}
 	subAgentExec := makeToolExec(mcpMgr, mcpNames)
+	subAgentSess := tools.NewSession() // holds the memory_temp store for this run
 	if mcpMgr != nil && (len(mcpNames) > 0 || len(mcpOverrides) > 0) {
 		subAgentDefs = append(subAgentDefs, mcpMgr.ActiveToolDefs(mcpNames, mcpOverrides)...)
 	}
@@ -338,7 +344,7 @@ func runNewsSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 				{Role: "user", Content: fmt.Sprintf("Источник: %s\nURL: %s\n\nСодержимое страницы:\n%s", s.Name, s.URL, s.Content)},
 			}
 
-			raw, err := doChat(cfg.BaseURL, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
+			raw, err := doChat(ctx, newsCfg, newsModelID, messages, newsCfg.Limit.Output, newsCfg.Limit.Context, thinkDisable)
 			if err != nil {
 				progress(fmt.Sprintf("    ошибка: %v", err))
 				continue
@@ -387,7 +393,7 @@ func runNewsSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 			continue
 		}
 
-		maxInputChars := cfg.Limit.Context * 3 / 2
+		maxInputChars := newsCfg.Limit.Context * 3 / 2
 		if maxInputChars <= 0 {
 			maxInputChars = 80000
 		}
@@ -418,7 +424,7 @@ func runNewsSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 			{Role: "user", Content: clusterInput},
 		}
 
-		clusterRaw, err := doChat(cfg.BaseURL, modelID, clusterMessages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
+		clusterRaw, err := doChat(ctx, newsCfg, newsModelID, clusterMessages, newsCfg.Limit.Output, newsCfg.Limit.Context, thinkDisable)
 		if err != nil {
 			progress(fmt.Sprintf("  ошибка кластеризации: %v", err))
 			continue
@@ -471,7 +477,7 @@ func runNewsSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 				{Role: "user", Content: fmt.Sprintf("Проанализируй тему \"%s\" используя указанные источники.", t.TopicTitle)},
 			}
 
-			analysis, err := doSubAgentWithTools(cfg.BaseURL, modelID, messages, subAgentDefs, cfg.Limit.Output, cfg.Limit.Context, 5, 15000, logf, subAgentExec, think)
+			analysis, err := doSubAgentWithTools(ctx, subAgentSess, subCfg, subModelID, messages, subAgentDefs, subCfg.Limit.Output, subCfg.Limit.Context, 5, 15000, logf, subAgentExec, think)
 			if err != nil {
 				progress(fmt.Sprintf("    ошибка: %v, используем briefs", err))
 				analysis = buildBriefFromArticles(t.Articles)
@@ -486,8 +492,6 @@ func runNewsSummary(cfg modelConfig, modelID string, showThinking bool, contentO
 		}
 	}
 
-	tools.ClearTempMemory()
-
 	if len(allResults) == 0 {
 		return "", fmt.Errorf("no topics extracted from any category")
 	}
@@ -537,7 +541,9 @@ func buildBriefFromArticles(articles []topicArticle) string {
 // --- Shared helpers for interactive modes ---
 
 // extractHeadlines runs Phase 1 (headline extraction) for the given sources.
-func extractHeadlines(cfg modelConfig, modelID string, sources []newsSource, catName string, prompts *Prompts, progress func(string)) []sourceHeadlines {
+func extractHeadlines(ctx context.Context, cfg modelConfig, modelID string, sources []newsSource, catName string, prompts *Prompts, progress func(string)) []sourceHeadlines {
+	cfg, modelID = models.forRole(roleNews, cfg, modelID)
+
 	var result []sourceHeadlines
 	for i := range sources {
 		s := &sources[i]
@@ -555,7 +561,7 @@ func extractHeadlines(cfg modelConfig, modelID string, sources []newsSource, cat
 			{Role: "user", Content: fmt.Sprintf("Источник: %s\nURL: %s\n\nСодержимое страницы:\n%s", s.Name, s.URL, s.Content)},
 		}
 
-		raw, err := doChat(cfg.BaseURL, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
+		raw, err := doChat(ctx, cfg, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
 		if err != nil {
 			progress(fmt.Sprintf("    ошибка: %v", err))
 			continue
@@ -582,7 +588,9 @@ func extractHeadlines(cfg modelConfig, modelID string, sources []newsSource, cat
 }
 
 // clusterTopics runs Phase 2 (topic clustering) on headlines.
-func clusterTopics(cfg modelConfig, modelID string, headlines []sourceHeadlines, filter string, prompts *Prompts, progress func(string)) (*topicClustering, error) {
+func clusterTopics(ctx context.Context, cfg modelConfig, modelID string, headlines []sourceHeadlines, filter string, prompts *Prompts, progress func(string)) (*topicClustering, error) {
+	cfg, modelID = models.forRole(roleNews, cfg, modelID)
+
 	headlinesJSON, err := json.Marshal(headlines)
 	if err != nil {
 		return nil, fmt.Errorf("marshal headlines: %w", err)
@@ -619,7 +627,7 @@ func clusterTopics(cfg modelConfig, modelID string, headlines []sourceHeadlines,
 		{Role: "user", Content: clusterInput},
 	}
 
-	clusterRaw, err := doChat(cfg.BaseURL, modelID, clusterMessages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
+	clusterRaw, err := doChat(ctx, cfg, modelID, clusterMessages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
 	if err != nil {
 		return nil, fmt.Errorf("clustering: %w", err)
 	}
@@ -640,13 +648,15 @@ type searchKeywords struct {
 }
 
 // generateSearchKeywords asks the LLM to produce keyword groups for pre-filtering pages.
-func generateSearchKeywords(cfg modelConfig, modelID string, query string, prompts *Prompts) (*searchKeywords, error) {
+func generateSearchKeywords(ctx context.Context, cfg modelConfig, modelID string, query string, prompts *Prompts) (*searchKeywords, error) {
+	cfg, modelID = models.forRole(roleNews, cfg, modelID)
+
 	messages := []Message{
 		{Role: "system", Content: prompts.NewsSearchKeywords},
 		{Role: "user", Content: query},
 	}
 
-	raw, err := doChat(cfg.BaseURL, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
+	raw, err := doChat(ctx, cfg, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
 	if err != nil {
 		return nil, fmt.Errorf("generate keywords: %w", err)
 	}
@@ -688,7 +698,9 @@ func contentMatchesKeywords(content string, groups [][]string) bool {
 
 // searchTopics runs a search-specific clustering: given all headlines and a query,
 // returns only topics relevant to the search query.
-func searchTopics(cfg modelConfig, modelID string, headlines []sourceHeadlines, query string, prompts *Prompts, progress func(string)) (*topicClustering, error) {
+func searchTopics(ctx context.Context, cfg modelConfig, modelID string, headlines []sourceHeadlines, query string, prompts *Prompts, progress func(string)) (*topicClustering, error) {
+	cfg, modelID = models.forRole(roleNews, cfg, modelID)
+
 	headlinesJSON, err := json.Marshal(headlines)
 	if err != nil {
 		return nil, fmt.Errorf("marshal headlines: %w", err)
@@ -722,7 +734,7 @@ func searchTopics(cfg modelConfig, modelID string, headlines []sourceHeadlines,
 		{Role: "user", Content: input},
 	}
 
-	raw, err := doChat(cfg.BaseURL, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
+	raw, err := doChat(ctx, cfg, modelID, messages, cfg.Limit.Output, cfg.Limit.Context, thinkDisable)
 	if err != nil {
 		return nil, fmt.Errorf("search clustering: %w", err)
 	}
@@ -735,9 +747,11 @@ func searchTopics(cfg modelConfig, modelID string, headlines []sourceHeadlines,
 }
 
 // deepDiveTopics runs Phase 3 (deep dive) for the given topics and returns results.
-func deepDiveTopics(cfg modelConfig, modelID string, topics []topicGroup, category string, prompts *Prompts, progress func(string),
+func deepDiveTopics(ctx context.Context, cfg modelConfig, modelID string, topics []topicGroup, category string, prompts *Prompts, progress func(string),
 	logf func(string, ...any), mcpMgr *MCPManager, mcpNames []string, think thinkMode, mcpOverrides map[string]bool) []topicResult {
 
+	cfg, modelID = models.forRole(roleSubAgent, cfg, modelID)
+
 	// Prepare tools for deep-dive sub-agents
 	wfsTool, _ := tools.Get("web_fetch_summarize")
 	subAgentDefs := []tools.Definition{wfsTool.Def}
@@ -747,6 +761,7 @@ func deepDiveTopics(cfg modelConfig, modelID string, topics []topicGroup, catego
 		}
 	}
 	subAgentExec := makeToolExec(mcpMgr, mcpNames)
+	subAgentSess := tools.NewSession() // holds the memory_temp store for this run
 	if mcpMgr != nil && (len(mcpNames) > 0 || len(mcpOverrides) > 0) {
 		subAgentDefs = append(subAgentDefs, mcpMgr.ActiveToolDefs(mcpNames, mcpOverrides)...)
 	}
@@ -785,7 +800,7 @@ func deepDiveTopics(cfg modelConfig, modelID string, topics []topicGroup, catego
 			{Role: "user", Content: fmt.Sprintf("Проанализируй тему \"%s\" используя указанные источники.", t.TopicTitle)},
 		}
 
-		analysis, err := doSubAgentWithTools(cfg.BaseURL, modelID, messages, subAgentDefs, cfg.Limit.Output, cfg.Limit.Context, 5, 15000, logf, subAgentExec, think)
+		analysis, err := doSubAgentWithTools(ctx, subAgentSess, cfg, modelID, messages, subAgentDefs, cfg.Limit.Output, cfg.Limit.Context, 5, 15000, logf, subAgentExec, think)
 		if err != nil {
 			progress(fmt.Sprintf("    ошибка: %v, используем briefs", err))
 			analysis = buildBriefFromArticles(t.Articles)
@@ -851,7 +866,7 @@ func matchCategory(input string, categories []newsCategory) *newsCategory {
 
 // runNewsBrowse handles "/news <category>" — interactive category browse mode.
 // Shows clustered topics, lets the user pick one for deep dive.
-func runNewsBrowse(cfg modelConfig, modelID string, cat *newsCategory, showThinking bool,
+func runNewsBrowse(ctx context.Context, cfg modelConfig, modelID string, cat *newsCategory, showThinking bool,
 	contentOut io.Writer, logf func(string, ...any), prompts *Prompts,
 	mcpMgr *MCPManager, mcpNames []string, think thinkMode, mcpOverrides map[string]bool,
 	prompter tools.UserPrompter) (string, error) {
@@ -862,7 +877,7 @@ func runNewsBrowse(cfg modelConfig, modelID string, cat *newsCategory, showThink
 
 	// Phase 0: Fetch sources for this category
 	progress(fmt.Sprintf("Загрузка %d источников для %s...", len(cat.URLs), cat.DisplayHeader()))
-	sources := fetchAllNews(cat.URLs, progress)
+	sources := fetchAllNews(ctx, cat.URLs, progress)
 
 	var ok int
 	for _, s := range sources {
@@ -876,14 +891,14 @@ func runNewsBrowse(cfg modelConfig, modelID string, cat *newsCategory, showThink
 
 	// Phase 1: Extract headlines
 	progress(fmt.Sprintf("Фаза 1 [%s]: Извлечение заголовков...", cat.Name))
-	headlines := extractHeadlines(cfg, modelID, sources, cat.Name, prompts, progress)
+	headlines := extractHeadlines(ctx, cfg, modelID, sources, cat.Name, prompts, progress)
 	if len(headlines) == 0 {
 		return "", fmt.Errorf("нет заголовков для %s", cat.Name)
 	}
 
 	// Phase 2: Cluster by topic
 	progress(fmt.Sprintf("Фаза 2 [%s]: Группировка по темам...", cat.Name))
-	clustering, err := clusterTopics(cfg, modelID, headlines, cat.Filter, prompts, progress)
+	clustering, err := clusterTopics(ctx, cfg, modelID, headlines, cat.Filter, prompts, progress)
 	if err != nil {
 		return "", err
 	}
@@ -917,7 +932,7 @@ func runNewsBrowse(cfg modelConfig, modelID string, cat *newsCategory, showThink
 
 	question := fmt.Sprintf("%s — %d тем найдено. Выберите тему для анализа:", cat.DisplayHeader(), len(clustering.Topics))
 
-	answer, err := prompter.Ask(tools.UserQuestion{
+	answer, err := prompter.Ask(ctx, tools.UserQuestion{
 		Question: question,
 		Options:  options,
 	})
@@ -961,8 +976,7 @@ func runNewsBrowse(cfg modelConfig, modelID string, cat *newsCategory, showThink
 
 	// Phase 3: Deep dive
 	progress(fmt.Sprintf("Фаза 3: Анализ %d тем...", len(selectedTopics)))
-	results := deepDiveTopics(cfg, modelID, selectedTopics, cat.Name, prompts, progress, logf, mcpMgr, mcpNames, think, mcpOverrides)
-	tools.ClearTempMemory()
+	results := deepDiveTopics(ctx, cfg, modelID, selectedTopics, cat.Name, prompts, progress, logf, mcpMgr, mcpNames, think, mcpOverrides)
 
 	// Format output
 	output := formatNewsOutput(results, []newsCategory{*cat})
@@ -974,7 +988,7 @@ func runNewsBrowse(cfg modelConfig, modelID string, cat *newsCategory, showThink
 // runNewsSearch handles "/news <search query>" — topic search across all sources.
 // Uses keyword pre-filtering: LLM generates multilingual keywords first, then
 // only pages containing matching keywords are processed with headline extraction.
-func runNewsSearch(cfg modelConfig, modelID string, query string, showThinking bool,
+func runNewsSearch(ctx context.Context, cfg modelConfig, modelID string, query string, showThinking bool,
 	contentOut io.Writer, logf func(string, ...any), configPath string, prompts *Prompts,
 	mcpMgr *MCPManager, mcpNames []string, think thinkMode, mcpOverrides map[string]bool) (string, error) {
 
@@ -990,7 +1004,7 @@ func runNewsSearch(cfg modelConfig, modelID string, query string, showThinking b
 
 	// Phase 0a: Generate search keywords (fast LLM call, runs before fetching pages)
 	progress(fmt.Sprintf("Генерация ключевых слов для \"%s\"...", query))
-	keywords, kwErr := generateSearchKeywords(cfg, modelID, query, prompts)
+	keywords, kwErr := generateSearchKeywords(ctx, cfg, modelID, query, prompts)
 	if kwErr != nil {
 		progress(fmt.Sprintf("  ⚠ Ошибка генерации ключевых слов: %v — будут обработаны все страницы", kwErr))
 	} else {
@@ -1018,7 +1032,7 @@ func runNewsSearch(cfg modelConfig, modelID string, query string, showThinking b
 
 	// Phase 0b: Fetch all pages
 	progress(fmt.Sprintf("Поиск: \"%s\" — загрузка %d источников...", query, len(allURLs)))
-	sources := fetchAllNews(allURLs, progress)
+	sources := fetchAllNews(ctx, allURLs, progress)
 
 	var fetchedOK int
 	for _, s := range sources {
@@ -1061,14 +1075,14 @@ func runNewsSearch(cfg modelConfig, modelID string, query string, showThinking b
 
 	// Phase 1: Extract headlines only from relevant sources
 	progress(fmt.Sprintf("Фаза 1: Извлечение заголовков из %d релевантных источников...", len(relevantSources)))
-	headlines := extractHeadlines(cfg, modelID, relevantSources, "search", prompts, progress)
+	headlines := extractHeadlines(ctx, cfg, modelID, relevantSources, "search", prompts, progress)
 	if len(headlines) == 0 {
 		return fmt.Sprintf("По запросу \"%s\" не удалось извлечь заголовки.", query), nil
 	}
 
 	// Phase 2: Search-specific clustering
 	progress(fmt.Sprintf("Фаза 2: Поиск тем по запросу \"%s\"...", query))
-	clustering, err := searchTopics(cfg, modelID, headlines, query, prompts, progress)
+	clustering, err := searchTopics(ctx, cfg, modelID, headlines, query, prompts, progress)
 	if err != nil {
 		return "", err
 	}
@@ -1080,8 +1094,7 @@ func runNewsSearch(cfg modelConfig, modelID string, query string, showThinking b
 
 	// Phase 3: Deep dive on all found topics
 	progress("Фаза 3: Анализ найденных тем...")
-	results := deepDiveTopics(cfg, modelID, clustering.Topics, "Поиск: "+query, prompts, progress, logf, mcpMgr, mcpNames, think, mcpOverrides)
-	tools.ClearTempMemory()
+	results := deepDiveTopics(ctx, cfg, modelID, clustering.Topics, "Поиск: "+query, prompts, progress, logf, mcpMgr, mcpNames, think, mcpOverrides)
 
 	if len(results) == 0 {
 		return fmt.Sprintf("По запросу \"%s\" ничего не найдено.", query), nil
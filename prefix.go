@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"ai-webfetch/tools"
+)
+
+// withRequestContext appends the per-request part of the prompt to the
+// user's message: the current time and the userinfo block, labelled so the
+// model does not take them for part of the question. Keeping them at the
+// very end lets tools, system prompt and history form a prefix that stays
+// byte-identical between requests and can be reused from the backend's
+// prefix cache (vLLM, llama.cpp, Anthropic).
+func withRequestContext(msg Message, now time.Time, userInfo string) Message {
+	zone, _ := now.Zone()
+	msg.Content += fmt.Sprintf("\n\n[Request context, not part of the question]\nCurrent time: %s (%s).",
+		now.Format("2006-01-02 15:04"), zone) + userInfo
+	return msg
+}
+
+// prefixDebug reports, per model, how much of each request's prompt is
+// shared with the previous request (-prefix-debug).
+var prefixDebug bool
+
+var (
+	prefixMu   sync.Mutex
+	prefixLast = map[string][]string{} // model -> promptParts of the last request
+)
+
+// promptParts serializes a request the way a prefix cache sees it: tool
+// definitions first (chat templates render them at the top of the prompt),
+// then one part per message.
+func promptParts(toolDefs []tools.Definition, messages []Message) []string {
+	parts := make([]string, 0, len(messages)+1)
+	b, _ := json.Marshal(toolDefs)
+	parts = append(parts, string(b))
+	for _, m := range messages {
+		b, _ := json.Marshal(m)
+		parts = append(parts, string(b))
+	}
+	return parts
+}
+
+// sharedPrefix compares two promptParts. It returns how many leading bytes
+// cur shares with prev, the total size of cur, and the index of the first
+// part that differs (-1 if cur starts with all of prev).
+func sharedPrefix(prev, cur []string) (shared, total, firstDiff int) {
+	firstDiff = -1
+	done := false
+	for i, p := range cur {
+		total += len(p)
+		if done {
+			continue
+		}
+		if i < len(prev) && p == prev[i] {
+			shared += len(p)
+			continue
+		}
+		done = true
+		if i >= len(prev) {
+			continue // prev ended here, the rest is new
+		}
+		n := 0
+		for n < len(p) && n < len(prev[i]) && p[n] == prev[i][n] {
+			n++
+		}
+		shared += n
+		firstDiff = i
+	}
+	return shared, total, firstDiff
+}
+
+// reportPrefixReuse prints to stderr how much of this request's prompt the
+// previous request to the same model already had, and where they diverge.
+func reportPrefixReuse(model string, toolDefs []tools.Definition, messages []Message) {
+	cur := promptParts(toolDefs, messages)
+	prefixMu.Lock()
+	prev, seen := prefixLast[model]
+	prefixLast[model] = cur
+	prefixMu.Unlock()
+	if !seen {
+		return
+	}
+
+	shared, total, diff := sharedPrefix(prev, cur)
+	where := "nothing changed, only appended"
+	switch {
+	case diff == 0:
+		where = "tool definitions changed"
+	case diff > 0:
+		where = fmt.Sprintf("first change in message %d (%s)", diff-1, messages[diff-1].Role)
+	}
+	pct := 100
+	if total > 0 {
+		pct = shared * 100 / total
+	}
+	fmt.Fprintf(os.Stderr, "%s[prefix %s: %d/%d bytes shared with previous request (%d%%), %s]%s\n",
+		colorDim, model, shared, total, pct, where, colorReset)
+}
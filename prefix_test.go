@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedPrefix(t *testing.T) {
+	prev := []string{"tools", "sys", "user1"}
+	if shared, total, diff := sharedPrefix(prev, []string{"tools", "sys", "user1", "asst1", "user2"}); shared != 13 || total != 23 || diff != -1 {
+		t.Errorf("appended: shared=%d total=%d diff=%d", shared, total, diff)
+	}
+	if shared, _, diff := sharedPrefix(prev, []string{"tools", "sys", "userX"}); shared != 12 || diff != 2 {
+		t.Errorf("changed message: shared=%d diff=%d", shared, diff)
+	}
+	if shared, _, diff := sharedPrefix(prev, []string{"tool2", "sys", "user1"}); shared != 4 || diff != 0 {
+		t.Errorf("changed tools: shared=%d diff=%d", shared, diff)
+	}
+}
+
+func TestWithRequestContext_StablePrefix(t *testing.T) {
+	history := []Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}
+	build := func(now time.Time) []string {
+		msgs := append([]Message{{Role: "system", Content: "sys"}}, history...)
+		msgs = append(msgs, withRequestContext(Message{Role: "user", Content: "q"}, now, ""))
+		return promptParts(nil, msgs)
+	}
+	a := build(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	b := build(time.Date(2026, 1, 1, 10, 5, 0, 0, time.UTC))
+	if _, _, diff := sharedPrefix(a, b); diff != len(a)-1 {
+		t.Errorf("first difference at part %d, want the trailing user message (%d)", diff, len(a)-1)
+	}
+	if !strings.Contains(b[len(b)-1], "Current time: 2026-01-01 10:05") {
+		t.Errorf("user message = %s", b[len(b)-1])
+	}
+}
@@ -20,6 +20,8 @@ type Prompts struct {
 	NewsSearchKeywords  string
 	ImapSummarize       string
 	ImapDigest          string
+	DocumentSummarize   string
+	HAAlertTriage       string
 }
 
 type promptMeta struct {
@@ -38,6 +40,8 @@ var promptFields = []promptMeta{
 	{"news-search-keywords.txt", func(p *Prompts) *string { return &p.NewsSearchKeywords }},
 	{"imap-summarize.txt", func(p *Prompts) *string { return &p.ImapSummarize }},
 	{"imap-digest.txt", func(p *Prompts) *string { return &p.ImapDigest }},
+	{"document-summarize.txt", func(p *Prompts) *string { return &p.DocumentSummarize }},
+	{"ha-alert-triage.txt", func(p *Prompts) *string { return &p.HAAlertTriage }},
 }
 
 func defaultPrompts() Prompts {
@@ -52,6 +56,8 @@ func defaultPrompts() Prompts {
 		NewsSearchKeywords:  defaultNewsSearchKeywords,
 		ImapSummarize:       defaultImapSummarize,
 		ImapDigest:          defaultImapDigest,
+		DocumentSummarize:   defaultDocumentSummarize,
+		HAAlertTriage:       defaultHAAlertTriage,
 	}
 }
 
@@ -280,3 +286,17 @@ const defaultImapDigest = `Analyze the email and its conversation history. Provi
 3. CONVERSATION: if history exists, briefly describe the ongoing conversation topic and context. If no history, write "No prior conversation."
 
 Response language: {language}.`
+
+const defaultDocumentSummarize = `You are given one part of a larger document that the user sent, and the user's question about the document.
+Extract everything in this part that is relevant to the question: facts, numbers, names, dates, definitions and conclusions. Keep key terms and figures exactly as written. If the question asks for a general overview, summarize the part completely.
+Do not answer the question itself and do not add anything that is not in the text. If nothing in the part is relevant, reply "Nothing relevant."
+Response language: {language}.`
+
+const defaultHAAlertTriage = `You triage a Home Assistant event for the user and decide whether it is worth a notification.
+You get the alert name, the event and the user's instructions for this alert. You may read related states with ha_state and, if cameras are listed, look at them with ha_camera_snapshot. Do not control any devices.
+Notify when the event matches what the user wants to know about; if the instructions say nothing about it, notify. Do not notify when the instructions exclude the event or the states or camera show it is harmless.
+Reply with JSON only:
+{"notify": true, "message": "...", "attach_snapshot": false}
+- message: one or two sentences for the user: what happened, where, and what the camera shows if you looked
+- attach_snapshot: true to send the last snapshot you took along with the message
+Response language: {language}.`
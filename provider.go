@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"ai-webfetch/tools"
+)
+
+// Provider names accepted in the "provider" field of a model in config.json.
+const (
+	providerOpenAI    = "openai"    // OpenAI-compatible /chat/completions (vLLM, llama.cpp, ...)
+	providerOllama    = "ollama"    // Ollama native /api/chat
+	providerAnthropic = "anthropic" // Anthropic Messages API
+)
+
+// llmRequest is a provider-neutral chat request.
+type llmRequest struct {
+	Model     string
+	Messages  []Message
+	Tools     []tools.Definition // nil = pure generation
+	MaxTokens int
+	Think     thinkMode
+}
+
+// streamEvents receives incremental output from a provider stream.
+// Both callbacks are optional.
+type streamEvents struct {
+	Reasoning func(string) // thinking/reasoning delta (separate channel, not <think> tags)
+	Content   func(string) // answer text delta (may still contain <think> tags)
+}
+
+func (ev streamEvents) reasoning(s string) {
+	if ev.Reasoning != nil && s != "" {
+		ev.Reasoning(s)
+	}
+}
+
+func (ev streamEvents) content(s string) {
+	if ev.Content != nil && s != "" {
+		ev.Content(s)
+	}
+}
+
+// chatProvider is an LLM backend. Implementations translate the
+// OpenAI-shaped Message/tools.Definition types into their wire format
+// and back into StreamResult.
+type chatProvider interface {
+	// Stream sends a streaming request, reporting deltas via ev.
+	// The returned result holds the raw accumulated content (with any
+	// <think> tags intact), native tool calls and token usage.
+	// A stream that ends before the completion marker is a transient error.
+	Stream(ctx context.Context, req llmRequest, ev streamEvents) (*StreamResult, error)
+	// Chat sends a non-streaming request and returns the raw content
+	// and token usage (no tool calls).
+	Chat(ctx context.Context, req llmRequest) (*StreamResult, error)
+}
+
+// newProvider returns the backend for a model config.
+// An empty provider means OpenAI-compatible.
+func newProvider(cfg modelConfig) (chatProvider, error) {
+	switch cfg.Provider {
+	case "", providerOpenAI:
+		return &openAIProvider{baseURL: cfg.BaseURL, apiKey: cfg.APIKey}, nil
+	case providerOllama:
+		return &ollamaProvider{baseURL: cfg.BaseURL}, nil
+	case providerAnthropic:
+		return &anthropicProvider{baseURL: cfg.BaseURL, apiKey: cfg.APIKey}, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q (known: %s, %s, %s)",
+		cfg.Provider, providerOpenAI, providerOllama, providerAnthropic)
+}
+
+// postJSON sends a JSON POST request and returns the response if the
+// status is 200. On any other status the body is read into an
+// *apiStatusError; connection failures are returned as transient errors.
+func postJSON(ctx context.Context, url string, payload []byte, headers map[string]string) (*http.Response, error) {
+	if requestDebug {
+		dumpRequestDebug(url, payload)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, transient("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &apiStatusError{Status: resp.StatusCode, Body: string(b)}
+	}
+	return resp, nil
+}
+
+// splitDataURI splits "data:image/png;base64,AAAA" into MIME type and
+// base64 payload. Returns ok=false for anything that is not a base64 data URI.
+func splitDataURI(uri string) (mimeType, data string, ok bool) {
+	rest, found := strings.CutPrefix(uri, "data:")
+	if !found {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(rest, ",")
+	if !found {
+		return "", "", false
+	}
+	mimeType, found = strings.CutSuffix(meta, ";base64")
+	if !found {
+		return "", "", false
+	}
+	return mimeType, data, true
+}
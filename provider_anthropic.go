@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// anthropicProvider talks to the Anthropic Messages API.
+// baseURL includes the version path, e.g. https://api.anthropic.com/v1.
+type anthropicProvider struct {
+	baseURL string
+	apiKey  string
+}
+
+const (
+	anthropicVersion          = "2023-06-01"
+	anthropicDefaultMaxTokens = 4096 // max_tokens is mandatory in this API
+	anthropicMinThinking      = 1024 // smallest accepted thinking budget
+)
+
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	Source    *anthropicSource `json:"source,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   []anthropicBlock `json:"content,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	MaxTokens int                `json:"max_tokens"`
+	Stream    bool               `json:"stream,omitempty"`
+	Thinking  *anthropicThinking `json:"thinking,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error anthropicError `json:"error"`
+}
+
+// anthropicContent builds text + image blocks, skipping empty text
+// (the API rejects empty text blocks).
+func anthropicContent(text string, images []ImageURL) []anthropicBlock {
+	var blocks []anthropicBlock
+	if strings.TrimSpace(text) != "" {
+		blocks = append(blocks, anthropicBlock{Type: "text", Text: text})
+	}
+	for _, img := range images {
+		if mimeType, data, ok := splitDataURI(img.URL); ok {
+			blocks = append(blocks, anthropicBlock{
+				Type:   "image",
+				Source: &anthropicSource{Type: "base64", MediaType: mimeType, Data: data},
+			})
+		}
+	}
+	return blocks
+}
+
+// anthropicMessages converts OpenAI-shaped messages to the Messages API:
+// system messages go to the top-level "system" field, tool results become
+// user-side tool_result blocks, and consecutive same-role messages are
+// merged (the API requires strictly alternating roles).
+func anthropicMessages(messages []Message) (string, []anthropicMessage) {
+	var system []string
+	var out []anthropicMessage
+	for _, m := range messages {
+		var role string
+		var blocks []anthropicBlock
+		switch m.Role {
+		case "system":
+			system = append(system, m.Content)
+			continue
+		case "tool":
+			role = "user"
+			result := anthropicContent(m.Content, m.Images)
+			if len(result) == 0 {
+				result = []anthropicBlock{{Type: "text", Text: "(empty)"}}
+			}
+			blocks = []anthropicBlock{{Type: "tool_result", ToolUseID: m.ToolCallID, Content: result}}
+		case "assistant":
+			role = "assistant"
+			blocks = anthropicContent(m.Content, nil)
+			for _, tc := range m.ToolCalls {
+				input := json.RawMessage(tc.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input})
+			}
+		default:
+			role = "user"
+			text := m.Content
+			if len(m.Videos) > 0 {
+				text += "\n[video omitted: not supported by the anthropic provider]"
+			}
+			blocks = anthropicContent(text, m.Images)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Content = append(out[n-1].Content, blocks...)
+			continue
+		}
+		out = append(out, anthropicMessage{Role: role, Content: blocks})
+	}
+	return strings.Join(system, "\n\n"), out
+}
+
+// hasToolCalls reports whether any message carries assistant tool calls.
+func hasToolCalls(messages []Message) bool {
+	for _, m := range messages {
+		if len(m.ToolCalls) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *anthropicProvider) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": anthropicVersion,
+	}
+}
+
+func (p *anthropicProvider) request(req llmRequest, stream bool) ([]byte, error) {
+	system, msgs := anthropicMessages(req.Messages)
+	reqBody := anthropicRequest{
+		Model:     req.Model,
+		System:    system,
+		Messages:  msgs,
+		MaxTokens: req.MaxTokens,
+		Stream:    stream,
+	}
+	if reqBody.MaxTokens <= 0 {
+		reqBody.MaxTokens = anthropicDefaultMaxTokens
+	}
+	for _, d := range req.Tools {
+		schema := d.Function.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object"}
+		}
+		reqBody.Tools = append(reqBody.Tools, anthropicTool{
+			Name:        d.Function.Name,
+			Description: d.Function.Description,
+			InputSchema: schema,
+		})
+	}
+	// Thinking budget must be below max_tokens; half of the output limit
+	// leaves room for the answer. Too small an output limit disables it.
+	// Thinking is also skipped for tool loops: the API requires signed
+	// thinking blocks to be echoed back with tool results, and Message
+	// does not carry them.
+	if req.Think == thinkEnable && len(req.Tools) == 0 && !hasToolCalls(req.Messages) {
+		if budget := reqBody.MaxTokens / 2; budget >= anthropicMinThinking {
+			reqBody.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+		}
+	}
+	return json.Marshal(reqBody)
+}
+
+func (p *anthropicProvider) Stream(ctx context.Context, req llmRequest, ev streamEvents) (*StreamResult, error) {
+	payload, err := p.request(req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := postJSON(ctx, p.baseURL+"/messages", payload, p.headers())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result StreamResult
+	var content strings.Builder
+	tcMap := map[int]*ToolCall{}
+	var tcOrder []int
+	done := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 256*1024), 256*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue // "event:" lines duplicate the "type" field
+		}
+		var e anthropicEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			continue
+		}
+
+		switch e.Type {
+		case "message_start":
+			result.Usage.PromptTokens = e.Message.Usage.InputTokens
+		case "content_block_start":
+			switch e.ContentBlock.Type {
+			case "tool_use":
+				tcMap[e.Index] = &ToolCall{
+					ID:       e.ContentBlock.ID,
+					Type:     "function",
+					Function: FuncCall{Name: e.ContentBlock.Name},
+				}
+				tcOrder = append(tcOrder, e.Index)
+			case "text":
+				content.WriteString(e.ContentBlock.Text)
+				ev.content(e.ContentBlock.Text)
+			}
+		case "content_block_delta":
+			switch e.Delta.Type {
+			case "text_delta":
+				content.WriteString(e.Delta.Text)
+				ev.content(e.Delta.Text)
+			case "thinking_delta":
+				ev.reasoning(e.Delta.Thinking)
+			case "input_json_delta":
+				if tc, ok := tcMap[e.Index]; ok {
+					tc.Function.Arguments += e.Delta.PartialJSON
+				}
+			}
+		case "message_delta":
+			result.Usage.CompletionTokens = e.Usage.OutputTokens
+		case "error":
+			if e.Error.Type == "overloaded_error" || e.Error.Type == "api_error" {
+				return nil, transient("API error (%s): %s", e.Error.Type, e.Error.Message)
+			}
+			return nil, fmt.Errorf("API error (%s): %s", e.Error.Type, e.Error.Message)
+		}
+		if e.Type == "message_stop" {
+			done = true
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, transient("stream read error: %w", err)
+	}
+	if !done {
+		return nil, transient("stream ended before completion")
+	}
+
+	result.Content = content.String()
+	for _, idx := range tcOrder {
+		tc := tcMap[idx]
+		if tc.Function.Arguments == "" {
+			tc.Function.Arguments = "{}"
+		}
+		result.ToolCalls = append(result.ToolCalls, *tc)
+	}
+	return &result, nil
+}
+
+func (p *anthropicProvider) Chat(ctx context.Context, req llmRequest) (*StreamResult, error) {
+	payload, err := p.request(req, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := postJSON(ctx, p.baseURL+"/messages", payload, p.headers())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Content []anthropicBlock `json:"content"`
+		Usage   anthropicUsage   `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	var sb strings.Builder
+	for _, b := range result.Content {
+		if b.Type == "text" {
+			sb.WriteString(b.Text)
+		}
+	}
+	if sb.Len() == 0 {
+		return nil, fmt.Errorf("empty response from model")
+	}
+	return &StreamResult{
+		Content: sb.String(),
+		Usage:   Usage{PromptTokens: result.Usage.InputTokens, CompletionTokens: result.Usage.OutputTokens},
+	}, nil
+}
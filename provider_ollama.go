@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ai-webfetch/tools"
+)
+
+// ollamaProvider talks to Ollama's native /api/chat endpoint
+// (newline-delimited JSON stream, "thinking" field, object tool arguments).
+// baseURL is the server root, e.g. http://localhost:11434.
+type ollamaProvider struct {
+	baseURL string
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"` // raw base64, no data: prefix
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaRequest struct {
+	Model    string             `json:"model"`
+	Messages []ollamaMessage    `json:"messages"`
+	Tools    []tools.Definition `json:"tools,omitempty"`
+	Stream   bool               `json:"stream"`
+	Think    *bool              `json:"think,omitempty"`
+	Options  map[string]any     `json:"options,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// ollamaMessages converts OpenAI-shaped messages to Ollama's format.
+// Tool results are matched to the preceding assistant tool calls by ID,
+// since Ollama identifies them by tool name.
+func ollamaMessages(messages []Message) []ollamaMessage {
+	out := make([]ollamaMessage, 0, len(messages))
+	callNames := map[string]string{}
+	for _, m := range messages {
+		om := ollamaMessage{Role: m.Role, Content: m.Content}
+		for _, img := range m.Images {
+			if _, data, ok := splitDataURI(img.URL); ok {
+				om.Images = append(om.Images, data)
+			}
+		}
+		if len(m.Videos) > 0 {
+			om.Content += "\n[video omitted: not supported by the ollama provider]"
+		}
+		if len(m.ToolCalls) > 0 {
+			callNames = map[string]string{}
+			for _, tc := range m.ToolCalls {
+				var otc ollamaToolCall
+				otc.Function.Name = tc.Function.Name
+				otc.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+				if !json.Valid(otc.Function.Arguments) {
+					otc.Function.Arguments = json.RawMessage("{}")
+				}
+				om.ToolCalls = append(om.ToolCalls, otc)
+				callNames[tc.ID] = tc.Function.Name
+			}
+		}
+		if m.Role == "tool" {
+			om.ToolName = callNames[m.ToolCallID]
+		}
+		out = append(out, om)
+	}
+	return out
+}
+
+func (p *ollamaProvider) request(req llmRequest, stream bool) ([]byte, error) {
+	reqBody := ollamaRequest{
+		Model:    req.Model,
+		Messages: ollamaMessages(req.Messages),
+		Tools:    req.Tools,
+		Stream:   stream,
+	}
+	switch req.Think {
+	case thinkEnable:
+		t := true
+		reqBody.Think = &t
+	case thinkDisable:
+		f := false
+		reqBody.Think = &f
+	}
+	if req.MaxTokens > 0 {
+		reqBody.Options = map[string]any{"num_predict": req.MaxTokens}
+	}
+	return json.Marshal(reqBody)
+}
+
+func (p *ollamaProvider) Stream(ctx context.Context, req llmRequest, ev streamEvents) (*StreamResult, error) {
+	payload, err := p.request(req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := postJSON(ctx, p.baseURL+"/api/chat", payload, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result StreamResult
+	var content strings.Builder
+	done := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 256*1024), 256*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			continue
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("API error: %s", chunk.Error)
+		}
+
+		ev.reasoning(chunk.Message.Thinking)
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			ev.content(chunk.Message.Content)
+		}
+		// Ollama sends each tool call complete, not as deltas
+		for _, tc := range chunk.Message.ToolCalls {
+			result.ToolCalls = append(result.ToolCalls, ToolCall{
+				ID:   fmt.Sprintf("ollama_tc_%d", len(result.ToolCalls)),
+				Type: "function",
+				Function: FuncCall{
+					Name:      tc.Function.Name,
+					Arguments: string(tc.Function.Arguments),
+				},
+			})
+		}
+
+		if chunk.Done {
+			done = true
+			result.Usage = Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+			}
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, transient("stream read error: %w", err)
+	}
+	if !done {
+		return nil, transient("stream ended before completion")
+	}
+
+	result.Content = content.String()
+	return &result, nil
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, req llmRequest) (*StreamResult, error) {
+	payload, err := p.request(req, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := postJSON(ctx, p.baseURL+"/api/chat", payload, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("API error: %s", result.Error)
+	}
+	return &StreamResult{
+		Content: result.Message.Content,
+		Usage:   Usage{PromptTokens: result.PromptEvalCount, CompletionTokens: result.EvalCount},
+	}, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// openAIProvider talks to an OpenAI-compatible /chat/completions endpoint
+// (vLLM, llama.cpp server, LM Studio, OpenAI itself).
+type openAIProvider struct {
+	baseURL string
+	apiKey  string
+}
+
+func (p *openAIProvider) headers() map[string]string {
+	if p.apiKey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + p.apiKey}
+}
+
+func (p *openAIProvider) request(req llmRequest, stream bool) ([]byte, error) {
+	reqBody := chatRequest{
+		Model:     req.Model,
+		Messages:  req.Messages,
+		Tools:     req.Tools,
+		Stream:    stream,
+		MaxTokens: req.MaxTokens,
+	}
+	if stream {
+		reqBody.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	applyThinkMode(&reqBody, req.Think)
+	return json.Marshal(reqBody)
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, req llmRequest, ev streamEvents) (*StreamResult, error) {
+	payload, err := p.request(req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := postJSON(ctx, p.baseURL+"/chat/completions", payload, p.headers())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result StreamResult
+	var content strings.Builder
+	tcMap := map[int]*ToolCall{}
+	done := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 256*1024), 256*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if chunk.Usage != nil {
+			result.Usage = *chunk.Usage
+		}
+
+		for _, ch := range chunk.Choices {
+			if ch.FinishReason != nil && *ch.FinishReason != "" {
+				done = true
+			}
+
+			// Reasoning content: "reasoning_content" (Qwen3 via older vLLM) or "reasoning" (vLLM 0.16+)
+			rc := ch.Delta.ReasoningContent
+			if rc == nil || *rc == "" {
+				rc = ch.Delta.Reasoning
+			}
+			if rc != nil {
+				ev.reasoning(*rc)
+			}
+
+			if ch.Delta.Content != nil && *ch.Delta.Content != "" {
+				content.WriteString(*ch.Delta.Content)
+				ev.content(*ch.Delta.Content)
+			}
+
+			// Tool calls (accumulated across chunks)
+			for _, tc := range ch.Delta.ToolCalls {
+				if existing, ok := tcMap[tc.Index]; ok {
+					if tc.ID != "" {
+						existing.ID = tc.ID
+					}
+					if tc.Function.Name != "" {
+						existing.Function.Name = tc.Function.Name
+					}
+					existing.Function.Arguments += tc.Function.Arguments
+				} else {
+					tcMap[tc.Index] = &ToolCall{
+						ID:   tc.ID,
+						Type: tc.Type,
+						Function: FuncCall{
+							Name:      tc.Function.Name,
+							Arguments: tc.Function.Arguments,
+						},
+					}
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, transient("stream read error: %w", err)
+	}
+	if !done {
+		return nil, transient("stream ended before completion")
+	}
+
+	result.Content = content.String()
+	for i := 0; i < len(tcMap); i++ {
+		if tc, ok := tcMap[i]; ok {
+			result.ToolCalls = append(result.ToolCalls, *tc)
+		}
+	}
+	return &result, nil
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, req llmRequest) (*StreamResult, error) {
+	payload, err := p.request(req, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := postJSON(ctx, p.baseURL+"/chat/completions", payload, p.headers())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage Usage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from model")
+	}
+	return &StreamResult{Content: result.Choices[0].Message.Content, Usage: result.Usage}, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ai-webfetch/tools"
+)
+
+// captureServer returns a test server that records the last request body
+// and path, and replies with the given content type and body.
+func captureServer(t *testing.T, contentType, body string, gotPath *string, gotBody *[]byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*gotPath = r.URL.Path
+		*gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", contentType)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var testToolDefs = []tools.Definition{{
+	Type: "function",
+	Function: tools.Function{
+		Name:        "get_weather",
+		Description: "Get weather",
+		Parameters:  tools.Parameters{Type: "object", Properties: map[string]tools.Property{"city": {Type: "string"}}},
+	},
+}}
+
+func collectEvents() (streamEvents, *strings.Builder, *strings.Builder) {
+	var reasoning, content strings.Builder
+	return streamEvents{
+		Reasoning: func(s string) { reasoning.WriteString(s) },
+		Content:   func(s string) { content.WriteString(s) },
+	}, &reasoning, &content
+}
+
+func TestNewProvider_Unknown(t *testing.T) {
+	if _, err := newProvider(modelConfig{Provider: "bogus"}); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+	p, err := newProvider(modelConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := p.(*openAIProvider); !ok {
+		t.Errorf("empty provider should be openai, got %T", p)
+	}
+}
+
+func TestOpenAIProvider_Stream(t *testing.T) {
+	sse := strings.Join([]string{
+		`data: {"choices":[{"delta":{"reasoning_content":"hmm"}}]}`,
+		`data: {"choices":[{"delta":{"content":"Hel"}}]}`,
+		`data: {"choices":[{"delta":{"content":"lo"}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"ci"}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"Prague\"}"}}]}}]}`,
+		`data: {"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":5}}`,
+		`data: [DONE]`,
+	}, "\n\n")
+	var path string
+	var body []byte
+	srv := captureServer(t, "text/event-stream", sse, &path, &body)
+
+	p := &openAIProvider{baseURL: srv.URL + "/v1", apiKey: "k"}
+	ev, reasoning, content := collectEvents()
+	res, err := p.Stream(context.Background(), llmRequest{Model: "m", Messages: []Message{{Role: "user", Content: "hi"}}, Tools: testToolDefs, Think: thinkDisable}, ev)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/v1/chat/completions" {
+		t.Errorf("path = %q", path)
+	}
+	if !strings.Contains(string(body), `"enable_thinking":false`) {
+		t.Errorf("think mode not sent: %s", body)
+	}
+	if reasoning.String() != "hmm" || content.String() != "Hello" || res.Content != "Hello" {
+		t.Errorf("reasoning=%q content=%q result=%q", reasoning, content, res.Content)
+	}
+	if len(res.ToolCalls) != 1 || res.ToolCalls[0].ID != "call_1" || res.ToolCalls[0].Function.Arguments != `{"city":"Prague"}` {
+		t.Errorf("tool calls = %+v", res.ToolCalls)
+	}
+	if res.Usage != (Usage{PromptTokens: 12, CompletionTokens: 5}) {
+		t.Errorf("usage = %+v", res.Usage)
+	}
+}
+
+func TestOpenAIProvider_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	p := &openAIProvider{baseURL: srv.URL}
+	_, err := p.Chat(context.Background(), llmRequest{Model: "m", Messages: []Message{{Role: "user", Content: "hi"}}})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected API error 503, got %v", err)
+	}
+}
+
+func TestOllamaProvider_Stream(t *testing.T) {
+	ndjson := strings.Join([]string{
+		`{"message":{"role":"assistant","content":"","thinking":"let me see"},"done":false}`,
+		`{"message":{"role":"assistant","content":"Sure"},"done":false}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Brno"}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":30,"eval_count":7}`,
+	}, "\n")
+	var path string
+	var body []byte
+	srv := captureServer(t, "application/x-ndjson", ndjson, &path, &body)
+
+	msgs := []Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "look", Images: []ImageURL{{URL: "data:image/png;base64,AAAA"}}},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "x1", Type: "function", Function: FuncCall{Name: "get_weather", Arguments: `{"city":"Praha"}`}}}},
+		{Role: "tool", ToolCallID: "x1", Content: "sunny"},
+	}
+	p := &ollamaProvider{baseURL: srv.URL}
+	ev, reasoning, content := collectEvents()
+	res, err := p.Stream(context.Background(), llmRequest{Model: "m", Messages: msgs, Tools: testToolDefs, MaxTokens: 100, Think: thinkEnable}, ev)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/api/chat" {
+		t.Errorf("path = %q", path)
+	}
+
+	var req ollamaRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("bad request body: %v", err)
+	}
+	if req.Think == nil || !*req.Think {
+		t.Errorf("think not enabled in request")
+	}
+	if req.Options["num_predict"] != float64(100) {
+		t.Errorf("num_predict = %v", req.Options["num_predict"])
+	}
+	if len(req.Messages[1].Images) != 1 || req.Messages[1].Images[0] != "AAAA" {
+		t.Errorf("images = %v", req.Messages[1].Images)
+	}
+	if string(req.Messages[2].ToolCalls[0].Function.Arguments) != `{"city":"Praha"}` {
+		t.Errorf("tool call arguments = %s", req.Messages[2].ToolCalls[0].Function.Arguments)
+	}
+	if req.Messages[3].ToolName != "get_weather" {
+		t.Errorf("tool_name = %q", req.Messages[3].ToolName)
+	}
+
+	if reasoning.String() != "let me see" || content.String() != "Sure" {
+		t.Errorf("reasoning=%q content=%q", reasoning, content)
+	}
+	if len(res.ToolCalls) != 1 || res.ToolCalls[0].Function.Name != "get_weather" || res.ToolCalls[0].Function.Arguments != `{"city":"Brno"}` {
+		t.Errorf("tool calls = %+v", res.ToolCalls)
+	}
+	if res.Usage != (Usage{PromptTokens: 30, CompletionTokens: 7}) {
+		t.Errorf("usage = %+v", res.Usage)
+	}
+}
+
+func TestOllamaProvider_Chat(t *testing.T) {
+	var path string
+	var body []byte
+	srv := captureServer(t, "application/json", `{"message":{"role":"assistant","content":"<think>x</think>ok"},"done":true}`, &path, &body)
+
+	p := &ollamaProvider{baseURL: srv.URL}
+	got, err := p.Chat(context.Background(), llmRequest{Model: "m", Messages: []Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Content != "<think>x</think>ok" {
+		t.Errorf("got %q", got.Content)
+	}
+	if !strings.Contains(string(body), `"stream":false`) {
+		t.Errorf("stream flag not false: %s", body)
+	}
+}
+
+func TestAnthropicProvider_Stream(t *testing.T) {
+	sse := strings.Join([]string{
+		"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":40,\"output_tokens\":1}}}",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Checking\"}}",
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"get_weather\",\"input\":{}}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\"}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"Prague\\\"}\"}}",
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":1}",
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":22}}",
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}",
+	}, "\n\n")
+	var path string
+	var body []byte
+	srv := captureServer(t, "text/event-stream", sse, &path, &body)
+
+	msgs := []Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "weather?", Images: []ImageURL{{URL: "data:image/jpeg;base64,BBBB"}}},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "toolu_0", Type: "function", Function: FuncCall{Name: "get_weather", Arguments: `{"city":"Brno"}`}}}},
+		{Role: "tool", ToolCallID: "toolu_0", Content: "rain"},
+		{Role: "user", Content: "and Prague?"},
+	}
+	p := &anthropicProvider{baseURL: srv.URL + "/v1", apiKey: "secret"}
+	ev, _, content := collectEvents()
+	res, err := p.Stream(context.Background(), llmRequest{Model: "claude", Messages: msgs, Tools: testToolDefs}, ev)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/v1/messages" {
+		t.Errorf("path = %q", path)
+	}
+
+	var req anthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("bad request body: %v", err)
+	}
+	if req.System != "be brief" {
+		t.Errorf("system = %q", req.System)
+	}
+	if req.MaxTokens != anthropicDefaultMaxTokens {
+		t.Errorf("max_tokens = %d", req.MaxTokens)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Name != "get_weather" || req.Tools[0].InputSchema == nil {
+		t.Errorf("tools = %+v", req.Tools)
+	}
+	// user, assistant, user(tool_result + text) — roles must alternate
+	if len(req.Messages) != 3 {
+		t.Fatalf("messages = %+v", req.Messages)
+	}
+	if img := req.Messages[0].Content[1]; img.Type != "image" || img.Source.MediaType != "image/jpeg" || img.Source.Data != "BBBB" {
+		t.Errorf("image block = %+v", img)
+	}
+	if tu := req.Messages[1].Content[0]; tu.Type != "tool_use" || tu.ID != "toolu_0" || string(tu.Input) != `{"city":"Brno"}` {
+		t.Errorf("tool_use block = %+v", tu)
+	}
+	last := req.Messages[2]
+	if last.Role != "user" || len(last.Content) != 2 || last.Content[0].Type != "tool_result" || last.Content[0].ToolUseID != "toolu_0" {
+		t.Errorf("tool_result message = %+v", last)
+	}
+
+	if content.String() != "Checking" || res.Content != "Checking" {
+		t.Errorf("content = %q / %q", content, res.Content)
+	}
+	if len(res.ToolCalls) != 1 || res.ToolCalls[0].ID != "toolu_1" || res.ToolCalls[0].Function.Arguments != `{"city":"Prague"}` {
+		t.Errorf("tool calls = %+v", res.ToolCalls)
+	}
+	if res.Usage != (Usage{PromptTokens: 40, CompletionTokens: 22}) {
+		t.Errorf("usage = %+v", res.Usage)
+	}
+}
+
+func TestAnthropicProvider_ChatThinking(t *testing.T) {
+	var path string
+	var body []byte
+	srv := captureServer(t, "application/json",
+		`{"content":[{"type":"thinking","thinking":"..."},{"type":"text","text":"42"}],"usage":{"input_tokens":5,"output_tokens":3}}`,
+		&path, &body)
+
+	p := &anthropicProvider{baseURL: srv.URL, apiKey: "secret"}
+	got, err := p.Chat(context.Background(), llmRequest{Model: "claude", Messages: []Message{{Role: "user", Content: "answer"}}, MaxTokens: 8000, Think: thinkEnable})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Content != "42" {
+		t.Errorf("got %q", got.Content)
+	}
+	var req anthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("bad request body: %v", err)
+	}
+	if req.Thinking == nil || req.Thinking.BudgetTokens != 4000 {
+		t.Errorf("thinking = %+v", req.Thinking)
+	}
+}
+
+func TestAnthropicProvider_StreamError(t *testing.T) {
+	var path string
+	var body []byte
+	srv := captureServer(t, "text/event-stream",
+		"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n",
+		&path, &body)
+
+	p := &anthropicProvider{baseURL: srv.URL}
+	_, err := p.Stream(context.Background(), llmRequest{Model: "claude", Messages: []Message{{Role: "user", Content: "hi"}}}, streamEvents{})
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Errorf("expected overloaded_error, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// quotaGuard enforces a user's quota during one query. It travels in the
+// query's context; runQuery checks it before every tool round.
+type quotaGuard struct {
+	quota      UserQuota
+	spentToday int // ledger tokens of today when the query started
+	meter      *usageMeter
+}
+
+type quotaGuardKey struct{}
+
+// errQuotaExceeded wraps every quota rejection.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// Sliding one-hour window of admitted requests per ledger user.
+var (
+	quotaMu       sync.Mutex
+	quotaRequests = map[string][]time.Time{}
+)
+
+// effectiveQuota returns the quota that applies to a user: none for admins,
+// the user's own quota if set, otherwise the bot default (may be nil).
+func effectiveQuota(user *UserConfig, def *UserQuota) *UserQuota {
+	if user != nil && user.Admin {
+		return nil
+	}
+	if user != nil && user.Quota != nil {
+		return user.Quota
+	}
+	return def
+}
+
+// admitQuery checks the hourly request and daily token limits before a
+// query starts, counts the request, and returns a context carrying the
+// guard for the per-round checks. A nil quota admits everything.
+func admitQuery(ctx context.Context, userName string, q *UserQuota, meter *usageMeter, now time.Time) (context.Context, error) {
+	if q == nil {
+		return ctx, nil
+	}
+	user := usageUser(userName)
+
+	spent := 0
+	if q.TokensPerDay > 0 {
+		var err error
+		if spent, err = usageTokensOn(user, now); err != nil {
+			return ctx, err
+		}
+		if spent >= q.TokensPerDay {
+			return ctx, fmt.Errorf("%w: daily token limit reached (%s of %s), resets at midnight",
+				errQuotaExceeded, formatTokenCount(spent), formatTokenCount(q.TokensPerDay))
+		}
+	}
+
+	if q.RequestsPerHour > 0 {
+		quotaMu.Lock()
+		cutoff := now.Add(-time.Hour)
+		recent := quotaRequests[user][:0]
+		for _, t := range quotaRequests[user] {
+			if t.After(cutoff) {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) >= q.RequestsPerHour {
+			quotaRequests[user] = recent
+			quotaMu.Unlock()
+			wait := recent[0].Add(time.Hour).Sub(now).Round(time.Minute)
+			return ctx, fmt.Errorf("%w: hourly request limit reached (%d), try again in %v",
+				errQuotaExceeded, q.RequestsPerHour, max(wait, time.Minute))
+		}
+		quotaRequests[user] = append(recent, now)
+		quotaMu.Unlock()
+	}
+
+	g := &quotaGuard{quota: *q, spentToday: spent, meter: meter}
+	return context.WithValue(ctx, quotaGuardKey{}, g), nil
+}
+
+// quotaRoundNote is added to an answer cut short by the tool-round quota.
+const quotaRoundNote = "⛔ tool round limit reached (%d per query), the answer may be incomplete"
+
+// quotaRoundLimit is called before executing tool round n (1-based) of a
+// query. It returns the user's tool-round limit once the round exceeds it,
+// else 0; the query is then answered with what it has gathered so far.
+func quotaRoundLimit(ctx context.Context, round int) int {
+	g, ok := ctx.Value(quotaGuardKey{}).(*quotaGuard)
+	if !ok || g.quota.MaxToolRounds <= 0 || round <= g.quota.MaxToolRounds {
+		return 0
+	}
+	return g.quota.MaxToolRounds
+}
+
+// checkQuotaRound is called before executing tool round n (1-based) of a
+// query and fails once the daily token limit is exhausted.
+func checkQuotaRound(ctx context.Context, round int) error {
+	g, ok := ctx.Value(quotaGuardKey{}).(*quotaGuard)
+	if !ok {
+		return nil
+	}
+	if g.quota.TokensPerDay > 0 && g.meter != nil {
+		if used := g.spentToday + g.meter.totalTokens(); used >= g.quota.TokensPerDay {
+			return fmt.Errorf("%w: daily token limit reached (%s of %s), resets at midnight",
+				errQuotaExceeded, formatTokenCount(used), formatTokenCount(g.quota.TokensPerDay))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuota_RequestsPerHour(t *testing.T) {
+	q := &UserQuota{RequestsPerHour: 2}
+	now := time.Now()
+	for i := range 2 {
+		if _, err := admitQuery(context.Background(), "quota-hour", q, nil, now.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	_, err := admitQuery(context.Background(), "quota-hour", q, nil, now.Add(2*time.Minute))
+	if !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("third request: err = %v", err)
+	}
+	if _, err := admitQuery(context.Background(), "quota-hour", q, nil, now.Add(61*time.Minute)); err != nil {
+		t.Errorf("after the window: %v", err)
+	}
+}
+
+func TestQuota_TokensAndToolRounds(t *testing.T) {
+	prev := usagePath
+	usagePath = filepath.Join(t.TempDir(), "usage.json")
+	defer func() { usagePath = prev }()
+
+	_, earlier := withUsageMeter(context.Background())
+	earlier.byModel["m"] = &usageTotals{Requests: 1, PromptTokens: 900}
+	if err := saveQueryUsage("quota-tokens", usageModeQuery, earlier); err != nil {
+		t.Fatal(err)
+	}
+
+	q := &UserQuota{TokensPerDay: 1000, MaxToolRounds: 2}
+	ctx, meter := withUsageMeter(context.Background())
+	ctx, err := admitQuery(ctx, "quota-tokens", q, meter, time.Now())
+	if err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	if err := checkQuotaRound(ctx, 3); err != nil {
+		t.Errorf("round 3: %v", err)
+	}
+	if quotaRoundLimit(ctx, 2) != 0 || quotaRoundLimit(ctx, 3) != 2 {
+		t.Errorf("round limit = %d, %d", quotaRoundLimit(ctx, 2), quotaRoundLimit(ctx, 3))
+	}
+	recordUsage(ctx, "m", Usage{PromptTokens: 150})
+	if err := checkQuotaRound(ctx, 2); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("over tokens: err = %v", err)
+	}
+
+	if admin := effectiveQuota(&UserConfig{Admin: true, Quota: q}, q); admin != nil {
+		t.Errorf("admin quota = %+v", admin)
+	}
+}
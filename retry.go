@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// retryConfig controls retries and failover for a model's endpoints.
+// Zero values mean defaults.
+type retryConfig struct {
+	Attempts     int `json:"attempts,omitempty"`     // tries per endpoint (default 3)
+	BackoffMs    int `json:"backoffMs,omitempty"`    // first retry delay, doubled each time (default 1000)
+	MaxBackoffMs int `json:"maxBackoffMs,omitempty"` // delay cap (default 30000)
+}
+
+const (
+	defaultRetryAttempts = 3
+	defaultBackoff       = time.Second
+	defaultMaxBackoff    = 30 * time.Second
+)
+
+func (r retryConfig) attempts() int {
+	if r.Attempts > 0 {
+		return r.Attempts
+	}
+	return defaultRetryAttempts
+}
+
+func (r retryConfig) backoff() (initial, maxDelay time.Duration) {
+	initial, maxDelay = defaultBackoff, defaultMaxBackoff
+	if r.BackoffMs > 0 {
+		initial = time.Duration(r.BackoffMs) * time.Millisecond
+	}
+	if r.MaxBackoffMs > 0 {
+		maxDelay = time.Duration(r.MaxBackoffMs) * time.Millisecond
+	}
+	return initial, maxDelay
+}
+
+// endpoints returns the base URLs to try in order: baseURL first (if set),
+// then baseURLs, without duplicates.
+func (cfg modelConfig) endpoints() []string {
+	var eps []string
+	seen := map[string]bool{}
+	for _, u := range append([]string{cfg.BaseURL}, cfg.BaseURLs...) {
+		if u != "" && !seen[u] {
+			seen[u] = true
+			eps = append(eps, u)
+		}
+	}
+	return eps
+}
+
+// apiStatusError is a non-200 HTTP response from an LLM endpoint.
+type apiStatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.Status, e.Body)
+}
+
+// transientError marks a failure worth retrying: connection errors,
+// streams cut off mid-response, provider-side overload.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+func transient(format string, args ...any) error {
+	return &transientError{err: fmt.Errorf(format, args...)}
+}
+
+// isRetryable reports whether err is a connection-level failure or a
+// 429/5xx response.
+func isRetryable(err error) bool {
+	var se *apiStatusError
+	if errors.As(err, &se) {
+		return se.Status == 429 || se.Status >= 500
+	}
+	var te *transientError
+	return errors.As(err, &te)
+}
+
+// llmLogf reports retries and failovers (set by main; silent by default).
+var llmLogf = func(string, ...any) {}
+
+// retrySleep waits for d or until ctx is cancelled; replaceable in tests.
+var retrySleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withRetry calls fn with a provider for each endpoint of cfg in turn.
+// Retryable errors are retried with exponential backoff up to
+// cfg.Retry.attempts() times per endpoint, then the next endpoint is tried.
+// Non-retryable errors (bad request, auth, unknown provider) and
+// cancellation of ctx return at once.
+func withRetry[T any](ctx context.Context, cfg modelConfig, fn func(p chatProvider) (T, error)) (T, error) {
+	var zero T
+	eps := cfg.endpoints()
+	if len(eps) == 0 {
+		return zero, fmt.Errorf("no baseURL configured")
+	}
+	attempts := cfg.Retry.attempts()
+	initial, maxDelay := cfg.Retry.backoff()
+
+	var lastErr error
+	for i, ep := range eps {
+		epCfg := cfg
+		epCfg.BaseURL = ep
+		p, err := newProvider(epCfg)
+		if err != nil {
+			return zero, err
+		}
+
+		delay := initial
+		for attempt := 1; attempt <= attempts; attempt++ {
+			res, err := fn(p)
+			if err == nil {
+				return res, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				return zero, ctx.Err()
+			}
+			if !isRetryable(err) {
+				return zero, err
+			}
+			if attempt == attempts {
+				break
+			}
+			llmLogf("%s[%s: attempt %d/%d failed: %v; retrying in %s]%s\n",
+				colorDim, ep, attempt, attempts, err, delay, colorReset)
+			if err := retrySleep(ctx, delay); err != nil {
+				return zero, err
+			}
+			delay = min(delay*2, maxDelay)
+		}
+
+		if i+1 < len(eps) {
+			llmLogf("%s[%s: giving up after %d attempts (%v); failing over to %s]%s\n",
+				colorDim, ep, attempts, lastErr, eps[i+1], colorReset)
+		}
+	}
+	if len(eps) > 1 {
+		return zero, fmt.Errorf("all %d endpoints failed: %w", len(eps), lastErr)
+	}
+	return zero, lastErr
+}
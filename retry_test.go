@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func noRetrySleep(t *testing.T) {
+	t.Helper()
+	prev := retrySleep
+	retrySleep = func(context.Context, time.Duration) error { return nil }
+	t.Cleanup(func() { retrySleep = prev })
+}
+
+const okSSE = "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n"
+
+func TestWithRetry_RetriesOn503(t *testing.T) {
+	noRetrySleep(t)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "loading model", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, okSSE)
+	}))
+	defer srv.Close()
+
+	res, err := doStream(context.Background(), modelConfig{BaseURL: srv.URL}, "m", []Message{{Role: "user", Content: "hi"}}, nil, 100, false, &strings.Builder{}, thinkDefault)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Content != "ok" || calls.Load() != 3 {
+		t.Errorf("content=%q calls=%d", res.Content, calls.Load())
+	}
+}
+
+func TestWithRetry_NoRetryOn400(t *testing.T) {
+	noRetrySleep(t)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	_, err := doChat(context.Background(), modelConfig{BaseURL: srv.URL}, "m", []Message{{Role: "user", Content: "hi"}}, 100, 0, thinkDefault)
+	if err == nil || calls.Load() != 1 {
+		t.Errorf("err=%v calls=%d, want error after 1 call", err, calls.Load())
+	}
+}
+
+func TestWithRetry_FailoverToNextEndpoint(t *testing.T) {
+	noRetrySleep(t)
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadURL := dead.URL
+	dead.Close() // connection refused
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, okSSE)
+	}))
+	defer srv.Close()
+
+	var logged []string
+	prevLog := llmLogf
+	llmLogf = func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+	defer func() { llmLogf = prevLog }()
+
+	cfg := modelConfig{BaseURL: deadURL, BaseURLs: []string{srv.URL}, Retry: retryConfig{Attempts: 2}}
+	res, err := doStream(context.Background(), cfg, "m", []Message{{Role: "user", Content: "hi"}}, nil, 100, false, &strings.Builder{}, thinkDefault)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Content != "ok" {
+		t.Errorf("content = %q", res.Content)
+	}
+	// one retry message + one failover message
+	if len(logged) != 2 || !strings.Contains(logged[1], "failing over") {
+		t.Errorf("logged = %q", logged)
+	}
+}
+
+func TestWithRetry_ReissuesTruncatedStream(t *testing.T) {
+	noRetrySleep(t)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// connection dies after a partial response
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"par\"}}]}\n\n")
+			return
+		}
+		fmt.Fprint(w, okSSE)
+	}))
+	defer srv.Close()
+
+	res, err := doStream(context.Background(), modelConfig{BaseURL: srv.URL}, "m", []Message{{Role: "user", Content: "hi"}}, nil, 100, false, &strings.Builder{}, thinkDefault)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Content != "ok" || calls.Load() != 2 {
+		t.Errorf("content=%q calls=%d", res.Content, calls.Load())
+	}
+}
+
+// dropWriter is a content writer that takes back failed attempts.
+type dropWriter struct{ strings.Builder }
+
+func (w *dropWriter) dropContent(n int) {
+	s := w.String()
+	w.Reset()
+	w.WriteString(s[:len(s)-n])
+}
+
+func TestWithRetry_DropsTextOfFailedAttempt(t *testing.T) {
+	noRetrySleep(t)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// partial text, then the connection dies inside a <think> tag
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"par <thi\"}}]}\n\n")
+			return
+		}
+		fmt.Fprint(w, okSSE)
+	}))
+	defer srv.Close()
+
+	out := &dropWriter{}
+	if _, err := doStream(context.Background(), modelConfig{BaseURL: srv.URL}, "m", []Message{{Role: "user", Content: "hi"}}, nil, 100, false, out, thinkDefault); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != "ok" {
+		t.Errorf("content written = %q, want only the retried attempt", out.String())
+	}
+}
+
+func TestWithRetry_AllEndpointsFail(t *testing.T) {
+	noRetrySleep(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	cfg := modelConfig{BaseURL: srv.URL, BaseURLs: []string{srv.URL + "/"}, Retry: retryConfig{Attempts: 2}}
+	_, err := doChat(context.Background(), cfg, "m", []Message{{Role: "user", Content: "hi"}}, 100, 0, thinkDefault)
+	if err == nil || !strings.Contains(err.Error(), "all 2 endpoints failed") || !strings.Contains(err.Error(), "429") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestWithRetry_StopsOnCancel(t *testing.T) {
+	var calls atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		cancel()
+		http.Error(w, "loading model", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := doChat(ctx, modelConfig{BaseURL: srv.URL}, "m", []Message{{Role: "user", Content: "hi"}}, 100, 0, thinkDefault)
+	if !errors.Is(err, context.Canceled) || calls.Load() != 1 {
+		t.Errorf("err=%v calls=%d, want context.Canceled after 1 call", err, calls.Load())
+	}
+}
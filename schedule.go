@@ -0,0 +1,469 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduled jobs
+//
+// Users can have recurring jobs in users.json ("schedules"): the news
+// digest, the mail digest, or any prompt with skills and MCP servers. The
+// bot runs them through its request queue and sends the result to the
+// user's chat for the job's category, replacing an external cron. The last
+// run of each job is kept in schedules.json, so a run missed while the bot
+// was down is caught up once at startup; a job still running (or waiting in
+// the queue) when it is due again skips that run.
+
+const (
+	scheduleNews   = "news"
+	scheduleMail   = "mail"
+	schedulePrompt = "prompt"
+
+	scheduleTick = 30 * time.Second
+
+	// silentReply is the answer with which a prompt job says that there is
+	// nothing to send.
+	silentReply = "NO_REPLY"
+)
+
+// UserSchedule is a recurring job of a user.
+type UserSchedule struct {
+	Name     string   `json:"name"`
+	Cron     string   `json:"cron"`               // "minute hour day month weekday", see cron.go
+	Type     string   `json:"type"`               // "news", "mail" or "prompt"
+	Hours    float64  `json:"hours,omitempty"`    // mail: digest period (default 24)
+	Prompt   string   `json:"prompt,omitempty"`   // prompt: the query
+	Skills   []string `json:"skills,omitempty"`   // prompt: skills to load
+	MCP      []string `json:"mcp,omitempty"`      // prompt: MCP servers to enable
+	Chat     string   `json:"chat,omitempty"`     // chats category: news, mail or other (default: by type)
+	Timezone string   `json:"timezone,omitempty"` // IANA zone of the cron fields (default: local)
+}
+
+// category is the chats category the result goes to.
+func (s *UserSchedule) category() string {
+	if s.Chat != "" {
+		return s.Chat
+	}
+	switch s.Type {
+	case scheduleNews, scheduleMail:
+		return s.Type
+	}
+	return "other"
+}
+
+// describe is a short description of what the job does.
+func (s *UserSchedule) describe() string {
+	switch s.Type {
+	case scheduleNews:
+		return "новости"
+	case scheduleMail:
+		return fmt.Sprintf("почта за %g ч", s.mailHours())
+	}
+	return fmt.Sprintf("запрос «%s»", truncate(s.Prompt, 40))
+}
+
+func (s *UserSchedule) mailHours() float64 {
+	if s.Hours > 0 {
+		return s.Hours
+	}
+	return 24
+}
+
+// scheduledJob is a user's schedule, ready to run.
+type scheduledJob struct {
+	UserSchedule
+	userName string
+	user     *UserConfig
+	spec     *cronSpec
+	loc      *time.Location
+	next     time.Time // scheduler only
+
+	// Tool policy of a prompt reminder set in a group (see tools.Reminder)
+	enabledTools []string
+	readOnly     bool
+}
+
+func (j *scheduledJob) key() string { return j.userName + "/" + j.Name }
+
+func newScheduledJob(userName string, u *UserConfig, s UserSchedule) (*scheduledJob, error) {
+	if s.Name == "" {
+		return nil, errors.New("name is empty")
+	}
+	switch s.Type {
+	case scheduleNews, scheduleMail:
+	case schedulePrompt:
+		if strings.TrimSpace(s.Prompt) == "" {
+			return nil, errors.New("prompt is empty")
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q (known: %s, %s, %s)", s.Type, scheduleNews, scheduleMail, schedulePrompt)
+	}
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.Local
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	return &scheduledJob{UserSchedule: s, userName: userName, user: u, spec: spec, loc: loc}, nil
+}
+
+// scheduleState is the persisted state of a job.
+type scheduleState struct {
+	LastRun time.Time `json:"last_run,omitzero"` // last scheduled (not manual) run
+	Paused  bool      `json:"paused,omitempty"`
+}
+
+var schedulesPath = "schedules.json"
+
+var errScheduleRunning = errors.New("предыдущий запуск ещё не завершён")
+
+// scheduler fires the jobs of all users.
+type scheduler struct {
+	mu      sync.Mutex
+	jobs    []*scheduledJob // by user, then name
+	state   map[string]*scheduleState
+	running map[string]bool
+	path    string
+
+	// run starts a job; done must be called once it has finished or was
+	// dropped.
+	run func(j *scheduledJob, done func())
+}
+
+// newScheduler collects the schedules of users; invalid ones are logged
+// and skipped.
+func newScheduler(users map[string]*UserConfig, path string, run func(*scheduledJob, func())) (*scheduler, error) {
+	s := &scheduler{state: map[string]*scheduleState{}, running: map[string]bool{}, path: path, run: run}
+	for name, u := range users {
+		for _, us := range u.Schedules {
+			j, err := newScheduledJob(name, u, us)
+			if err != nil {
+				log.Printf("Schedule %s/%s skipped: %v", name, us.Name, err)
+				continue
+			}
+			if slices.ContainsFunc(s.jobs, func(o *scheduledJob) bool { return o.key() == j.key() }) {
+				log.Printf("Schedule %s skipped: duplicate name", j.key())
+				continue
+			}
+			s.jobs = append(s.jobs, j)
+		}
+	}
+	slices.SortFunc(s.jobs, func(a, b *scheduledJob) int { return cmp.Compare(a.key(), b.key()) })
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	// Keep the state of configured jobs only
+	for key := range s.state {
+		if s.job(key) == nil {
+			delete(s.state, key)
+		}
+	}
+	for _, j := range s.jobs {
+		if s.state[j.key()] == nil {
+			s.state[j.key()] = &scheduleState{}
+		}
+	}
+	return s, nil
+}
+
+func (s *scheduler) job(key string) *scheduledJob {
+	for _, j := range s.jobs {
+		if j.key() == key {
+			return j
+		}
+	}
+	return nil
+}
+
+// save writes the state file; callers hold s.mu.
+func (s *scheduler) save() {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving %s: %v", s.path, err)
+	}
+}
+
+// start catches up on missed runs and fires the jobs when due until ctx
+// is done.
+func (s *scheduler) start(ctx context.Context) {
+	s.catchUp(time.Now())
+	go func() {
+		ticker := time.NewTicker(scheduleTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.tick(now)
+			}
+		}
+	}()
+}
+
+// catchUp runs once every job that was due between its last run and now,
+// and plans the next runs. A job without a last run starts counting now.
+func (s *scheduler) catchUp(now time.Time) {
+	var missed []*scheduledJob
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		st := s.state[j.key()]
+		if st.LastRun.IsZero() {
+			st.LastRun = now
+		} else if due := j.spec.next(st.LastRun.In(j.loc)); !st.Paused && !due.IsZero() && !due.After(now) {
+			log.Printf("Schedule %s missed its run at %s, running now", j.key(), due.Format("2006-01-02 15:04"))
+			missed = append(missed, j)
+		}
+		j.next = j.spec.next(now.In(j.loc))
+	}
+	s.save()
+	s.mu.Unlock()
+
+	for _, j := range missed {
+		if err := s.fire(j, now, true); err != nil {
+			log.Printf("Schedule %s: %v", j.key(), err)
+		}
+	}
+}
+
+// tick fires the jobs that are due at now.
+func (s *scheduler) tick(now time.Time) {
+	var due []*scheduledJob
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		if j.next.IsZero() || now.Before(j.next) {
+			continue
+		}
+		j.next = j.spec.next(now.In(j.loc))
+		if !s.state[j.key()].Paused {
+			due = append(due, j)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, j := range due {
+		if err := s.fire(j, now, true); err != nil {
+			log.Printf("Schedule %s: run skipped: %v", j.key(), err)
+		}
+	}
+}
+
+// fire starts a run of j unless one is still in progress. Scheduled runs
+// are recorded as the job's last run.
+func (s *scheduler) fire(j *scheduledJob, now time.Time, scheduled bool) error {
+	key := j.key()
+	s.mu.Lock()
+	if s.running[key] {
+		s.mu.Unlock()
+		return errScheduleRunning
+	}
+	s.running[key] = true
+	if scheduled {
+		s.state[key].LastRun = now
+		s.save()
+	}
+	s.mu.Unlock()
+
+	log.Printf("Schedule %s: starting (%s)", key, j.describe())
+	var once sync.Once
+	s.run(j, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.running, key)
+			s.mu.Unlock()
+		})
+	})
+	return nil
+}
+
+// command handles "/schedules [pause|resume|run name]" for a user.
+func (s *scheduler) command(userName string, args []string, now time.Time) string {
+	if len(args) == 0 {
+		return s.list(userName)
+	}
+	if len(args) != 2 {
+		return "Использование: /schedules [pause|resume|run имя]"
+	}
+	j := s.job(userName + "/" + args[1])
+	if j == nil {
+		return fmt.Sprintf("Расписание %q не найдено.", args[1])
+	}
+	switch args[0] {
+	case "pause", "resume":
+		s.mu.Lock()
+		st := s.state[j.key()]
+		paused := args[0] == "pause"
+		st.Paused = paused
+		if !paused {
+			st.LastRun = now // runs missed while paused are not caught up
+		}
+		s.save()
+		s.mu.Unlock()
+		if paused {
+			return fmt.Sprintf("⏸ %s на паузе.", j.Name)
+		}
+		return fmt.Sprintf("▶️ %s снова по расписанию.", j.Name)
+	case "run":
+		if err := s.fire(j, now, false); err != nil {
+			return fmt.Sprintf("%s: %v.", j.Name, err)
+		}
+		return fmt.Sprintf("⏰ %s запущено.", j.Name)
+	}
+	return "Использование: /schedules [pause|resume|run имя]"
+}
+
+// list describes the user's jobs.
+func (s *scheduler) list(userName string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sb strings.Builder
+	for _, j := range s.jobs {
+		if j.userName != userName {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n• %s — %s, %s", j.Name, j.describe(), j.Cron)
+		switch {
+		case s.running[j.key()]:
+			sb.WriteString(", ▶ выполняется")
+		case s.state[j.key()].Paused:
+			sb.WriteString(", ⏸ на паузе")
+		case !j.next.IsZero():
+			fmt.Fprintf(&sb, ", следующий запуск %s", j.next.Format("02.01 15:04"))
+		}
+	}
+	if sb.Len() == 0 {
+		return "Расписаний нет. Их задают в users.json (schedules)."
+	}
+	return "⏰ Расписания:" + sb.String() + "\n\n/schedules pause|resume|run имя"
+}
+
+// runScheduledJob runs a job for the bot and sends the result to chatID.
+// Like a query it can be cancelled with /cancel; its tokens go to the
+// user's usage ledger.
+func runScheduledJob(token string, cfg modelConfig, modelID string,
+	showThinking bool, logf func(string, ...any), promptsTemplate *Prompts, defaultLang string,
+	verboseTools bool, newsConfigPath string, mcpMgr *MCPManager, globalThink thinkMode, j *scheduledJob, chatID int64) {
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	defer trackQuery(chatID, 0, j.user.TelegramID, stop)()
+
+	usageMode := usageModeQuery
+	ctx, meter := withUsageMeter(ctx)
+	defer func() {
+		if err := saveQueryUsage(j.userName, usageMode, meter); err != nil {
+			log.Printf("Usage ledger error: %v", err)
+		}
+	}()
+
+	u := j.user
+	sess := userSession(u, j.userName)
+	sess.EnabledTools, sess.ReadOnly = j.enabledTools, j.readOnly
+	if j.readOnly {
+		sess.ReadTools = mcpMgr.ReadOnlyTools()
+	}
+	lang := defaultLang
+	if u.Language != "" {
+		lang = u.Language
+	}
+	prompts := *promptsTemplate // copy template
+	applyLanguage(&prompts, lang)
+	if u.Memory != "" {
+		prompts.SystemPrompt += MemoryPromptHint
+	}
+	if sess.UserInfoAvailable() {
+		prompts.SystemPrompt += UserInfoPromptHint
+	}
+	var mcpOverrides map[string]bool
+	if len(u.MCP) > 0 {
+		mcpOverrides = u.MCP
+	}
+
+	var result string
+	var mcpNames []string
+	var err error
+	switch j.Type {
+	case scheduleNews:
+		usageMode = usageModeNews
+		result, err = runNewsSummary(ctx, cfg, modelID, showThinking, io.Discard, logf, newsConfigPath, &prompts, mcpMgr, nil, globalThink, mcpOverrides)
+	case scheduleMail:
+		usageMode = usageModeMail
+		result, err = runMailSummary(ctx, sess, cfg, modelID, showThinking, io.Discard, logf, &prompts, j.mailHours(), mcpMgr, nil, globalThink, mcpOverrides)
+	default:
+		mcpNames = j.MCP
+		if len(j.Skills) > 0 {
+			skillText, skillMCP, skillErr := loadSkills(skillSearchDirs(), j.Skills)
+			if skillErr != nil {
+				log.Printf("Schedule %s: skills error: %v", j.key(), skillErr)
+			} else {
+				prompts.SystemPrompt += skillText
+				mcpNames = dedup(mcpNames, skillMCP)
+			}
+		}
+		if len(mcpNames) > 0 {
+			if mcpMgr == nil {
+				err = errors.New("MCP not configured (mcp.json not found)")
+			} else {
+				err = mcpMgr.InitServers(mcpNames)
+			}
+		}
+		if err == nil {
+			activeModules := append(append([]string{}, j.Skills...), mcpNames...)
+			result, _, err = runQuery(ctx, sess, cfg, modelID, j.Prompt, showThinking, verboseTools, io.Discard, logf, &prompts, mcpMgr, mcpNames, globalThink, nil, nil, nil, mcpOverrides, activeModules)
+		}
+	}
+	if ctx.Err() != nil {
+		_ = sendToChat(token, chatID, fmt.Sprintf("⏰ %s: отменено.", j.Name))
+		return
+	}
+	if err != nil {
+		log.Printf("Schedule %s: %v", j.key(), err)
+		_ = sendToChat(token, chatID, fmt.Sprintf("⏰ %s: ошибка: %v", j.Name, err))
+		return
+	}
+
+	reply := stripThinkTags(result)
+	switch strings.TrimSpace(reply) {
+	case "":
+		log.Printf("Schedule %s: empty result", j.key())
+		return
+	case silentReply:
+		log.Printf("Schedule %s: nothing to send", j.key())
+		return
+	}
+	// Replies to the result continue the conversation
+	sentMsgID, err := sendBotReply(token, chatID, reply, 0)
+	if err != nil {
+		log.Printf("Error sending schedule %s to chat %d: %v", j.key(), chatID, err)
+	} else if sentMsgID != 0 {
+		storeMessage(chatID, sentMsgID, &storedMessage{Role: "assistant", Content: reply, SkillNames: j.Skills, MCPNames: mcpNames})
+	}
+}
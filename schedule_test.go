@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	users := map[string]*UserConfig{
+		"alice": {Schedules: []UserSchedule{
+			{Name: "news", Cron: "0 8 * * *", Type: scheduleNews},
+			{Name: "mail", Cron: "0 18 * * *", Type: scheduleMail, Hours: 12},
+			{Name: "bad", Cron: "0 8 * *", Type: scheduleNews},
+			{Name: "empty", Cron: "0 8 * * *", Type: schedulePrompt},
+		}},
+		"bob": {Schedules: []UserSchedule{{Name: "digest", Cron: "0 * * * *", Type: schedulePrompt, Prompt: "Что нового?", Timezone: "UTC"}}},
+	}
+	// alice/news last ran two days ago, so a run was missed
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+	state := `{"alice/news":{"last_run":"` + now.AddDate(0, 0, -2).Format(time.RFC3339) + `"},"removed/job":{"paused":true}}`
+	if err := os.WriteFile(path, []byte(state), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var started []string
+	dones := map[string]func(){}
+	s, err := newScheduler(users, path, func(j *scheduledJob, done func()) {
+		started = append(started, j.key())
+		dones[j.key()] = done
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.jobs) != 3 || s.jobs[0].key() != "alice/mail" || s.state["removed/job"] != nil {
+		t.Fatalf("jobs = %d, state = %v", len(s.jobs), s.state)
+	}
+
+	s.catchUp(now)
+	if strings.Join(started, ",") != "alice/news" {
+		t.Fatalf("caught up: %v", started)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"bob/digest"`) {
+		t.Errorf("state file: %s", data)
+	}
+
+	// alice/news is still running at its next time, so that run is skipped
+	started = nil
+	s.tick(time.Date(2026, 10, 17, 8, 0, 30, 0, time.Local))
+	if got := strings.Join(started, ","); got != "alice/mail,bob/digest" {
+		t.Errorf("due runs: %v", got)
+	}
+	if got := s.command("alice", []string{"run", "news"}, now); !strings.Contains(got, "не завершён") {
+		t.Errorf("run while running: %q", got)
+	}
+	dones["alice/news"]()
+	dones["alice/news"]() // done is idempotent
+
+	started = nil
+	if got := s.command("alice", []string{"run", "news"}, now); !strings.Contains(got, "запущено") || len(started) != 1 {
+		t.Errorf("manual run: %q, started %v", got, started)
+	}
+	dones["alice/mail"]()
+	s.command("alice", []string{"pause", "mail"}, now)
+	list := s.command("alice", nil, now)
+	if !strings.Contains(list, "mail — почта за 12 ч, 0 18 * * *, ⏸ на паузе") ||
+		!strings.Contains(list, "news — новости, 0 8 * * *, ▶ выполняется") || strings.Contains(list, "digest") {
+		t.Errorf("list:\n%s", list)
+	}
+	if got := s.command("alice", []string{"pause", "digest"}, now); !strings.Contains(got, "не найдено") {
+		t.Errorf("other user's job: %q", got)
+	}
+	if got := s.command("carol", nil, now); !strings.HasPrefix(got, "Расписаний нет") {
+		t.Errorf("no schedules: %q", got)
+	}
+
+	// Paused jobs are not run
+	started = nil
+	s.tick(time.Date(2026, 10, 17, 18, 0, 10, 0, time.Local))
+	for _, k := range started {
+		if k == "alice/mail" {
+			t.Error("paused job ran")
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"ai-webfetch/tools"
@@ -88,16 +89,7 @@ func findSkill(dirs []string, name string) ([]byte, error) {
 // extracts "mcp:" values (comma-separated server names).
 // Returns the body (without frontmatter) and any MCP server names found.
 func parseSkillFrontmatter(data []byte) ([]byte, []string) {
-	s := string(data)
-	if !strings.HasPrefix(s, "---\n") {
-		return data, nil
-	}
-	end := strings.Index(s[4:], "\n---\n")
-	if end < 0 {
-		return data, nil
-	}
-	frontmatter := s[4 : 4+end]
-	body := []byte(s[4+end+5:])
+	frontmatter, body := splitSkillFrontmatter(data)
 
 	var mcpNames []string
 	for _, line := range strings.Split(frontmatter, "\n") {
@@ -115,6 +107,74 @@ func parseSkillFrontmatter(data []byte) ([]byte, []string) {
 	return body, mcpNames
 }
 
+// splitSkillFrontmatter separates the "---"-delimited frontmatter from the
+// skill body; without frontmatter it returns "" and data.
+func splitSkillFrontmatter(data []byte) (string, []byte) {
+	s := string(data)
+	if !strings.HasPrefix(s, "---\n") {
+		return "", data
+	}
+	end := strings.Index(s[4:], "\n---\n")
+	if end < 0 {
+		return "", data
+	}
+	return s[4 : 4+end], []byte(s[4+end+5:])
+}
+
+// skillDescription returns the one-line "description:" of the skill's frontmatter.
+func skillDescription(data []byte) string {
+	frontmatter, _ := splitSkillFrontmatter(data)
+	for _, line := range strings.Split(frontmatter, "\n") {
+		if val, ok := strings.CutPrefix(strings.TrimSpace(line), "description:"); ok {
+			val = strings.Trim(strings.TrimSpace(val), `"'`)
+			if val == ">" || val == "|" { // multi-line YAML values are not supported
+				return ""
+			}
+			return val
+		}
+	}
+	return ""
+}
+
+// skillInfo is a skill found in the skill directories.
+type skillInfo struct {
+	Name        string
+	Description string
+}
+
+// listSkills returns the skills in dirs, in both layouts of findSkill, sorted
+// by name. A name found in several directories is listed once, as findSkill
+// resolves it.
+func listSkills(dirs []string) []skillInfo {
+	seen := map[string]bool{}
+	var skills []skillInfo
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutSuffix(e.Name(), ".md")
+			path := filepath.Join(dir, e.Name())
+			if e.IsDir() {
+				name, ok = e.Name(), true
+				path = filepath.Join(path, "SKILL.md")
+			}
+			if !ok || seen[name] {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			seen[name] = true
+			skills = append(skills, skillInfo{Name: name, Description: skillDescription(data)})
+		}
+	}
+	sort.Slice(skills, func(i, j int) bool { return skills[i].Name < skills[j].Name })
+	return skills
+}
+
 // loadSkills reads skill markdown files and returns concatenated text
 // plus any MCP server names from skill frontmatter.
 func loadSkills(dirs []string, names []string) (string, []string, error) {
@@ -154,6 +214,8 @@ func parseCommandName(s string) (string, string) {
 var reservedCommands = map[string]bool{
 	"think": true, "nothink": true, "mcp": true, "skills": true,
 	"news": true, "mail": true, "start": true, "help": true,
+	"model": true, "cancel": true, "usage": true, "jobs": true,
+	"schedules": true,
 }
 
 // parseSkillShortcut checks if query starts with "/name" where name
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Speech
+//
+// Voice notes and audio files are transcribed by an OpenAI-compatible
+// /audio/transcriptions endpoint (e.g. a local whisper.cpp server) and
+// handled as if the text was typed. Users with voice_replies in users.json
+// also get answers spoken by an /audio/speech endpoint.
+
+// speechConfig is the "speech" part of the bot section in telegram.json.
+type speechConfig struct {
+	STT *speechEndpoint `json:"stt,omitempty"` // speech to text
+	TTS *speechEndpoint `json:"tts,omitempty"` // text to speech, for voice_replies
+}
+
+type speechEndpoint struct {
+	BaseURL  string `json:"base_url"` // API root, e.g. "http://localhost:8080/v1"
+	APIKey   string `json:"api_key,omitempty"`
+	Model    string `json:"model,omitempty"`    // default "whisper-1" / "tts-1"
+	Language string `json:"language,omitempty"` // STT: ISO-639-1 hint, detected if unset
+	Voice    string `json:"voice,omitempty"`    // TTS: default "alloy"
+}
+
+// maxSpokenText caps the text sent to TTS (OpenAI's limit is 4096 characters).
+const maxSpokenText = 4000
+
+// botSpeech is the bot's speech configuration; runBot sets it.
+var botSpeech *speechConfig
+
+func (c *speechConfig) stt() *speechEndpoint {
+	if c == nil {
+		return nil
+	}
+	return c.STT
+}
+
+func (c *speechConfig) tts() *speechEndpoint {
+	if c == nil {
+		return nil
+	}
+	return c.TTS
+}
+
+func (e *speechEndpoint) url(path string) string {
+	return strings.TrimRight(e.BaseURL, "/") + path
+}
+
+func (e *speechEndpoint) model(def string) string {
+	if e.Model != "" {
+		return e.Model
+	}
+	return def
+}
+
+func (e *speechEndpoint) do(req *http.Request) ([]byte, error) {
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, truncate(strings.TrimSpace(string(body)), 200))
+	}
+	return body, nil
+}
+
+// transcribe converts audio to text.
+func (e *speechEndpoint) transcribe(ctx context.Context, fileName string, audio []byte) (string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	_ = w.WriteField("model", e.model("whisper-1"))
+	_ = w.WriteField("response_format", "json")
+	if e.Language != "" {
+		_ = w.WriteField("language", e.Language)
+	}
+	part, err := w.CreateFormFile("file", fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(audio); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url("/audio/transcriptions"), &buf)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	body, err := e.do(req)
+	if err != nil {
+		return "", fmt.Errorf("transcription: %w", err)
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("transcription decode: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// synthesize speaks text as OGG/Opus, the format of Telegram voice messages.
+func (e *speechEndpoint) synthesize(ctx context.Context, text string) ([]byte, error) {
+	voice := e.Voice
+	if voice == "" {
+		voice = "alloy"
+	}
+	payload, err := json.Marshal(map[string]string{
+		"model":           e.model("tts-1"),
+		"input":           text,
+		"voice":           voice,
+		"response_format": "opus",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url("/audio/speech"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	audio, err := e.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("speech synthesis: %w", err)
+	}
+	return audio, nil
+}
+
+var (
+	reSpeechCode  = regexp.MustCompile("(?s)```.*?```")
+	reSpeechLink  = regexp.MustCompile(`\[([^\]]+)\]\([^)]+\)`)
+	reSpeechURL   = regexp.MustCompile(`https?://\S+`)
+	reSpeechMarks = regexp.MustCompile("[*_`#>|~]+")
+	reSpeechSpace = regexp.MustCompile(`[ \t]+`)
+	reSpeechParas = regexp.MustCompile(`\s*\n\s*\n\s*`)
+)
+
+// speakableText turns a markdown answer into text worth reading aloud: code
+// blocks, URLs and formatting marks are dropped, link texts kept.
+func speakableText(md string) string {
+	s := stripThinkTags(md)
+	s = reSpeechCode.ReplaceAllString(s, "")
+	s = reSpeechLink.ReplaceAllString(s, "$1")
+	s = reSpeechURL.ReplaceAllString(s, "")
+	s = reSpeechMarks.ReplaceAllString(s, "")
+	s = reSpeechSpace.ReplaceAllString(s, " ")
+	s = reSpeechParas.ReplaceAllString(s, "\n\n")
+	s = strings.TrimSpace(s)
+	if r := []rune(s); len(r) > maxSpokenText {
+		s = string(r[:maxSpokenText])
+		// End at the last full sentence
+		if i := strings.LastIndexAny(s, ".!?\n"); i > len(s)/2 {
+			s = s[:i+1]
+		}
+	}
+	return s
+}
+
+// sendVoiceReply speaks an answer as a voice message, replying to message
+// replyTo (best-effort: failures are logged).
+func sendVoiceReply(ctx context.Context, token string, chatID int64, answer string, replyTo int64) {
+	tts := botSpeech.tts()
+	text := speakableText(answer)
+	if tts == nil || text == "" {
+		return
+	}
+	audio, err := tts.synthesize(ctx, text)
+	if err != nil {
+		log.Printf("Voice reply for chat %d: %v", chatID, err)
+		return
+	}
+	if _, err := sendVoice(token, chatID, audio, replyTo); err != nil {
+		log.Printf("Error sending voice reply to chat %d: %v", chatID, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpeechEndpoint_Transcribe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "bad request "+r.URL.Path, http.StatusBadRequest)
+			return
+		}
+		f, hdr, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		audio, _ := io.ReadAll(f)
+		if hdr.Filename != "voice.ogg" || string(audio) != "OggS" ||
+			r.FormValue("model") != "whisper-1" || r.FormValue("language") != "ru" {
+			http.Error(w, "unexpected form", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"text":" Какая погода завтра? "}`))
+	}))
+	defer srv.Close()
+
+	ep := &speechEndpoint{BaseURL: srv.URL + "/v1/", APIKey: "key", Language: "ru"}
+	text, err := ep.transcribe(context.Background(), "voice.ogg", []byte("OggS"))
+	if err != nil || text != "Какая погода завтра?" {
+		t.Errorf("transcribe = %q, %v", text, err)
+	}
+
+	ep.APIKey = ""
+	if _, err := ep.transcribe(context.Background(), "voice.ogg", []byte("OggS")); err == nil || !strings.Contains(err.Error(), "HTTP 400") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestSpeechEndpoint_Synthesize(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/audio/speech" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte("OggS-audio"))
+	}))
+	defer srv.Close()
+
+	ep := &speechEndpoint{BaseURL: srv.URL, Model: "piper", Voice: "irina"}
+	audio, err := ep.synthesize(context.Background(), "Привет")
+	if err != nil || string(audio) != "OggS-audio" {
+		t.Fatalf("synthesize = %q, %v", audio, err)
+	}
+	if got["model"] != "piper" || got["voice"] != "irina" || got["input"] != "Привет" || got["response_format"] != "opus" {
+		t.Errorf("request = %v", got)
+	}
+}
+
+func TestSpeakableText(t *testing.T) {
+	md := "<think>plan</think>## Итог\n\n**Завтра** будет _солнечно_, см. [прогноз](https://example.com/f) или https://example.com.\n\n```\ncode\n```\nВсё."
+	want := "Итог\n\nЗавтра будет солнечно, см. прогноз или\n\nВсё."
+	if got := speakableText(md); got != want {
+		t.Errorf("speakableText = %q, want %q", got, want)
+	}
+
+	long := strings.Repeat("Предложение. ", maxSpokenText/10)
+	got := speakableText(long)
+	if n := len([]rune(got)); n > maxSpokenText || !strings.HasSuffix(got, ".") {
+		t.Errorf("long text: %d runes, ends %q", n, got[len(got)-5:])
+	}
+}
@@ -18,9 +18,25 @@ import (
 const telegramMaxLen = 4096
 
 type botConfig struct {
-	WebhookURL         string `json:"webhook_url"`
-	Listen             string `json:"listen"`
-	AllowUnregistered  bool   `json:"allow_unregistered_users"`
+	// Mode selects how updates arrive: "webhook" (default, needs a public
+	// HTTPS endpoint) or "polling" (getUpdates, works behind NAT).
+	Mode              string `json:"mode,omitempty"`
+	WebhookURL        string `json:"webhook_url"`
+	Listen            string `json:"listen"`
+	SecretToken       string `json:"secret_token,omitempty"`     // required on webhook requests (random per start if unset)
+	PollTimeoutSec    int    `json:"poll_timeout_sec,omitempty"` // long-poll wait per getUpdates call (default 50)
+	Workers           int    `json:"workers,omitempty"`          // queries answered at once, across all chats (default 2)
+	AllowUnregistered bool   `json:"allow_unregistered_users"`
+	CommandsPerUser   bool   `json:"commands_per_user,omitempty"` // command menu per registered user's private chat
+	AskTimeoutSec     int    `json:"ask_timeout_sec,omitempty"`   // ask_user waits this long for an answer (default 600)
+	// DefaultQuota applies to users without their own quota, including unregistered ones.
+	DefaultQuota *UserQuota `json:"default_quota,omitempty"`
+	// History configures the conversation store used for reply threading.
+	History *historyConfig `json:"history,omitempty"`
+	// Groups enables group chats, keyed by chat ID; the bot ignores other groups.
+	Groups map[string]*groupConfig `json:"groups,omitempty"`
+	// Speech configures transcription of voice messages and spoken replies.
+	Speech *speechConfig `json:"speech,omitempty"`
 }
 
 type telegramConfig struct {
@@ -156,6 +172,47 @@ func sendPhotoDataURI(token string, chatID int64, dataURI, caption string, reply
 	return result.Result.MessageID, nil
 }
 
+// sendVoice sends OGG/Opus audio as a voice message and returns its message_id.
+func sendVoice(token string, chatID int64, audio []byte, replyToMsgID int64) (int64, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	_ = writer.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	if replyToMsgID != 0 {
+		_ = writer.WriteField("reply_to_message_id", strconv.FormatInt(replyToMsgID, 10))
+	}
+	part, err := writer.CreateFormFile("voice", "reply.ogg")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := part.Write(audio); err != nil {
+		return 0, err
+	}
+	_ = writer.Close()
+
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendVoice", token)
+	resp, err := http.Post(apiURL, writer.FormDataContentType(), &buf)
+	if err != nil {
+		return 0, fmt.Errorf("sendVoice request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Result      struct {
+			MessageID int64 `json:"message_id"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, fmt.Errorf("sendVoice decode: %w", err)
+	}
+	if !result.OK {
+		return 0, fmt.Errorf("sendVoice: %s", result.Description)
+	}
+	return result.Result.MessageID, nil
+}
+
 // sendToChat sends text to a single chat with markdown→HTML conversion + splitting.
 // Falls back to plain text if HTML parsing fails.
 func sendToChat(token string, chatID int64, text string) error {
@@ -261,6 +318,164 @@ func sendMessageWithKeyboard(token string, chatID int64, text string, keyboard a
 	return result.Result.MessageID, nil
 }
 
+// sendPoll sends a non-anonymous poll, so that answers arrive as poll_answer
+// updates, and returns its message_id and poll ID.
+func sendPoll(token string, chatID int64, question string, options []string, multi bool, keyboard any) (int64, string, error) {
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendPoll", token)
+	type pollOption struct {
+		Text string `json:"text"`
+	}
+	opts := make([]pollOption, len(options))
+	for i, o := range options {
+		opts[i] = pollOption{Text: o}
+	}
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return 0, "", fmt.Errorf("marshal options: %w", err)
+	}
+	vals := url.Values{
+		"chat_id":                 {strconv.FormatInt(chatID, 10)},
+		"question":                {question},
+		"options":                 {string(optsJSON)},
+		"is_anonymous":            {"false"},
+		"allows_multiple_answers": {strconv.FormatBool(multi)},
+	}
+	if keyboard != nil {
+		replyMarkup, err := json.Marshal(keyboard)
+		if err != nil {
+			return 0, "", fmt.Errorf("marshal keyboard: %w", err)
+		}
+		vals.Set("reply_markup", string(replyMarkup))
+	}
+	resp, err := http.PostForm(apiURL, vals)
+	if err != nil {
+		return 0, "", fmt.Errorf("sendPoll: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Result      struct {
+			MessageID int64 `json:"message_id"`
+			Poll      struct {
+				ID string `json:"id"`
+			} `json:"poll"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, "", fmt.Errorf("sendPoll decode: %w", err)
+	}
+	if !result.OK {
+		return 0, "", fmt.Errorf("sendPoll: %s", result.Description)
+	}
+	return result.Result.MessageID, result.Result.Poll.ID, nil
+}
+
+// stopPoll closes a poll sent by the bot and removes its inline keyboard.
+func stopPoll(token string, chatID, messageID int64) error {
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/stopPoll", token)
+	vals := url.Values{
+		"chat_id":      {strconv.FormatInt(chatID, 10)},
+		"message_id":   {strconv.FormatInt(messageID, 10)},
+		"reply_markup": {`{"inline_keyboard":[]}`},
+	}
+	resp, err := http.PostForm(apiURL, vals)
+	if err != nil {
+		return fmt.Errorf("stopPoll: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("stopPoll decode: %w", err)
+	}
+	if !result.OK {
+		return fmt.Errorf("stopPoll: %s", result.Description)
+	}
+	return nil
+}
+
+// editMessageText replaces the text of a message sent by the bot; a nil
+// keyboard removes its inline keyboard.
+func editMessageText(token string, chatID, messageID int64, text, parseMode string, keyboard any) error {
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/editMessageText", token)
+	vals := url.Values{
+		"chat_id":    {strconv.FormatInt(chatID, 10)},
+		"message_id": {strconv.FormatInt(messageID, 10)},
+		"text":       {text},
+	}
+	if parseMode != "" {
+		vals.Set("parse_mode", parseMode)
+	}
+	if keyboard != nil {
+		replyMarkup, err := json.Marshal(keyboard)
+		if err != nil {
+			return fmt.Errorf("marshal keyboard: %w", err)
+		}
+		vals.Set("reply_markup", string(replyMarkup))
+	}
+	resp, err := http.PostForm(apiURL, vals)
+	if err != nil {
+		return fmt.Errorf("editMessageText: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("editMessageText decode: %w", err)
+	}
+	if !result.OK {
+		return fmt.Errorf("editMessageText: %s", result.Description)
+	}
+	return nil
+}
+
+// setMyCommands sets the bot's command menu; scope nil means the default
+// scope (all chats), otherwise a BotCommandScope object.
+func setMyCommands(token string, commands []botCommand, scope any) error {
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/setMyCommands", token)
+	cmds, err := json.Marshal(commands)
+	if err != nil {
+		return fmt.Errorf("marshal commands: %w", err)
+	}
+	vals := url.Values{"commands": {string(cmds)}}
+	if scope != nil {
+		s, err := json.Marshal(scope)
+		if err != nil {
+			return fmt.Errorf("marshal scope: %w", err)
+		}
+		vals.Set("scope", string(s))
+	}
+	resp, err := http.PostForm(apiURL, vals)
+	if err != nil {
+		return fmt.Errorf("setMyCommands: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("setMyCommands decode: %w", err)
+	}
+	if !result.OK {
+		return fmt.Errorf("setMyCommands: %s", result.Description)
+	}
+	return nil
+}
+
 // answerCallbackQuery acknowledges a callback query to remove the loading indicator.
 func answerCallbackQuery(token, callbackQueryID string) error {
 	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/answerCallbackQuery", token)
@@ -275,6 +490,45 @@ func answerCallbackQuery(token, callbackQueryID string) error {
 	return nil
 }
 
+// getMe returns the bot's own user (ID and username).
+func getMe(token string) (*TGUser, error) {
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/getMe", token)
+	resp, err := http.Get(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("getMe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Result      TGUser `json:"result"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("getMe decode: %w", err)
+	}
+	if !result.OK {
+		return nil, fmt.Errorf("getMe: %s", result.Description)
+	}
+	return &result.Result, nil
+}
+
+// deleteMessage removes a message sent by the bot (best-effort).
+func deleteMessage(token string, chatID, messageID int64) error {
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/deleteMessage", token)
+	vals := url.Values{
+		"chat_id":    {strconv.FormatInt(chatID, 10)},
+		"message_id": {strconv.FormatInt(messageID, 10)},
+	}
+	resp, err := http.PostForm(apiURL, vals)
+	if err != nil {
+		return fmt.Errorf("deleteMessage: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
 // sendTypingAction sends a "typing" indicator to a chat.
 func sendTypingAction(token string, chatID int64) error {
 	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendChatAction", token)
@@ -1,10 +1,11 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
-	"sync"
 )
 
 // UserOption is a single choice presented to the user.
@@ -18,37 +19,38 @@ type UserQuestion struct {
 	Question    string       `json:"question"`
 	Options     []UserOption `json:"options,omitempty"`
 	MultiSelect bool         `json:"multi_select,omitempty"`
+	// FreeText offers typing an own answer besides the options.
+	FreeText bool `json:"free_text,omitempty"`
 }
 
-// UserPrompter asks the user a question and returns their answer.
+// UserPrompter asks the user a question and returns their answer: the
+// label of the chosen option, the typed text, or for MultiSelect questions
+// the chosen labels as formatted by FormatSelection.
+// Ask should give up with ctx.Err() if ctx is cancelled while waiting.
 type UserPrompter interface {
-	Ask(q UserQuestion) (string, error)
+	Ask(ctx context.Context, q UserQuestion) (string, error)
 }
 
-// Goroutine-local prompter storage.
-var askOverrides sync.Map // goroutineID -> UserPrompter
-
-// SetPrompter stores a UserPrompter for the calling goroutine.
-func SetPrompter(p UserPrompter) { askOverrides.Store(goroutineID(), p) }
-
-// ClearPrompter removes the UserPrompter for the calling goroutine.
-func ClearPrompter() { askOverrides.Delete(goroutineID()) }
-
-// GetPrompter returns the UserPrompter for the calling goroutine, or nil.
-func GetPrompter() UserPrompter {
-	v, ok := askOverrides.Load(goroutineID())
-	if !ok {
-		return nil
+// FormatSelection is the answer to a MultiSelect question: a JSON array of
+// the chosen labels.
+func FormatSelection(labels []string) string {
+	if labels == nil {
+		labels = []string{}
 	}
-	return v.(UserPrompter)
+	data, _ := json.Marshal(labels)
+	return string(data)
 }
 
-// AskAvailable returns true if a UserPrompter is set for the current goroutine.
-func AskAvailable() bool {
-	_, ok := askOverrides.Load(goroutineID())
-	return ok
+// AskAvailable returns true if the session has a UserPrompter.
+func (s *Session) AskAvailable() bool {
+	return s != nil && s.Prompter != nil
 }
 
+// noAnswerResult is the tool result when the user lets the question time out.
+const noAnswerResult = "No answer: the user did not respond within %s. " +
+	"Do not ask again. Continue with a reasonable default and say which one you chose, " +
+	"or stop and explain what you need from the user."
+
 // ask_user tool arguments (parsed from raw JSON).
 type askUserArgs struct {
 	Question    string `json:"question"`
@@ -67,7 +69,9 @@ func init() {
 					"Use this when you need clarification, a choice between options, " +
 					"or confirmation before proceeding. You can provide predefined options " +
 					"or ask an open-ended question (omit options). " +
-					"The user can always provide a custom free-text answer.",
+					"The user can always provide a custom free-text answer. " +
+					"With multi_select the answer is a JSON array of the chosen labels. " +
+					"If the user does not answer in time, the result says so.",
 				Parameters: map[string]any{
 					"type": "object",
 					"properties": map[string]any{
@@ -106,8 +110,8 @@ func init() {
 	})
 }
 
-func execAskUser(args json.RawMessage) (string, error) {
-	p := GetPrompter()
+func execAskUser(ctx context.Context, sess *Session, args json.RawMessage) (string, error) {
+	p := sess.Prompter
 	if p == nil {
 		return "", fmt.Errorf("ask_user is not available in this mode")
 	}
@@ -127,12 +131,22 @@ func execAskUser(args json.RawMessage) (string, error) {
 		}
 	}
 
-	answer, err := p.Ask(UserQuestion{
+	askCtx := ctx
+	if sess.AskTimeout > 0 {
+		var cancel context.CancelFunc
+		askCtx, cancel = context.WithTimeout(ctx, sess.AskTimeout)
+		defer cancel()
+	}
+	answer, err := p.Ask(askCtx, UserQuestion{
 		Question:    a.Question,
 		Options:     options,
 		MultiSelect: a.MultiSelect,
+		FreeText:    true,
 	})
 	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Sprintf(noAnswerResult, sess.AskTimeout), nil
+		}
 		return "", fmt.Errorf("ask_user failed: %w", err)
 	}
 
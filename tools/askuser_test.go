@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// promptFunc adapts a function to UserPrompter.
+type promptFunc func(ctx context.Context, q UserQuestion) (string, error)
+
+func (f promptFunc) Ask(ctx context.Context, q UserQuestion) (string, error) { return f(ctx, q) }
+
+func TestExecAskUser(t *testing.T) {
+	sess := NewSession()
+	var asked UserQuestion
+	sess.Prompter = promptFunc(func(ctx context.Context, q UserQuestion) (string, error) {
+		asked = q
+		return FormatSelection([]string{"red", "blue"}), nil
+	})
+	got, err := execAskUser(context.Background(), sess,
+		[]byte(`{"question":"Colors?","options":[{"label":"red"},{"label":"blue"}],"multi_select":true}`))
+	if err != nil || got != `User answered: ["red","blue"]` {
+		t.Errorf("result = %q, %v", got, err)
+	}
+	if !asked.MultiSelect || !asked.FreeText || len(asked.Options) != 2 {
+		t.Errorf("question = %+v", asked)
+	}
+}
+
+func TestExecAskUser_Timeout(t *testing.T) {
+	sess := NewSession()
+	sess.AskTimeout = 10 * time.Millisecond
+	sess.Prompter = promptFunc(func(ctx context.Context, q UserQuestion) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	got, err := execAskUser(context.Background(), sess, []byte(`{"question":"Proceed?"}`))
+	if err != nil || !strings.HasPrefix(got, "No answer: the user did not respond within 10ms.") {
+		t.Errorf("result = %q, %v", got, err)
+	}
+
+	// Cancelling the request is an error, not a missing answer
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := execAskUser(ctx, sess, []byte(`{"question":"Proceed?"}`)); err == nil {
+		t.Error("cancelled question returned no error")
+	}
+}
+
+func TestFormatSelection(t *testing.T) {
+	if got := FormatSelection(nil); got != "[]" {
+		t.Errorf("empty selection = %s", got)
+	}
+	if got := FormatSelection([]string{`say "hi"`}); got != `["say \"hi\""]` {
+		t.Errorf("selection = %s", got)
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"net/http"
 	"sort"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/apognu/gocal"
@@ -32,39 +32,24 @@ type ICalURL struct {
 	URL  string
 }
 
-var calOverrides sync.Map // goroutineID → *CalendarConfig
-
-// SetCalendarOverride sets the calendar config for the current goroutine.
-func SetCalendarOverride(cfg *CalendarConfig) {
-	calOverrides.Store(goroutineID(), cfg)
-}
-
-// ClearCalendarOverride removes the calendar config for the current goroutine.
-func ClearCalendarOverride() {
-	calOverrides.Delete(goroutineID())
-}
+// uidSeq keeps UIDs of events and contacts created in the same nanosecond apart.
+var uidSeq atomic.Int64
 
 // CalendarAvailable returns true if calendar tools should be visible.
-func CalendarAvailable() bool {
-	_, ok := calOverrides.Load(goroutineID())
-	return ok
+func (s *Session) CalendarAvailable() bool {
+	return s != nil && s.Calendar != nil
 }
 
 // CalendarWritable returns true if calendar write tools should be visible.
-func CalendarWritable() bool {
-	v, ok := calOverrides.Load(goroutineID())
-	if !ok {
-		return false
-	}
-	cfg := v.(*CalendarConfig)
-	return cfg.Server != "" && cfg.Writable
+func (s *Session) CalendarWritable() bool {
+	return s.CalendarAvailable() && s.Calendar.Server != "" && s.Calendar.Writable
 }
 
-func getCalendarConfig() (*CalendarConfig, error) {
-	if v, ok := calOverrides.Load(goroutineID()); ok {
-		return v.(*CalendarConfig), nil
+func (s *Session) calendarConfig() (*CalendarConfig, error) {
+	if !s.CalendarAvailable() {
+		return nil, fmt.Errorf("no calendar config for this context")
 	}
-	return nil, fmt.Errorf("no calendar config for this context")
+	return s.Calendar, nil
 }
 
 func dialCalDAV(cfg *CalendarConfig) (*caldav.Client, error) {
@@ -72,12 +57,11 @@ func dialCalDAV(cfg *CalendarConfig) (*caldav.Client, error) {
 	return caldav.NewClient(httpClient, cfg.Server)
 }
 
-func findCalendars(cfg *CalendarConfig) ([]caldav.Calendar, error) {
+func findCalendars(ctx context.Context, cfg *CalendarConfig) ([]caldav.Calendar, error) {
 	client, err := dialCalDAV(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("connect to CalDAV: %w", err)
 	}
-	ctx := context.Background()
 	principal, err := client.FindCurrentUserPrincipal(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find principal: %w", err)
@@ -110,8 +94,12 @@ type calEvent struct {
 	ReadOnly     bool
 }
 
-func fetchICalEvents(ical ICalURL, start, end time.Time) ([]calEvent, error) {
-	resp, err := http.Get(ical.URL)
+func fetchICalEvents(ctx context.Context, ical ICalURL, start, end time.Time) ([]calEvent, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", ical.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", ical.Name, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch %s: %w", ical.Name, err)
 	}
@@ -278,8 +266,8 @@ func formatEventDetail(ev calEvent) string {
 
 // --- Tool executors ---
 
-func execCalList(rawArgs json.RawMessage) (string, error) {
-	cfg, err := getCalendarConfig()
+func execCalList(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
+	cfg, err := sess.calendarConfig()
 	if err != nil {
 		return "", err
 	}
@@ -288,7 +276,7 @@ func execCalList(rawArgs json.RawMessage) (string, error) {
 
 	// CalDAV calendars
 	if cfg.Server != "" {
-		calendars, err := findCalendars(cfg)
+		calendars, err := findCalendars(ctx, cfg)
 		if err != nil {
 			return "", err
 		}
@@ -314,7 +302,7 @@ func execCalList(rawArgs json.RawMessage) (string, error) {
 	return result, nil
 }
 
-func execCalEvents(rawArgs json.RawMessage) (string, error) {
+func execCalEvents(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Calendar  string `json:"calendar"`
 		StartDate string `json:"start_date"`
@@ -343,7 +331,7 @@ func execCalEvents(rawArgs json.RawMessage) (string, error) {
 		}
 	}
 
-	cfg, err := getCalendarConfig()
+	cfg, err := sess.calendarConfig()
 	if err != nil {
 		return "", err
 	}
@@ -353,7 +341,7 @@ func execCalEvents(rawArgs json.RawMessage) (string, error) {
 
 	// CalDAV events
 	if cfg.Server != "" {
-		calendars, err := findCalendars(cfg)
+		calendars, err := findCalendars(ctx, cfg)
 		if err != nil {
 			return "", err
 		}
@@ -361,7 +349,6 @@ func execCalEvents(rawArgs json.RawMessage) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		ctx := context.Background()
 		for _, cal := range calendars {
 			if args.Calendar != "" && cal.Path != args.Calendar && cal.Name != args.Calendar {
 				continue
@@ -394,7 +381,7 @@ func execCalEvents(rawArgs json.RawMessage) (string, error) {
 		if args.Calendar != "" && icalURL.Name != args.Calendar {
 			continue
 		}
-		events, err := fetchICalEvents(icalURL, start, end)
+		events, err := fetchICalEvents(ctx, icalURL, start, end)
 		if err != nil {
 			log.Printf("iCal fetch %s failed: %v", icalURL.Name, err)
 			queryErrors = append(queryErrors, fmt.Sprintf("%s: %v", icalURL.Name, err))
@@ -445,7 +432,7 @@ func execCalEvents(rawArgs json.RawMessage) (string, error) {
 	return strings.TrimSpace(sb.String()), nil
 }
 
-func execCalEvent(rawArgs json.RawMessage) (string, error) {
+func execCalEvent(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Path string `json:"path"`
 	}
@@ -454,7 +441,7 @@ func execCalEvent(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("path is required")
 	}
 
-	cfg, err := getCalendarConfig()
+	cfg, err := sess.calendarConfig()
 	if err != nil {
 		return "", err
 	}
@@ -467,7 +454,7 @@ func execCalEvent(rawArgs json.RawMessage) (string, error) {
 		return "", err
 	}
 
-	obj, err := client.GetCalendarObject(context.Background(), args.Path)
+	obj, err := client.GetCalendarObject(ctx, args.Path)
 	if err != nil {
 		return "", fmt.Errorf("get event: %w", err)
 	}
@@ -476,7 +463,7 @@ func execCalEvent(rawArgs json.RawMessage) (string, error) {
 	return formatEventDetail(ev), nil
 }
 
-func execCalCreateEvent(rawArgs json.RawMessage) (string, error) {
+func execCalCreateEvent(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Calendar    string `json:"calendar"`
 		Summary     string `json:"summary"`
@@ -490,7 +477,7 @@ func execCalCreateEvent(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("calendar, summary, start, and end are required")
 	}
 
-	cfg, err := getCalendarConfig()
+	cfg, err := sess.calendarConfig()
 	if err != nil {
 		return "", err
 	}
@@ -507,7 +494,7 @@ func execCalCreateEvent(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("invalid end: %w", err)
 	}
 
-	uid := fmt.Sprintf("%d-%d@ai-webfetch", time.Now().UnixNano(), goroutineID())
+	uid := fmt.Sprintf("%d-%d@ai-webfetch", time.Now().UnixNano(), uidSeq.Add(1))
 
 	event := ical.NewComponent(ical.CompEvent)
 	event.Props.SetText(ical.PropUID, uid)
@@ -540,7 +527,7 @@ func execCalCreateEvent(rawArgs json.RawMessage) (string, error) {
 	}
 
 	path := strings.TrimRight(args.Calendar, "/") + "/" + uid + ".ics"
-	obj, err := client.PutCalendarObject(context.Background(), path, icalCal)
+	obj, err := client.PutCalendarObject(ctx, path, icalCal)
 	if err != nil {
 		return "", fmt.Errorf("create event: %w", err)
 	}
@@ -548,7 +535,7 @@ func execCalCreateEvent(rawArgs json.RawMessage) (string, error) {
 	return fmt.Sprintf("Event created: %s\nPath: %s", args.Summary, obj.Path), nil
 }
 
-func execCalUpdateEvent(rawArgs json.RawMessage) (string, error) {
+func execCalUpdateEvent(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Path        string `json:"path"`
 		Summary     string `json:"summary"`
@@ -562,7 +549,7 @@ func execCalUpdateEvent(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("path is required")
 	}
 
-	cfg, err := getCalendarConfig()
+	cfg, err := sess.calendarConfig()
 	if err != nil {
 		return "", err
 	}
@@ -574,7 +561,6 @@ func execCalUpdateEvent(rawArgs json.RawMessage) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	ctx := context.Background()
 
 	obj, err := client.GetCalendarObject(ctx, args.Path)
 	if err != nil {
@@ -631,7 +617,7 @@ func execCalUpdateEvent(rawArgs json.RawMessage) (string, error) {
 	return fmt.Sprintf("Event updated: %s", updated.Path), nil
 }
 
-func execCalDeleteEvent(rawArgs json.RawMessage) (string, error) {
+func execCalDeleteEvent(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Path string `json:"path"`
 	}
@@ -640,7 +626,7 @@ func execCalDeleteEvent(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("path is required")
 	}
 
-	cfg, err := getCalendarConfig()
+	cfg, err := sess.calendarConfig()
 	if err != nil {
 		return "", err
 	}
@@ -653,7 +639,7 @@ func execCalDeleteEvent(rawArgs json.RawMessage) (string, error) {
 		return "", err
 	}
 
-	if err := client.RemoveAll(context.Background(), args.Path); err != nil {
+	if err := client.RemoveAll(ctx, args.Path); err != nil {
 		return "", fmt.Errorf("delete event: %w", err)
 	}
 
@@ -686,7 +672,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execCalList,
+		Execute:         execCalList,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -707,7 +694,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execCalEvents,
+		Execute:         execCalEvents,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -725,7 +713,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execCalEvent,
+		Execute:         execCalEvent,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -749,6 +738,7 @@ func init() {
 			},
 		},
 		Execute: execCalCreateEvent,
+		Writes:  true,
 	})
 
 	Register(&Tool{
@@ -772,6 +762,7 @@ func init() {
 			},
 		},
 		Execute: execCalUpdateEvent,
+		Writes:  true,
 	})
 
 	Register(&Tool{
@@ -790,5 +781,6 @@ func init() {
 			},
 		},
 		Execute: execCalDeleteEvent,
+		Writes:  true,
 	})
 }
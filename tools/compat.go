@@ -0,0 +1,195 @@
+package tools
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- Goroutine-keyed compatibility shim ---
+//
+// Before Session existed, per-user state was set for the calling goroutine
+// with the functions below. They now edit a Session kept per goroutine,
+// which code that has not been migrated yet passes to All and Tool.Execute
+// via CurrentSession. New code should build a Session with NewSession and
+// pass it explicitly; this file goes away once nothing calls it.
+
+var goroutineSessions sync.Map // goroutineID → *Session
+
+// goroutineID extracts the current goroutine ID from runtime.Stack().
+func goroutineID() int64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	// Stack starts with "goroutine <id> [..."
+	s := string(buf[:n])
+	s = s[len("goroutine "):]
+	s = s[:strings.IndexByte(s, ' ')]
+	id, _ := strconv.ParseInt(s, 10, 64)
+	return id
+}
+
+// CurrentSession returns the session of the calling goroutine, creating an
+// empty one on first use.
+//
+// Deprecated: pass a Session explicitly.
+func CurrentSession() *Session {
+	if v, ok := goroutineSessions.Load(goroutineID()); ok {
+		return v.(*Session)
+	}
+	v, _ := goroutineSessions.LoadOrStore(goroutineID(), NewSession())
+	return v.(*Session)
+}
+
+// clearSession applies a Clear* change and forgets the goroutine's session
+// once nothing is configured in it any more.
+func clearSession(fn func(s *Session)) {
+	id := goroutineID()
+	v, ok := goroutineSessions.Load(id)
+	if !ok {
+		return
+	}
+	s := v.(*Session)
+	fn(s)
+	s.store.mu.Lock()
+	empty := len(s.store.images) == 0 && len(s.store.temp) == 0 && s.store.video == nil
+	s.store.mu.Unlock()
+	if empty && s.Imap == nil && !s.HA && s.Calendar == nil && s.Contacts == nil &&
+		s.MemoryDir == "" && s.UserInfo == "" && s.Prompter == nil && s.ImageSender == nil {
+		goroutineSessions.Delete(id)
+	}
+}
+
+// Deprecated: set Session.Imap.
+func SetImapOverride(cfg *ImapUserConfig) { CurrentSession().Imap = cfg }
+
+// Deprecated: set Session.Imap.
+func ClearImapOverride() { clearSession(func(s *Session) { s.Imap = nil }) }
+
+// Deprecated: use Session.ImapAvailable.
+func ImapAvailable() bool { return CurrentSession().ImapAvailable() }
+
+// Deprecated: set Session.HA.
+func SetHAEnabled(enabled bool) { CurrentSession().HA = enabled }
+
+// Deprecated: set Session.HA.
+func ClearHAEnabled() { clearSession(func(s *Session) { s.HA = false }) }
+
+// Deprecated: use Session.HAAvailable.
+func HAAvailable() bool { return CurrentSession().HAAvailable() }
+
+// Deprecated: set Session.Calendar.
+func SetCalendarOverride(cfg *CalendarConfig) { CurrentSession().Calendar = cfg }
+
+// Deprecated: set Session.Calendar.
+func ClearCalendarOverride() { clearSession(func(s *Session) { s.Calendar = nil }) }
+
+// Deprecated: use Session.CalendarAvailable.
+func CalendarAvailable() bool { return CurrentSession().CalendarAvailable() }
+
+// Deprecated: use Session.CalendarWritable.
+func CalendarWritable() bool { return CurrentSession().CalendarWritable() }
+
+// Deprecated: set Session.Contacts.
+func SetContactsOverride(cfg *ContactsConfig) { CurrentSession().Contacts = cfg }
+
+// Deprecated: set Session.Contacts.
+func ClearContactsOverride() { clearSession(func(s *Session) { s.Contacts = nil }) }
+
+// Deprecated: use Session.ContactsAvailable.
+func ContactsAvailable() bool { return CurrentSession().ContactsAvailable() }
+
+// Deprecated: use Session.ContactsWritable.
+func ContactsWritable() bool { return CurrentSession().ContactsWritable() }
+
+// Deprecated: set Session.MemoryDir.
+func SetMemoryOverride(path string) { CurrentSession().MemoryDir = path }
+
+// Deprecated: set Session.MemoryDir.
+func ClearMemoryOverride() { clearSession(func(s *Session) { s.MemoryDir = "" }) }
+
+// Deprecated: use Session.MemoryAvailable.
+func MemoryAvailable() bool { return CurrentSession().MemoryAvailable() }
+
+// Deprecated: use Session.ClearTemp.
+func ClearTempMemory() { clearSession(func(s *Session) { s.ClearTemp() }) }
+
+// Deprecated: set Session.UserInfo and Session.UserName.
+func SetUserInfoOverride(path, username string) {
+	s := CurrentSession()
+	s.UserInfo, s.UserName = path, username
+}
+
+// Deprecated: set Session.UserInfo.
+func ClearUserInfoOverride() { clearSession(func(s *Session) { s.UserInfo = "" }) }
+
+// Deprecated: use Session.UserInfoAvailable.
+func UserInfoAvailable() bool { return CurrentSession().UserInfoAvailable() }
+
+// Deprecated: set Session.Prompter.
+func SetPrompter(p UserPrompter) { CurrentSession().Prompter = p }
+
+// Deprecated: set Session.Prompter.
+func ClearPrompter() { clearSession(func(s *Session) { s.Prompter = nil }) }
+
+// Deprecated: use Session.Prompter.
+func GetPrompter() UserPrompter { return CurrentSession().Prompter }
+
+// Deprecated: use Session.AskAvailable.
+func AskAvailable() bool { return CurrentSession().AskAvailable() }
+
+// Deprecated: set Session.ImageSender.
+func SetImageSender(sender ImageSender) { CurrentSession().ImageSender = sender }
+
+// Deprecated: set Session.ImageSender.
+func ClearImageSender() { clearSession(func(s *Session) { s.ImageSender = nil }) }
+
+// Deprecated: use Session.ImageSender.
+func GetImageSender() ImageSender { return CurrentSession().ImageSender }
+
+// Deprecated: use Session.ImageSenderAvailable.
+func ImageSenderAvailable() bool { return CurrentSession().ImageSenderAvailable() }
+
+// Deprecated: use Session.SetVideoState.
+func SetVideoState(path string, duration float64, frameWidth, maxFrames int) {
+	CurrentSession().SetVideoState(path, duration, frameWidth, maxFrames)
+}
+
+// Deprecated: sessions drop their video state with them.
+func ClearVideoState() {
+	clearSession(func(s *Session) {
+		s.store.mu.Lock()
+		s.store.video = nil
+		s.store.mu.Unlock()
+	})
+}
+
+// Deprecated: use Session.VideoAvailable.
+func VideoAvailable() bool { return CurrentSession().VideoAvailable() }
+
+// Deprecated: use Session.SetPendingImages.
+func SetPendingImages(images []string) { CurrentSession().SetPendingImages(images) }
+
+// Deprecated: use Session.TakePendingImages.
+func TakePendingImages() []string { return CurrentSession().TakePendingImages() }
+
+// Deprecated: use Session.AddImage.
+func AddSessionImage(uri string) int { return CurrentSession().AddImage(uri) }
+
+// Deprecated: use Session.Image.
+func GetSessionImage(imageID int) (string, bool) { return CurrentSession().Image(imageID) }
+
+// Deprecated: sessions drop their images with them.
+func ClearSessionImages() {
+	clearSession(func(s *Session) {
+		s.store.mu.Lock()
+		s.store.images = nil
+		s.store.mu.Unlock()
+	})
+}
+
+// Deprecated: use Session.SetVideoFrameStrip.
+func SetVideoFrameStrip() { CurrentSession().SetVideoFrameStrip() }
+
+// Deprecated: use Session.TakeVideoFrameStrip.
+func TakeVideoFrameStrip() bool { return CurrentSession().TakeVideoFrameStrip() }
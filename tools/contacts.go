@@ -7,7 +7,6 @@ import (
 	"log"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/emersion/go-vcard"
@@ -23,38 +22,21 @@ type ContactsConfig struct {
 	Writable bool
 }
 
-var contactsOverrides sync.Map // goroutineID → *ContactsConfig
-
-// SetContactsOverride sets the contacts config for the current goroutine.
-func SetContactsOverride(cfg *ContactsConfig) {
-	contactsOverrides.Store(goroutineID(), cfg)
-}
-
-// ClearContactsOverride removes the contacts config for the current goroutine.
-func ClearContactsOverride() {
-	contactsOverrides.Delete(goroutineID())
-}
-
 // ContactsAvailable returns true if contacts tools should be visible.
-func ContactsAvailable() bool {
-	_, ok := contactsOverrides.Load(goroutineID())
-	return ok
+func (s *Session) ContactsAvailable() bool {
+	return s != nil && s.Contacts != nil
 }
 
 // ContactsWritable returns true if contacts write tools should be visible.
-func ContactsWritable() bool {
-	v, ok := contactsOverrides.Load(goroutineID())
-	if !ok {
-		return false
-	}
-	return v.(*ContactsConfig).Writable
+func (s *Session) ContactsWritable() bool {
+	return s.ContactsAvailable() && s.Contacts.Writable
 }
 
-func getContactsConfig() (*ContactsConfig, error) {
-	if v, ok := contactsOverrides.Load(goroutineID()); ok {
-		return v.(*ContactsConfig), nil
+func (s *Session) contactsConfig() (*ContactsConfig, error) {
+	if !s.ContactsAvailable() {
+		return nil, fmt.Errorf("no contacts config for this context")
 	}
-	return nil, fmt.Errorf("no contacts config for this context")
+	return s.Contacts, nil
 }
 
 func dialCardDAV(cfg *ContactsConfig) (*carddav.Client, error) {
@@ -62,12 +44,11 @@ func dialCardDAV(cfg *ContactsConfig) (*carddav.Client, error) {
 	return carddav.NewClient(httpClient, cfg.Server)
 }
 
-func findAddressBooks(cfg *ContactsConfig) ([]carddav.AddressBook, error) {
+func findAddressBooks(ctx context.Context, cfg *ContactsConfig) ([]carddav.AddressBook, error) {
 	client, err := dialCardDAV(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("connect to CardDAV: %w", err)
 	}
-	ctx := context.Background()
 	principal, err := client.FindCurrentUserPrincipal(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find principal: %w", err)
@@ -137,7 +118,7 @@ func formatContact(obj carddav.AddressObject) string {
 
 // --- Tool executors ---
 
-func execContactsSearch(rawArgs json.RawMessage) (string, error) {
+func execContactsSearch(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Query       string `json:"query"`
 		AddressBook string `json:"address_book"`
@@ -151,7 +132,7 @@ func execContactsSearch(rawArgs json.RawMessage) (string, error) {
 		args.Limit = 20
 	}
 
-	cfg, err := getContactsConfig()
+	cfg, err := sess.contactsConfig()
 	if err != nil {
 		return "", err
 	}
@@ -160,9 +141,8 @@ func execContactsSearch(rawArgs json.RawMessage) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	ctx := context.Background()
 
-	books, err := findAddressBooks(cfg)
+	books, err := findAddressBooks(ctx, cfg)
 	if err != nil {
 		return "", err
 	}
@@ -284,7 +264,7 @@ func execContactsSearch(rawArgs json.RawMessage) (string, error) {
 	return strings.TrimSpace(sb.String()), nil
 }
 
-func execContactsGet(rawArgs json.RawMessage) (string, error) {
+func execContactsGet(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Path string `json:"path"`
 	}
@@ -293,7 +273,7 @@ func execContactsGet(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("path is required")
 	}
 
-	cfg, err := getContactsConfig()
+	cfg, err := sess.contactsConfig()
 	if err != nil {
 		return "", err
 	}
@@ -303,7 +283,7 @@ func execContactsGet(rawArgs json.RawMessage) (string, error) {
 		return "", err
 	}
 
-	obj, err := client.GetAddressObject(context.Background(), args.Path)
+	obj, err := client.GetAddressObject(ctx, args.Path)
 	if err != nil {
 		return "", fmt.Errorf("get contact: %w", err)
 	}
@@ -311,7 +291,7 @@ func execContactsGet(rawArgs json.RawMessage) (string, error) {
 	return formatContact(*obj), nil
 }
 
-func execContactsCreate(rawArgs json.RawMessage) (string, error) {
+func execContactsCreate(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		AddressBook  string `json:"address_book"`
 		Name         string `json:"name"`
@@ -325,7 +305,7 @@ func execContactsCreate(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("address_book and name are required")
 	}
 
-	cfg, err := getContactsConfig()
+	cfg, err := sess.contactsConfig()
 	if err != nil {
 		return "", err
 	}
@@ -333,7 +313,7 @@ func execContactsCreate(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("contacts are not writable")
 	}
 
-	uid := fmt.Sprintf("%d-%d@ai-webfetch", time.Now().UnixNano(), goroutineID())
+	uid := fmt.Sprintf("%d-%d@ai-webfetch", time.Now().UnixNano(), uidSeq.Add(1))
 
 	card := make(vcard.Card)
 	card.SetValue(vcard.FieldVersion, "3.0")
@@ -360,7 +340,7 @@ func execContactsCreate(rawArgs json.RawMessage) (string, error) {
 	}
 
 	path := strings.TrimRight(args.AddressBook, "/") + "/" + uid + ".vcf"
-	obj, err := client.PutAddressObject(context.Background(), path, card)
+	obj, err := client.PutAddressObject(ctx, path, card)
 	if err != nil {
 		return "", fmt.Errorf("create contact: %w", err)
 	}
@@ -368,7 +348,7 @@ func execContactsCreate(rawArgs json.RawMessage) (string, error) {
 	return fmt.Sprintf("Contact created: %s\nPath: %s", args.Name, obj.Path), nil
 }
 
-func execContactsUpdate(rawArgs json.RawMessage) (string, error) {
+func execContactsUpdate(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Path         string `json:"path"`
 		Name         string `json:"name"`
@@ -382,7 +362,7 @@ func execContactsUpdate(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("path is required")
 	}
 
-	cfg, err := getContactsConfig()
+	cfg, err := sess.contactsConfig()
 	if err != nil {
 		return "", err
 	}
@@ -394,7 +374,6 @@ func execContactsUpdate(rawArgs json.RawMessage) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	ctx := context.Background()
 
 	obj, err := client.GetAddressObject(ctx, args.Path)
 	if err != nil {
@@ -427,7 +406,7 @@ func execContactsUpdate(rawArgs json.RawMessage) (string, error) {
 	return fmt.Sprintf("Contact updated: %s", updated.Path), nil
 }
 
-func execContactsDelete(rawArgs json.RawMessage) (string, error) {
+func execContactsDelete(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Path string `json:"path"`
 	}
@@ -436,7 +415,7 @@ func execContactsDelete(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("path is required")
 	}
 
-	cfg, err := getContactsConfig()
+	cfg, err := sess.contactsConfig()
 	if err != nil {
 		return "", err
 	}
@@ -449,7 +428,7 @@ func execContactsDelete(rawArgs json.RawMessage) (string, error) {
 		return "", err
 	}
 
-	if err := client.RemoveAll(context.Background(), args.Path); err != nil {
+	if err := client.RemoveAll(ctx, args.Path); err != nil {
 		return "", fmt.Errorf("delete contact: %w", err)
 	}
 
@@ -476,7 +455,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execContactsSearch,
+		Execute:         execContactsSearch,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -494,7 +474,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execContactsGet,
+		Execute:         execContactsGet,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -518,6 +499,7 @@ func init() {
 			},
 		},
 		Execute: execContactsCreate,
+		Writes:  true,
 	})
 
 	Register(&Tool{
@@ -541,6 +523,7 @@ func init() {
 			},
 		},
 		Execute: execContactsUpdate,
+		Writes:  true,
 	})
 
 	Register(&Tool{
@@ -559,5 +542,6 @@ func init() {
 			},
 		},
 		Execute: execContactsDelete,
+		Writes:  true,
 	})
 }
@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -12,9 +13,11 @@ import (
 
 func init() {
 	RegisterCommand(&Command{
-		Name:       "eat",
-		MCPServers: []string{"nutricalc"},
-		Handler:    handleEat,
+		Name:        "eat",
+		Description: "записать еду в дневник питания (текст, фото блюда или этикетки)",
+		MCPServers:  []string{"nutricalc"},
+		Writes:      true,
+		Handler:     handleEat,
 	})
 }
 
@@ -24,19 +27,8 @@ func init() {
 // meals with the wrong offset. Configure via userinfo: a module setting
 // "nutricalc_timezone" (only_for "eat") or a global "timezone"/"tz", e.g.
 // "Europe/Prague".
-func nutriLocation() *time.Location {
-	if cfg := getUserInfoConfig(); cfg != nil {
-		if entries, err := userInfoGet(cfg); err == nil {
-			for _, key := range []string{"nutricalc_timezone", "timezone", "tz"} {
-				if e, ok := entries[key]; ok && e.Value != "" {
-					if loc, err := time.LoadLocation(e.Value); err == nil {
-						return loc
-					}
-				}
-			}
-		}
-	}
-	return time.Local
+func nutriLocation(sess *Session) *time.Location {
+	return userLocation(sess, "nutricalc_timezone", "timezone", "tz")
 }
 
 // nutriTimestamp returns an RFC3339 timestamp that makes the nutricalc UI show
@@ -45,8 +37,8 @@ func nutriLocation() *time.Location {
 // the real local offset (e.g. 19:20+02:00) displays as 17:20. We therefore
 // take the user's local wall clock and label it as UTC (19:20Z), which the UI
 // then renders as 19:20.
-func nutriTimestamp() string {
-	now := time.Now().In(nutriLocation())
+func nutriTimestamp(sess *Session) string {
+	now := time.Now().In(nutriLocation(sess))
 	wall := time.Date(now.Year(), now.Month(), now.Day(),
 		now.Hour(), now.Minute(), now.Second(), 0, time.UTC)
 	return wall.Format(time.RFC3339)
@@ -116,38 +108,40 @@ type dayStats struct {
 // --- Main handler ---
 
 func handleEat(ctx *CommandContext) (string, error) {
+	sess := ctx.Session
+
 	// Step 0: Resolve username
-	username, err := resolveNutricalcUsername()
+	username, err := resolveNutricalcUsername(ctx.Context, sess)
 	if err != nil {
 		return "", fmt.Errorf("username resolution: %w", err)
 	}
 
 	text := strings.TrimSpace(ctx.Text)
-	today := time.Now().In(nutriLocation()).Format("2006-01-02")
+	today := time.Now().In(nutriLocation(sess)).Format("2006-01-02")
 
 	// Step 1: Determine mode
 	switch {
 	case len(ctx.Images) > 0:
 		return handleEatImage(ctx, username, today)
 	case text == "":
-		return handleEatStats(username, today)
+		return handleEatStats(ctx.Context, username, today)
 	default:
-		return handleEatText(text, username, today)
+		return handleEatText(ctx.Context, sess, text, username, today)
 	}
 }
 
 // --- Stats-only mode ---
 
-func handleEatStats(username, date string) (string, error) {
-	return fetchAndFormatDayStats(username, date)
+func handleEatStats(ctx context.Context, username, date string) (string, error) {
+	return fetchAndFormatDayStats(ctx, username, date)
 }
 
 // --- Text mode ---
 
-func handleEatText(text, username, date string) (string, error) {
+func handleEatText(ctx context.Context, sess *Session, text, username, date string) (string, error) {
 	// Check if this is a catalog-add with inline macros
 	if ca := parseCatalogAdd(text); ca != nil {
-		return handleCatalogAdd(ca, username, date)
+		return handleCatalogAdd(ctx, sess, ca, username, date)
 	}
 
 	items := parseEatItems(text)
@@ -157,7 +151,7 @@ func handleEatText(text, username, date string) (string, error) {
 
 	var results []string
 	for _, item := range items {
-		result, err := processEatItem(item, username, date)
+		result, err := processEatItem(ctx, sess, item, username, date)
 		if err != nil {
 			results = append(results, fmt.Sprintf("%s: ошибка — %v", item.Name, err))
 			continue
@@ -166,7 +160,7 @@ func handleEatText(text, username, date string) (string, error) {
 	}
 
 	// Show daily stats after all items
-	stats, err := fetchAndFormatDayStats(username, date)
+	stats, err := fetchAndFormatDayStats(ctx, username, date)
 	if err != nil {
 		stats = fmt.Sprintf("(ошибка статистики: %v)", err)
 	}
@@ -182,6 +176,7 @@ type imageAnalysisResult struct {
 	Items   []imageAnalysisFoodItem `json:"items,omitempty"`
 	Name    string                  `json:"name,omitempty"`
 	Per100g macros                  `json:"per_100g,omitempty"`
+	WeightG float64                 `json:"weight_g,omitempty"` // label: portion seen on the other photos
 }
 
 type imageAnalysisFoodItem struct {
@@ -190,6 +185,7 @@ type imageAnalysisFoodItem struct {
 }
 
 func handleEatImage(ctx *CommandContext, username, date string) (string, error) {
+	sess := ctx.Session
 	if SubAgentImageFn == nil {
 		return "", fmt.Errorf("image analysis not available")
 	}
@@ -207,9 +203,19 @@ Return: {"type": "food", "items": [{"name": "food name", "weight_g": N}]}
 
 Rules: use Russian names when possible. For labels: ALWAYS normalize to per 100g.
 For food: estimate realistic portion weights. Return ONLY JSON.`
+	userMsg := "Analyze this image."
+	if len(ctx.Images) > 1 {
+		// An album, e.g. the meal and the label of its product
+		systemPrompt += `
+
+You get several photos of the same meal. If one of them is a nutrition label, return type "label"
+with the label's name and values, and in "weight_g" the estimated weight of the portion of that
+product seen on the other photos (omit it if no portion is shown).`
+		userMsg = "Analyze these images."
+	}
 
 	thinkOn := true
-	resp, err := SubAgentImageFn(systemPrompt, "Analyze this image.", ctx.Images, &thinkOn)
+	resp, err := SubAgentImageFn(ctx.Context, systemPrompt, userMsg, ctx.Images, &thinkOn)
 	if err != nil {
 		return "", fmt.Errorf("image analysis: %w", err)
 	}
@@ -226,21 +232,21 @@ For food: estimate realistic portion weights. Return ONLY JSON.`
 
 	switch analysis.Type {
 	case "label":
-		return handleEatLabel(analysis, ctx.Text, username, date)
+		return handleEatLabel(ctx.Context, sess, analysis, ctx.Text, username, date)
 	default:
-		return handleEatFood(analysis.Items, username, date)
+		return handleEatFood(ctx.Context, sess, analysis.Items, username, date)
 	}
 }
 
 // handleEatFood processes a food photo: each recognized item goes through the normal catalog search flow.
-func handleEatFood(items []imageAnalysisFoodItem, username, date string) (string, error) {
+func handleEatFood(ctx context.Context, sess *Session, items []imageAnalysisFoodItem, username, date string) (string, error) {
 	var results []string
 	for _, ex := range items {
 		item := eatItem{Name: ex.Name, Weight: ex.WeightG, Unit: "г"}
 		if item.Weight == 0 {
 			item.Weight = 100
 		}
-		result, err := processEatItem(item, username, date)
+		result, err := processEatItem(ctx, sess, item, username, date)
 		if err != nil {
 			results = append(results, fmt.Sprintf("%s: ошибка — %v", ex.Name, err))
 			continue
@@ -248,7 +254,7 @@ func handleEatFood(items []imageAnalysisFoodItem, username, date string) (string
 		results = append(results, result)
 	}
 
-	stats, err := fetchAndFormatDayStats(username, date)
+	stats, err := fetchAndFormatDayStats(ctx, username, date)
 	if err != nil {
 		stats = fmt.Sprintf("(ошибка статистики: %v)", err)
 	}
@@ -257,7 +263,7 @@ func handleEatFood(items []imageAnalysisFoodItem, username, date string) (string
 
 // handleEatLabel processes a nutrition label photo.
 // Caption text is parsed for product name and/or weight — it is NOT sent to the AI.
-func handleEatLabel(analysis imageAnalysisResult, captionText string, username, date string) (string, error) {
+func handleEatLabel(ctx context.Context, sess *Session, analysis imageAnalysisResult, captionText string, username, date string) (string, error) {
 	per100g := analysis.Per100g
 	labelName := analysis.Name
 
@@ -279,8 +285,8 @@ func handleEatLabel(analysis imageAnalysisResult, captionText string, username,
 	if finalName == "" {
 		finalName = labelName
 	}
-	if finalName == "" && AskAvailable() {
-		answer, err := GetPrompter().Ask(UserQuestion{
+	if finalName == "" && sess.AskAvailable() {
+		answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 			Question: "Название продукта не распознано. Введите название:",
 		})
 		if err != nil {
@@ -292,18 +298,22 @@ func handleEatLabel(analysis imageAnalysisResult, captionText string, username,
 		return "", fmt.Errorf("не удалось определить название продукта")
 	}
 
+	// Portion estimated from a meal photo sent with the label
+	if weight == 0 && analysis.WeightG > 0 {
+		weight = math.Round(analysis.WeightG)
+	}
 	if weightUnit == "" && weight > 0 {
 		weightUnit = "г"
 	}
 
 	if weight > 0 {
-		return handleLabelWithWeight(finalName, per100g, weight, weightUnit, username, date)
+		return handleLabelWithWeight(ctx, sess, finalName, per100g, weight, weightUnit, username, date)
 	}
-	return handleLabelNoWeight(finalName, per100g, username, date)
+	return handleLabelNoWeight(ctx, sess, finalName, per100g, username, date)
 }
 
 // handleLabelWithWeight handles a label photo when caption includes weight (e.g. "творог 20г").
-func handleLabelWithWeight(name string, per100g macros, weight float64, weightUnit string, username, date string) (string, error) {
+func handleLabelWithWeight(ctx context.Context, sess *Session, name string, per100g macros, weight float64, weightUnit string, username, date string) (string, error) {
 	ratio := weight / 100
 	diaryMacros := macros{
 		Calories: math.Round(per100g.Calories*ratio*10) / 10,
@@ -312,11 +322,11 @@ func handleLabelWithWeight(name string, per100g macros, weight float64, weightUn
 		Fats:     math.Round(per100g.Fats*ratio*10) / 10,
 	}
 
-	if !AskAvailable() {
+	if !sess.AskAvailable() {
 		return "", fmt.Errorf("need interactive mode for label processing")
 	}
 
-	answer, err := GetPrompter().Ask(UserQuestion{
+	answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 		Question: fmt.Sprintf("%s %.0f%s (%.0f ккал)\nна 100г: %.0f ккал, %.1fб, %.1fу, %.1fж",
 			name, weight, weightUnit, diaryMacros.Calories,
 			per100g.Calories, per100g.Protein, per100g.Carbs, per100g.Fats),
@@ -337,7 +347,7 @@ func handleLabelWithWeight(name string, per100g macros, weight float64, weightUn
 	var parts []string
 
 	if answer != "Только дневник" {
-		if err := catalogAddProduct(name, per100g, "AI", "100g", 100, "г"); err != nil {
+		if err := catalogAddProduct(ctx, name, per100g, "AI", "100g", 100, "г"); err != nil {
 			return "", err
 		}
 		parts = append(parts, fmt.Sprintf("+ В каталог: %s (%.0f ккал/100г)", name, per100g.Calories))
@@ -353,16 +363,16 @@ func handleLabelWithWeight(name string, per100g macros, weight float64, weightUn
 			"carbs":     diaryMacros.Carbs,
 			"fats":      diaryMacros.Fats,
 			"is_custom": true,
-			"timestamp": nutriTimestamp(),
+			"timestamp": nutriTimestamp(sess),
 		}
-		_, err := mcpCall("nutricalc__diary_add_meal", mealArgs)
+		_, err := mcpCall(ctx, "nutricalc__diary_add_meal", mealArgs)
 		if err != nil {
 			return "", fmt.Errorf("diary_add_meal: %w", err)
 		}
 		parts = append(parts, fmt.Sprintf("+ В дневник: %s %.0f%s (%.0f ккал, %.1fб, %.1fу, %.1fж)",
 			name, weight, weightUnit, diaryMacros.Calories, diaryMacros.Protein, diaryMacros.Carbs, diaryMacros.Fats))
 
-		stats, err := fetchAndFormatDayStats(username, date)
+		stats, err := fetchAndFormatDayStats(ctx, username, date)
 		if err == nil {
 			parts = append(parts, "\n"+stats)
 		}
@@ -372,13 +382,13 @@ func handleLabelWithWeight(name string, per100g macros, weight float64, weightUn
 }
 
 // handleLabelNoWeight handles a label photo when no weight is specified in caption.
-func handleLabelNoWeight(name string, per100g macros, username, date string) (string, error) {
-	if !AskAvailable() {
+func handleLabelNoWeight(ctx context.Context, sess *Session, name string, per100g macros, username, date string) (string, error) {
+	if !sess.AskAvailable() {
 		return fmt.Sprintf("Этикетка: %s\n%.0f ккал, %.1fб, %.1fу, %.1fж на 100г",
 			name, per100g.Calories, per100g.Protein, per100g.Carbs, per100g.Fats), nil
 	}
 
-	answer, err := GetPrompter().Ask(UserQuestion{
+	answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 		Question: fmt.Sprintf("%s — на 100г:\n%.0f ккал, %.1fб, %.1fу, %.1fж",
 			name, per100g.Calories, per100g.Protein, per100g.Carbs, per100g.Fats),
 		Options: []UserOption{
@@ -401,14 +411,14 @@ func handleLabelNoWeight(name string, per100g macros, username, date string) (st
 	var parts []string
 
 	if addToCatalog {
-		if err := catalogAddProduct(name, per100g, "AI", "100g", 100, "г"); err != nil {
+		if err := catalogAddProduct(ctx, name, per100g, "AI", "100g", 100, "г"); err != nil {
 			return "", err
 		}
 		parts = append(parts, fmt.Sprintf("+ В каталог: %s (%.0f ккал/100г)", name, per100g.Calories))
 	}
 
 	if addToDiary {
-		weightAnswer, err := GetPrompter().Ask(UserQuestion{
+		weightAnswer, err := sess.Prompter.Ask(ctx, UserQuestion{
 			Question: "Введите вес в граммах:",
 		})
 		if err != nil {
@@ -442,16 +452,16 @@ func handleLabelNoWeight(name string, per100g macros, username, date string) (st
 			"carbs":     diaryMacros.Carbs,
 			"fats":      diaryMacros.Fats,
 			"is_custom": true,
-			"timestamp": nutriTimestamp(),
+			"timestamp": nutriTimestamp(sess),
 		}
-		_, err = mcpCall("nutricalc__diary_add_meal", mealArgs)
+		_, err = mcpCall(ctx, "nutricalc__diary_add_meal", mealArgs)
 		if err != nil {
 			return "", fmt.Errorf("diary_add_meal: %w", err)
 		}
 		parts = append(parts, fmt.Sprintf("+ В дневник: %s %.0fг (%.0f ккал, %.1fб, %.1fу, %.1fж)",
 			name, w, diaryMacros.Calories, diaryMacros.Protein, diaryMacros.Carbs, diaryMacros.Fats))
 
-		stats, err := fetchAndFormatDayStats(username, date)
+		stats, err := fetchAndFormatDayStats(ctx, username, date)
 		if err == nil {
 			parts = append(parts, "\n"+stats)
 		}
@@ -462,17 +472,17 @@ func handleLabelNoWeight(name string, per100g macros, username, date string) (st
 
 // --- Process a single eat item ---
 
-func processEatItem(item eatItem, username, date string) (string, error) {
+func processEatItem(ctx context.Context, sess *Session, item eatItem, username, date string) (string, error) {
 	// Search catalog
-	match, err := findCatalogMatch(item.Name, item.Unit)
+	match, err := findCatalogMatch(ctx, sess, item.Name, item.Unit)
 	if err != nil {
 		return "", err
 	}
 
 	if match == nil {
 		// No match — offer to add or skip
-		if AskAvailable() {
-			answer, askErr := GetPrompter().Ask(UserQuestion{
+		if sess.AskAvailable() {
+			answer, askErr := sess.Prompter.Ask(ctx, UserQuestion{
 				Question: fmt.Sprintf("'%s' не найден в каталоге.", item.Name),
 				Options: []UserOption{
 					{Label: "Добавить в каталог"},
@@ -486,14 +496,14 @@ func processEatItem(item eatItem, username, date string) (string, error) {
 				return fmt.Sprintf("%s: пропущен", item.Name), nil
 			}
 			// Estimate macros via AI and add to catalog
-			return handleAddToCatalog(item, username, date)
+			return handleAddToCatalog(ctx, sess, item, username, date)
 		}
 		return "", fmt.Errorf("'%s' не найден в каталоге", item.Name)
 	}
 
 	// If no weight specified, ask user to pick a serving or enter weight
-	if item.Weight == 0 && len(match.Servings) > 0 && AskAvailable() {
-		chosen, qty, askErr := askServingOrWeight(match)
+	if item.Weight == 0 && len(match.Servings) > 0 && sess.AskAvailable() {
+		chosen, qty, askErr := askServingOrWeight(ctx, sess, match)
 		if askErr != nil {
 			return "", askErr
 		}
@@ -507,7 +517,7 @@ func processEatItem(item eatItem, username, date string) (string, error) {
 				Carbs:    math.Round(serving.Macros.Carbs*quantity*10) / 10,
 				Fats:     math.Round(serving.Macros.Fats*quantity*10) / 10,
 			}
-			return addAndReport(match, item.Name, serving, quantity, actualMacros, username, date)
+			return addAndReport(ctx, sess, match, item.Name, serving, quantity, actualMacros, username, date)
 		}
 		// qty holds the weight in grams the user typed — update item
 		item.Weight = qty
@@ -525,11 +535,11 @@ func processEatItem(item eatItem, username, date string) (string, error) {
 		Fats:     math.Round(serving.Macros.Fats*quantity*10) / 10,
 	}
 
-	return addAndReport(match, item.Name, serving, quantity, actualMacros, username, date)
+	return addAndReport(ctx, sess, match, item.Name, serving, quantity, actualMacros, username, date)
 }
 
 // addAndReport calls diary_add_meal and returns a formatted confirmation line.
-func addAndReport(match *catalogItem, label string, serving catalogServing, quantity float64, m macros, username, date string) (string, error) {
+func addAndReport(ctx context.Context, sess *Session, match *catalogItem, label string, serving catalogServing, quantity float64, m macros, username, date string) (string, error) {
 	mealArgs := map[string]any{
 		"user":       username,
 		"date":       date,
@@ -541,10 +551,10 @@ func addAndReport(match *catalogItem, label string, serving catalogServing, quan
 		"item_id":    match.ID,
 		"serving_id": serving.ID,
 		"quantity":   math.Round(quantity*1000) / 1000,
-		"timestamp":  nutriTimestamp(),
+		"timestamp":  nutriTimestamp(sess),
 	}
 
-	_, err := mcpCall("nutricalc__diary_add_meal", mealArgs)
+	_, err := mcpCall(ctx, "nutricalc__diary_add_meal", mealArgs)
 	if err != nil {
 		return "", fmt.Errorf("diary_add_meal: %w", err)
 	}
@@ -564,7 +574,7 @@ func addAndReport(match *catalogItem, label string, serving catalogServing, quan
 
 // askServingOrWeight presents available servings as buttons plus "Указать вес".
 // Returns (serving, quantity, err). If serving is nil, quantity holds the weight in grams.
-func askServingOrWeight(match *catalogItem) (*catalogServing, float64, error) {
+func askServingOrWeight(ctx context.Context, sess *Session, match *catalogItem) (*catalogServing, float64, error) {
 	var options []UserOption
 	for _, s := range match.Servings {
 		label := s.Label
@@ -575,7 +585,7 @@ func askServingOrWeight(match *catalogItem) (*catalogServing, float64, error) {
 	}
 	options = append(options, UserOption{Label: "Указать вес"})
 
-	answer, err := GetPrompter().Ask(UserQuestion{
+	answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 		Question: fmt.Sprintf("%s — сколько?", match.Name),
 		Options:  options,
 	})
@@ -584,7 +594,7 @@ func askServingOrWeight(match *catalogItem) (*catalogServing, float64, error) {
 	}
 
 	if answer == "Указать вес" {
-		weightAnswer, err := GetPrompter().Ask(UserQuestion{
+		weightAnswer, err := sess.Prompter.Ask(ctx, UserQuestion{
 			Question: "Введите вес в граммах:",
 		})
 		if err != nil {
@@ -613,20 +623,20 @@ func askServingOrWeight(match *catalogItem) (*catalogServing, float64, error) {
 
 // --- Catalog search cascade ---
 
-func findCatalogMatch(name, userUnit string) (*catalogItem, error) {
+func findCatalogMatch(ctx context.Context, sess *Session, name, userUnit string) (*catalogItem, error) {
 	// Load full catalog upfront — we need complete serving data (with quantity/unit)
 	// that catalog_search doesn't return.
-	catalog := loadFullCatalog()
+	catalog := loadFullCatalog(ctx)
 
 	// 1. Direct catalog_search
-	searchResult, err := mcpCall("nutricalc__catalog_search", map[string]any{
+	searchResult, err := mcpCall(ctx, "nutricalc__catalog_search", map[string]any{
 		"query": name,
 		"limit": 5,
 	})
 	if err == nil {
 		var sr catalogSearchResult
 		if json.Unmarshal([]byte(searchResult), &sr) == nil && len(sr.Results) > 0 {
-			picked := pickSearchResult(sr.Results, userUnit)
+			picked := pickSearchResult(ctx, sess, sr.Results, userUnit)
 			if picked != nil {
 				return enrichFromCatalog(picked, catalog), nil
 			}
@@ -636,14 +646,14 @@ func findCatalogMatch(name, userUnit string) (*catalogItem, error) {
 	// 2. Try individual words (≥3 chars)
 	words := significantWords(name)
 	for _, word := range words {
-		searchResult, err = mcpCall("nutricalc__catalog_search", map[string]any{
+		searchResult, err = mcpCall(ctx, "nutricalc__catalog_search", map[string]any{
 			"query": word,
 			"limit": 5,
 		})
 		if err == nil {
 			var sr catalogSearchResult
 			if json.Unmarshal([]byte(searchResult), &sr) == nil && len(sr.Results) > 0 {
-				picked := pickSearchResult(sr.Results, userUnit)
+				picked := pickSearchResult(ctx, sess, sr.Results, userUnit)
 				if picked != nil {
 					return enrichFromCatalog(picked, catalog), nil
 				}
@@ -688,15 +698,15 @@ func findCatalogMatch(name, userUnit string) (*catalogItem, error) {
 	}
 
 	// Multiple matches from full catalog — ask user
-	if AskAvailable() {
-		return askUserToPickCatalogItem(matches)
+	if sess.AskAvailable() {
+		return askUserToPickCatalogItem(ctx, sess, matches)
 	}
 	return &matches[0], nil
 }
 
 // loadFullCatalog fetches the complete catalog file. Returns item map or nil.
-func loadFullCatalog() map[string]catalogItem {
-	fileResult, err := mcpCall("nutricalc__catalog_get_file", map[string]any{})
+func loadFullCatalog(ctx context.Context) map[string]catalogItem {
+	fileResult, err := mcpCall(ctx, "nutricalc__catalog_get_file", map[string]any{})
 	if err != nil {
 		return nil
 	}
@@ -723,7 +733,7 @@ func enrichFromCatalog(item *catalogItem, catalog map[string]catalogItem) *catal
 // servings) and asks only when there are truly different products.
 // When the user specified a unit (г, мл, шт), auto-selects the matching
 // serving variant instead of prompting.
-func pickSearchResult(results []catalogSearchMatch, userUnit string) *catalogItem {
+func pickSearchResult(ctx context.Context, sess *Session, results []catalogSearchMatch, userUnit string) *catalogItem {
 	// Group results by item ID
 	type group struct {
 		first   catalogSearchMatch
@@ -758,8 +768,8 @@ func pickSearchResult(results []catalogSearchMatch, userUnit string) *catalogIte
 	if len(unique) == 1 {
 		return searchResultToItem(unique[0])
 	}
-	if AskAvailable() {
-		item, _ := askUserToPickResult(unique)
+	if sess.AskAvailable() {
+		item, _ := askUserToPickResult(ctx, sess, unique)
 		return item
 	}
 	return searchResultToItem(unique[0])
@@ -805,7 +815,7 @@ func isPieceUnit(unit string) bool {
 	return false
 }
 
-func askUserToPickResult(results []catalogSearchMatch) (*catalogItem, error) {
+func askUserToPickResult(ctx context.Context, sess *Session, results []catalogSearchMatch) (*catalogItem, error) {
 	var options []UserOption
 	for _, r := range results {
 		label := r.Name
@@ -816,7 +826,7 @@ func askUserToPickResult(results []catalogSearchMatch) (*catalogItem, error) {
 	}
 	options = append(options, UserOption{Label: "Пропустить"})
 
-	answer, err := GetPrompter().Ask(UserQuestion{
+	answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 		Question: "Выберите продукт:",
 		Options:  options,
 	})
@@ -837,7 +847,7 @@ func askUserToPickResult(results []catalogSearchMatch) (*catalogItem, error) {
 	return searchResultToItem(results[0]), nil
 }
 
-func askUserToPickCatalogItem(items []catalogItem) (*catalogItem, error) {
+func askUserToPickCatalogItem(ctx context.Context, sess *Session, items []catalogItem) (*catalogItem, error) {
 	var options []UserOption
 	limit := len(items)
 	if limit > 8 {
@@ -853,7 +863,7 @@ func askUserToPickCatalogItem(items []catalogItem) (*catalogItem, error) {
 	}
 	options = append(options, UserOption{Label: "Пропустить"})
 
-	answer, err := GetPrompter().Ask(UserQuestion{
+	answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 		Question: "Выберите продукт:",
 		Options:  options,
 	})
@@ -932,34 +942,34 @@ func calculateQuantity(item eatItem, serving catalogServing) float64 {
 
 // --- Add to catalog (AI-estimated macros) ---
 
-func handleAddToCatalog(item eatItem, username, date string) (string, error) {
-	m, err := estimateMacrosWithConfirm(item.Name)
+func handleAddToCatalog(ctx context.Context, sess *Session, item eatItem, username, date string) (string, error) {
+	m, err := estimateMacrosWithConfirm(ctx, sess, item.Name)
 	if err != nil {
 		return "", err
 	}
 
 	// Add to catalog (macros are per 100g)
-	if err := catalogAddProduct(item.Name, m, "AI", "100g", 100, "г"); err != nil {
+	if err := catalogAddProduct(ctx, item.Name, m, "AI", "100g", 100, "г"); err != nil {
 		return "", err
 	}
 
 	// Now search for the newly added item and log it
-	return processEatItem(item, username, date)
+	return processEatItem(ctx, sess, item, username, date)
 }
 
 // handleCatalogAdd processes a multi-line catalog-add input.
 // If weight is specified, asks whether macros are per-serving or per-100g,
 // then offers to add to catalog, diary, or both.
-func handleCatalogAdd(ca *catalogAddInput, username, date string) (string, error) {
+func handleCatalogAdd(ctx context.Context, sess *Session, ca *catalogAddInput, username, date string) (string, error) {
 	m := ca.Macros
 
 	if ca.Weight > 0 {
 		// Macros + weight: ask if values are for this weight or per 100g
-		if !AskAvailable() {
+		if !sess.AskAvailable() {
 			return "", fmt.Errorf("need to clarify: macros per %.0f%s or per 100г", ca.Weight, ca.WeightUnit)
 		}
 
-		answer, err := GetPrompter().Ask(UserQuestion{
+		answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 			Question: fmt.Sprintf("%s — КБЖУ (%.0f/%.1f/%.1f/%.1f) это на:",
 				ca.Name, m.Calories, m.Protein, m.Carbs, m.Fats),
 			Options: []UserOption{
@@ -996,7 +1006,7 @@ func handleCatalogAdd(ca *catalogAddInput, username, date string) (string, error
 		}
 
 		// Ask: catalog + diary, or just diary?
-		action, err := GetPrompter().Ask(UserQuestion{
+		action, err := sess.Prompter.Ask(ctx, UserQuestion{
 			Question: fmt.Sprintf("%s %.0f%s (%.0f ккал) — куда?",
 				ca.Name, ca.Weight, ca.WeightUnit, diaryMacros.Calories),
 			Options: []UserOption{
@@ -1020,7 +1030,7 @@ func handleCatalogAdd(ca *catalogAddInput, username, date string) (string, error
 				sQty = 100
 				sUnit = "г"
 			}
-			if err := catalogAddProduct(ca.Name, catalogMacros, "AI", sLabel, sQty, sUnit); err != nil {
+			if err := catalogAddProduct(ctx, ca.Name, catalogMacros, "AI", sLabel, sQty, sUnit); err != nil {
 				return "", err
 			}
 			parts = append(parts, fmt.Sprintf("+ В каталог: %s (%.0f ккал/%s)", ca.Name, catalogMacros.Calories, sLabel))
@@ -1036,16 +1046,16 @@ func handleCatalogAdd(ca *catalogAddInput, username, date string) (string, error
 				"carbs":     diaryMacros.Carbs,
 				"fats":      diaryMacros.Fats,
 				"is_custom": true,
-				"timestamp": nutriTimestamp(),
+				"timestamp": nutriTimestamp(sess),
 			}
-			_, err := mcpCall("nutricalc__diary_add_meal", mealArgs)
+			_, err := mcpCall(ctx, "nutricalc__diary_add_meal", mealArgs)
 			if err != nil {
 				return "", fmt.Errorf("diary_add_meal: %w", err)
 			}
 			parts = append(parts, fmt.Sprintf("+ В дневник: %s %.0f%s (%.0f ккал, %.1fб, %.1fу, %.1fж)",
 				ca.Name, ca.Weight, ca.WeightUnit, diaryMacros.Calories, diaryMacros.Protein, diaryMacros.Carbs, diaryMacros.Fats))
 
-			stats, err := fetchAndFormatDayStats(username, date)
+			stats, err := fetchAndFormatDayStats(ctx, username, date)
 			if err == nil {
 				parts = append(parts, "\n"+stats)
 			}
@@ -1055,7 +1065,7 @@ func handleCatalogAdd(ca *catalogAddInput, username, date string) (string, error
 	}
 
 	// No weight — just add to catalog (macros are per 100g)
-	if err := catalogAddProduct(ca.Name, m, "AI", "100g", 100, "г"); err != nil {
+	if err := catalogAddProduct(ctx, ca.Name, m, "AI", "100g", 100, "г"); err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("+ В каталог: %s (%.0f ккал, %.1fб, %.1fу, %.1fж)",
@@ -1063,12 +1073,12 @@ func handleCatalogAdd(ca *catalogAddInput, username, date string) (string, error
 }
 
 // estimateMacrosWithConfirm uses AI to estimate macros and asks user to confirm.
-func estimateMacrosWithConfirm(name string) (macros, error) {
+func estimateMacrosWithConfirm(ctx context.Context, sess *Session, name string) (macros, error) {
 	if SubAgentFn == nil {
 		return macros{}, fmt.Errorf("AI estimation not available for '%s'", name)
 	}
 
-	estimate, err := SubAgentFn(
+	estimate, err := SubAgentFn(ctx,
 		"You are a nutrition expert. Estimate macros per 100g for the given food. Return ONLY JSON: {\"calories\":N,\"protein\":N,\"carbs\":N,\"fats\":N}",
 		fmt.Sprintf("Estimate macros per 100g for: %s", name),
 	)
@@ -1085,11 +1095,11 @@ func estimateMacrosWithConfirm(name string) (macros, error) {
 		return macros{}, fmt.Errorf("parse AI macros: %w", err)
 	}
 
-	if !AskAvailable() {
+	if !sess.AskAvailable() {
 		return m, nil
 	}
 
-	answer, err := GetPrompter().Ask(UserQuestion{
+	answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 		Question: fmt.Sprintf("%s — на 100г:\n%.0f ккал, %.1fб, %.1fу, %.1fж\nДобавить?",
 			name, m.Calories, m.Protein, m.Carbs, m.Fats),
 		Options: []UserOption{
@@ -1213,9 +1223,9 @@ func parseWeightLine(line string) (weight float64, unit string, ok bool) {
 
 // --- Username resolution ---
 
-func resolveNutricalcUsername() (string, error) {
+func resolveNutricalcUsername(ctx context.Context, sess *Session) (string, error) {
 	// Try userinfo first (most reliable — persisted to file)
-	if cfg := getUserInfoConfig(); cfg != nil {
+	if cfg := sess.userInfoConfig(); cfg != nil {
 		if entries, err := userInfoGet(cfg); err == nil {
 			for _, key := range []string{"nutricalc_username", "eat_username"} {
 				if e, ok := entries[key]; ok && e.Value != "" {
@@ -1228,7 +1238,7 @@ func resolveNutricalcUsername() (string, error) {
 	// Try memory_search
 	if tool, ok := Get("memory_search"); ok {
 		args, _ := json.Marshal(map[string]string{"query": "nutricalc username"})
-		result, err := tool.Execute(args)
+		result, err := tool.Execute(ctx, sess, args)
 		if err == nil && result != "" && result != "No memories found." {
 			// Parse username from memory result
 			if name := extractUsernameFromMemory(result); name != "" {
@@ -1238,8 +1248,8 @@ func resolveNutricalcUsername() (string, error) {
 	}
 
 	// Ask user if prompter available
-	if AskAvailable() {
-		answer, err := GetPrompter().Ask(UserQuestion{
+	if sess.AskAvailable() {
+		answer, err := sess.Prompter.Ask(ctx, UserQuestion{
 			Question: "Какое имя пользователя в nutricalc?",
 		})
 		if err != nil {
@@ -1251,7 +1261,7 @@ func resolveNutricalcUsername() (string, error) {
 		}
 
 		// Save to userinfo (persistent, checked first on next call)
-		if cfg := getUserInfoConfig(); cfg != nil {
+		if cfg := sess.userInfoConfig(); cfg != nil {
 			userInfoSet(cfg, "nutricalc_username", UserInfoEntry{
 				Value:   username,
 				OnlyFor: "eat",
@@ -1265,7 +1275,7 @@ func resolveNutricalcUsername() (string, error) {
 				"name":      "nutricalc username",
 				"facts":     fmt.Sprintf(`["%s"]`, username),
 			})
-			tool.Execute(args)
+			tool.Execute(ctx, sess, args)
 		}
 
 		return username, nil
@@ -1298,8 +1308,8 @@ func extractUsernameFromMemory(memResult string) string {
 
 // --- Day stats ---
 
-func fetchAndFormatDayStats(username, date string) (string, error) {
-	result, err := mcpCall("nutricalc__diary_day_stats", map[string]any{
+func fetchAndFormatDayStats(ctx context.Context, username, date string) (string, error) {
+	result, err := mcpCall(ctx, "nutricalc__diary_day_stats", map[string]any{
 		"user": username,
 		"date": date,
 	})
@@ -1439,7 +1449,7 @@ func extractJSON(text string) (json.RawMessage, error) {
 
 // --- MCP helper ---
 
-func mcpCall(tool string, args map[string]any) (string, error) {
+func mcpCall(ctx context.Context, tool string, args map[string]any) (string, error) {
 	if MCPCallFn == nil {
 		return "", fmt.Errorf("MCP not configured")
 	}
@@ -1447,13 +1457,13 @@ func mcpCall(tool string, args map[string]any) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return MCPCallFn(tool, data)
+	return MCPCallFn(ctx, tool, data)
 }
 
 // catalogAddProduct adds a product via catalog_add_product and then patches the
 // serving with quantity/unit via catalog_update_item (the add endpoint only
 // accepts serving_label, not quantity/unit).
-func catalogAddProduct(name string, m macros, categoryName, servingLabel string, servingQty float64, servingUnit string) error {
+func catalogAddProduct(ctx context.Context, name string, m macros, categoryName, servingLabel string, servingQty float64, servingUnit string) error {
 	addArgs := map[string]any{
 		"name":          name,
 		"calories":      m.Calories,
@@ -1463,7 +1473,7 @@ func catalogAddProduct(name string, m macros, categoryName, servingLabel string,
 		"category_name": categoryName,
 		"serving_label": servingLabel,
 	}
-	result, err := mcpCall("nutricalc__catalog_add_product", addArgs)
+	result, err := mcpCall(ctx, "nutricalc__catalog_add_product", addArgs)
 	if err != nil {
 		return fmt.Errorf("catalog_add_product: %w", err)
 	}
@@ -1504,6 +1514,6 @@ func catalogAddProduct(name string, m macros, categoryName, servingLabel string,
 			},
 		}},
 	}
-	_, _ = mcpCall("nutricalc__catalog_update_item", updateArgs) // best-effort
+	_, _ = mcpCall(ctx, "nutricalc__catalog_update_item", updateArgs) // best-effort
 	return nil
 }
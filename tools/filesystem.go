@@ -2,6 +2,7 @@ package tools
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -47,7 +48,7 @@ func RegisterFilesystem(root string, readWrite bool) {
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Path string `json:"path"`
 			}
@@ -106,7 +107,7 @@ Reading a 200KB file wastes most of the available context and leaves no room for
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Path   string `json:"path"`
 				Line   int    `json:"line"`
@@ -187,7 +188,7 @@ Reading a 200KB file wastes most of the available context and leaves no room for
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Path string `json:"path"`
 			}
@@ -232,7 +233,7 @@ Example output:
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Pattern    string `json:"pattern"`
 				Path       string `json:"path"`
@@ -417,7 +418,7 @@ Example output:
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Path    string `json:"path"`
 				Content string `json:"content"`
@@ -436,6 +437,7 @@ Example output:
 			}
 			return fmt.Sprintf("wrote %d bytes to %s", len(p.Content), p.Path), nil
 		},
+		Writes: true,
 	})
 
 	Register(&Tool{
@@ -454,7 +456,7 @@ Example output:
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Path    string `json:"path"`
 				Content string `json:"content"`
@@ -479,6 +481,7 @@ Example output:
 			}
 			return fmt.Sprintf("appended %d bytes to %s", n, p.Path), nil
 		},
+		Writes: true,
 	})
 
 	Register(&Tool{
@@ -497,7 +500,7 @@ Example output:
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Path  string `json:"path"`
 				Patch string `json:"patch"`
@@ -521,6 +524,7 @@ Example output:
 			}
 			return fmt.Sprintf("patched %s", p.Path), nil
 		},
+		Writes: true,
 	})
 
 	Register(&Tool{
@@ -538,7 +542,7 @@ Example output:
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Path string `json:"path"`
 			}
@@ -553,6 +557,7 @@ Example output:
 			}
 			return fmt.Sprintf("created directory %s", p.Path), nil
 		},
+		Writes: true,
 	})
 
 	Register(&Tool{
@@ -571,7 +576,7 @@ Example output:
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Path      string `json:"path"`
 				Recursive bool   `json:"recursive"`
@@ -597,6 +602,7 @@ Example output:
 			}
 			return fmt.Sprintf("removed %s", p.Path), nil
 		},
+		Writes: true,
 	})
 }
 
@@ -1,9 +1,11 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -47,7 +49,7 @@ func runGrep(t *testing.T, args map[string]interface{}) string {
 		t.Fatal("fs_grep tool not registered")
 	}
 	raw, _ := json.Marshal(args)
-	result, err := tool.Execute(raw)
+	result, err := tool.Execute(context.Background(), nil, raw)
 	if err != nil {
 		t.Fatalf("fs_grep error: %v", err)
 	}
@@ -204,7 +206,7 @@ func TestFsGrep_InvalidRegex(t *testing.T) {
 		t.Fatal("fs_grep not registered")
 	}
 	raw, _ := json.Marshal(map[string]interface{}{"pattern": "[invalid", "regex": true})
-	_, err := tool.Execute(raw)
+	_, err := tool.Execute(context.Background(), nil, raw)
 	if err == nil {
 		t.Fatal("expected error for invalid regex")
 	}
@@ -219,7 +221,7 @@ func TestFsGrep_EmptyPattern(t *testing.T) {
 		t.Fatal("fs_grep not registered")
 	}
 	raw, _ := json.Marshal(map[string]interface{}{"pattern": ""})
-	_, err := tool.Execute(raw)
+	_, err := tool.Execute(context.Background(), nil, raw)
 	if err == nil {
 		t.Fatal("expected error for empty pattern")
 	}
@@ -287,8 +289,11 @@ func indexOf(s, sub string) int {
 }
 
 func deregisterAll() {
-	// Clear registry to avoid interference between tests.
+	// Remove the filesystem tools to avoid interference between tests;
+	// tools registered by init stay for the other tests of the package.
 	for k := range registry {
-		delete(registry, k)
+		if strings.HasPrefix(k, "fs_") {
+			delete(registry, k)
+		}
 	}
 }
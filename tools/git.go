@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -39,7 +40,7 @@ func RegisterGit(repoPath string) error {
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Limit int    `json:"limit"`
 				Path  string `json:"path"`
@@ -119,7 +120,7 @@ func RegisterGit(repoPath string) error {
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Commit string `json:"commit"`
 				Diff   bool   `json:"diff"`
@@ -223,7 +224,7 @@ func RegisterGit(repoPath string) error {
 				},
 			},
 		},
-		Execute: func(args json.RawMessage) (string, error) {
+		Execute: func(_ context.Context, _ *Session, args json.RawMessage) (string, error) {
 			var p struct {
 				Commit string `json:"commit"`
 				Base   string `json:"base"`
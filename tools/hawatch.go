@@ -0,0 +1,133 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Home Assistant trigger subscriptions
+//
+// HAWatch holds a WebSocket connection of its own — the tools' connection is
+// opened on demand and reads only command results — with one
+// subscribe_trigger per trigger, and reports every firing. Pings detect a
+// dead connection; it is re-established with a growing delay.
+
+const (
+	haWatchPing       = 30 * time.Second
+	haWatchMinBackoff = 5 * time.Second
+	haWatchMaxBackoff = 5 * time.Minute
+)
+
+// HATriggerEvent is a firing of a subscribed trigger.
+type HATriggerEvent struct {
+	Trigger     int    // index into the triggers given to HAWatch
+	EntityID    string // empty for triggers without an entity
+	FromState   string
+	ToState     string
+	Name        string // friendly name of the entity
+	Description string // from Home Assistant, e.g. "state of binary_sensor.front_door"
+}
+
+// haTriggerMsg is a subscription event or command result.
+type haTriggerMsg struct {
+	wsMsg
+	Event struct {
+		Variables struct {
+			Trigger struct {
+				EntityID    string       `json:"entity_id"`
+				Description string       `json:"description"`
+				FromState   *entityState `json:"from_state"`
+				ToState     *entityState `json:"to_state"`
+			} `json:"trigger"`
+		} `json:"variables"`
+	} `json:"event"`
+}
+
+func (m *haTriggerMsg) event() HATriggerEvent {
+	t := m.Event.Variables.Trigger
+	ev := HATriggerEvent{Trigger: m.ID - 1, EntityID: t.EntityID, Description: t.Description}
+	if t.FromState != nil {
+		ev.FromState = t.FromState.State
+	}
+	if t.ToState != nil {
+		ev.ToState = t.ToState.State
+		if name, ok := t.ToState.Attributes["friendly_name"].(string); ok {
+			ev.Name = name
+		}
+	}
+	return ev
+}
+
+// HAWatch subscribes to triggers — Home Assistant trigger configs such as
+// {"platform": "state", "entity_id": "binary_sensor.door", "to": "on"} —
+// and calls fire for each event until ctx is done. fire is called by the
+// WebSocket reader; a stall long enough to miss the pings drops the
+// connection. HAWatch only returns early, with an error, when
+// homeassistant.json is missing.
+func HAWatch(ctx context.Context, triggers []any, fire func(HATriggerEvent), logf func(string, ...any)) error {
+	if _, err := getHAConfig(); err != nil {
+		return err
+	}
+	reconnectLoop(ctx, "Home Assistant subscription", haWatchMinBackoff, haWatchMaxBackoff,
+		func() error { return haWatchOnce(ctx, triggers, fire, logf) }, logf)
+	return nil
+}
+
+func haWatchOnce(ctx context.Context, triggers []any, fire func(HATriggerEvent), logf func(string, ...any)) error {
+	ws, err := haDial()
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	// Subscription i has command ID i+1; pings follow
+	for i, trigger := range triggers {
+		ws.SetWriteDeadline(time.Now().Add(15 * time.Second))
+		if err := websocket.JSON.Send(ws, map[string]any{"id": i + 1, "type": "subscribe_trigger", "trigger": trigger}); err != nil {
+			return fmt.Errorf("WS send subscribe_trigger: %w", err)
+		}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(haWatchPing)
+		defer ticker.Stop()
+		for id := len(triggers) + 1; ; id++ {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ws.SetWriteDeadline(time.Now().Add(15 * time.Second))
+				if websocket.JSON.Send(ws, map[string]any{"id": id, "type": "ping"}) != nil {
+					return // the read below fails too
+				}
+			}
+		}
+	}()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(2*haWatchPing + 15*time.Second))
+		var msg haTriggerMsg
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return fmt.Errorf("WS recv: %w", err)
+		}
+		subscription := msg.ID >= 1 && msg.ID <= len(triggers)
+		switch {
+		case !subscription:
+			// pong
+		case msg.Type == "result" && msg.Success != nil && !*msg.Success:
+			errMsg := "failed"
+			if msg.Error != nil {
+				errMsg = msg.Error.Message
+			}
+			logf("Home Assistant subscribe_trigger %d: %s", msg.ID-1, errMsg)
+		case msg.Type == "event":
+			fire(msg.event())
+		}
+	}
+}
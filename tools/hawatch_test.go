@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestHAWatch(t *testing.T) {
+	subscribed := make(chan map[string]any, 2)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, map[string]any{"type": "auth_required"})
+		var auth map[string]any
+		if websocket.JSON.Receive(ws, &auth) != nil || auth["access_token"] != "secret" {
+			websocket.JSON.Send(ws, map[string]any{"type": "auth_invalid", "message": "bad token"})
+			return
+		}
+		websocket.JSON.Send(ws, map[string]any{"type": "auth_ok"})
+		for range 2 {
+			var cmd map[string]any
+			if websocket.JSON.Receive(ws, &cmd) != nil {
+				return
+			}
+			subscribed <- cmd
+		}
+		websocket.JSON.Send(ws, map[string]any{"id": 1, "type": "result", "success": false, "error": map[string]any{"message": "invalid trigger"}})
+		websocket.JSON.Send(ws, map[string]any{"id": 2, "type": "result", "success": true})
+		websocket.JSON.Send(ws, map[string]any{"id": 2, "type": "event", "event": map[string]any{"variables": map[string]any{"trigger": map[string]any{
+			"entity_id":   "binary_sensor.front_door",
+			"description": "state of binary_sensor.front_door",
+			"from_state":  map[string]any{"state": "off"},
+			"to_state":    map[string]any{"state": "on", "attributes": map[string]any{"friendly_name": "Front door"}},
+		}}}})
+		var rest map[string]any
+		websocket.JSON.Receive(ws, &rest) // until the watcher hangs up
+	}))
+	defer srv.Close()
+
+	saved := haCfg
+	haCfg = &haConfig{URL: srv.URL, Token: "secret"}
+	defer func() { haCfg = saved }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan HATriggerEvent, 1)
+	logged := make(chan string, 4)
+	done := make(chan error, 1)
+	triggers := []any{
+		map[string]any{"platform": "bogus"},
+		map[string]any{"platform": "state", "entity_id": "binary_sensor.front_door", "to": "on"},
+	}
+	go func() {
+		done <- HAWatch(ctx, triggers, func(ev HATriggerEvent) { events <- ev }, func(format string, args ...any) { logged <- format })
+	}()
+
+	for i := range 2 {
+		cmd := <-subscribed
+		if cmd["type"] != "subscribe_trigger" || cmd["id"] != float64(i+1) || cmd["trigger"] == nil {
+			t.Errorf("command %d = %v", i, cmd)
+		}
+	}
+	select {
+	case ev := <-events:
+		want := HATriggerEvent{Trigger: 1, EntityID: "binary_sensor.front_door", FromState: "off", ToState: "on", Name: "Front door", Description: "state of binary_sensor.front_door"}
+		if ev != want {
+			t.Errorf("event = %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	if len(logged) != 1 {
+		t.Errorf("%d log lines for the failed subscription", len(logged))
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("HAWatch = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("HAWatch did not stop")
+	}
+}
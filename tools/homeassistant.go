@@ -1,15 +1,14 @@
 package tools
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"runtime"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,35 +16,9 @@ import (
 	"golang.org/x/net/websocket"
 )
 
-var haOverrides sync.Map // goroutineID → bool
-
-func haGoroutineID() int64 {
-	var buf [64]byte
-	n := runtime.Stack(buf[:], false)
-	s := string(buf[:n])
-	s = s[len("goroutine "):]
-	s = s[:strings.IndexByte(s, ' ')]
-	id, _ := strconv.ParseInt(s, 10, 64)
-	return id
-}
-
-// SetHAEnabled sets the HA availability for the current goroutine.
-func SetHAEnabled(enabled bool) {
-	haOverrides.Store(haGoroutineID(), enabled)
-}
-
-// ClearHAEnabled removes the HA override for the current goroutine.
-func ClearHAEnabled() {
-	haOverrides.Delete(haGoroutineID())
-}
-
-// HAAvailable returns true if HA tools should be visible for the current goroutine.
-func HAAvailable() bool {
-	v, ok := haOverrides.Load(haGoroutineID())
-	if !ok {
-		return false
-	}
-	return v.(bool)
+// HAAvailable returns true if HA tools should be visible for the session.
+func (s *Session) HAAvailable() bool {
+	return s != nil && s.HA
 }
 
 // --- Config ---
@@ -164,15 +137,35 @@ func HAClose() {
 	haWS.disconnect()
 }
 
-func (h *haConn) ensureConnected() error {
+func (h *haConn) ensureConnected(ctx context.Context) error {
 	if h.conn != nil {
 		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	cfg, err := getHAConfig()
+	ws, err := haDial()
 	if err != nil {
 		return err
 	}
+	h.conn = ws
+	h.seq = 0
+
+	if err := h.loadCaches(ctx); err != nil {
+		h.disconnect()
+		return fmt.Errorf("load caches: %w", err)
+	}
+
+	return nil
+}
+
+// haDial opens an authenticated WebSocket connection to Home Assistant.
+func haDial() (*websocket.Conn, error) {
+	cfg, err := getHAConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	wsURL := cfg.URL
 	wsURL = strings.Replace(wsURL, "https://", "wss://", 1)
@@ -181,7 +174,7 @@ func (h *haConn) ensureConnected() error {
 
 	ws, err := websocket.Dial(wsURL, "", cfg.URL)
 	if err != nil {
-		return fmt.Errorf("WS connect: %w", err)
+		return nil, fmt.Errorf("WS connect: %w", err)
 	}
 	ws.MaxPayloadBytes = 16 << 20 // 16 MB for large get_states
 
@@ -190,7 +183,7 @@ func (h *haConn) ensureConnected() error {
 	ws.SetReadDeadline(time.Now().Add(15 * time.Second))
 	if err := websocket.JSON.Receive(ws, &greeting); err != nil {
 		ws.Close()
-		return fmt.Errorf("WS greeting: %w", err)
+		return nil, fmt.Errorf("WS greeting: %w", err)
 	}
 
 	// Authenticate
@@ -199,34 +192,34 @@ func (h *haConn) ensureConnected() error {
 		"type": "auth", "access_token": cfg.Token,
 	}); err != nil {
 		ws.Close()
-		return fmt.Errorf("WS send auth: %w", err)
+		return nil, fmt.Errorf("WS send auth: %w", err)
 	}
 
 	var authResp map[string]interface{}
 	ws.SetReadDeadline(time.Now().Add(15 * time.Second))
 	if err := websocket.JSON.Receive(ws, &authResp); err != nil {
 		ws.Close()
-		return fmt.Errorf("WS auth response: %w", err)
+		return nil, fmt.Errorf("WS auth response: %w", err)
 	}
 	if authResp["type"] != "auth_ok" {
 		ws.Close()
-		return fmt.Errorf("WS auth failed: %v", authResp["message"])
-	}
-
-	h.conn = ws
-	h.seq = 0
-
-	if err := h.loadCaches(); err != nil {
-		h.disconnect()
-		return fmt.Errorf("load caches: %w", err)
+		return nil, fmt.Errorf("WS auth failed: %v", authResp["message"])
 	}
-
-	return nil
+	return ws, nil
 }
 
 // sendCmd sends a WS command and reads the matching result.
+// Cancelling ctx unblocks the pending read and drops the connection
+// (it is re-established on the next call).
 // Must be called under h.mu lock.
-func (h *haConn) sendCmd(cmdType string, extra map[string]interface{}) (json.RawMessage, error) {
+func (h *haConn) sendCmd(ctx context.Context, cmdType string, extra map[string]interface{}) (json.RawMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn := h.conn
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
 	h.seq++
 	id := h.seq
 
@@ -246,6 +239,9 @@ func (h *haConn) sendCmd(cmdType string, extra map[string]interface{}) (json.Raw
 		var msg wsMsg
 		if err := websocket.JSON.Receive(h.conn, &msg); err != nil {
 			h.disconnect()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			return nil, fmt.Errorf("WS recv %s: %w", cmdType, err)
 		}
 		if msg.ID == id && msg.Type == "result" {
@@ -262,7 +258,7 @@ func (h *haConn) sendCmd(cmdType string, extra map[string]interface{}) (json.Raw
 	}
 }
 
-func (h *haConn) loadCaches() error {
+func (h *haConn) loadCaches(ctx context.Context) error {
 	cmds := []string{
 		"config/area_registry/list",
 		"config/floor_registry/list",
@@ -273,7 +269,7 @@ func (h *haConn) loadCaches() error {
 
 	results := make([]json.RawMessage, len(cmds))
 	for i, cmd := range cmds {
-		r, err := h.sendCmd(cmd, nil)
+		r, err := h.sendCmd(ctx, cmd, nil)
 		if err != nil {
 			return err
 		}
@@ -313,8 +309,8 @@ func (h *haConn) loadCaches() error {
 	return nil
 }
 
-func (h *haConn) refreshStates() error {
-	result, err := h.sendCmd("get_states", nil)
+func (h *haConn) refreshStates(ctx context.Context) error {
+	result, err := h.sendCmd(ctx, "get_states", nil)
 	if err != nil {
 		return err
 	}
@@ -474,7 +470,7 @@ func formatEntityState(es entityState) string {
 
 // --- Tool executors ---
 
-func execHAList(rawArgs json.RawMessage) (string, error) {
+func execHAList(ctx context.Context, _ *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Target string `json:"target"`
 		Domain string `json:"domain"`
@@ -486,7 +482,7 @@ func execHAList(rawArgs json.RawMessage) (string, error) {
 
 	haWS.mu.Lock()
 	defer haWS.mu.Unlock()
-	if err := haWS.ensureConnected(); err != nil {
+	if err := haWS.ensureConnected(ctx); err != nil {
 		return "", err
 	}
 
@@ -550,7 +546,7 @@ func execHAList(rawArgs json.RawMessage) (string, error) {
 	return result, nil
 }
 
-func execHAState(rawArgs json.RawMessage) (string, error) {
+func execHAState(ctx context.Context, _ *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		EntityID string `json:"entity_id"`
 	}
@@ -561,7 +557,7 @@ func execHAState(rawArgs json.RawMessage) (string, error) {
 
 	haWS.mu.Lock()
 	defer haWS.mu.Unlock()
-	if err := haWS.ensureConnected(); err != nil {
+	if err := haWS.ensureConnected(ctx); err != nil {
 		return "", err
 	}
 
@@ -572,7 +568,7 @@ func execHAState(rawArgs json.RawMessage) (string, error) {
 	return formatEntityState(es), nil
 }
 
-func execHACall(rawArgs json.RawMessage) (string, error) {
+func execHACall(ctx context.Context, _ *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Domain   string `json:"domain"`
 		Service  string `json:"service"`
@@ -586,7 +582,7 @@ func execHACall(rawArgs json.RawMessage) (string, error) {
 
 	haWS.mu.Lock()
 	defer haWS.mu.Unlock()
-	if err := haWS.ensureConnected(); err != nil {
+	if err := haWS.ensureConnected(ctx); err != nil {
 		return "", err
 	}
 
@@ -597,7 +593,7 @@ func execHACall(rawArgs json.RawMessage) (string, error) {
 		}
 	}
 
-	_, err := haWS.sendCmd("call_service", map[string]interface{}{
+	_, err := haWS.sendCmd(ctx, "call_service", map[string]interface{}{
 		"domain":       args.Domain,
 		"service":      args.Service,
 		"target":       map[string]string{"entity_id": args.EntityID},
@@ -609,7 +605,7 @@ func execHACall(rawArgs json.RawMessage) (string, error) {
 
 	// Wait for state to settle, then refresh cache
 	time.Sleep(500 * time.Millisecond)
-	if err := haWS.refreshStates(); err != nil {
+	if err := haWS.refreshStates(ctx); err != nil {
 		return "Service called successfully, but failed to read new state.", nil
 	}
 
@@ -620,7 +616,7 @@ func execHACall(rawArgs json.RawMessage) (string, error) {
 	return formatEntityState(es), nil
 }
 
-func execHACameraSnapshot(rawArgs json.RawMessage) (string, error) {
+func execHACameraSnapshot(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		EntityID string `json:"entity_id"`
 	}
@@ -635,7 +631,7 @@ func execHACameraSnapshot(rawArgs json.RawMessage) (string, error) {
 	}
 
 	url := cfg.URL + "/api/camera_proxy/" + args.EntityID
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", fmt.Errorf("create request: %w", err)
 	}
@@ -663,7 +659,7 @@ func execHACameraSnapshot(rawArgs json.RawMessage) (string, error) {
 	}
 
 	dataURI := fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(data))
-	SetPendingImages([]string{dataURI})
+	sess.SetPendingImages([]string{dataURI})
 
 	return fmt.Sprintf("Snapshot captured from %s (%d bytes, %s). The image is attached.", args.EntityID, len(data), contentType), nil
 }
@@ -748,6 +744,7 @@ func init() {
 			},
 		},
 		Execute: execHACall,
+		Writes:  true,
 	})
 
 	Register(&Tool{
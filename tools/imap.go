@@ -2,14 +2,12 @@ package tools
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime"
-	"runtime"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	netmail "net/mail"
@@ -30,52 +28,43 @@ type ImapUserConfig struct {
 	Password string `json:"password"`
 }
 
-var imapOverrides sync.Map // goroutineID → *ImapUserConfig
-
-// goroutineID extracts the current goroutine ID from runtime.Stack().
-func goroutineID() int64 {
-	var buf [64]byte
-	n := runtime.Stack(buf[:], false)
-	// Stack starts with "goroutine <id> [..."
-	s := string(buf[:n])
-	s = s[len("goroutine "):]
-	s = s[:strings.IndexByte(s, ' ')]
-	id, _ := strconv.ParseInt(s, 10, 64)
-	return id
+// ImapAvailable returns true if the session has an IMAP config.
+func (s *Session) ImapAvailable() bool {
+	return s != nil && s.Imap != nil
 }
 
-// SetImapOverride sets the IMAP config for the current goroutine.
-func SetImapOverride(cfg *ImapUserConfig) {
-	imapOverrides.Store(goroutineID(), cfg)
+func (s *Session) imapConfig() (*ImapUserConfig, error) {
+	if !s.ImapAvailable() {
+		return nil, fmt.Errorf("no IMAP config for this context (use -user or configure telegram_id in users.json)")
+	}
+	return s.Imap, nil
 }
 
-// ClearImapOverride removes the IMAP config for the current goroutine.
-func ClearImapOverride() {
-	imapOverrides.Delete(goroutineID())
-}
+// imapDial opens the connection to the server; tests replace it to reach a
+// local server without TLS.
+var imapDial = imapclient.DialTLS
 
-// ImapAvailable returns true if the current goroutine has an IMAP override set.
-func ImapAvailable() bool {
-	_, ok := imapOverrides.Load(goroutineID())
-	return ok
+// dialIMAP connects and logs in. The connection is closed when ctx is
+// cancelled, which makes any pending command's Wait() return an error.
+func dialIMAP(ctx context.Context, sess *Session) (*imapclient.Client, error) {
+	return dialIMAPWith(ctx, sess, nil)
 }
 
-func getImapConfig() (*ImapUserConfig, error) {
-	if v, ok := imapOverrides.Load(goroutineID()); ok {
-		return v.(*ImapUserConfig), nil
-	}
-	return nil, fmt.Errorf("no IMAP config for this context (use -user or configure telegram_id in users.json)")
-}
-
-func dialIMAP() (*imapclient.Client, error) {
-	cfg, err := getImapConfig()
+// dialIMAPWith is dialIMAP with client options, e.g. a handler for
+// unilateral data.
+func dialIMAPWith(ctx context.Context, sess *Session, options *imapclient.Options) (*imapclient.Client, error) {
+	cfg, err := sess.imapConfig()
 	if err != nil {
 		return nil, err
 	}
-	c, err := imapclient.DialTLS(cfg.Server, nil)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c, err := imapDial(cfg.Server, options)
 	if err != nil {
 		return nil, fmt.Errorf("connect to %s failed: %w", cfg.Server, err)
 	}
+	context.AfterFunc(ctx, func() { c.Close() })
 	if err := c.Login(cfg.Username, cfg.Password).Wait(); err != nil {
 		c.Close()
 		return nil, fmt.Errorf("login failed: %w", err)
@@ -96,7 +85,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execListMailboxes,
+		Execute:         execListMailboxes,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -122,7 +112,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execListMessages,
+		Execute:         execListMessages,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -143,7 +134,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execReadMessage,
+		Execute:         execReadMessage,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -163,7 +155,8 @@ func init() {
 				},
 			},
 		},
-		Execute: execSummarizeMessage,
+		Execute:         execSummarizeMessage,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -184,12 +177,13 @@ func init() {
 				},
 			},
 		},
-		Execute: execDigestMessage,
+		Execute:         execDigestMessage,
+		ConcurrencySafe: true,
 	})
 }
 
-func execListMailboxes(rawArgs json.RawMessage) (string, error) {
-	c, err := dialIMAP()
+func execListMailboxes(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
+	c, err := dialIMAP(ctx, sess)
 	if err != nil {
 		return "", err
 	}
@@ -208,7 +202,7 @@ func execListMailboxes(rawArgs json.RawMessage) (string, error) {
 	return sb.String(), nil
 }
 
-func execListMessages(rawArgs json.RawMessage) (string, error) {
+func execListMessages(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Mailbox    string  `json:"mailbox"`
 		Limit      int     `json:"limit"`
@@ -232,7 +226,7 @@ func execListMessages(rawArgs json.RawMessage) (string, error) {
 		args.Limit = 50
 	}
 
-	c, err := dialIMAP()
+	c, err := dialIMAP(ctx, sess)
 	if err != nil {
 		return "", err
 	}
@@ -426,8 +420,8 @@ type emailContent struct {
 }
 
 // fetchEmailContent fetches and parses an email by UID (read-only, no flags changed).
-func fetchEmailContent(mailbox string, uid uint32) (*emailContent, error) {
-	c, err := dialIMAP()
+func fetchEmailContent(ctx context.Context, sess *Session, mailbox string, uid uint32) (*emailContent, error) {
+	c, err := dialIMAP(ctx, sess)
 	if err != nil {
 		return nil, err
 	}
@@ -563,7 +557,7 @@ func fetchEmailContent(mailbox string, uid uint32) (*emailContent, error) {
 	return result, nil
 }
 
-func execReadMessage(rawArgs json.RawMessage) (string, error) {
+func execReadMessage(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Mailbox   string `json:"mailbox"`
 		UID       uint32 `json:"uid"`
@@ -578,7 +572,7 @@ func execReadMessage(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("uid is required")
 	}
 
-	email, err := fetchEmailContent(args.Mailbox, args.UID)
+	email, err := fetchEmailContent(ctx, sess, args.Mailbox, args.UID)
 	if err != nil {
 		return "", err
 	}
@@ -611,7 +605,7 @@ func execReadMessage(rawArgs json.RawMessage) (string, error) {
 	return result, nil
 }
 
-func execSummarizeMessage(rawArgs json.RawMessage) (string, error) {
+func execSummarizeMessage(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Mailbox string `json:"mailbox"`
 		UID     uint32 `json:"uid"`
@@ -628,7 +622,7 @@ func execSummarizeMessage(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("sub-agent not available")
 	}
 
-	email, err := fetchEmailContent(args.Mailbox, args.UID)
+	email, err := fetchEmailContent(ctx, sess, args.Mailbox, args.UID)
 	if err != nil {
 		return "", err
 	}
@@ -649,7 +643,7 @@ func execSummarizeMessage(rawArgs json.RawMessage) (string, error) {
 		content = content[:60000] + "\n[...truncated]"
 	}
 
-	summary, err := SubAgentFn(systemPrompt, content)
+	summary, err := SubAgentFn(ctx, systemPrompt, content)
 	if err != nil {
 		return "", fmt.Errorf("summarization failed: %w", err)
 	}
@@ -659,8 +653,8 @@ func execSummarizeMessage(rawArgs json.RawMessage) (string, error) {
 }
 
 // searchRelatedMessages searches a mailbox for messages involving a participant within a time window.
-func searchRelatedMessages(mailbox, participant string, sinceHours float64, limit int) ([]RelatedMsg, error) {
-	c, err := dialIMAP()
+func searchRelatedMessages(ctx context.Context, sess *Session, mailbox, participant string, sinceHours float64, limit int) ([]RelatedMsg, error) {
+	c, err := dialIMAP(ctx, sess)
 	if err != nil {
 		return nil, err
 	}
@@ -781,7 +775,7 @@ type MailDigestConfig struct {
 
 // FetchUnreadGrouped fetches unread emails from INBOX, groups them by sender,
 // and retrieves conversation history for each group.
-func FetchUnreadGrouped(cfg MailDigestConfig) ([]SenderGroup, error) {
+func FetchUnreadGrouped(ctx context.Context, sess *Session, cfg MailDigestConfig) ([]SenderGroup, error) {
 	if cfg.SentMailbox == "" {
 		cfg.SentMailbox = "Sent"
 	}
@@ -796,7 +790,7 @@ func FetchUnreadGrouped(cfg MailDigestConfig) ([]SenderGroup, error) {
 		progress = func(string) {}
 	}
 
-	c, err := dialIMAP()
+	c, err := dialIMAP(ctx, sess)
 	if err != nil {
 		return nil, err
 	}
@@ -896,7 +890,7 @@ func FetchUnreadGrouped(cfg MailDigestConfig) ([]SenderGroup, error) {
 
 		// Fetch full content for each email
 		for i, e := range emails {
-			content, err := fetchEmailContent("INBOX", e.UID)
+			content, err := fetchEmailContent(ctx, sess, "INBOX", e.UID)
 			if err != nil {
 				continue
 			}
@@ -911,8 +905,8 @@ func FetchUnreadGrouped(cfg MailDigestConfig) ([]SenderGroup, error) {
 		g.Emails = emails
 
 		// Search conversation history in INBOX + Sent
-		inboxMsgs, _ := searchRelatedMessages("INBOX", addr, cfg.ContextHours, 15)
-		sentMsgs, _ := searchRelatedMessages(cfg.SentMailbox, addr, cfg.ContextHours, 15)
+		inboxMsgs, _ := searchRelatedMessages(ctx, sess, "INBOX", addr, cfg.ContextHours, 15)
+		sentMsgs, _ := searchRelatedMessages(ctx, sess, cfg.SentMailbox, addr, cfg.ContextHours, 15)
 
 		// Dedup: exclude unread UIDs from inbox history
 		for _, r := range inboxMsgs {
@@ -928,7 +922,7 @@ func FetchUnreadGrouped(cfg MailDigestConfig) ([]SenderGroup, error) {
 	return groups, nil
 }
 
-func execDigestMessage(rawArgs json.RawMessage) (string, error) {
+func execDigestMessage(ctx context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Mailbox      string  `json:"mailbox"`
 		UID          uint32  `json:"uid"`
@@ -953,7 +947,7 @@ func execDigestMessage(rawArgs json.RawMessage) (string, error) {
 	}
 
 	// 1. Fetch the target email
-	email, err := fetchEmailContent(args.Mailbox, args.UID)
+	email, err := fetchEmailContent(ctx, sess, args.Mailbox, args.UID)
 	if err != nil {
 		return "", err
 	}
@@ -965,8 +959,8 @@ func execDigestMessage(rawArgs json.RawMessage) (string, error) {
 
 	hasHistory := false
 	if email.FromAddr != "" {
-		inboxMsgs, _ := searchRelatedMessages(args.Mailbox, email.FromAddr, args.ContextHours, 15)
-		sentMsgs, _ := searchRelatedMessages(args.SentMailbox, email.FromAddr, args.ContextHours, 15)
+		inboxMsgs, _ := searchRelatedMessages(ctx, sess, args.Mailbox, email.FromAddr, args.ContextHours, 15)
+		sentMsgs, _ := searchRelatedMessages(ctx, sess, args.SentMailbox, email.FromAddr, args.ContextHours, 15)
 
 		sb.WriteString("\n=== CONVERSATION HISTORY ===\n")
 		for _, r := range inboxMsgs {
@@ -1002,7 +996,7 @@ func execDigestMessage(rawArgs json.RawMessage) (string, error) {
 Respond in the same language as the email content.`
 	}
 
-	summary, err := SubAgentFn(systemPrompt, content)
+	summary, err := SubAgentFn(ctx, systemPrompt, content)
 	if err != nil {
 		return "", fmt.Errorf("digest failed: %w", err)
 	}
@@ -0,0 +1,204 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Mailbox watching
+//
+// WatchImap keeps a connection with the mailbox selected and waits in IDLE
+// for new messages; servers without IDLE are polled. New messages are found
+// by UID — everything above the highest UID seen — so messages that arrived
+// while the connection was down are reported after the reconnect, unless
+// the mailbox's UIDVALIDITY changed. Messages already in the mailbox when
+// the watch starts are not reported.
+
+var (
+	imapWatchRecheck    = 10 * time.Minute // IDLE is left to check the mailbox at least this often
+	imapWatchPoll       = time.Minute      // check interval for servers without IDLE
+	imapWatchMinBackoff = 5 * time.Second
+	imapWatchMaxBackoff = 5 * time.Minute
+)
+
+// ImapNewMessage is a message that arrived in a watched mailbox.
+type ImapNewMessage struct {
+	Mailbox string
+	UID     uint32
+	From    string
+	Subject string
+}
+
+// imapWatchState is what a watch remembers across reconnects.
+type imapWatchState struct {
+	uidValidity uint32
+	lastUID     imap.UID
+}
+
+// WatchImap calls found for every new message of mailbox until ctx is
+// done. found gets the envelope only and is called between IMAP commands,
+// so anything slow, such as reading the message, belongs in another
+// goroutine. The error is for a session without an IMAP account; a lost
+// connection is just logged and reopened.
+func WatchImap(ctx context.Context, sess *Session, mailbox string, found func(ImapNewMessage), logf func(string, ...any)) error {
+	if _, err := sess.imapConfig(); err != nil {
+		return err
+	}
+	var state imapWatchState
+	reconnectLoop(ctx, "IMAP watch "+mailbox, imapWatchMinBackoff, imapWatchMaxBackoff,
+		func() error { return state.watch(ctx, sess, mailbox, found) }, logf)
+	return nil
+}
+
+// watch runs one connection until it fails.
+func (w *imapWatchState) watch(ctx context.Context, sess *Session, mailbox string, found func(ImapNewMessage)) error {
+	ctx, cancel := context.WithCancel(ctx) // closes the connection on return
+	defer cancel()
+
+	// EXISTS during IDLE ends it
+	changed := make(chan struct{}, 1)
+	c, err := dialIMAPWith(ctx, sess, &imapclient.Options{
+		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
+			Mailbox: func(data *imapclient.UnilateralDataMailbox) {
+				if data.NumMessages != nil {
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	sel, err := c.Select(mailbox, &imap.SelectOptions{ReadOnly: true}).Wait()
+	if err != nil {
+		return fmt.Errorf("SELECT %s failed: %w", mailbox, err)
+	}
+	if w.uidValidity != sel.UIDValidity {
+		// First connection or a recreated mailbox: only later messages count
+		w.uidValidity, w.lastUID = sel.UIDValidity, 0
+		if sel.UIDNext > 0 {
+			w.lastUID = sel.UIDNext - 1
+		} else if err := w.check(c, mailbox, nil); err != nil {
+			return err
+		}
+	}
+
+	idle := c.Caps().Has(imap.CapIdle) || c.Caps().Has(imap.CapIMAP4rev2)
+	for {
+		if err := w.check(c, mailbox, found); err != nil {
+			return err
+		}
+		if !idle {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(imapWatchPoll):
+			}
+			continue
+		}
+		if err := waitIdle(ctx, c, changed); err != nil {
+			return err
+		}
+	}
+}
+
+// check reports the messages above lastUID and moves it; with found nil it
+// only moves lastUID.
+func (w *imapWatchState) check(c *imapclient.Client, mailbox string, found func(ImapNewMessage)) error {
+	criteria := &imap.SearchCriteria{UID: []imap.UIDSet{{{Start: w.lastUID + 1, Stop: 0}}}}
+	data, err := c.UIDSearch(criteria, nil).Wait()
+	if err != nil {
+		return fmt.Errorf("UID SEARCH failed: %w", err)
+	}
+	var uids []imap.UID
+	for _, uid := range data.AllUIDs() {
+		if uid > w.lastUID { // "n:*" always includes the last message
+			uids = append(uids, uid)
+		}
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+	if found == nil {
+		w.lastUID = slices.Max(uids)
+		return nil
+	}
+
+	msgs, err := c.Fetch(imap.UIDSetNum(uids...), &imap.FetchOptions{Envelope: true, UID: true}).Collect()
+	if err != nil {
+		return fmt.Errorf("FETCH failed: %w", err)
+	}
+	slices.SortFunc(msgs, func(a, b *imapclient.FetchMessageBuffer) int { return cmp.Compare(a.UID, b.UID) })
+	for _, m := range msgs {
+		msg := ImapNewMessage{Mailbox: mailbox, UID: uint32(m.UID)}
+		if m.Envelope != nil {
+			msg.From = fmtImapAddrs(m.Envelope.From)
+			msg.Subject = decodeHeader(m.Envelope.Subject)
+		}
+		found(msg)
+		w.lastUID = max(w.lastUID, m.UID)
+	}
+	return nil
+}
+
+// waitIdle waits in IDLE until the mailbox changes or imapWatchRecheck
+// passes.
+func waitIdle(ctx context.Context, c *imapclient.Client, changed <-chan struct{}) error {
+	cmd, err := c.Idle()
+	if err != nil {
+		return fmt.Errorf("IDLE failed: %w", err)
+	}
+	ended := make(chan error, 1)
+	go func() { ended <- cmd.Wait() }()
+
+	timer := time.NewTimer(imapWatchRecheck)
+	defer timer.Stop()
+	select {
+	case err := <-ended:
+		if err == nil {
+			err = errors.New("ended by the server")
+		}
+		return fmt.Errorf("IDLE: %w", err)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-changed:
+	case <-timer.C:
+	}
+	if err := cmd.Close(); err != nil {
+		return fmt.Errorf("IDLE: %w", err)
+	}
+	if err := <-ended; err != nil {
+		return fmt.Errorf("IDLE: %w", err)
+	}
+	return nil
+}
+
+// ImapMarkSeen sets the \Seen flag of a message.
+func ImapMarkSeen(ctx context.Context, sess *Session, mailbox string, uid uint32) error {
+	c, err := dialIMAP(ctx, sess)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if _, err := c.Select(mailbox, nil).Wait(); err != nil {
+		return fmt.Errorf("SELECT %s failed: %w", mailbox, err)
+	}
+	flags := &imap.StoreFlags{Op: imap.StoreFlagsAdd, Silent: true, Flags: []imap.Flag{imap.FlagSeen}}
+	if err := c.Store(imap.UIDSetNum(imap.UID(uid)), flags, nil).Close(); err != nil {
+		return fmt.Errorf("STORE failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+)
+
+// imapTestServer serves mem on addr ("" = a free port) without TLS.
+func imapTestServer(t *testing.T, mem *imapmemserver.Server, addr string) (*imapserver.Server, string) {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := imapserver.New(&imapserver.Options{
+		NewSession: func(*imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return mem.NewSession(), nil, nil
+		},
+		Caps:         imap.CapSet{imap.CapIMAP4rev1: {}},
+		InsecureAuth: true,
+		Logger:       testLogger{t},
+	})
+	go srv.Serve(ln)
+	return srv, ln.Addr().String()
+}
+
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Printf(format string, args ...any) { l.t.Logf(format, args...) }
+
+func appendTestMessage(t *testing.T, user *imapmemserver.User, subject string) {
+	t.Helper()
+	msg := "From: Alice <alice@example.com>\r\nSubject: " + subject + "\r\n\r\nHello\r\n"
+	if _, err := user.Append("INBOX", bytes.NewReader([]byte(msg)), &imap.AppendOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchImap(t *testing.T) {
+	defer func(dial func(string, *imapclient.Options) (*imapclient.Client, error), backoff time.Duration) {
+		imapDial, imapWatchMinBackoff = dial, backoff
+	}(imapDial, imapWatchMinBackoff)
+	imapDial = imapclient.DialInsecure
+	imapWatchMinBackoff = 50 * time.Millisecond
+
+	mem := imapmemserver.New()
+	user := imapmemserver.NewUser("bob", "secret")
+	if err := user.Create("INBOX", nil); err != nil {
+		t.Fatal(err)
+	}
+	mem.AddUser(user)
+	appendTestMessage(t, user, "old")
+	srv, addr := imapTestServer(t, mem, "")
+
+	sess := NewSession()
+	sess.Imap = &ImapUserConfig{Server: addr, Username: "bob", Password: "secret"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	found := make(chan ImapNewMessage, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- WatchImap(ctx, sess, "INBOX", func(m ImapNewMessage) { found <- m }, t.Logf)
+	}()
+	next := func() ImapNewMessage {
+		t.Helper()
+		select {
+		case m := <-found:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("no new message reported")
+			return ImapNewMessage{}
+		}
+	}
+
+	// Wait until the watch is in IDLE: it reports a message appended then
+	// without a reconnect
+	time.Sleep(200 * time.Millisecond)
+	appendTestMessage(t, user, "first")
+	if m := next(); m.Subject != "first" || m.UID != 2 || m.From != "Alice <alice@example.com>" {
+		t.Errorf("new message = %+v", m)
+	}
+
+	// Mail that arrives while the server is away is reported after the reconnect
+	srv.Close()
+	appendTestMessage(t, user, "while down")
+	srv, _ = imapTestServer(t, mem, addr)
+	defer srv.Close()
+	if m := next(); m.Subject != "while down" || m.UID != 3 {
+		t.Errorf("after reconnect = %+v", m)
+	}
+	select {
+	case m := <-found:
+		t.Errorf("reported again: %+v", m)
+	default:
+	}
+
+	if err := ImapMarkSeen(ctx, sess, "INBOX", 3); err != nil {
+		t.Fatal(err)
+	}
+	status, err := user.Status("INBOX", &imap.StatusOptions{NumUnseen: true})
+	if err != nil || *status.NumUnseen != 2 {
+		t.Errorf("unseen after marking = %+v, %v", status, err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("WatchImap = %v", err)
+	}
+	if err := WatchImap(context.Background(), NewSession(), "INBOX", nil, t.Logf); err == nil {
+		t.Error("watched without an IMAP config")
+	}
+}
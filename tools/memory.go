@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -11,29 +12,17 @@ import (
 	"time"
 )
 
-// --- Per-goroutine memory path (same pattern as IMAP overrides) ---
+// --- Session memory path ---
 
-var memoryOverrides sync.Map // goroutineID → string (dir path)
-
-func SetMemoryOverride(path string) {
-	memoryOverrides.Store(goroutineID(), path)
-}
-
-func ClearMemoryOverride() {
-	memoryOverrides.Delete(goroutineID())
-}
-
-func MemoryAvailable() bool {
-	_, ok := memoryOverrides.Load(goroutineID())
-	return ok
+func (s *Session) MemoryAvailable() bool {
+	return s != nil && s.MemoryDir != ""
 }
 
-func getMemoryPath() (string, error) {
-	v, ok := memoryOverrides.Load(goroutineID())
-	if !ok {
+func (s *Session) memoryPath() (string, error) {
+	if !s.MemoryAvailable() {
 		return "", fmt.Errorf("memory not configured")
 	}
-	return v.(string), nil
+	return s.MemoryDir, nil
 }
 
 // --- Data model ---
@@ -140,6 +129,7 @@ Both can be combined in one call.`,
 			},
 		},
 		Execute: execMemStore,
+		Writes:  true,
 	})
 
 	Register(&Tool{
@@ -159,7 +149,8 @@ Both can be combined in one call.`,
 				},
 			},
 		},
-		Execute: execMemSearch,
+		Execute:         execMemSearch,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -177,7 +168,8 @@ Both can be combined in one call.`,
 				},
 			},
 		},
-		Execute: execMemRecall,
+		Execute:         execMemRecall,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -196,6 +188,7 @@ Both can be combined in one call.`,
 			},
 		},
 		Execute: execMemForget,
+		Writes:  true,
 	})
 
 	// --- Session-scoped temporary storage ---
@@ -239,8 +232,8 @@ Both can be combined in one call.`,
 
 // --- Tool implementations ---
 
-func execMemStore(rawArgs json.RawMessage) (string, error) {
-	dir, err := getMemoryPath()
+func execMemStore(_ context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
+	dir, err := sess.memoryPath()
 	if err != nil {
 		return "", err
 	}
@@ -337,8 +330,8 @@ func execMemStore(rawArgs json.RawMessage) (string, error) {
 	return strings.Join(parts, "\n"), nil
 }
 
-func execMemSearch(rawArgs json.RawMessage) (string, error) {
-	dir, err := getMemoryPath()
+func execMemSearch(_ context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
+	dir, err := sess.memoryPath()
 	if err != nil {
 		return "", err
 	}
@@ -425,8 +418,8 @@ func execMemSearch(rawArgs json.RawMessage) (string, error) {
 	return sb.String(), nil
 }
 
-func execMemRecall(rawArgs json.RawMessage) (string, error) {
-	dir, err := getMemoryPath()
+func execMemRecall(_ context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
+	dir, err := sess.memoryPath()
 	if err != nil {
 		return "", err
 	}
@@ -484,8 +477,8 @@ func execMemRecall(rawArgs json.RawMessage) (string, error) {
 	return sb.String(), nil
 }
 
-func execMemForget(rawArgs json.RawMessage) (string, error) {
-	dir, err := getMemoryPath()
+func execMemForget(_ context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
+	dir, err := sess.memoryPath()
 	if err != nil {
 		return "", err
 	}
@@ -619,8 +612,8 @@ func fmtEpisode(ep memoryEpisode) string {
 // MemoryLookup searches persistent memory for the given query and returns
 // a brief text summary. Returns "" if memory is not available or nothing found.
 // Intended for Go-level injection into sub-agent inputs.
-func MemoryLookup(query string) string {
-	dir, err := getMemoryPath()
+func MemoryLookup(sess *Session, query string) string {
+	dir, err := sess.memoryPath()
 	if err != nil {
 		return ""
 	}
@@ -657,25 +650,31 @@ func MemoryLookup(query string) string {
 
 // --- Session-scoped temporary storage ---
 
-var tempStore sync.Map // goroutineID → map[string]string
+func (s *Session) tempPut(key, value string) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	s.store.temp[key] = value
+}
 
-func getTempMap() map[string]string {
-	gid := goroutineID()
-	v, ok := tempStore.Load(gid)
-	if !ok {
-		m := make(map[string]string)
-		tempStore.Store(gid, m)
-		return m
+// tempSnapshot returns a copy of the session's temp data.
+func (s *Session) tempSnapshot() map[string]string {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	m := make(map[string]string, len(s.store.temp))
+	for k, v := range s.store.temp {
+		m[k] = v
 	}
-	return v.(map[string]string)
+	return m
 }
 
-// ClearTempMemory removes all session-scoped temp data for the current goroutine.
-func ClearTempMemory() {
-	tempStore.Delete(goroutineID())
+// ClearTemp removes all session-scoped temp data.
+func (s *Session) ClearTemp() {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	clear(s.store.temp)
 }
 
-func execTempPut(rawArgs json.RawMessage) (string, error) {
+func execTempPut(_ context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Key   string `json:"key"`
 		Value string `json:"value"`
@@ -685,18 +684,17 @@ func execTempPut(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("key is required")
 	}
 
-	m := getTempMap()
-	m[args.Key] = args.Value
+	sess.tempPut(args.Key, args.Value)
 	return fmt.Sprintf("Stored: %s (%d bytes)", args.Key, len(args.Value)), nil
 }
 
-func execTempGet(rawArgs json.RawMessage) (string, error) {
+func execTempGet(_ context.Context, sess *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		Key string `json:"key"`
 	}
 	json.Unmarshal(rawArgs, &args)
 
-	m := getTempMap()
+	m := sess.tempSnapshot()
 
 	if args.Key != "" {
 		v, ok := m[args.Key]
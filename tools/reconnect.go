@@ -0,0 +1,31 @@
+package tools
+
+import (
+	"context"
+	"time"
+)
+
+// reconnectLoop keeps a long-lived connection up until ctx is done. connect
+// runs one connection and returns when it fails; the failure is reported to
+// logf under name and connect is retried after a wait that doubles from
+// minWait up to maxWait. A connection that lasted longer than maxWait
+// starts the waits over.
+func reconnectLoop(ctx context.Context, name string, minWait, maxWait time.Duration, connect func() error, logf func(string, ...any)) {
+	wait := minWait
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := connect()
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxWait {
+			wait = minWait
+		}
+		logf("%s: %v; reconnecting in %s", name, err, wait)
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxWait)
+	}
+}
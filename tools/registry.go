@@ -1,9 +1,10 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
+	"sort"
 	"strings"
-	"sync"
 	"sync/atomic"
 )
 
@@ -34,24 +35,33 @@ type Definition struct {
 }
 
 // Tool binds a definition with its execution logic.
+// ctx is cancelled when the user aborts the query (/cancel, Stop, Ctrl+C);
+// tools doing network or subprocess work must honor it. sess is the
+// request's Session (user config, prompter, images).
 type Tool struct {
 	Def     Definition
-	Execute func(args json.RawMessage) (string, error)
+	Execute func(ctx context.Context, sess *Session, args json.RawMessage) (string, error)
+	// ConcurrencySafe marks tools that may run in parallel with other calls
+	// of the same round (no shared connection, no user interaction, no writes).
+	ConcurrencySafe bool
+	// Writes marks tools that change state outside the request (devices,
+	// calendars, files, stored memory); read-only sessions hide them.
+	Writes bool
 }
 
 var registry = map[string]*Tool{}
 
 // SubAgentFn is set by main to allow tools to make sub-agent AI calls.
 // Depth tracking and display are handled by the implementation in main.
-var SubAgentFn func(systemPrompt, userMessage string) (string, error)
+var SubAgentFn func(ctx context.Context, systemPrompt, userMessage string) (string, error)
 
 // SubAgentImageFn is like SubAgentFn but accepts image data URIs for vision tasks.
 // think overrides thinking mode: nil = use global default, *true = force enable, *false = force disable.
-var SubAgentImageFn func(systemPrompt, userMessage string, images []string, think *bool) (string, error)
+var SubAgentImageFn func(ctx context.Context, systemPrompt, userMessage string, images []string, think *bool) (string, error)
 
 // MCPCallFn is set by main to allow tools to call MCP server tools directly.
 // qualifiedName is "server__tool", e.g. "nutricalc__catalog_search".
-var MCPCallFn func(qualifiedName string, args json.RawMessage) (string, error)
+var MCPCallFn func(ctx context.Context, qualifiedName string, args json.RawMessage) (string, error)
 
 // SubAgentDepth tracks the current nesting level of sub-agent calls.
 var SubAgentDepth atomic.Int32
@@ -74,71 +84,28 @@ func Get(name string) (*Tool, bool) {
 	return t, ok
 }
 
-// pendingImages allows tools to return images alongside text results.
-// Key: goroutineID, Value: []string (data URIs).
-var pendingImages sync.Map
-
-// SetPendingImages stores image data URIs for the calling goroutine.
-// Called by tools that produce images (e.g. ha_camera_snapshot).
-func SetPendingImages(images []string) {
-	pendingImages.Store(goroutineID(), images)
-}
-
-// TakePendingImages retrieves and removes any images set by the last tool call.
-// Called by the execution loop after each tool execution.
-func TakePendingImages() []string {
-	v, ok := pendingImages.LoadAndDelete(goroutineID())
-	if !ok {
-		return nil
-	}
-	return v.([]string)
-}
-
-// sessionImageStore holds images produced by tools during a session,
-// indexed by sequential 1-based IDs so the model can reference them.
-// Key: goroutineID, Value: *[]string (data URIs, index+1 = image ID).
-var sessionImageStore sync.Map
-
-// AddSessionImage stores an image data URI and returns its 1-based ID.
-func AddSessionImage(uri string) int {
-	id := goroutineID()
-	val, _ := sessionImageStore.LoadOrStore(id, &[]string{})
-	imgs := val.(*[]string)
-	*imgs = append(*imgs, uri)
-	return len(*imgs)
-}
-
-// GetSessionImage returns the data URI for the given 1-based image ID.
-func GetSessionImage(imageID int) (string, bool) {
-	val, ok := sessionImageStore.Load(goroutineID())
-	if !ok {
-		return "", false
-	}
-	imgs := val.(*[]string)
-	if imageID < 1 || imageID > len(*imgs) {
-		return "", false
-	}
-	return (*imgs)[imageID-1], true
-}
-
-// ClearSessionImages removes all stored session images for the current goroutine.
-func ClearSessionImages() {
-	sessionImageStore.Delete(goroutineID())
-}
-
-// All returns the definitions of all registered tools.
-// Tools are filtered by prefix based on per-goroutine availability.
-func All() []Definition {
-	hideImap := !ImapAvailable()
-	hideHA := !HAAvailable()
-	hideCal := !CalendarAvailable()
-	hideCalWrite := hideCal || !CalendarWritable()
-	hideContacts := !ContactsAvailable()
-	hideContactsWrite := hideContacts || !ContactsWritable()
-	hideAsk := !AskAvailable()
-	hideImageSend := !ImageSenderAvailable()
-	hideMemory := !MemoryAvailable()
-	hideUserInfo := !UserInfoAvailable()
+// ConcurrencySafe reports whether the named tool may run in parallel with
+// other tool calls. Unknown (e.g. MCP) tools are not.
+func ConcurrencySafe(name string) bool {
+	t, ok := registry[name]
+	return ok && t.ConcurrencySafe
+}
+
+// All returns the definitions of all registered tools, sorted by name so
+// that the tools part of the prompt is identical between requests (prefix
+// caching). Tools are filtered by prefix based on what the session enables.
+func All(sess *Session) []Definition {
+	hideImap := !sess.ImapAvailable()
+	hideHA := !sess.HAAvailable()
+	hideCal := !sess.CalendarAvailable()
+	hideCalWrite := hideCal || !sess.CalendarWritable()
+	hideContacts := !sess.ContactsAvailable()
+	hideContactsWrite := hideContacts || !sess.ContactsWritable()
+	hideAsk := !sess.AskAvailable()
+	hideImageSend := !sess.ImageSenderAvailable()
+	hideMemory := !sess.MemoryAvailable()
+	hideUserInfo := !sess.UserInfoAvailable()
+	hideReminders := !sess.RemindersAvailable()
 
 	defs := make([]Definition, 0, len(registry))
 	for _, t := range registry {
@@ -174,11 +141,18 @@ func All() []Definition {
 		if hideUserInfo && strings.HasPrefix(name, "userinfo_") {
 			continue
 		}
-		if !VideoAvailable() && name == "video_get_frames" {
+		if hideReminders && strings.HasPrefix(name, "reminder_") {
+			continue
+		}
+		if !sess.VideoAvailable() && name == "video_get_frames" {
+			continue
+		}
+		if !sess.Allows(name) {
 			continue
 		}
 		defs = append(defs, t.Def)
 	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Function.Name < defs[j].Function.Name })
 	return defs
 }
 
@@ -194,15 +168,19 @@ func isContactsWriteTool(name string) bool {
 
 // CommandContext holds the input for a slash command handler.
 type CommandContext struct {
-	Text   string   // remaining text after /command
-	Images []string // base64 data URIs
+	Context context.Context // cancelled when the user aborts the command
+	Session *Session        // the user's tool session
+	Text    string          // remaining text after /command
+	Images  []string        // base64 data URIs
 }
 
 // Command describes a registered slash command.
 type Command struct {
-	Name       string
-	MCPServers []string
-	Handler    func(ctx *CommandContext) (string, error)
+	Name        string
+	Description string // shown in the bot's command menu and /help
+	MCPServers  []string
+	Writes      bool // changes state (e.g. a diary); refused in read-only sessions
+	Handler     func(ctx *CommandContext) (string, error)
 }
 
 var commands = map[string]*Command{}
@@ -215,3 +193,13 @@ func GetCommand(name string) *Command { return commands[name] }
 
 // IsCommand returns true if a command with this name is registered.
 func IsCommand(name string) bool { return commands[name] != nil }
+
+// Commands returns the registered commands sorted by name.
+func Commands() []*Command {
+	list := make([]*Command, 0, len(commands))
+	for _, c := range commands {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,491 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reminders
+//
+// reminder_create stores a reminder in a ReminderStore shared by all users
+// of the bot; the bot process watches the store and delivers due reminders
+// to the chat they were created in. A reminder either sends its text or,
+// in prompt mode, runs its text as a query and sends the answer. Times are
+// read and shown in the user's time zone from userinfo ("timezone" or
+// "tz"), so "every day at 9:00" stays at 9:00 across DST changes.
+
+// Reminder is a message the bot sends at a given time.
+type Reminder struct {
+	ID       string    `json:"id"`
+	User     string    `json:"user"`    // users.json name
+	ChatID   int64     `json:"chat_id"` // where it is delivered
+	Text     string    `json:"text"`
+	At       time.Time `json:"at"`               // next delivery
+	Repeat   string    `json:"repeat,omitempty"` // see reminderRepeats; "" = once
+	Prompt   bool      `json:"prompt,omitempty"` // Text is a query run at delivery
+	Timezone string    `json:"timezone"`         // wall clock for At and Repeat
+	Day      int       `json:"day,omitempty"`    // day of month of a monthly or yearly reminder
+	Fired    bool      `json:"fired,omitempty"`  // one-off already delivered, kept for snoozing
+
+	// Tool policy of the session that created a prompt reminder (a group's
+	// EnabledTools and ReadOnly), applied when its query runs
+	Tools    []string `json:"tools,omitempty"`
+	ReadOnly bool     `json:"read_only,omitempty"`
+}
+
+// reminderRepeats are the supported recurrences.
+var reminderRepeats = []string{"daily", "weekdays", "weekly", "monthly", "yearly"}
+
+// firedReminderTTL is how long a delivered one-off reminder can be snoozed.
+const firedReminderTTL = 24 * time.Hour
+
+// ReminderLocation is the time zone the user of sess sets and reads
+// reminders in: "timezone" or "tz" from userinfo, else the local zone.
+func ReminderLocation(sess *Session) *time.Location {
+	return userLocation(sess, "timezone", "tz")
+}
+
+// Location returns the reminder's time zone.
+func (r *Reminder) Location() *time.Location {
+	if loc, err := time.LoadLocation(r.Timezone); err == nil {
+		return loc
+	}
+	return time.Local
+}
+
+// advance moves a recurring reminder to its first occurrence after now.
+// A weekdays reminder set for a weekend starts on the Monday after. Monthly
+// and yearly ones keep the day they were set for (Day), falling back to the
+// last day of shorter months.
+func (r *Reminder) advance(now time.Time) {
+	if !slices.Contains(reminderRepeats, r.Repeat) {
+		return
+	}
+	t := r.At.In(r.Location())
+	if r.Day == 0 {
+		r.Day = t.Day()
+	}
+	if r.Repeat == "weekdays" {
+		t = skipWeekend(t)
+	}
+	for !t.After(now) {
+		switch r.Repeat {
+		case "daily":
+			t = t.AddDate(0, 0, 1)
+		case "weekdays":
+			t = skipWeekend(t.AddDate(0, 0, 1))
+		case "weekly":
+			t = t.AddDate(0, 0, 7)
+		case "monthly":
+			t = clampedDate(t.Year(), t.Month()+1, r.Day, t)
+		case "yearly":
+			t = clampedDate(t.Year()+1, t.Month(), r.Day, t)
+		}
+	}
+	r.At = t
+}
+
+func skipWeekend(t time.Time) time.Time {
+	for t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// clampedDate is day of the given month (normalized, so month 13 is January
+// of the next year) at the wall clock of clock, or the month's last day if
+// it is shorter.
+func clampedDate(year int, month time.Month, day int, clock time.Time) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, clock.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return time.Date(first.Year(), first.Month(), min(day, last), clock.Hour(), clock.Minute(), clock.Second(), 0, clock.Location())
+}
+
+// describe is the reminder's line in reminder_list.
+func (r *Reminder) describe() string {
+	line := fmt.Sprintf("%s: %s", r.ID, r.At.In(r.Location()).Format("Mon 2006-01-02 15:04 MST"))
+	if r.Repeat != "" {
+		line += ", " + r.Repeat
+	}
+	if r.Prompt {
+		line += ", prompt"
+	}
+	return line + " — " + r.Text
+}
+
+// ReminderStore is the persistent set of reminders of all users, kept in
+// one JSON file. It is safe for concurrent use.
+type ReminderStore struct {
+	mu      sync.Mutex
+	path    string
+	items   []*Reminder
+	lastID  int
+	changed chan struct{}
+}
+
+// OpenReminderStore loads the reminders in path; a missing file is an
+// empty store.
+func OpenReminderStore(path string) (*ReminderStore, error) {
+	s := &ReminderStore{path: path, changed: make(chan struct{}, 1)}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.items); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	for _, r := range s.items {
+		if n, err := strconv.Atoi(strings.TrimPrefix(r.ID, "r")); err == nil && n > s.lastID {
+			s.lastID = n
+		}
+	}
+	return s, nil
+}
+
+// Changed is signalled whenever a reminder is added or rescheduled, so
+// that the delivery loop can recompute its wait.
+func (s *ReminderStore) Changed() <-chan struct{} { return s.changed }
+
+// save writes the store. Caller must hold s.mu.
+func (s *ReminderStore) save() error {
+	data, err := json.MarshalIndent(s.items, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *ReminderStore) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Add stores r under a new ID and returns it.
+func (s *ReminderStore) Add(r Reminder) (Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	r.ID = "r" + strconv.Itoa(s.lastID)
+	s.items = append(s.items, &r)
+	if err := s.save(); err != nil {
+		s.items = s.items[:len(s.items)-1]
+		return Reminder{}, err
+	}
+	s.notify()
+	return r, nil
+}
+
+// List returns the user's pending reminders of chatID, soonest first. A
+// chat only sees its own: reminders set in a private chat stay out of a
+// group's list.
+func (s *ReminderStore) List(user string, chatID int64) []Reminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Reminder
+	for _, r := range s.items {
+		if r.User == user && r.ChatID == chatID && !r.Fired {
+			out = append(out, *r)
+		}
+	}
+	slices.SortFunc(out, func(a, b Reminder) int { return a.At.Compare(b.At) })
+	return out
+}
+
+// Cancel deletes one of the user's reminders of chatID.
+func (s *ReminderStore) Cancel(user string, chatID int64, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.items, func(r *Reminder) bool {
+		return r.ID == id && r.User == user && r.ChatID == chatID && !r.Fired
+	})
+	if i < 0 {
+		return fmt.Errorf("no reminder %s", id)
+	}
+	s.items = slices.Delete(s.items, i, i+1)
+	return s.save()
+}
+
+// Next returns when the next reminder is due, or the zero time if none is.
+func (s *ReminderStore) Next() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next time.Time
+	for _, r := range s.items {
+		if !r.Fired && (next.IsZero() || r.At.Before(next)) {
+			next = r.At
+		}
+	}
+	return next
+}
+
+// Due takes the reminders due at now for delivery: recurring ones move to
+// their next occurrence, one-off ones are kept as fired for a day so that
+// they can be snoozed. Reminders missed while the bot was down are
+// delivered once.
+func (s *ReminderStore) Due(now time.Time) []Reminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Reminder
+	changed := false
+	s.items = slices.DeleteFunc(s.items, func(r *Reminder) bool {
+		if r.Fired {
+			expired := now.Sub(r.At) > firedReminderTTL
+			changed = changed || expired
+			return expired
+		}
+		if r.At.After(now) {
+			return false
+		}
+		due = append(due, *r)
+		changed = true
+		if r.Repeat != "" {
+			r.advance(now)
+		} else {
+			r.Fired = true
+		}
+		return false
+	})
+	if changed {
+		if err := s.save(); err != nil {
+			// Delivery goes on; the reminders may fire again after a restart.
+			log.Printf("reminders: save %s: %v", s.path, err)
+		}
+	}
+	return due
+}
+
+// Snooze delivers reminder id of chatID again after d and returns the
+// snoozed reminder. A one-off reminder is re-armed; for a recurring one a
+// one-off copy is added, the recurrence stays as it was.
+func (s *ReminderStore) Snooze(chatID int64, id string, d time.Duration, now time.Time) (Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.items, func(r *Reminder) bool { return r.ID == id && r.ChatID == chatID })
+	if i < 0 {
+		return Reminder{}, fmt.Errorf("no reminder %s", id)
+	}
+	r := s.items[i]
+	if r.Repeat != "" {
+		c := *r
+		s.lastID++
+		c.ID, c.Repeat = "r"+strconv.Itoa(s.lastID), ""
+		s.items = append(s.items, &c)
+		r = &c
+	}
+	r.At, r.Fired = now.Add(d).Truncate(time.Minute), false
+	if err := s.save(); err != nil {
+		return Reminder{}, err
+	}
+	s.notify()
+	return *r, nil
+}
+
+// --- Session ---
+
+// Reminders connects a session to the bot's reminder store.
+type Reminders struct {
+	Store  *ReminderStore
+	ChatID int64 // chat that receives reminders created in the session
+}
+
+// RemindersAvailable reports whether reminder_* tools can be used: they
+// need a delivering bot and a named user.
+func (s *Session) RemindersAvailable() bool {
+	return s != nil && s.Reminders != nil && s.Reminders.Store != nil && s.UserName != ""
+}
+
+// parseReminderTime reads a local date and time in loc; an explicit
+// offset (RFC 3339) is honored.
+func parseReminderTime(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, want YYYY-MM-DD HH:MM", value)
+}
+
+// --- Tool registration ---
+
+func init() {
+	Register(&Tool{
+		Def: Definition{
+			Type: "function",
+			Function: Function{
+				Name: "reminder_create",
+				Description: "Create a reminder that the bot sends to this chat later, with buttons to snooze it. " +
+					"Give either 'at' or 'in_minutes'; 'at' is in the user's time zone (userinfo 'timezone'), " +
+					"which may differ from the server time in the request context. " +
+					"With prompt=true the text is an instruction you will run at that time, and its answer is sent instead " +
+					"(e.g. 'check the weather in Prague and remind me to take an umbrella if rain is expected'); " +
+					"such a run may decide that nothing needs to be sent.",
+				Parameters: Parameters{
+					Type: "object",
+					Properties: map[string]Property{
+						"text":       {Type: "string", Description: "What to remind about, as it should be shown to the user; with prompt=true, the instruction to run"},
+						"at":         {Type: "string", Description: "Local date and time in the user's time zone, 'YYYY-MM-DD HH:MM'"},
+						"in_minutes": {Type: "integer", Description: "Alternative to 'at': minutes from now"},
+						"repeat":     {Type: "string", Description: "Recurrence: " + strings.Join(reminderRepeats, ", ") + ". Default: once"},
+						"prompt":     {Type: "boolean", Description: "If true, run text as a query at that time and send the result. Default: false"},
+					},
+					Required: []string{"text"},
+				},
+			},
+		},
+		Execute: executeReminderCreate,
+		Writes:  true,
+	})
+
+	Register(&Tool{
+		Def: Definition{
+			Type: "function",
+			Function: Function{
+				Name:        "reminder_list",
+				Description: "List the user's pending reminders set in this chat, with their IDs, next time (user's time zone) and recurrence.",
+				Parameters: Parameters{
+					Type:       "object",
+					Properties: map[string]Property{},
+				},
+			},
+		},
+		Execute:         executeReminderList,
+		ConcurrencySafe: true,
+	})
+
+	Register(&Tool{
+		Def: Definition{
+			Type: "function",
+			Function: Function{
+				Name:        "reminder_cancel",
+				Description: "Cancel a pending reminder by ID (see reminder_list). Cancelling a recurring reminder stops all its future occurrences.",
+				Parameters: Parameters{
+					Type: "object",
+					Properties: map[string]Property{
+						"id": {Type: "string", Description: "Reminder ID, e.g. 'r12'"},
+					},
+					Required: []string{"id"},
+				},
+			},
+		},
+		Execute: executeReminderCancel,
+		Writes:  true,
+	})
+}
+
+// --- Tool handlers ---
+
+func executeReminderCreate(_ context.Context, sess *Session, args json.RawMessage) (string, error) {
+	if !sess.RemindersAvailable() {
+		return "", fmt.Errorf("reminders are only available in the Telegram bot")
+	}
+	var p struct {
+		Text      string `json:"text"`
+		At        string `json:"at"`
+		InMinutes int    `json:"in_minutes"`
+		Repeat    string `json:"repeat"`
+		Prompt    bool   `json:"prompt"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	p.Text = strings.TrimSpace(p.Text)
+	if p.Text == "" {
+		return "", fmt.Errorf("text is required")
+	}
+	if p.Repeat != "" && !slices.Contains(reminderRepeats, p.Repeat) {
+		return "", fmt.Errorf("repeat must be one of %s", strings.Join(reminderRepeats, ", "))
+	}
+
+	loc := ReminderLocation(sess)
+	now := time.Now()
+	var at time.Time
+	switch {
+	case p.At != "":
+		t, err := parseReminderTime(p.At, loc)
+		if err != nil {
+			return "", err
+		}
+		at = t
+	case p.InMinutes > 0:
+		at = now.Add(time.Duration(p.InMinutes) * time.Minute)
+	default:
+		return "", fmt.Errorf("at or in_minutes is required")
+	}
+	at = at.Truncate(time.Minute)
+	if !at.After(now) && p.Repeat == "" {
+		return "", fmt.Errorf("%s is in the past (now %s)", at.In(loc).Format("2006-01-02 15:04"), now.In(loc).Format("2006-01-02 15:04 MST"))
+	}
+
+	r := Reminder{
+		User:     sess.UserName,
+		ChatID:   sess.Reminders.ChatID,
+		Text:     p.Text,
+		At:       at,
+		Repeat:   p.Repeat,
+		Prompt:   p.Prompt,
+		Timezone: loc.String(),
+	}
+	if p.Prompt {
+		r.Tools, r.ReadOnly = sess.EnabledTools, sess.ReadOnly
+	}
+	r.advance(now)
+	r, err := sess.Reminders.Store.Add(r)
+	if err != nil {
+		return "", fmt.Errorf("save reminder: %w", err)
+	}
+	return "Reminder set. " + r.describe(), nil
+}
+
+func executeReminderList(_ context.Context, sess *Session, _ json.RawMessage) (string, error) {
+	if !sess.RemindersAvailable() {
+		return "", fmt.Errorf("reminders are only available in the Telegram bot")
+	}
+	list := sess.Reminders.Store.List(sess.UserName, sess.Reminders.ChatID)
+	if len(list) == 0 {
+		return "No pending reminders.", nil
+	}
+	lines := make([]string, len(list))
+	for i := range list {
+		lines[i] = list[i].describe()
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func executeReminderCancel(_ context.Context, sess *Session, args json.RawMessage) (string, error) {
+	if !sess.RemindersAvailable() {
+		return "", fmt.Errorf("reminders are only available in the Telegram bot")
+	}
+	var p struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if p.ID == "" {
+		return "", fmt.Errorf("id is required")
+	}
+	if err := sess.Reminders.Store.Cancel(sess.UserName, sess.Reminders.ChatID, p.ID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Reminder %s cancelled.", p.ID), nil
+}
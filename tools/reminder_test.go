@@ -0,0 +1,214 @@
+package tools
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReminderAdvance(t *testing.T) {
+	prague, err := time.LoadLocation("Europe/Prague")
+	if err != nil {
+		t.Skip("no tzdata")
+	}
+	// Friday 9:00 before the switch to summer time on Sunday 2026-03-29
+	at := time.Date(2026, 3, 27, 9, 0, 0, 0, prague)
+	for _, tc := range []struct {
+		repeat string
+		now    time.Time
+		want   time.Time
+	}{
+		{"daily", at.Add(time.Minute), time.Date(2026, 3, 28, 9, 0, 0, 0, prague)},
+		{"daily", time.Date(2026, 3, 29, 10, 0, 0, 0, prague), time.Date(2026, 3, 30, 9, 0, 0, 0, prague)},
+		{"weekdays", at, time.Date(2026, 3, 30, 9, 0, 0, 0, prague)},
+		{"weekly", at, time.Date(2026, 4, 3, 9, 0, 0, 0, prague)},
+		{"monthly", at, time.Date(2026, 4, 27, 9, 0, 0, 0, prague)},
+		{"", at.Add(time.Hour), at},
+	} {
+		r := Reminder{At: at, Repeat: tc.repeat, Timezone: "Europe/Prague"}
+		r.advance(tc.now)
+		if !r.At.Equal(tc.want) || r.At.In(prague).Hour() != 9 {
+			t.Errorf("%q after %v: %v, want %v", tc.repeat, tc.now, r.At, tc.want)
+		}
+	}
+}
+
+func TestReminderAdvance_KeepsDay(t *testing.T) {
+	at := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+	r := Reminder{At: at, Repeat: "monthly", Timezone: "UTC"}
+	var got []string
+	for range 3 {
+		r.advance(r.At)
+		got = append(got, r.At.Format("01-02"))
+	}
+	if strings.Join(got, " ") != "02-28 03-31 04-30" {
+		t.Errorf("monthly from Jan 31: %v", got)
+	}
+
+	r = Reminder{At: time.Date(2028, 2, 29, 9, 0, 0, 0, time.UTC), Repeat: "yearly", Timezone: "UTC"}
+	r.advance(time.Date(2031, 3, 1, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2032, 2, 29, 9, 0, 0, 0, time.UTC); !r.At.Equal(want) {
+		t.Errorf("yearly from Feb 29: %v, want %v", r.At, want)
+	}
+
+	// Saturday, before now: the first occurrence is Monday
+	r = Reminder{At: time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC), Repeat: "weekdays", Timezone: "UTC"}
+	r.advance(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC); !r.At.Equal(want) {
+		t.Errorf("weekdays from Saturday: %v, want %v", r.At, want)
+	}
+}
+
+func TestReminderStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reminders.json")
+	s, err := OpenReminderStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	once, _ := s.Add(Reminder{User: "alice", ChatID: 1, Text: "call mom", At: now.Add(time.Hour), Timezone: "UTC"})
+	daily, _ := s.Add(Reminder{User: "alice", ChatID: 1, Text: "pills", At: now.Add(30 * time.Minute), Repeat: "daily", Timezone: "UTC"})
+	other, _ := s.Add(Reminder{User: "bob", ChatID: 2, Text: "gym", At: now.Add(2 * time.Hour), Timezone: "UTC"})
+	if once.ID != "r1" || other.ID != "r3" {
+		t.Fatalf("ids = %s, %s", once.ID, other.ID)
+	}
+	if list := s.List("alice", 1); len(list) != 2 || list[0].ID != daily.ID {
+		t.Errorf("list = %+v", list)
+	}
+	if err := s.Cancel("alice", 1, other.ID); err == nil {
+		t.Error("cancelled another user's reminder")
+	}
+	if next := s.Next(); !next.Equal(daily.At) {
+		t.Errorf("next = %v", next)
+	}
+
+	due := s.Due(now.Add(90 * time.Minute))
+	if len(due) != 2 {
+		t.Fatalf("due = %+v", due)
+	}
+	if list := s.List("alice", 1); len(list) != 1 || !list[0].At.Equal(daily.At.AddDate(0, 0, 1)) {
+		t.Errorf("after delivery: %+v", list)
+	}
+
+	// The delivered one-off can be snoozed from its chat only
+	if _, err := s.Snooze(2, once.ID, 10*time.Minute, now); err == nil {
+		t.Error("snoozed from another chat")
+	}
+	r, err := s.Snooze(1, once.ID, 10*time.Minute, now.Add(90*time.Minute))
+	if err != nil || r.ID != once.ID || !r.At.Equal(now.Add(100*time.Minute)) {
+		t.Errorf("snooze = %+v, %v", r, err)
+	}
+	// Snoozing a recurring reminder adds a one-off copy
+	c, err := s.Snooze(1, daily.ID, time.Hour, now.Add(90*time.Minute))
+	if err != nil || c.ID != "r4" || c.Repeat != "" {
+		t.Errorf("snooze of recurring = %+v, %v", c, err)
+	}
+
+	reopened, err := OpenReminderStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := reopened.List("alice", 1); len(list) != 3 {
+		t.Errorf("reopened list = %+v", list)
+	}
+	if r, _ := reopened.Add(Reminder{User: "bob", ChatID: 2, Text: "x", At: now, Timezone: "UTC"}); r.ID != "r5" {
+		t.Errorf("id after reopen = %s", r.ID)
+	}
+}
+
+func TestReminderCreate(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenReminderStore(filepath.Join(dir, "reminders.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := NewSession()
+	if len(filterNames(All(sess), "reminder_")) != 0 {
+		t.Error("reminder tools shown without a store")
+	}
+	sess.UserName = "alice"
+	sess.UserInfo = filepath.Join(dir, "userinfo.json")
+	sess.Reminders = &Reminders{Store: store, ChatID: 7}
+	if err := userInfoSet(sess.userInfoConfig(), "timezone", UserInfoEntry{Value: "Asia/Tokyo"}); err != nil {
+		t.Fatal(err)
+	}
+	if loc := ReminderLocation(sess); loc.String() != "Asia/Tokyo" {
+		t.Errorf("ReminderLocation = %s", loc)
+	}
+	if len(filterNames(All(sess), "reminder_")) != 3 {
+		t.Error("reminder tools hidden")
+	}
+
+	ctx := context.Background()
+	if _, err := executeReminderCreate(ctx, sess, []byte(`{"text":"old","at":"2001-01-01 09:00"}`)); err == nil {
+		t.Error("created a reminder in the past")
+	}
+	if _, err := executeReminderCreate(ctx, sess, []byte(`{"text":"x","in_minutes":5,"repeat":"hourly"}`)); err == nil {
+		t.Error("accepted an unknown repeat")
+	}
+	out, err := executeReminderCreate(ctx, sess, []byte(`{"text":"standup","at":"2001-01-01 09:30","repeat":"weekdays"}`))
+	if err != nil || !strings.Contains(out, "09:30 JST, weekdays — standup") {
+		t.Fatalf("create = %q, %v", out, err)
+	}
+	list := store.List("alice", 7)
+	if len(list) != 1 || list[0].ChatID != 7 || list[0].Timezone != "Asia/Tokyo" || !list[0].At.After(time.Now()) {
+		t.Errorf("stored = %+v", list)
+	}
+
+	if _, err := executeReminderCancel(ctx, sess, []byte(`{"id":"`+list[0].ID+`"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := executeReminderList(ctx, sess, nil); out != "No pending reminders." {
+		t.Errorf("list after cancel = %q", out)
+	}
+
+	// A prompt reminder keeps the tool policy it was set under
+	sess.EnabledTools, sess.ReadOnly = []string{"web_fetch"}, true
+	if _, err := executeReminderCreate(ctx, sess, []byte(`{"text":"weather","in_minutes":5,"prompt":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	if list := store.List("alice", 7); len(list) != 1 || !list[0].ReadOnly || len(list[0].Tools) != 1 {
+		t.Errorf("stored = %+v", list)
+	}
+}
+
+func TestReminderList_PerChat(t *testing.T) {
+	store, err := OpenReminderStore(filepath.Join(t.TempDir(), "reminders.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	private, group := NewSession(), NewSession()
+	private.UserName, group.UserName = "alice", "alice"
+	private.Reminders = &Reminders{Store: store, ChatID: 1}
+	group.Reminders = &Reminders{Store: store, ChatID: -100}
+
+	if _, err := executeReminderCreate(ctx, private, []byte(`{"text":"see the doctor","in_minutes":30}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := executeReminderCreate(ctx, group, []byte(`{"text":"buy milk","in_minutes":60}`)); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := executeReminderList(ctx, group, nil)
+	if strings.Contains(out, "doctor") || !strings.Contains(out, "buy milk") {
+		t.Errorf("group list = %q", out)
+	}
+	if out, _ := executeReminderList(ctx, private, nil); strings.Contains(out, "milk") || !strings.Contains(out, "doctor") {
+		t.Errorf("private list = %q", out)
+	}
+	if _, err := executeReminderCancel(ctx, group, []byte(`{"id":"r1"}`)); err == nil {
+		t.Error("group session cancelled a private reminder")
+	}
+}
+
+func filterNames(defs []Definition, prefix string) []string {
+	var names []string
+	for _, d := range defs {
+		if strings.HasPrefix(d.Function.Name, prefix) {
+			names = append(names, d.Function.Name)
+		}
+	}
+	return names
+}
@@ -1,9 +1,9 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"sync"
 )
 
 // ImageSender sends an image to the user (e.g. via Telegram).
@@ -11,28 +11,9 @@ type ImageSender interface {
 	SendImage(dataURI string, caption string) error
 }
 
-// Goroutine-local image sender storage.
-var imageSenderOverrides sync.Map // goroutineID -> ImageSender
-
-// SetImageSender stores an ImageSender for the calling goroutine.
-func SetImageSender(s ImageSender) { imageSenderOverrides.Store(goroutineID(), s) }
-
-// ClearImageSender removes the ImageSender for the calling goroutine.
-func ClearImageSender() { imageSenderOverrides.Delete(goroutineID()) }
-
-// GetImageSender returns the ImageSender for the calling goroutine, or nil.
-func GetImageSender() ImageSender {
-	v, ok := imageSenderOverrides.Load(goroutineID())
-	if !ok {
-		return nil
-	}
-	return v.(ImageSender)
-}
-
-// ImageSenderAvailable returns true if an ImageSender is set for the current goroutine.
-func ImageSenderAvailable() bool {
-	_, ok := imageSenderOverrides.Load(goroutineID())
-	return ok
+// ImageSenderAvailable returns true if the session has an ImageSender.
+func (s *Session) ImageSenderAvailable() bool {
+	return s != nil && s.ImageSender != nil
 }
 
 type sendImageArgs struct {
@@ -70,8 +51,8 @@ func init() {
 	})
 }
 
-func execSendImage(args json.RawMessage) (string, error) {
-	sender := GetImageSender()
+func execSendImage(_ context.Context, sess *Session, args json.RawMessage) (string, error) {
+	sender := sess.ImageSender
 	if sender == nil {
 		return "", fmt.Errorf("send_image is not available in this mode")
 	}
@@ -84,7 +65,7 @@ func execSendImage(args json.RawMessage) (string, error) {
 		return "", fmt.Errorf("image_id must be a positive integer")
 	}
 
-	dataURI, ok := GetSessionImage(a.ImageID)
+	dataURI, ok := sess.Image(a.ImageID)
 	if !ok {
 		return fmt.Sprintf("Image #%d not found. No image with this ID was captured in the current session.", a.ImageID), nil
 	}
@@ -0,0 +1,180 @@
+package tools
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session is the per-request environment of the tools: which user they act
+// for, which integrations are enabled, and where questions and images go.
+// Build one per request with NewSession, fill in the configuration and pass
+// it to All and Tool.Execute. Configuration fields must not change while
+// tools run; the session stores (images, temp memory, video) are safe for
+// parallel tool calls.
+type Session struct {
+	UserName    string          // users.json name, the key inside the userinfo file
+	Imap        *ImapUserConfig // nil hides imap_* tools
+	HA          bool            // Home Assistant tools enabled
+	Calendar    *CalendarConfig // nil hides cal_* tools
+	Contacts    *ContactsConfig // nil hides contacts_* tools
+	MemoryDir   string          // persistent memory directory ("" hides memory_* tools)
+	UserInfo    string          // userinfo JSON file ("" hides userinfo_* tools)
+	Prompter    UserPrompter    // target of ask_user (nil hides it)
+	AskTimeout  time.Duration   // ask_user gives up waiting after this (0 = never)
+	ImageSender ImageSender     // target of send_image (nil hides it)
+	Reminders   *Reminders      // reminder store of the bot (nil hides reminder_* tools)
+
+	// Tool policy, e.g. for group chats; see Allows
+	EnabledTools []string // only these tools: names or "prefix*" patterns (nil = all)
+	ReadOnly     bool     // hides tools that change state (Tool.Writes) and unknown tools
+	ReadTools    []string // tools outside the registry that ReadOnly keeps, as in EnabledTools
+
+	store *sessionStore // shared by all views from ForCall
+	call  *callState    // output of the tool call being executed
+}
+
+// sessionStore holds the state that tools accumulate during a request.
+type sessionStore struct {
+	mu     sync.Mutex
+	images []string          // session images, index+1 = image ID
+	temp   map[string]string // memory_temp_put / memory_temp_get
+	video  *videoState
+}
+
+// callState is what a single tool call hands back besides its text.
+type callState struct {
+	mu         sync.Mutex
+	images     []string // see SetPendingImages
+	stripVideo bool     // see SetVideoFrameStrip
+}
+
+// NewSession returns an empty session: no user, no integrations.
+func NewSession() *Session {
+	return &Session{
+		store: &sessionStore{temp: map[string]string{}},
+		call:  &callState{},
+	}
+}
+
+// ForCall returns a view of s for one tool call that runs in parallel with
+// others: same configuration and stores, but its own pending images and
+// frame-strip signal.
+func (s *Session) ForCall() *Session {
+	c := *s
+	c.call = &callState{}
+	return &c
+}
+
+// --- Tool policy ---
+
+// Allows reports whether the session's tool policy permits the named tool,
+// built-in or MCP ("server__tool"). Hidden tools are also refused when
+// called, since a model may still try them. A read-only session cannot tell
+// what a tool outside the registry does, so it refuses it unless ReadTools
+// lists it.
+func (s *Session) Allows(name string) bool {
+	if s == nil {
+		return true
+	}
+	if s.ReadOnly {
+		t, ok := registry[name]
+		if ok && t.Writes || !ok && !matchTool(s.ReadTools, name) {
+			return false
+		}
+	}
+	return s.EnabledTools == nil || matchTool(s.EnabledTools, name)
+}
+
+// AllowsCommand reports whether the session's tool policy permits a
+// registered command. Commands are listed in EnabledTools as "/name";
+// read-only sessions refuse the ones that write.
+func (s *Session) AllowsCommand(cmd *Command) bool {
+	if s == nil {
+		return true
+	}
+	if s.ReadOnly && cmd.Writes {
+		return false
+	}
+	return s.EnabledTools == nil || matchTool(s.EnabledTools, "/"+cmd.Name)
+}
+
+// matchTool reports whether name matches one of patterns: names or "prefix*".
+func matchTool(patterns []string, name string) bool {
+	for _, pat := range patterns {
+		if prefix, ok := strings.CutSuffix(pat, "*"); ok && strings.HasPrefix(name, prefix) || pat == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterAllowed returns the definitions of defs the session allows; used
+// for tools that do not come from All, such as MCP tools.
+func (s *Session) FilterAllowed(defs []Definition) []Definition {
+	var out []Definition
+	for _, d := range defs {
+		if s.Allows(d.Function.Name) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// --- Session images ---
+
+// AddImage stores an image data URI produced during the session and
+// returns its 1-based ID.
+func (s *Session) AddImage(uri string) int {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	s.store.images = append(s.store.images, uri)
+	return len(s.store.images)
+}
+
+// Image returns the data URI for the given 1-based image ID.
+func (s *Session) Image(imageID int) (string, bool) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	if imageID < 1 || imageID > len(s.store.images) {
+		return "", false
+	}
+	return s.store.images[imageID-1], true
+}
+
+// --- Per-call output ---
+
+// SetPendingImages attaches image data URIs to the current tool call's
+// result. Called by tools that produce images (e.g. ha_camera_snapshot).
+func (s *Session) SetPendingImages(images []string) {
+	s.call.mu.Lock()
+	defer s.call.mu.Unlock()
+	s.call.images = images
+}
+
+// TakePendingImages retrieves and removes any images set by the last tool call.
+// Called by the execution loop after each tool execution.
+func (s *Session) TakePendingImages() []string {
+	s.call.mu.Lock()
+	defer s.call.mu.Unlock()
+	images := s.call.images
+	s.call.images = nil
+	return images
+}
+
+// SetVideoFrameStrip signals that old video frames should be stripped
+// from the message history (set by video_get_frames).
+func (s *Session) SetVideoFrameStrip() {
+	s.call.mu.Lock()
+	defer s.call.mu.Unlock()
+	s.call.stripVideo = true
+}
+
+// TakeVideoFrameStrip checks and clears the strip signal.
+func (s *Session) TakeVideoFrameStrip() bool {
+	s.call.mu.Lock()
+	defer s.call.mu.Unlock()
+	strip := s.call.stripVideo
+	s.call.stripVideo = false
+	return strip
+}
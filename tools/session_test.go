@@ -0,0 +1,131 @@
+package tools
+
+import (
+	"sort"
+	"testing"
+)
+
+func hasTool(defs []Definition, name string) bool {
+	for _, d := range defs {
+		if d.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAll_FiltersBySession(t *testing.T) {
+	if hasTool(All(nil), "imap_list_mailboxes") {
+		t.Error("nil session lists imap tools")
+	}
+	sess := NewSession()
+	if hasTool(All(sess), "imap_list_mailboxes") || hasTool(All(sess), "memory_search") {
+		t.Error("empty session lists imap or memory tools")
+	}
+	sess.Imap = &ImapUserConfig{Server: "imap.example.com:993"}
+	sess.MemoryDir = t.TempDir()
+	defs := All(sess)
+	if !hasTool(defs, "imap_list_mailboxes") || !hasTool(defs, "memory_search") {
+		t.Error("configured session hides imap or memory tools")
+	}
+}
+
+func TestSession_ForCall(t *testing.T) {
+	sess := NewSession()
+	call := sess.ForCall()
+
+	// Session images are shared
+	if id := call.AddImage("data:a"); id != 1 {
+		t.Fatalf("image ID = %d", id)
+	}
+	if uri, ok := sess.Image(1); !ok || uri != "data:a" {
+		t.Errorf("Image(1) = %q, %v", uri, ok)
+	}
+
+	// Per-call output is not
+	call.SetPendingImages([]string{"data:b"})
+	call.SetVideoFrameStrip()
+	if imgs := sess.TakePendingImages(); imgs != nil {
+		t.Errorf("parent pending images = %v", imgs)
+	}
+	if sess.TakeVideoFrameStrip() {
+		t.Error("parent got the frame-strip signal")
+	}
+	if imgs := call.TakePendingImages(); len(imgs) != 1 || imgs[0] != "data:b" {
+		t.Errorf("call pending images = %v", imgs)
+	}
+	if call.TakePendingImages() != nil {
+		t.Error("pending images not cleared")
+	}
+}
+
+func TestCompat_GoroutineSession(t *testing.T) {
+	SetImapOverride(&ImapUserConfig{Server: "imap.example.com:993"})
+	if !ImapAvailable() || !hasTool(All(CurrentSession()), "imap_list_mailboxes") {
+		t.Error("override not visible through the shim")
+	}
+
+	done := make(chan bool)
+	go func() { done <- ImapAvailable() }()
+	if <-done {
+		t.Error("override leaked to another goroutine")
+	}
+
+	ClearImapOverride()
+	if ImapAvailable() {
+		t.Error("override not cleared")
+	}
+}
+
+func TestAll_Sorted(t *testing.T) {
+	sess := NewSession()
+	sess.Imap = &ImapUserConfig{}
+	sess.MemoryDir = t.TempDir()
+	for range 5 {
+		defs := All(sess)
+		if !sort.SliceIsSorted(defs, func(i, j int) bool { return defs[i].Function.Name < defs[j].Function.Name }) {
+			t.Fatal("tool definitions are not sorted by name")
+		}
+	}
+}
+
+func TestSession_Allows(t *testing.T) {
+	sess := NewSession()
+	if !sess.Allows("ha_call") || !sess.Allows("github__create_issue") {
+		t.Error("default policy refuses tools")
+	}
+	sess.ReadOnly = true
+	if sess.Allows("ha_call") || sess.Allows("memory_store") || !sess.Allows("ha_state") {
+		t.Error("read-only policy")
+	}
+	if sess.Allows("github__create_issue") {
+		t.Error("read-only policy allows an unknown tool")
+	}
+	sess.ReadTools = []string{"github__*"}
+	sess.EnabledTools = []string{"ha_*", "github__*"}
+	if !sess.Allows("ha_state") || !sess.Allows("github__create_issue") || sess.Allows("web_fetch") {
+		t.Error("enabled tools policy")
+	}
+	defs := sess.FilterAllowed([]Definition{{Function: Function{Name: "github__list"}}, {Function: Function{Name: "other__list"}}})
+	if len(defs) != 1 || defs[0].Function.Name != "github__list" {
+		t.Errorf("FilterAllowed = %v", defs)
+	}
+	if hasTool(All(sess), "web_fetch") {
+		t.Error("All lists a tool outside the policy")
+	}
+}
+
+func TestSession_AllowsCommand(t *testing.T) {
+	write := &Command{Name: "eat", Writes: true}
+	read := &Command{Name: "look"}
+	sess := NewSession()
+	sess.ReadOnly = true
+	if sess.AllowsCommand(write) || !sess.AllowsCommand(read) {
+		t.Error("read-only policy")
+	}
+	sess.ReadOnly = false
+	sess.EnabledTools = []string{"/look", "web_fetch"}
+	if sess.AllowsCommand(write) || !sess.AllowsCommand(read) {
+		t.Error("enabled tools policy")
+	}
+}
@@ -1,12 +1,14 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // UserInfoEntry represents a single user setting.
@@ -16,37 +18,24 @@ type UserInfoEntry struct {
 	OnlyFor  string `json:"only_for,omitempty"`
 }
 
-// userInfoConfig holds per-goroutine userinfo state.
+// userInfoConfig locates a user's settings.
 type userInfoConfig struct {
 	Path     string // file path to userinfo JSON
 	Username string // user's key inside the file
 }
 
-var userInfoOverrides sync.Map // goroutineID → *userInfoConfig
-var userInfoMu sync.Map       // filepath → *sync.Mutex
+var userInfoMu sync.Map // filepath → *sync.Mutex
 
-// SetUserInfoOverride sets the userinfo config for the current goroutine.
-func SetUserInfoOverride(path, username string) {
-	userInfoOverrides.Store(goroutineID(), &userInfoConfig{Path: path, Username: username})
+// UserInfoAvailable returns true if userinfo is configured for the session.
+func (s *Session) UserInfoAvailable() bool {
+	return s != nil && s.UserInfo != "" && s.UserName != ""
 }
 
-// ClearUserInfoOverride removes the userinfo config for the current goroutine.
-func ClearUserInfoOverride() {
-	userInfoOverrides.Delete(goroutineID())
-}
-
-// UserInfoAvailable returns true if userinfo is configured for the current goroutine.
-func UserInfoAvailable() bool {
-	_, ok := userInfoOverrides.Load(goroutineID())
-	return ok
-}
-
-func getUserInfoConfig() *userInfoConfig {
-	v, ok := userInfoOverrides.Load(goroutineID())
-	if !ok {
+func (s *Session) userInfoConfig() *userInfoConfig {
+	if !s.UserInfoAvailable() {
 		return nil
 	}
-	return v.(*userInfoConfig)
+	return &userInfoConfig{Path: s.UserInfo, Username: s.UserName}
 }
 
 func userInfoFileMu(path string) *sync.Mutex {
@@ -129,8 +118,8 @@ func userInfoDelete(cfg *userInfoConfig, key string) error {
 // UserInfoPromptBlock returns a text block to inject into the system prompt.
 // It includes all entries with in_prompt=true and only_for="" (global),
 // plus entries whose only_for matches one of the activeModules.
-func UserInfoPromptBlock(activeModules []string) string {
-	cfg := getUserInfoConfig()
+func UserInfoPromptBlock(sess *Session, activeModules []string) string {
+	cfg := sess.userInfoConfig()
 	if cfg == nil {
 		return ""
 	}
@@ -194,8 +183,8 @@ func UserInfoPromptBlock(activeModules []string) string {
 
 // UserInfoForModule returns key→value pairs for a given module name.
 // Useful for commands that need to access their onlyFor settings.
-func UserInfoForModule(module string) map[string]string {
-	cfg := getUserInfoConfig()
+func UserInfoForModule(sess *Session, module string) map[string]string {
+	cfg := sess.userInfoConfig()
 	if cfg == nil {
 		return nil
 	}
@@ -216,6 +205,23 @@ func UserInfoForModule(module string) map[string]string {
 	return result
 }
 
+// userLocation returns the time zone named by the first of keys set in the
+// user's settings, falling back to the process local zone.
+func userLocation(sess *Session, keys ...string) *time.Location {
+	if cfg := sess.userInfoConfig(); cfg != nil {
+		if entries, err := userInfoGet(cfg); err == nil {
+			for _, key := range keys {
+				if e, ok := entries[key]; ok && e.Value != "" {
+					if loc, err := time.LoadLocation(e.Value); err == nil {
+						return loc
+					}
+				}
+			}
+		}
+	}
+	return time.Local
+}
+
 // --- Tool registration ---
 
 func init() {
@@ -238,6 +244,7 @@ func init() {
 			},
 		},
 		Execute: executeUserInfoSet,
+		Writes:  true,
 	})
 
 	Register(&Tool{
@@ -255,7 +262,8 @@ func init() {
 				},
 			},
 		},
-		Execute: executeUserInfoGet,
+		Execute:         executeUserInfoGet,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -272,7 +280,8 @@ func init() {
 				},
 			},
 		},
-		Execute: executeUserInfoList,
+		Execute:         executeUserInfoList,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -291,13 +300,14 @@ func init() {
 			},
 		},
 		Execute: executeUserInfoDelete,
+		Writes:  true,
 	})
 }
 
 // --- Tool handlers ---
 
-func executeUserInfoSet(args json.RawMessage) (string, error) {
-	cfg := getUserInfoConfig()
+func executeUserInfoSet(_ context.Context, sess *Session, args json.RawMessage) (string, error) {
+	cfg := sess.userInfoConfig()
 	if cfg == nil {
 		return "", fmt.Errorf("userinfo not configured for this user")
 	}
@@ -343,8 +353,8 @@ func executeUserInfoSet(args json.RawMessage) (string, error) {
 	return desc, nil
 }
 
-func executeUserInfoGet(args json.RawMessage) (string, error) {
-	cfg := getUserInfoConfig()
+func executeUserInfoGet(_ context.Context, sess *Session, args json.RawMessage) (string, error) {
+	cfg := sess.userInfoConfig()
 	if cfg == nil {
 		return "", fmt.Errorf("userinfo not configured for this user")
 	}
@@ -379,8 +389,8 @@ func executeUserInfoGet(args json.RawMessage) (string, error) {
 	return result, nil
 }
 
-func executeUserInfoList(args json.RawMessage) (string, error) {
-	cfg := getUserInfoConfig()
+func executeUserInfoList(_ context.Context, sess *Session, args json.RawMessage) (string, error) {
+	cfg := sess.userInfoConfig()
 	if cfg == nil {
 		return "", fmt.Errorf("userinfo not configured for this user")
 	}
@@ -431,8 +441,8 @@ func executeUserInfoList(args json.RawMessage) (string, error) {
 	return strings.Join(lines, "\n"), nil
 }
 
-func executeUserInfoDelete(args json.RawMessage) (string, error) {
-	cfg := getUserInfoConfig()
+func executeUserInfoDelete(_ context.Context, sess *Session, args json.RawMessage) (string, error) {
+	cfg := sess.userInfoConfig()
 	if cfg == nil {
 		return "", fmt.Errorf("userinfo not configured for this user")
 	}
@@ -1,15 +1,15 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
 	"strconv"
 	"strings"
-	"sync"
 )
 
-// --- Video state management (goroutine-local) ---
+// --- Video state management (per session) ---
 
 type videoState struct {
 	Path       string  // path to video file (original or temp)
@@ -18,50 +18,28 @@ type videoState struct {
 	MaxFrames  int     // max overview frames
 }
 
-var videoStore sync.Map
-
-// SetVideoState stores video file info for the current goroutine.
+// SetVideoState stores video file info for the session.
 // Called when a video is converted to overview frames.
-func SetVideoState(path string, duration float64, frameWidth, maxFrames int) {
-	videoStore.Store(goroutineID(), &videoState{
+func (s *Session) SetVideoState(path string, duration float64, frameWidth, maxFrames int) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	s.store.video = &videoState{
 		Path: path, Duration: duration, FrameWidth: frameWidth, MaxFrames: maxFrames,
-	})
-}
-
-// ClearVideoState removes video state for the current goroutine.
-func ClearVideoState() {
-	videoStore.Delete(goroutineID())
+	}
 }
 
-// VideoAvailable returns true if a video is loaded for the current goroutine.
-func VideoAvailable() bool {
-	_, ok := videoStore.Load(goroutineID())
-	return ok
+// VideoAvailable returns true if a video is loaded in the session.
+func (s *Session) VideoAvailable() bool {
+	return s.videoState() != nil
 }
 
-func getVideoState() *videoState {
-	v, ok := videoStore.Load(goroutineID())
-	if !ok {
+func (s *Session) videoState() *videoState {
+	if s == nil || s.store == nil {
 		return nil
 	}
-	return v.(*videoState)
-}
-
-// --- Video frame strip signal ---
-// When video_get_frames executes, it signals that previous video frames
-// should be stripped from the message history to save context.
-
-var videoFrameStrip sync.Map
-
-// SetVideoFrameStrip signals that old video frames should be stripped.
-func SetVideoFrameStrip() {
-	videoFrameStrip.Store(goroutineID(), true)
-}
-
-// TakeVideoFrameStrip checks and clears the strip signal.
-func TakeVideoFrameStrip() bool {
-	_, ok := videoFrameStrip.LoadAndDelete(goroutineID())
-	return ok
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	return s.store.video
 }
 
 // --- Frame extraction callback (set by main) ---
@@ -141,7 +119,7 @@ func init() {
 	})
 }
 
-func executeVideoGetFrames(args json.RawMessage) (string, error) {
+func executeVideoGetFrames(_ context.Context, sess *Session, args json.RawMessage) (string, error) {
 	var params struct {
 		StartTime          string `json:"start_time"`
 		EndTime            string `json:"end_time"`
@@ -152,7 +130,7 @@ func executeVideoGetFrames(args json.RawMessage) (string, error) {
 		return "", fmt.Errorf("parse args: %w", err)
 	}
 
-	vs := getVideoState()
+	vs := sess.videoState()
 	if vs == nil {
 		return "", fmt.Errorf("no video available in current session")
 	}
@@ -197,8 +175,8 @@ func executeVideoGetFrames(args json.RawMessage) (string, error) {
 	for _, f := range frames {
 		uris = append(uris, f.DataURI)
 	}
-	SetPendingImages(uris)
-	SetVideoFrameStrip()
+	sess.SetPendingImages(uris)
+	sess.SetVideoFrameStrip()
 
 	// Build result text
 	duration := end - start
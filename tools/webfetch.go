@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -36,7 +37,8 @@ func init() {
 				},
 			},
 		},
-		Execute: executeWebFetch,
+		Execute:         executeWebFetch,
+		ConcurrencySafe: true,
 	})
 
 	Register(&Tool{
@@ -61,7 +63,8 @@ func init() {
 				},
 			},
 		},
-		Execute: executeWebFetchSummarize,
+		Execute:         executeWebFetchSummarize,
+		ConcurrencySafe: true,
 	})
 }
 
@@ -72,9 +75,9 @@ var consentCookies = map[string]string{
 	"idnes.cz": "dCMP=gemius=1",
 }
 
-func FetchURL(rawURL string) (string, error) {
+func FetchURL(ctx context.Context, rawURL string) (string, error) {
 	client := &http.Client{Timeout: 30 * time.Second}
-	req, err := http.NewRequest("GET", rawURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("request error: %w", err)
 	}
@@ -112,7 +115,7 @@ func FetchURL(rawURL string) (string, error) {
 	return fmt.Sprintf("HTTP %d\n\n%s", resp.StatusCode, text), nil
 }
 
-func executeWebFetch(rawArgs json.RawMessage) (string, error) {
+func executeWebFetch(ctx context.Context, _ *Session, rawArgs json.RawMessage) (string, error) {
 	var args webFetchArgs
 	if err := json.Unmarshal(rawArgs, &args); err != nil {
 		return "", fmt.Errorf("invalid arguments: %w", err)
@@ -120,10 +123,10 @@ func executeWebFetch(rawArgs json.RawMessage) (string, error) {
 	if args.URL == "" {
 		return "", fmt.Errorf("url is required")
 	}
-	return FetchURL(args.URL)
+	return FetchURL(ctx, args.URL)
 }
 
-func executeWebFetchSummarize(rawArgs json.RawMessage) (string, error) {
+func executeWebFetchSummarize(ctx context.Context, _ *Session, rawArgs json.RawMessage) (string, error) {
 	var args struct {
 		URL    string `json:"url"`
 		Prompt string `json:"prompt"`
@@ -138,7 +141,7 @@ func executeWebFetchSummarize(rawArgs json.RawMessage) (string, error) {
 		return "", fmt.Errorf("sub-agent not available")
 	}
 
-	content, err := FetchURL(args.URL)
+	content, err := FetchURL(ctx, args.URL)
 	if err != nil {
 		return "", err
 	}
@@ -152,7 +155,7 @@ func executeWebFetchSummarize(rawArgs json.RawMessage) (string, error) {
 		prompt = "Summarize the key information from this web page concisely."
 	}
 
-	summary, err := SubAgentFn(prompt, content)
+	summary, err := SubAgentFn(ctx, prompt, content)
 	if err != nil {
 		return "", fmt.Errorf("summarization failed: %w", err)
 	}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage modes recorded in the ledger.
+const (
+	usageModeQuery = "query"
+	usageModeMail  = "mail-summary"
+	usageModeNews  = "news-summary"
+)
+
+// usageTotals is an accumulated token count.
+type usageTotals struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (t *usageTotals) add(o usageTotals) {
+	t.Requests += o.Requests
+	t.PromptTokens += o.PromptTokens
+	t.CompletionTokens += o.CompletionTokens
+}
+
+// usageMeter accumulates the token usage of one query (main model and
+// sub-agents) per model. It travels in the query's context.
+type usageMeter struct {
+	mu      sync.Mutex
+	byModel map[string]*usageTotals
+	last    Usage // usage of the most recent LLM call
+}
+
+type usageMeterKey struct{}
+
+// withUsageMeter returns a context that accumulates LLM usage into a new meter.
+func withUsageMeter(ctx context.Context) (context.Context, *usageMeter) {
+	m := &usageMeter{byModel: map[string]*usageTotals{}}
+	return context.WithValue(ctx, usageMeterKey{}, m), m
+}
+
+// recordUsage adds one LLM call to the meter carried by ctx, if any.
+func recordUsage(ctx context.Context, model string, u Usage) {
+	m, ok := ctx.Value(usageMeterKey{}).(*usageMeter)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.byModel[model]
+	if t == nil {
+		t = &usageTotals{}
+		m.byModel[model] = t
+	}
+	t.add(usageTotals{Requests: 1, PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens})
+	m.last = u
+}
+
+// lastContextTokens returns prompt+completion tokens of the most recent
+// call, i.e. the real size of the conversation after it (0 if unreported).
+func (m *usageMeter) lastContextTokens() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last.PromptTokens == 0 {
+		return 0
+	}
+	return m.last.PromptTokens + m.last.CompletionTokens
+}
+
+// totalTokens returns prompt+completion tokens of all calls so far.
+func (m *usageMeter) totalTokens() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, t := range m.byModel {
+		n += t.PromptTokens + t.CompletionTokens
+	}
+	return n
+}
+
+// --- Ledger ---
+
+// usageLedger is the persisted usage history:
+// user -> day ("2006-01-02") -> mode -> model -> totals.
+type usageLedger map[string]map[string]map[string]map[string]*usageTotals
+
+var (
+	usagePath = "usage.json"
+	usageMu   sync.Mutex
+)
+
+// usageUser is the ledger key for a users.json name ("default" without users.json).
+func usageUser(userName string) string {
+	if userName == "" {
+		return "default"
+	}
+	return userName
+}
+
+func loadUsageLedger(path string) (usageLedger, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return usageLedger{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l usageLedger
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if l == nil {
+		l = usageLedger{}
+	}
+	return l, nil
+}
+
+func saveUsageLedger(path string, l usageLedger) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// saveQueryUsage adds a finished query's usage to the ledger under today's date.
+func saveQueryUsage(userName, mode string, m *usageMeter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.byModel) == 0 {
+		return nil
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	l, err := loadUsageLedger(usagePath)
+	if err != nil {
+		return err
+	}
+	user := usageUser(userName)
+	day := time.Now().Format("2006-01-02")
+	if l[user] == nil {
+		l[user] = map[string]map[string]map[string]*usageTotals{}
+	}
+	if l[user][day] == nil {
+		l[user][day] = map[string]map[string]*usageTotals{}
+	}
+	if l[user][day][mode] == nil {
+		l[user][day][mode] = map[string]*usageTotals{}
+	}
+	for model, t := range m.byModel {
+		dst := l[user][day][mode][model]
+		if dst == nil {
+			dst = &usageTotals{}
+			l[user][day][mode][model] = dst
+		}
+		dst.add(*t)
+	}
+	return saveUsageLedger(usagePath, l)
+}
+
+// usageTokensOn returns a user's prompt+completion tokens recorded on now's date.
+func usageTokensOn(userName string, now time.Time) (int, error) {
+	usageMu.Lock()
+	l, err := loadUsageLedger(usagePath)
+	usageMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, models := range l[usageUser(userName)][now.Format("2006-01-02")] {
+		for _, t := range models {
+			n += t.PromptTokens + t.CompletionTokens
+		}
+	}
+	return n, nil
+}
+
+// usageReport formats today's and this month's totals for a user,
+// broken down by model and by mode.
+func usageReport(userName string, now time.Time) (string, error) {
+	usageMu.Lock()
+	l, err := loadUsageLedger(usagePath)
+	usageMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	days := l[usageUser(userName)]
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Usage (%s)\n", usageUser(userName))
+	writeUsagePeriod(&sb, "Today "+now.Format("2006-01-02"), days, now.Format("2006-01-02"))
+	writeUsagePeriod(&sb, "Month "+now.Format("2006-01"), days, now.Format("2006-01"))
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// writeUsagePeriod sums all days whose key starts with prefix.
+func writeUsagePeriod(sb *strings.Builder, title string, days map[string]map[string]map[string]*usageTotals, prefix string) {
+	var total usageTotals
+	byModel := map[string]*usageTotals{}
+	byMode := map[string]*usageTotals{}
+	for day, modes := range days {
+		if !strings.HasPrefix(day, prefix) {
+			continue
+		}
+		for mode, models := range modes {
+			for model, t := range models {
+				total.add(*t)
+				addTotals(byModel, model, *t)
+				addTotals(byMode, mode, *t)
+			}
+		}
+	}
+
+	fmt.Fprintf(sb, "\n%s: %s\n", title, formatTotals(total))
+	if total.Requests == 0 {
+		return
+	}
+	sb.WriteString("  by model:\n")
+	for _, k := range sortedKeys(byModel) {
+		fmt.Fprintf(sb, "    %s: %s\n", k, formatTotals(*byModel[k]))
+	}
+	sb.WriteString("  by mode:\n")
+	for _, k := range sortedKeys(byMode) {
+		fmt.Fprintf(sb, "    %s: %s\n", k, formatTotals(*byMode[k]))
+	}
+}
+
+func addTotals(m map[string]*usageTotals, key string, t usageTotals) {
+	if m[key] == nil {
+		m[key] = &usageTotals{}
+	}
+	m[key].add(t)
+}
+
+func sortedKeys(m map[string]*usageTotals) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatTotals(t usageTotals) string {
+	return fmt.Sprintf("%d req, %s in / %s out", t.Requests, formatTokenCount(t.PromptTokens), formatTokenCount(t.CompletionTokens))
+}
+
+// formatTokenCount renders 1234567 as "1.2M", 45200 as "45.2k".
+func formatTokenCount(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsage_StreamRequestsAndRecordsUsage(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":120,\"completion_tokens\":8}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	ctx, meter := withUsageMeter(context.Background())
+	for range 2 {
+		if _, err := doStream(ctx, modelConfig{BaseURL: srv.URL}, "m", []Message{{Role: "user", Content: "hi"}}, nil, 100, false, io.Discard, thinkDefault); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !strings.Contains(string(body), `"stream_options":{"include_usage":true}`) {
+		t.Errorf("stream_options missing: %s", body)
+	}
+	got := *meter.byModel["m"]
+	if got != (usageTotals{Requests: 2, PromptTokens: 240, CompletionTokens: 16}) {
+		t.Errorf("meter = %+v", got)
+	}
+	if meter.lastContextTokens() != 128 {
+		t.Errorf("lastContextTokens = %d", meter.lastContextTokens())
+	}
+}
+
+func TestUsage_LedgerReport(t *testing.T) {
+	prev := usagePath
+	usagePath = filepath.Join(t.TempDir(), "usage.json")
+	defer func() { usagePath = prev }()
+
+	ctx, meter := withUsageMeter(context.Background())
+	recordUsage(ctx, "big", Usage{PromptTokens: 45200, CompletionTokens: 800})
+	recordUsage(ctx, "small", Usage{PromptTokens: 100, CompletionTokens: 20})
+	if err := saveQueryUsage("alice", usageModeQuery, meter); err != nil {
+		t.Fatal(err)
+	}
+	ctx, meter = withUsageMeter(context.Background())
+	recordUsage(ctx, "small", Usage{PromptTokens: 900, CompletionTokens: 80})
+	if err := saveQueryUsage("alice", usageModeMail, meter); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := usageReport("alice", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"3 req, 46.2k in / 900 out",
+		"big: 1 req, 45.2k in / 800 out",
+		"small: 2 req, 1.0k in / 100 out",
+		"mail-summary: 1 req, 900 in / 80 out",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report missing %q:\n%s", want, report)
+		}
+	}
+
+	other, _ := usageReport("bob", time.Now())
+	if !strings.Contains(other, "0 req") {
+		t.Errorf("other user's report:\n%s", other)
+	}
+}
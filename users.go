@@ -22,11 +22,22 @@ type UserImapConfig struct {
 	Server   string `json:"server"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Watch pushes important new mail to the mail chat (see botmail.go).
+	Watch *UserImapWatch `json:"watch,omitempty"`
+}
+
+// UserImapWatch configures the background mail watcher of a user.
+type UserImapWatch struct {
+	Mailboxes   []string `json:"mailboxes,omitempty"`    // default: INBOX
+	Threshold   string   `json:"threshold,omitempty"`    // least important category pushed (default: needs-reply)
+	SentMailbox string   `json:"sent_mailbox,omitempty"` // for the conversation history (default: Sent)
 }
 
 // UserHAConfig controls Home Assistant access for a user.
 type UserHAConfig struct {
 	Enabled bool `json:"enabled"`
+	// Alerts are Home Assistant events the bot watches for the user (see botalerts.go).
+	Alerts []HAAlert `json:"alerts,omitempty"`
 }
 
 // UserCalendarConfig holds CalDAV/iCal calendar settings for a user.
@@ -52,6 +63,13 @@ type UserContactsConfig struct {
 	Writable bool   `json:"writable,omitempty"`
 }
 
+// UserQuota limits a user's load on the inference server. Zero fields are unlimited.
+type UserQuota struct {
+	RequestsPerHour int `json:"requests_per_hour,omitempty"`
+	TokensPerDay    int `json:"tokens_per_day,omitempty"`  // prompt + completion, all models
+	MaxToolRounds   int `json:"max_tool_rounds,omitempty"` // per query
+}
+
 // UserConfig holds all per-user settings.
 type UserConfig struct {
 	TelegramID int64               `json:"telegram_id"`
@@ -64,6 +82,12 @@ type UserConfig struct {
 	MCP        map[string]bool     `json:"mcp,omitempty"`
 	Memory     string              `json:"memory,omitempty"`
 	Userinfo   string              `json:"userinfo,omitempty"`
+	Quota      *UserQuota          `json:"quota,omitempty"`
+	Admin      bool                `json:"admin,omitempty"` // exempt from quotas
+	// VoiceReplies also sends bot answers as voice messages (needs speech.tts in telegram.json).
+	VoiceReplies bool `json:"voice_replies,omitempty"`
+	// Schedules are recurring digests and prompts run by the bot (see schedule.go).
+	Schedules []UserSchedule `json:"schedules,omitempty"`
 }
 
 var (
@@ -167,6 +191,23 @@ func userContactsConfig(u *UserConfig) *tools.ContactsConfig {
 	}
 }
 
+// userSession builds the tool session for a user: integrations, memory and
+// userinfo come from the user's config. A nil user gets an empty session.
+func userSession(u *UserConfig, userName string) *tools.Session {
+	sess := tools.NewSession()
+	sess.UserName = userName
+	if u == nil {
+		return sess
+	}
+	sess.Imap = userImapConfig(u)
+	sess.HA = u.HA != nil && u.HA.Enabled
+	sess.Calendar = userCalendarConfig(u)
+	sess.Contacts = userContactsConfig(u)
+	sess.MemoryDir = u.Memory
+	sess.UserInfo = u.Userinfo
+	return sess
+}
+
 // userChatID returns the chat ID for a message category.
 // If overrideChatID is non-zero, it takes precedence.
 func userChatID(u *UserConfig, category string, overrideChatID int64) int64 {